@@ -0,0 +1,427 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: linsk/v1/linsk.proto
+
+package linskv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	LinskService_ListDevices_FullMethodName   = "/linsk.v1.LinskService/ListDevices"
+	LinskService_ListSessions_FullMethodName  = "/linsk.v1.LinskService/ListSessions"
+	LinskService_GetSession_FullMethodName    = "/linsk.v1.LinskService/GetSession"
+	LinskService_StartSession_FullMethodName  = "/linsk.v1.LinskService/StartSession"
+	LinskService_StopSession_FullMethodName   = "/linsk.v1.LinskService/StopSession"
+	LinskService_WatchSessions_FullMethodName = "/linsk.v1.LinskService/WatchSessions"
+	LinskService_StreamLogs_FullMethodName    = "/linsk.v1.LinskService/StreamLogs"
+)
+
+// LinskServiceClient is the client API for LinskService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LinskService is the control API served by `linsk daemon`. Every call must
+// carry the daemon's API token in the "authorization: Bearer <token>" metadata.
+type LinskServiceClient interface {
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error)
+	StartSession(ctx context.Context, in *StartSessionRequest, opts ...grpc.CallOption) (*StartSessionResponse, error)
+	// StopSession gracefully stops a running session, or forgets an ended one.
+	StopSession(ctx context.Context, in *StopSessionRequest, opts ...grpc.CallOption) (*StopSessionResponse, error)
+	// WatchSessions streams the current state of the sessions first, and then
+	// a snapshot of a session on every change of it (lifecycle state, shares,
+	// errors). Slow receivers may miss intermediate snapshots.
+	WatchSessions(ctx context.Context, in *WatchSessionsRequest, opts ...grpc.CallOption) (LinskService_WatchSessionsClient, error)
+	// StreamLogs streams the latest logs of a session first, and then the new
+	// ones as they come. The stream ends once the session ends.
+	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (LinskService_StreamLogsClient, error)
+}
+
+type linskServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLinskServiceClient(cc grpc.ClientConnInterface) LinskServiceClient {
+	return &linskServiceClient{cc}
+}
+
+func (c *linskServiceClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, LinskService_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *linskServiceClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, LinskService_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *linskServiceClient) GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*GetSessionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSessionResponse)
+	err := c.cc.Invoke(ctx, LinskService_GetSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *linskServiceClient) StartSession(ctx context.Context, in *StartSessionRequest, opts ...grpc.CallOption) (*StartSessionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartSessionResponse)
+	err := c.cc.Invoke(ctx, LinskService_StartSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *linskServiceClient) StopSession(ctx context.Context, in *StopSessionRequest, opts ...grpc.CallOption) (*StopSessionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StopSessionResponse)
+	err := c.cc.Invoke(ctx, LinskService_StopSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *linskServiceClient) WatchSessions(ctx context.Context, in *WatchSessionsRequest, opts ...grpc.CallOption) (LinskService_WatchSessionsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LinskService_ServiceDesc.Streams[0], LinskService_WatchSessions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &linskServiceWatchSessionsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LinskService_WatchSessionsClient interface {
+	Recv() (*WatchSessionsResponse, error)
+	grpc.ClientStream
+}
+
+type linskServiceWatchSessionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *linskServiceWatchSessionsClient) Recv() (*WatchSessionsResponse, error) {
+	m := new(WatchSessionsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *linskServiceClient) StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (LinskService_StreamLogsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LinskService_ServiceDesc.Streams[1], LinskService_StreamLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &linskServiceStreamLogsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LinskService_StreamLogsClient interface {
+	Recv() (*StreamLogsResponse, error)
+	grpc.ClientStream
+}
+
+type linskServiceStreamLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *linskServiceStreamLogsClient) Recv() (*StreamLogsResponse, error) {
+	m := new(StreamLogsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LinskServiceServer is the server API for LinskService service.
+// All implementations must embed UnimplementedLinskServiceServer
+// for forward compatibility
+//
+// LinskService is the control API served by `linsk daemon`. Every call must
+// carry the daemon's API token in the "authorization: Bearer <token>" metadata.
+type LinskServiceServer interface {
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error)
+	StartSession(context.Context, *StartSessionRequest) (*StartSessionResponse, error)
+	// StopSession gracefully stops a running session, or forgets an ended one.
+	StopSession(context.Context, *StopSessionRequest) (*StopSessionResponse, error)
+	// WatchSessions streams the current state of the sessions first, and then
+	// a snapshot of a session on every change of it (lifecycle state, shares,
+	// errors). Slow receivers may miss intermediate snapshots.
+	WatchSessions(*WatchSessionsRequest, LinskService_WatchSessionsServer) error
+	// StreamLogs streams the latest logs of a session first, and then the new
+	// ones as they come. The stream ends once the session ends.
+	StreamLogs(*StreamLogsRequest, LinskService_StreamLogsServer) error
+	mustEmbedUnimplementedLinskServiceServer()
+}
+
+// UnimplementedLinskServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLinskServiceServer struct {
+}
+
+func (UnimplementedLinskServiceServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedLinskServiceServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedLinskServiceServer) GetSession(context.Context, *GetSessionRequest) (*GetSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
+}
+func (UnimplementedLinskServiceServer) StartSession(context.Context, *StartSessionRequest) (*StartSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartSession not implemented")
+}
+func (UnimplementedLinskServiceServer) StopSession(context.Context, *StopSessionRequest) (*StopSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopSession not implemented")
+}
+func (UnimplementedLinskServiceServer) WatchSessions(*WatchSessionsRequest, LinskService_WatchSessionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSessions not implemented")
+}
+func (UnimplementedLinskServiceServer) StreamLogs(*StreamLogsRequest, LinskService_StreamLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedLinskServiceServer) mustEmbedUnimplementedLinskServiceServer() {}
+
+// UnsafeLinskServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LinskServiceServer will
+// result in compilation errors.
+type UnsafeLinskServiceServer interface {
+	mustEmbedUnimplementedLinskServiceServer()
+}
+
+func RegisterLinskServiceServer(s grpc.ServiceRegistrar, srv LinskServiceServer) {
+	s.RegisterService(&LinskService_ServiceDesc, srv)
+}
+
+func _LinskService_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LinskServiceServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LinskService_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LinskServiceServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LinskService_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LinskServiceServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LinskService_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LinskServiceServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LinskService_GetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LinskServiceServer).GetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LinskService_GetSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LinskServiceServer).GetSession(ctx, req.(*GetSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LinskService_StartSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LinskServiceServer).StartSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LinskService_StartSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LinskServiceServer).StartSession(ctx, req.(*StartSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LinskService_StopSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LinskServiceServer).StopSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LinskService_StopSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LinskServiceServer).StopSession(ctx, req.(*StopSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LinskService_WatchSessions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchSessionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LinskServiceServer).WatchSessions(m, &linskServiceWatchSessionsServer{ServerStream: stream})
+}
+
+type LinskService_WatchSessionsServer interface {
+	Send(*WatchSessionsResponse) error
+	grpc.ServerStream
+}
+
+type linskServiceWatchSessionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *linskServiceWatchSessionsServer) Send(m *WatchSessionsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _LinskService_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LinskServiceServer).StreamLogs(m, &linskServiceStreamLogsServer{ServerStream: stream})
+}
+
+type LinskService_StreamLogsServer interface {
+	Send(*StreamLogsResponse) error
+	grpc.ServerStream
+}
+
+type linskServiceStreamLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *linskServiceStreamLogsServer) Send(m *StreamLogsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// LinskService_ServiceDesc is the grpc.ServiceDesc for LinskService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LinskService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "linsk.v1.LinskService",
+	HandlerType: (*LinskServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDevices",
+			Handler:    _LinskService_ListDevices_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _LinskService_ListSessions_Handler,
+		},
+		{
+			MethodName: "GetSession",
+			Handler:    _LinskService_GetSession_Handler,
+		},
+		{
+			MethodName: "StartSession",
+			Handler:    _LinskService_StartSession_Handler,
+		},
+		{
+			MethodName: "StopSession",
+			Handler:    _LinskService_StopSession_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSessions",
+			Handler:       _LinskService_WatchSessions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _LinskService_StreamLogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "linsk/v1/linsk.proto",
+}
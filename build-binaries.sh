@@ -8,6 +8,15 @@ if [ -z "$version" ]; then
     exit 1
 fi
 
+# The minisign public key that the VM images distributed via release
+# metadata are signed with. Without it, the release binaries would trust no key.
+image_signing_public_key=$LINSK_IMAGE_SIGNING_PUBLIC_KEY
+
+if [ -z "$image_signing_public_key" ]; then
+    echo "Image signing public key is not specified (LINSK_IMAGE_SIGNING_PUBLIC_KEY environment variable)"
+    exit 1
+fi
+
 function build() {
     name="linsk_${1}_${2}_${version}"
     binary_name="$name"
@@ -15,7 +24,7 @@ function build() {
         binary_name="$binary_name.exe"
     fi
     
-    CGO_ENABLED=0 GOOS=$1 GOARCH=$2 go build -trimpath -o build/$binary_name
+    CGO_ENABLED=0 GOOS=$1 GOARCH=$2 go build -trimpath -ldflags "-X github.com/AlexSSD7/linsk/constants.imageSigningPublicKey=$image_signing_public_key" -o build/$binary_name
     cd build
     zip $name.zip $binary_name
     rm $binary_name
@@ -23,6 +32,7 @@ function build() {
 }
 
 build windows amd64
+build windows arm64
 build darwin amd64
 build darwin arm64
 
@@ -0,0 +1,118 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var attachCmd = &cobra.Command{
+	Use:               "attach <name>",
+	Short:             "Follow the output of an instance started with \"linsk run --detach\". Press Ctrl+C to stop following; the instance keeps running.",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+		name := args[0]
+
+		logPath, err := store.GetInstanceLogPath(name)
+		if err != nil {
+			slog.Error("Bad instance name", "error", err.Error())
+			os.Exit(1)
+		}
+
+		f, err := os.Open(logPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				slog.Error("No detached instance with the name was found", "name", name)
+			} else {
+				slog.Error("Failed to open instance log file", "error", err.Error())
+			}
+
+			os.Exit(1)
+		}
+
+		defer func() { _ = f.Close() }()
+
+		ctx, ctxCancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer ctxCancel()
+
+		err = followInstanceLog(ctx, store, name, f, os.Stdout)
+		if err != nil {
+			slog.Error("Failed to follow instance output", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if ctx.Err() != nil {
+			slog.Info("Detached from the instance, it keeps running in the background", "name", name)
+		}
+	},
+}
+
+var attachFollowFlag bool
+
+func init() {
+	attachCmd.Flags().BoolVarP(&attachFollowFlag, "follow", "f", true, "Keep printing the new output until the instance exits. If disabled, only the output so far is printed.")
+}
+
+// followInstanceLog copies the instance log to w, waiting for the new output
+// until the instance stops running or the context is canceled.
+func followInstanceLog(ctx context.Context, store *storage.Storage, name string, f *os.File, w io.Writer) error {
+	for {
+		_, err := io.Copy(w, f)
+		if err != nil {
+			return errors.Wrap(err, "copy log")
+		}
+
+		if !attachFollowFlag {
+			return nil
+		}
+
+		inst, err := findRunningInstance(store, name)
+		if err != nil {
+			return err
+		}
+
+		if inst == nil {
+			// Copying one last time so that the output written just before the exit is not lost.
+			_, err = io.Copy(w, f)
+			if err != nil {
+				return errors.Wrap(err, "copy log")
+			}
+
+			slog.Info("The instance is not running", "name", name)
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(detachedPollInterval):
+		}
+	}
+}
@@ -29,7 +29,9 @@ var buildCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		store := createStoreOrExit()
 
-		exitCode := store.RunCLIImageBuild(vmDebugFlag, buildOverwriteFlag)
+		ctx, ctxCancel := newInterruptContext()
+		exitCode := store.RunCLIImageBuild(ctx, vmDebugFlag, buildOverwriteFlag)
+		ctxCancel()
 		if exitCode != 0 {
 			os.Exit(exitCode)
 		}
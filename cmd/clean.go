@@ -17,14 +17,11 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"log/slog"
 	"os"
-	"strings"
 
-	"github.com/AlexSSD7/linsk/nettap"
-	"github.com/AlexSSD7/linsk/utils"
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
 	"github.com/spf13/cobra"
 )
 
@@ -34,45 +31,20 @@ var cleanCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		store := createStoreOrExit()
 
-		if nettap.Available() {
-			tm, err := nettap.NewTapManager(slog.With("caller", "nettap-manager"))
-			if err != nil {
-				slog.Error("Failed to create network tap manager, will not attempt to remove dangling tap interfaces", "error", err.Error())
-			} else {
-				tapAllocs, err := store.ListNetTapAllocations()
-				if err != nil {
-					slog.Error("Failed to list net tap allocations, will not attempt to remove dangling tap interfaces", "error", err.Error())
-				} else {
-					removed, err := tm.PruneTaps(tapAllocs)
-					if err != nil {
-						slog.Error("Failed to prune dangling network taps", "error", err.Error())
-					} else if len(removed) > 0 {
-						slog.Info("Removed dangling network taps", "count", len(removed))
-					}
-
-					for _, removedTapName := range removed {
-						err = store.ReleaseNetTapAllocation(removedTapName)
-						if err != nil {
-							slog.Error("Failed to release removed network tap allocation", "error", err.Error(), "name", removedTapName)
-						}
-					}
-				}
-			}
-		}
+		// The data directory is about to be removed, so we're removing
+		// the leftovers that live outside of it unconditionally.
+		checkLeftovers(store, true)
 
 		rmPath := store.DataDirPath()
-		fmt.Fprintf(os.Stderr, "Will permanently remove '"+rmPath+"'. Proceed? (y/n) > ")
-
-		reader := bufio.NewReader(os.Stdin)
-		answer, err := reader.ReadBytes('\n')
+		proceed, err := promptYesNo("Will permanently remove '" + rmPath + "'. Proceed?")
 		if err != nil {
 			slog.Error("Failed to read answer", "error", err.Error())
 			os.Exit(1)
 		}
 
-		if utils.ClearUnprintableChars(strings.ToLower(string(answer)), false) != "y" {
+		if !proceed {
 			fmt.Fprintf(os.Stderr, "Aborted.\n")
-			os.Exit(2)
+			os.Exit(exitcode.Aborted)
 		}
 
 		err = os.RemoveAll(rmPath)
@@ -0,0 +1,141 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/spf13/cobra"
+)
+
+// completeVMCommandArgs completes the positional arguments shared by the
+// commands that start a VM: the passthrough target first, and the in-VM
+// device name second. The in-VM device names come from the last
+// `linsk ls` run against the same target, as discovering them requires
+// starting a VM, which is far too slow for shell completion.
+func completeVMCommandArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return completePassthroughArg(toComplete)
+	case 1:
+		store, err := storage.NewStorage(slog.With("caller", "storage"), dataDirFlag)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		devs, err := store.GetDiscoveredDevices(args[0])
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		return devs, cobra.ShellCompDirectiveNoFileComp
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completePassthroughArg(toComplete string) ([]string, cobra.ShellCompDirective) {
+	if imgPath, ok := strings.CutPrefix(toComplete, "img:"); ok {
+		return completeImagePaths(imgPath), cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ret := []string{"img:"}
+
+	devs, err := osspecifics.ListHostBlockDevices()
+	if err == nil {
+		for _, dev := range devs {
+			ret = append(ret, "dev:"+dev)
+		}
+	}
+
+	return ret, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImagePaths lists the directories and files matching the partially
+// typed disk image path. Shells complete file names only for whole words,
+// which does not work with the "img:" prefix.
+func completeImagePaths(partial string) []string {
+	dir, prefix := filepath.Split(partial)
+
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var ret []string
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+
+		name := "img:" + dir + entry.Name()
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+
+		ret = append(ret, name)
+	}
+
+	return ret
+}
+
+func flattenBlockDeviceNames(devs []vm.BlockDevice) []string {
+	var ret []string
+
+	for i := range devs {
+		ret = append(ret, devs[i].Name)
+		ret = append(ret, flattenBlockDeviceNames(devs[i].Children)...)
+	}
+
+	return ret
+}
+
+// completeInstanceName completes the name of a running instance.
+func completeInstanceName(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	store, err := storage.NewStorage(slog.With("caller", "storage"), dataDirFlag)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	instances, err := store.ListInstances()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var ret []string
+	for _, inst := range instances {
+		ret = append(ret, inst.ID)
+	}
+
+	return ret, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,141 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/AlexSSD7/linsk/config"
+	"github.com/spf13/cobra"
+)
+
+func getConfigPathOrExit() string {
+	if configPathFlag != "" {
+		return configPathFlag
+	}
+
+	p, err := config.GetDefaultPath()
+	if err != nil {
+		slog.Error("Failed to get config file path", "error", err.Error())
+		os.Exit(1)
+	}
+
+	return p
+}
+
+const (
+	shareUserEnv     = "LINSK_SHARE_USER"
+	sharePasswordEnv = "LINSK_SHARE_PASSWORD"
+	dataDirEnv       = "LINSK_DATA_DIR"
+)
+
+// applyUserConfig sets the flags that were not specified explicitly to the
+// values from the environment variables, or, if not set, from the config file.
+func applyUserConfig(cmd *cobra.Command) {
+	for flagName, env := range map[string]string{
+		"share-user":     shareUserEnv,
+		"share-password": sharePasswordEnv,
+		"data-dir":       dataDirEnv,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || f.Changed {
+			continue
+		}
+
+		// Marks the flag as changed, so the config file value is not applied.
+		err := cmd.Flags().Set(flagName, value)
+		if err != nil {
+			slog.Error("Failed to apply environment variable value", "error", err.Error(), "flag", flagName, "env", env)
+			os.Exit(1)
+		}
+	}
+
+	configPath := getConfigPathOrExit()
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		slog.Error("Failed to load config file", "error", err.Error(), "path", configPath)
+		os.Exit(1)
+	}
+
+	if configPathFlag != "" {
+		_, err := os.Stat(configPath)
+		if err != nil {
+			slog.Error("Failed to stat the specified config file", "error", err.Error(), "path", configPath)
+			os.Exit(1)
+		}
+	}
+
+	uintToStr := func(v uint32) string {
+		if v == 0 {
+			return ""
+		}
+
+		return fmt.Sprint(v)
+	}
+
+	boolToStr := func(v bool) string {
+		if !v {
+			return ""
+		}
+
+		return "true"
+	}
+
+	for flagName, value := range map[string]string{
+		"data-dir":      cfg.DataDir,
+		"share-backend": cfg.ShareBackend,
+		"share-listen":  cfg.ShareListen,
+		"image-flavor":  cfg.ImageFlavor,
+		"alpine-mirror": cfg.AlpineMirror,
+
+		"vm-mem-alloc":         uintToStr(cfg.VMMemAlloc),
+		"vm-cpus":              uintToStr(cfg.VMCPUs),
+		"vm-os-up-timeout":     uintToStr(cfg.VMOSUpTimeout),
+		"vm-ssh-setup-timeout": uintToStr(cfg.VMSSHSetupTimeout),
+		"vm-accel":             cfg.VMAccel,
+		"vm-drive-cache":       cfg.VMDriveCache,
+		"vm-drive-aio":         cfg.VMDriveAIO,
+
+		"share-user":     cfg.ShareUser,
+		"share-password": cfg.SharePassword,
+
+		"auto-run": boolToStr(cfg.WatchAutoRun),
+	} {
+		if value == "" {
+			continue
+		}
+
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || f.Changed {
+			continue
+		}
+
+		err := f.Value.Set(value)
+		if err != nil {
+			slog.Error("Failed to apply config file value", "error", err.Error(), "flag", flagName, "path", configPath)
+			os.Exit(1)
+		}
+	}
+}
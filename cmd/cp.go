@@ -0,0 +1,500 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/transfer"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <device>:<path> <host path> | <host path> <device>:<path>",
+	Short: "Start a VM and copy files or directories between the device's file system and the host, without starting a network file share.",
+	Long: `Start a VM, mount the file system, and copy a file or a directory from it to the host, or the other way around. No network file share client or credentials are needed.
+
+The files are transferred over SFTP on the SSH connection to the VM by default. Use --transfer=ftp to transfer them over an FTP server started in the VM instead. Symlinks are copied over SFTP only.
+
+The device is specified in the same syntax as for "linsk run" (e.g. "dev:/dev/sdb"), followed by a colon and the absolute path on the file system, like "dev:/dev/sdb:/home/user/Documents". The device is passed through read-only when copying from it.
+
+If the destination is an existing directory, the source is copied into it. Otherwise, the source is copied to the destination path under the new name.`,
+	Example: `  linsk cp dev:/dev/sdb:/home/user/Documents .
+  linsk cp --vm-device vdb2 dev:/dev/sdb:/etc/fstab fstab.bak
+  linsk cp report.pdf dev:/dev/sdb:/home/user/
+  linsk cp --transfer ftp dev:/dev/sdb:/home/user/Music .`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcDev, srcPath, srcInVM := parseVMPathArg(args[0])
+		dstDev, dstPath, dstInVM := parseVMPathArg(args[1])
+
+		var passthroughArg string
+
+		switch {
+		case srcInVM && dstInVM:
+			slog.Error("Copying between two devices is not supported. One of the paths must be a host path.")
+			os.Exit(exitcode.Usage)
+		case srcInVM:
+			passthroughArg = srcDev
+
+			// Passing the device through read-only guarantees
+			// that copying from it has no side effects.
+			readOnlyFlag = true
+		case dstInVM:
+			passthroughArg = dstDev
+
+			_, err := os.Lstat(args[0])
+			if err != nil {
+				slog.Error("Failed to stat the source", "error", err.Error())
+				os.Exit(1)
+			}
+		default:
+			slog.Error(`Neither of the paths is a device path. Please specify one in the "<device>:<path>" form, where the path is absolute.`)
+			os.Exit(exitcode.Usage)
+		}
+
+		configureVMRuntimeFlags()
+
+		if vmRuntimeLUKSContainerDevice != "" && !cmd.Flags().Changed("vm-device") {
+			slog.Error("Cannot use the default (entire) device with a LUKS container. Please specify the in-VM device name with --vm-device.")
+			os.Exit(exitcode.Usage)
+		}
+
+		opener, err := newFileTransferOpener(cpTransferFlag)
+		if err != nil {
+			slog.Error("Failed to set up the file transfer", "protocol", cpTransferFlag, "error", err.Error())
+			os.Exit(exitcode.Usage)
+		}
+
+		exitCode := runVM(passthroughArg, func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+			err := mountForFileAccess(ctx, fm)
+			if err != nil {
+				slog.Error("Failed to mount the disk inside the VM", "error", err.Error())
+				return exitcode.ForError(err)
+			}
+
+			ft, err := opener.Open(ctx, i, fm)
+			if err != nil {
+				slog.Error("Failed to open the file transfer", "protocol", cpTransferFlag, "error", err.Error())
+				return exitcode.ForError(err)
+			}
+
+			defer func() { _ = ft.Close() }()
+
+			var stats copyStats
+
+			if srcInVM {
+				err = copyFromVM(ctx, ft, srcPath, args[1], &stats)
+			} else {
+				err = copyToVM(ctx, ft, args[0], dstPath, &stats)
+			}
+			if err != nil {
+				slog.Error("Failed to copy", "error", err.Error())
+				return 1
+			}
+
+			slog.Info("Copied successfully", "files", stats.files, "dirs", stats.dirs, "size", humanize.IBytes(uint64(stats.bytes)))
+
+			return 0
+		}, opener.ports, false, false)
+
+		_ = opener.Close()
+
+		os.Exit(exitCode)
+	},
+}
+
+var (
+	fileAccessVMDeviceFlag string
+	fileAccessFSTypeFlag   string
+	fileAccessSubvolFlag   string
+	fileAccessLUKSFlag     bool
+
+	cpTransferFlag string
+)
+
+func init() {
+	initVMRuntimeFlags(cpCmd.Flags())
+	initFileAccessFlags(cpCmd.Flags())
+
+	cpCmd.Flags().StringVar(&cpTransferFlag, "transfer", transfer.ProtocolSFTP, "Specifies the protocol to transfer the files with. Available: "+strings.Join(transfer.ListProtocols(), ", ")+".")
+}
+
+func initFileAccessFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&fileAccessVMDeviceFlag, "vm-device", defaultVMMountDevName, `Specifies the in-VM device name to mount, like "vdb1" or "mapper/vg-root". The entire passed-through device is mounted by default.`)
+	flags.StringVar(&fileAccessFSTypeFlag, "fs-type", "", "Specifies the file system type to mount the device with. Detected automatically by default.")
+	flags.StringVar(&fileAccessSubvolFlag, "subvol", "", `Specifies the btrfs subvolume (like "@home") to mount instead of the default one.`)
+	flags.BoolVarP(&fileAccessLUKSFlag, "luks", "l", false, "Use cryptsetup to open a LUKS volume (password will be prompted).")
+}
+
+// mountForFileAccess mounts the device specified with the file access
+// flags, read-only if --read-only was set by the command.
+func mountForFileAccess(ctx context.Context, fm *vm.FileManager) error {
+	err := assembleRAIDIfRequested(ctx, fm)
+	if err != nil {
+		return errors.Wrap(err, "assemble raid arrays")
+	}
+
+	slog.Info("Mounting the device", "dev", fileAccessVMDeviceFlag, "luks", fileAccessLUKSFlag, "read-only", readOnlyFlag)
+
+	return fm.Mount(ctx, fileAccessVMDeviceFlag, vm.MountConfig{
+		LUKSContainerPreopen: vmRuntimeLUKSContainerDevice,
+		LUKSOptions:          getLUKSOptions(),
+		BtrfsSubvolume:       fileAccessSubvolFlag,
+		FSTypeOverride:       fileAccessFSTypeFlag,
+		LUKS:                 fileAccessLUKSFlag,
+		ReadOnly:             readOnlyFlag,
+	})
+}
+
+// parseVMPathArg splits the "<device>:<path>" argument into the device
+// passthrough value and the absolute path on the device's file system.
+// The split is done at the last ":/", as the passthrough values contain
+// colons too (like "dev:/dev/sdb" or "img:C:\disk.img"). False is returned
+// for the host paths, including the Windows ones like "C:/Users".
+func parseVMPathArg(arg string) (string, string, bool) {
+	i := strings.LastIndex(arg, ":/")
+	if i == -1 {
+		return "", "", false
+	}
+
+	dev, p := arg[:i], arg[i+1:]
+	if !strings.Contains(dev, ":") {
+		return "", "", false
+	}
+
+	return dev, p, true
+}
+
+type copyStats struct {
+	files int
+	dirs  int
+	bytes int64
+}
+
+// fileTransferOpener opens the FileTransfer of the protocol selected with
+// --transfer. The FTP server is started as a share, which needs the ports to
+// be forwarded when the VM is started.
+type fileTransferOpener struct {
+	protocol string
+
+	// Set for FTP only.
+	supervisor *share.Supervisor
+	ports      []vm.PortForwardingRule
+}
+
+func newFileTransferOpener(protocol string) (*fileTransferOpener, error) {
+	o := &fileTransferOpener{
+		protocol: protocol,
+	}
+
+	switch protocol {
+	case transfer.ProtocolSFTP:
+	case transfer.ProtocolFTP:
+		uc, err := share.RawUserConfiguration{
+			ListenIP: share.GetDefaultListenIPStr(),
+			FTPExtIP: share.GetDefaultListenIPStr(),
+			ReadOnly: readOnlyFlag,
+		}.Process([]string{"ftp"}, slog.With("caller", "share-config"))
+		if err != nil {
+			return nil, errors.Wrap(err, "process share configuration")
+		}
+
+		share.SetPortReserver(createStoreOrExit().ReservePort)
+
+		supervisor, vmOpts, err := share.NewSupervisor([]string{"ftp"}, uc)
+		if err != nil {
+			return nil, errors.Wrap(err, "initialize ftp share")
+		}
+
+		o.supervisor = supervisor
+		o.ports = vmOpts.Ports
+	default:
+		return nil, fmt.Errorf("unknown transfer protocol '%v'", protocol)
+	}
+
+	return o, nil
+}
+
+// Open opens the FileTransfer. The file system must be mounted already.
+func (o *fileTransferOpener) Open(ctx context.Context, vi vm.Instance, fm *vm.FileManager) (transfer.FileTransfer, error) {
+	if o.supervisor == nil {
+		ft, err := transfer.DialSFTP(ctx, vi)
+		if err != nil {
+			return nil, err
+		}
+
+		return ft, nil
+	}
+
+	activeShares, err := o.supervisor.Apply(ctx, &share.VMShareContext{
+		Instance:    vi,
+		FileManager: fm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "start ftp share")
+	}
+
+	as := activeShares[0]
+
+	u, err := url.Parse(as.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse share url")
+	}
+
+	ft, err := transfer.DialFTP(ctx, u.Host, as.Username, as.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return ft, nil
+}
+
+func (o *fileTransferOpener) Close() error {
+	if o.supervisor == nil {
+		return nil
+	}
+
+	return o.supervisor.Close()
+}
+
+func copyFromVM(ctx context.Context, ft transfer.FileTransfer, src string, dst string, stats *copyStats) error {
+	srcStat, err := ft.Stat(ctx, src)
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+
+	dstRoot := dst
+
+	dstStat, err := os.Stat(dst)
+	if err == nil && dstStat.IsDir() {
+		dstRoot = filepath.Join(dst, path.Base(path.Clean("/"+src)))
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "stat destination")
+	}
+
+	return copyEntryFromVM(ctx, ft, path.Clean("/"+src), srcStat, dstRoot, stats)
+}
+
+func copyEntryFromVM(ctx context.Context, ft transfer.FileTransfer, src string, info fs.FileInfo, target string, stats *copyStats) error {
+	switch {
+	case info.IsDir():
+		err := os.MkdirAll(target, 0755)
+		if err != nil {
+			return errors.Wrapf(err, "create directory '%v'", target)
+		}
+
+		stats.dirs++
+
+		entries, err := ft.List(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			// The names come from the untrusted guest file system. A Linux file name
+			// may contain "\" or be "..", which would escape the destination on the
+			// host (Windows in particular).
+			if !isLocalHostFileName(e.Name()) {
+				slog.Warn("Skipping an entry with a name unsafe on the host", "dir", src, "name", e.Name())
+				continue
+			}
+
+			err = copyEntryFromVM(ctx, ft, path.Join(src, e.Name()), e, filepath.Join(target, e.Name()), stats)
+			if err != nil {
+				return err
+			}
+		}
+	case info.Mode().IsRegular():
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()|0200)
+		if err != nil {
+			return errors.Wrapf(err, "create file '%v'", target)
+		}
+
+		cw := &countingWriter{w: f}
+
+		err = ft.Get(ctx, src, cw)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		err = f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "close file '%v'", target)
+		}
+
+		stats.files++
+		stats.bytes += cw.n
+	case info.Mode()&fs.ModeSymlink != 0:
+		st, ok := ft.(transfer.SymlinkTransfer)
+		if !ok {
+			slog.Warn("The transfer protocol does not support symlinks, skipping", "path", src)
+			return nil
+		}
+
+		link, err := st.Readlink(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		// The absolute and ".." targets could point anywhere on the host.
+		if !filepath.IsLocal(link) {
+			slog.Warn("Skipping a symlink pointing outside of the copied directory", "path", src, "target", link)
+			return nil
+		}
+
+		err = os.Symlink(link, target)
+		if err != nil {
+			// Creating symlinks requires extra privileges on Windows.
+			slog.Warn("Failed to create a symlink, skipping", "path", target, "target", link, "error", err.Error())
+		}
+	default:
+		slog.Warn("Skipping a special file", "path", src)
+	}
+
+	return nil
+}
+
+// isLocalHostFileName reports whether the name is a single file name
+// that stays in its directory when joined on the host. The colons are
+// rejected on Windows, as they address the alternate data streams.
+func isLocalHostFileName(name string) bool {
+	if !filepath.IsLocal(name) || name != filepath.Base(name) {
+		return false
+	}
+
+	return !osspecifics.IsWindows() || !strings.Contains(name, ":")
+}
+
+func copyToVM(ctx context.Context, ft transfer.FileTransfer, src string, dst string, stats *copyStats) error {
+	dstRoot := path.Clean("/" + dst)
+
+	dstStat, err := ft.Stat(ctx, dstRoot)
+	if err == nil && dstStat.IsDir() {
+		dstRoot = path.Join(dstRoot, filepath.Base(src))
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "stat destination")
+	}
+
+	if dstRoot == "/" {
+		return fmt.Errorf("bad destination path")
+	}
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return errors.Wrap(err, "get relative path")
+		}
+
+		target := path.Join(dstRoot, filepath.ToSlash(rel))
+
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrapf(err, "stat '%v'", p)
+		}
+
+		switch {
+		case info.IsDir():
+			err = ft.Mkdir(ctx, target)
+			if err != nil {
+				return err
+			}
+
+			stats.dirs++
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return errors.Wrapf(err, "open '%v'", p)
+			}
+
+			defer func() { _ = f.Close() }()
+
+			cr := &countingReader{r: f}
+
+			err = ft.Put(ctx, target, cr, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+
+			stats.files++
+			stats.bytes += cr.n
+		case info.Mode()&fs.ModeSymlink != 0:
+			st, ok := ft.(transfer.SymlinkTransfer)
+			if !ok {
+				slog.Warn("The transfer protocol does not support symlinks, skipping", "path", p)
+				return nil
+			}
+
+			link, err := os.Readlink(p)
+			if err != nil {
+				return errors.Wrapf(err, "read symlink '%v'", p)
+			}
+
+			err = st.Symlink(ctx, filepath.ToSlash(link), target)
+			if err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skipping a special file", "path", p)
+		}
+
+		return nil
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
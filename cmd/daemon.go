@@ -0,0 +1,237 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/AlexSSD7/linsk/daemon"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/service"
+	"github.com/spf13/cobra"
+)
+
+const daemonTokenFileName = "daemon-token"
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a localhost REST API for listing devices and managing file share sessions. See DAEMON.md for the API reference.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, addr := range []string{daemonListenFlag, daemonGRPCListenFlag, daemonMetricsListenFlag} {
+			if addr == "" {
+				continue
+			}
+
+			err := checkLoopbackListenAddr(addr)
+			if err != nil {
+				slog.Error("Bad listen address", "error", err.Error(), "value", addr)
+				os.Exit(1)
+			}
+		}
+
+		store := createStoreOrExit()
+
+		exe, err := os.Executable()
+		if err != nil {
+			slog.Error("Failed to get the Linsk executable path", "error", err.Error())
+			os.Exit(1)
+		}
+
+		token, err := daemon.GenerateToken()
+		if err != nil {
+			slog.Error("Failed to generate API token", "error", err.Error())
+			os.Exit(1)
+		}
+
+		tokenFile := daemonTokenFileFlag
+		if tokenFile == "" {
+			tokenFile = filepath.Join(store.DataDirPath(), daemonTokenFileName)
+		}
+
+		err = os.WriteFile(tokenFile, []byte(token+"\n"), 0600)
+		if err != nil {
+			slog.Error("Failed to write API token file", "error", err.Error(), "path", tokenFile)
+			os.Exit(1)
+		}
+
+		defer func() { _ = os.Remove(tokenFile) }()
+
+		sessions := daemon.NewSessionManager(slog.With("caller", "daemon-sessions"), exe, getChildProcessBaseArgs(cmd))
+
+		apiSrv := daemon.NewServer(slog.With("caller", "daemon-api"), token, sessions, func() ([]daemon.Device, error) {
+			return listDaemonDevices(store.GetDiscoveredDevices)
+		})
+
+		srv := &http.Server{
+			Addr:              daemonListenFlag,
+			Handler:           apiSrv,
+			ReadHeaderTimeout: time.Second * 10,
+		}
+
+		ctx, ctxCancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer ctxCancel()
+
+		// The Windows service manager asks the daemon to stop with a control request instead of a signal.
+		ctx, serviceDone, err := service.Notify(ctx)
+		if err != nil {
+			slog.Error("Failed to set up the service status reporting", "error", err.Error())
+			os.Exit(1)
+		}
+
+		defer serviceDone()
+
+		grpcSrv := apiSrv.NewGRPCServer()
+
+		if daemonGRPCListenFlag != "" {
+			lis, err := net.Listen("tcp", daemonGRPCListenFlag)
+			if err != nil {
+				slog.Error("Failed to listen for the gRPC API", "error", err.Error(), "listen", daemonGRPCListenFlag)
+				os.Exit(1)
+			}
+
+			slog.Info("Starting the gRPC API server", "listen", daemonGRPCListenFlag)
+
+			go func() {
+				err := grpcSrv.Serve(lis)
+				if err != nil {
+					slog.Error("Failed to run the gRPC API server", "error", err.Error())
+					ctxCancel()
+				}
+			}()
+		}
+
+		var metricsSrv *http.Server
+
+		if daemonMetricsListenFlag != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", sessions.MetricsHandler())
+
+			metricsSrv = &http.Server{
+				Addr:              daemonMetricsListenFlag,
+				Handler:           mux,
+				ReadHeaderTimeout: time.Second * 10,
+			}
+
+			slog.Info("Starting the metrics server", "listen", daemonMetricsListenFlag)
+
+			go func() {
+				err := metricsSrv.ListenAndServe()
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					slog.Error("Failed to run the metrics server", "error", err.Error())
+					ctxCancel()
+				}
+			}()
+		}
+
+		go func() {
+			<-ctx.Done()
+
+			slog.Info("Shutting the daemon down")
+
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer shutdownCancel()
+
+			// The streaming calls never end by themselves, hence no graceful stop.
+			grpcSrv.Stop()
+			_ = srv.Shutdown(shutdownCtx)
+
+			if metricsSrv != nil {
+				_ = metricsSrv.Shutdown(shutdownCtx)
+			}
+		}()
+
+		slog.Info("Starting the daemon", "listen", daemonListenFlag, "token-file", tokenFile)
+
+		err = srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to run the API server", "error", err.Error())
+			sessions.StopAll()
+			os.Exit(1)
+		}
+
+		sessions.StopAll()
+	},
+}
+
+func checkLoopbackListenAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("the daemon can listen only on a loopback address")
+	}
+
+	return nil
+}
+
+func listDaemonDevices(getDiscovered func(passthroughArg string) ([]string, error)) ([]daemon.Device, error) {
+	paths, err := osspecifics.ListHostBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]daemon.Device, 0, len(paths))
+
+	for _, p := range paths {
+		target := "dev:" + p
+
+		discovered, err := getDiscovered(target)
+		if err != nil {
+			return nil, err
+		}
+
+		if discovered == nil {
+			discovered = []string{}
+		}
+
+		ret = append(ret, daemon.Device{
+			Path:       p,
+			Target:     target,
+			Discovered: discovered,
+		})
+	}
+
+	return ret, nil
+}
+
+var (
+	daemonListenFlag     string
+	daemonTokenFileFlag  string
+	daemonGRPCListenFlag string
+
+	daemonMetricsListenFlag string
+)
+
+func init() {
+	daemonCmd.Flags().StringVar(&daemonListenFlag, "listen", "127.0.0.1:9472", "Specifies the loopback address and port to serve the REST API on.")
+	daemonCmd.Flags().StringVar(&daemonGRPCListenFlag, "grpc-listen", "127.0.0.1:9473", "Specifies the loopback address and port to serve the gRPC API on. Empty disables the gRPC API.")
+	daemonCmd.Flags().StringVar(&daemonMetricsListenFlag, "metrics-listen", "127.0.0.1:9474", "Specifies the loopback address and port to serve the Prometheus metrics on, at /metrics. The metrics require no token. Empty disables the metrics.")
+	daemonCmd.Flags().StringVar(&daemonTokenFileFlag, "token-file", "", "Specifies the file to write the generated API token to. (default is daemon-token in the data directory)")
+}
@@ -0,0 +1,258 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	recordTypeDetached = "detached"
+
+	detachedPollInterval = 250 * time.Millisecond
+	detachedLogTailLines = 20
+)
+
+type detachedRecordData struct {
+	Name            string                  `json:"name"`
+	PID             int                     `json:"pid"`
+	LogPath         string                  `json:"log_path"`
+	CredentialsPath string                  `json:"credentials_path,omitempty"`
+	Shares          []storage.InstanceShare `json:"shares"`
+}
+
+// runDetached starts `linsk run` with the same arguments as a background
+// process that outlives the current one, waits for its file share to
+// start, and prints the share details.
+func runDetached(args []string) {
+	if len(args) == 0 {
+		slog.Error("The device must be specified to run a detached instance")
+		os.Exit(1)
+	}
+
+	if debugShellFlag || dryRunFlag {
+		slog.Error("--detach cannot be used together with --debug-shell or --dry-run")
+		os.Exit(1)
+	}
+
+	luksNonInteractive := vmRuntimeLUKSKeyFileFlag != "" || vmRuntimeLUKSPasswordFileFlag != "" || os.Getenv(luksPasswordEnv) != ""
+	if (luksFlag || getLUKSContainerDevice() != "") && !luksNonInteractive {
+		slog.Error("LUKS volumes cannot be opened in a detached instance with the password entered interactively. Please specify it with --luks-password-file, --luks-keyfile or the " + luksPasswordEnv + " environment variable.")
+		os.Exit(1)
+	}
+
+	store := createStoreOrExit()
+
+	name := instanceNameFlag
+	if name == "" {
+		var err error
+		name, err = generateInstanceName()
+		if err != nil {
+			slog.Error("Failed to generate instance name", "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	err := checkInstanceNameAvailable(store, name)
+	if err != nil {
+		slog.Error("Cannot use the instance name", "error", err.Error())
+		os.Exit(1)
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		slog.Error("Failed to get the path to the Linsk executable", "error", err.Error())
+		os.Exit(1)
+	}
+
+	logFile, err := store.CreateInstanceLog(name)
+	if err != nil {
+		slog.Error("Failed to create instance log file", "error", err.Error())
+		os.Exit(1)
+	}
+
+	defer func() { _ = logFile.Close() }()
+
+	c := exec.Command(exePath, getDetachedRunArgs(os.Args[1:], name)...)
+	c.Stdout = logFile
+	c.Stderr = logFile
+
+	if vmRuntimeLUKSPasswordFileFlag == secretFileStdin || sharePasswordFileFlag == secretFileStdin {
+		// The detached instance reads the secret from our stdin.
+		c.Stdin = os.Stdin
+	}
+	osspecifics.SetDetachedProcessCmd(c)
+
+	err = c.Start()
+	if err != nil {
+		slog.Error("Failed to start detached instance", "error", err.Error())
+		os.Exit(1)
+	}
+
+	slog.Info("Started detached instance, waiting for the file share to start", "name", name, "pid", c.Process.Pid, "log", logFile.Name())
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- c.Wait()
+	}()
+
+	ctx, ctxCancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer ctxCancel()
+
+	inst, err := waitDetachedInstanceReady(ctx, store, name, c.Process.Pid, exited)
+	if err != nil {
+		slog.Error("Detached instance failed to start", "error", err.Error(), "log", logFile.Name())
+
+		if ctx.Err() != nil {
+			err = osspecifics.InterruptProcess(c.Process.Pid)
+			if err != nil {
+				slog.Warn("Failed to interrupt the detached instance", "error", err.Error())
+			}
+		} else if !jsonOutputFlag {
+			printFileTail(logFile.Name(), detachedLogTailLines)
+		}
+
+		os.Exit(1)
+	}
+
+	if jsonOutputFlag {
+		emitJSONRecord(recordTypeDetached, detachedRecordData{
+			Name:            inst.ID,
+			PID:             inst.PID,
+			LogPath:         logFile.Name(),
+			CredentialsPath: inst.CredentialsPath,
+			Shares:          inst.Shares,
+		})
+
+		return
+	}
+
+	if inst.CredentialsPath != "" {
+		creds, err := os.ReadFile(inst.CredentialsPath)
+		if err != nil {
+			slog.Warn("Failed to read the share credentials", "error", err.Error())
+		} else {
+			fmt.Fprint(os.Stderr, string(creds))
+		}
+	} else {
+		slog.Warn("The instance did not record the share credentials, see its output with \"linsk attach\"", "name", inst.ID)
+	}
+
+	fmt.Fprintf(os.Stderr, "The instance '%v' runs in the background (PID %v).\nUse \"linsk attach %v\" to see its output and \"linsk stop %v\" to shut it down.\n", inst.ID, inst.PID, inst.ID, inst.ID)
+}
+
+// getDetachedRunArgs returns the arguments of the current `linsk run`
+// without --detach, making sure that the instance name is set.
+func getDetachedRunArgs(args []string, name string) []string {
+	var ret []string
+
+	for _, arg := range args {
+		if arg == "--detach" || strings.HasPrefix(arg, "--detach=") {
+			continue
+		}
+
+		ret = append(ret, arg)
+	}
+
+	if instanceNameFlag == "" {
+		ret = append(ret, "--name", name)
+	}
+
+	return ret
+}
+
+func generateInstanceName() (string, error) {
+	b := make([]byte, 3)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+
+	return "linsk-" + hex.EncodeToString(b), nil
+}
+
+// waitDetachedInstanceReady waits until the detached instance records
+// its file shares in the runtime registry.
+func waitDetachedInstanceReady(ctx context.Context, store *storage.Storage, name string, pid int, exited <-chan error) (*storage.Instance, error) {
+	ticker := time.NewTicker(detachedPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "interrupted")
+		case err := <-exited:
+			if err != nil {
+				return nil, errors.Wrap(err, "instance exited")
+			}
+
+			return nil, fmt.Errorf("instance exited")
+		case <-ticker.C:
+			inst, err := findRunningInstance(store, name)
+			if err != nil {
+				return nil, err
+			}
+
+			// The shares are recorded along with the credentials path, which
+			// stays empty if the credentials could not be saved.
+			if inst != nil && inst.PID == pid && len(inst.Shares) != 0 {
+				return inst, nil
+			}
+		}
+	}
+}
+
+// printFileTail prints the last lines of the file to stderr.
+func printFileTail(path string, lines int) {
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Warn("Failed to open file", "path", path, "error", err.Error())
+		return
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var tail []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tail = append(tail, scanner.Text())
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+
+	for _, line := range tail {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
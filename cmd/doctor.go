@@ -0,0 +1,317 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/spf13/cobra"
+)
+
+const (
+	doctorStatusOK   = "ok"
+	doctorStatusWarn = "warn"
+	doctorStatusFail = "fail"
+
+	// The VM image and its overlays need about that much, with a margin.
+	doctorMinFreeSpace = 2 << 30
+)
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+
+	// Fix is the suggested action for a failed check.
+	Fix string `json:"fix,omitempty"`
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the Linsk setup: QEMU installation, hardware acceleration, raw device access, data directory space, port availability, and VM image integrity.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		checks := []doctorCheck{}
+		checks = append(checks, checkDoctorQEMU()...)
+		checks = append(checks,
+			checkDoctorAcceleration(),
+			checkDoctorDeviceAccess(),
+			checkDoctorDataDirSpace(store),
+			checkDoctorPorts(),
+			checkDoctorImage(store),
+		)
+
+		failed := false
+		for _, c := range checks {
+			if c.Status == doctorStatusFail {
+				failed = true
+			}
+		}
+
+		if jsonOutputFlag {
+			err := json.NewEncoder(os.Stdout).Encode(checks)
+			if err != nil {
+				slog.Error("Failed to encode the check results", "error", err.Error())
+				os.Exit(1)
+			}
+		} else {
+			for _, c := range checks {
+				fmt.Printf("[%v] %v: %v\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+				if c.Fix != "" {
+					fmt.Printf("       Fix: %v\n", c.Fix)
+				}
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func getQEMUInstallFix() string {
+	switch {
+	case osspecifics.IsWindows():
+		return `Install QEMU from https://qemu.weilnetz.de/ (or with "winget install SoftwareFreedomConservancy.QEMU") and add its directory to PATH.`
+	case osspecifics.IsMacOS():
+		return `Install QEMU with "brew install qemu".`
+	default:
+		return `Install QEMU with your package manager, e.g. "sudo apt install qemu-system qemu-utils".`
+	}
+}
+
+func checkDoctorQEMU() []doctorCheck {
+	qemuSystemCmd, err := vm.GetQEMUSystemBaseCmd()
+	if err != nil {
+		return []doctorCheck{{Name: "QEMU", Status: doctorStatusFail, Detail: err.Error(), Fix: "Linsk does not support this CPU architecture."}}
+	}
+
+	var ret []doctorCheck
+
+	for _, bin := range []string{qemuSystemCmd, qemucli.GetImgBaseCmd()} {
+		p, err := exec.LookPath(bin)
+		if err != nil {
+			ret = append(ret, doctorCheck{Name: bin, Status: doctorStatusFail, Detail: "not found in PATH", Fix: getQEMUInstallFix()})
+			continue
+		}
+
+		ret = append(ret, doctorCheck{Name: bin, Status: doctorStatusOK, Detail: p})
+	}
+
+	if ret[0].Status != doctorStatusOK {
+		return ret
+	}
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer ctxCancel()
+
+	qemuVersion, err := qemucli.ProbeVersion(ctx, qemuSystemCmd)
+	if err != nil {
+		return append(ret, doctorCheck{Name: "QEMU version", Status: doctorStatusFail, Detail: err.Error(), Fix: "Reinstall QEMU. " + getQEMUInstallFix()})
+	}
+
+	return append(ret, doctorCheck{Name: "QEMU version", Status: doctorStatusOK, Detail: qemuVersion.String()})
+}
+
+func checkDoctorAcceleration() doctorCheck {
+	c := doctorCheck{Name: "Hardware acceleration"}
+
+	if osspecifics.IsWindows() && runtime.GOARCH == "arm64" {
+		c.Status = doctorStatusWarn
+		c.Detail = "QEMU has no hardware acceleration on Windows on ARM64, the VM is emulated"
+		return c
+	}
+
+	available, err := osspecifics.CheckHardwareAccelerationAvailable()
+	switch {
+	case err != nil:
+		c.Status = doctorStatusWarn
+		c.Detail = "failed to check: " + err.Error()
+	case available:
+		c.Status = doctorStatusOK
+		c.Detail = "available"
+		return c
+	default:
+		c.Status = doctorStatusWarn
+		c.Detail = "unavailable, the VM will be emulated and run several times slower"
+	}
+
+	switch {
+	case osspecifics.IsWindows():
+		c.Fix = `Enable "Windows Hypervisor Platform" in "Turn Windows features on or off" and reboot.`
+	case osspecifics.IsMacOS():
+		c.Fix = "HVF is unavailable in nested virtualization. Run Linsk on the host macOS."
+	default:
+		c.Fix = `Enable virtualization in the firmware settings, load the kvm module, and add your user to the kvm group ("sudo usermod -aG kvm $USER").`
+	}
+
+	return c
+}
+
+func checkDoctorDeviceAccess() doctorCheck {
+	c := doctorCheck{Name: "Raw device access"}
+
+	devs, err := osspecifics.ListHostBlockDevices()
+	if err != nil {
+		c.Status = doctorStatusWarn
+		c.Detail = "failed to list block devices: " + err.Error()
+		return c
+	}
+
+	if len(devs) == 0 {
+		c.Status = doctorStatusWarn
+		c.Detail = "no block devices found"
+		return c
+	}
+
+	var accessible []string
+
+	for _, dev := range devs {
+		f, err := os.Open(dev)
+		if err != nil {
+			continue
+		}
+
+		_ = f.Close()
+
+		accessible = append(accessible, dev)
+	}
+
+	if len(accessible) != 0 {
+		c.Status = doctorStatusOK
+		c.Detail = fmt.Sprintf("%v of %v block devices can be opened (%v)", len(accessible), len(devs), strings.Join(accessible, ", "))
+		return c
+	}
+
+	c.Status = doctorStatusWarn
+	c.Detail = fmt.Sprintf("none of the %v block devices can be opened, only disk images can be used", len(devs))
+
+	switch {
+	case osspecifics.IsWindows():
+		c.Fix = "Run Linsk from a terminal started as Administrator."
+	case osspecifics.IsMacOS():
+		c.Fix = `Run Linsk with "sudo".`
+	default:
+		c.Fix = `Run Linsk with "sudo", or add your user to the disk group ("sudo usermod -aG disk $USER").`
+	}
+
+	return c
+}
+
+func checkDoctorDataDirSpace(store *storage.Storage) doctorCheck {
+	c := doctorCheck{Name: "Data directory space"}
+
+	dataDir, err := filepath.Abs(store.DataDirPath())
+	if err != nil {
+		c.Status = doctorStatusWarn
+		c.Detail = "failed to get absolute data dir path: " + err.Error()
+		return c
+	}
+
+	usage, err := disk.Usage(dataDir)
+	if err != nil {
+		c.Status = doctorStatusWarn
+		c.Detail = "failed to get free space: " + err.Error()
+		return c
+	}
+
+	c.Detail = fmt.Sprintf("%v free in %v", humanize.IBytes(usage.Free), dataDir)
+
+	if usage.Free < doctorMinFreeSpace {
+		c.Status = doctorStatusWarn
+		c.Fix = fmt.Sprintf("Free up at least %v, or move the data directory with --data-dir (or \"data_dir\" in the config file).", humanize.IBytes(doctorMinFreeSpace))
+
+		return c
+	}
+
+	c.Status = doctorStatusOK
+
+	return c
+}
+
+func checkDoctorPorts() doctorCheck {
+	c := doctorCheck{Name: "Network share ports"}
+
+	port, err := share.FindAvailablePort()
+	if err != nil {
+		c.Status = doctorStatusFail
+		c.Detail = err.Error()
+		c.Fix = "Make sure Linsk is allowed to listen on localhost ports, e.g. by the firewall or security software."
+
+		return c
+	}
+
+	c.Status = doctorStatusOK
+	c.Detail = fmt.Sprintf("port %v is available", port)
+
+	return c
+}
+
+func checkDoctorImage(store *storage.Storage) doctorCheck {
+	c := doctorCheck{Name: "VM image"}
+
+	imagePath, err := store.CheckVMImageExists()
+	if err != nil {
+		c.Status = doctorStatusFail
+		c.Detail = err.Error()
+		return c
+	}
+
+	if imagePath == "" {
+		c.Status = doctorStatusWarn
+		c.Detail = "not built yet"
+		c.Fix = `Run "linsk build".`
+
+		return c
+	}
+
+	err = store.VerifyVMImage(imagePath)
+	if err != nil {
+		c.Status = doctorStatusFail
+		c.Detail = err.Error()
+
+		if errors.Is(err, storage.ErrImageCorrupted) {
+			c.Fix = `Rebuild the image with "linsk build --overwrite".`
+		}
+
+		return c
+	}
+
+	c.Status = doctorStatusOK
+	c.Detail = "integrity verified (" + imagePath + ")"
+
+	return c
+}
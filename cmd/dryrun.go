@@ -0,0 +1,108 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+)
+
+func printRunDryRunPlan(devName string, fsTypeOverride string, vmOpts *share.VMShareOptions) {
+	fsToPrint := "<auto>"
+	if fsTypeOverride != "" {
+		fsToPrint = fsTypeOverride
+	}
+
+	mountOptionsToPrint := "<default>"
+	if mountOptionsFlag != "" {
+		mountOptionsToPrint = mountOptionsFlag
+	}
+
+	fmt.Printf("Mount:\n  Device: %v\n  Filesystem: %v\n  LUKS: %v\n", devName, fsToPrint, luksFlag)
+	if vmRuntimeLUKSContainerDevice != "" {
+		fmt.Printf("  LUKS container: %v\n", vmRuntimeLUKSContainerDevice)
+	}
+	fmt.Printf("  Mount options: %v\n  Read-only: %v\n", mountOptionsToPrint, readOnlyFlag)
+
+	fmt.Printf("Share:\n  Backend: %v\n  Listen IP: %v\n  Net tap: %v\n", shareBackendFlag, shareListenIPFlag, vmOpts.EnableTap)
+	for _, pf := range vmOpts.Ports {
+		fmt.Printf("  Port forwarding: %v\n", formatPortForwardingRule(pf))
+	}
+}
+
+func printDryRunPlan(cfg vm.Config, vi *vm.VM, pendingDownloads []string) {
+	fmt.Printf("VM:\n  Hostname: %v\n  Memory: %v MiB\n  vCPUs: %v\n", vi.Hostname(), cfg.MemoryAlloc, vi.CPUs())
+
+	if v := vi.QEMUVersion(); !v.IsZero() {
+		fmt.Printf("  QEMU version: %v\n", v)
+	}
+
+	for _, drive := range cfg.Drives {
+		fmt.Printf("  Boot drive: %v (snapshot: %v)\n", drive.Path, drive.SnapshotMode)
+	}
+
+	if cfg.BIOSPath != "" {
+		fmt.Printf("  BIOS: %v\n", cfg.BIOSPath)
+	}
+
+	fmt.Printf("Passthrough:\n")
+
+	if len(cfg.PassthroughConfig.Block) == 0 && len(cfg.PassthroughConfig.USB) == 0 {
+		fmt.Printf("  <none>\n")
+	}
+
+	for _, dev := range cfg.PassthroughConfig.Block {
+		fmt.Printf("  Block device: %v (block size: %v, read-only: %v)\n", dev.Path, dev.BlockSize, dev.ReadOnly)
+	}
+
+	for _, dev := range cfg.PassthroughConfig.USB {
+		fmt.Printf("  USB device: %04x:%04x\n", dev.VendorID, dev.ProductID)
+	}
+
+	fmt.Printf("Networking:\n  Unrestricted: %v\n", cfg.UnrestrictedNetworking)
+
+	for _, pf := range cfg.ExtraPortForwardingRules {
+		fmt.Printf("  Port forwarding: %v\n", formatPortForwardingRule(pf))
+	}
+
+	for _, tap := range cfg.Taps {
+		fmt.Printf("  Net tap: %v (will be created at startup)\n", tap.Name)
+	}
+
+	if len(pendingDownloads) != 0 {
+		fmt.Printf("Downloads (skipped in dry run):\n")
+
+		for _, url := range pendingDownloads {
+			fmt.Printf("  %v\n", url)
+		}
+	}
+
+	fmt.Printf("QEMU command:\n  %v\n", qemucli.RenderCommandLine(vi.QEMUCommandLine()))
+}
+
+func formatPortForwardingRule(pf vm.PortForwardingRule) string {
+	hostIPStr := "*"
+	if pf.HostIP != nil {
+		hostIPStr = pf.HostIP.String()
+	}
+
+	return net.JoinHostPort(hostIPStr, fmt.Sprint(pf.HostPort)) + " -> guest:" + fmt.Sprint(pf.VMPort)
+}
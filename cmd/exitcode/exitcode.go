@@ -0,0 +1,72 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package exitcode defines the exit codes Linsk uses for the different
+// classes of failures, so that the wrapping scripts can tell them apart.
+// The codes are part of the CLI interface and are documented in the README.
+package exitcode
+
+import (
+	"context"
+	"os"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+const (
+	OK = 0
+
+	// Generic is used for all failures without a more specific code.
+	Generic = 1
+
+	// Usage is used for invalid commands, arguments and flags.
+	Usage = 2
+
+	DeviceNotFound   = 3
+	PermissionDenied = 4
+	UnlockFailed     = 5
+	VMBootTimeout    = 6
+	ShareFailed      = 7
+
+	// Aborted is used when the user declined a confirmation prompt.
+	Aborted = 8
+
+	// Interrupted is used when the operation was canceled with Ctrl+C
+	// (or SIGINT/SIGTERM) before it could complete.
+	Interrupted = 130
+)
+
+// ForError returns the exit code for the class of the error,
+// or Generic if the error does not belong to any.
+func ForError(err error) int {
+	switch {
+	case err == nil:
+		return OK
+	case errors.Is(err, vm.ErrDeviceNotFound):
+		return DeviceNotFound
+	case errors.Is(err, vm.ErrPermissionDenied), errors.Is(err, os.ErrPermission):
+		return PermissionDenied
+	case errors.Is(err, vm.ErrUnlockFailed):
+		return UnlockFailed
+	case errors.Is(err, vm.ErrBootTimeout):
+		return VMBootTimeout
+	case errors.Is(err, context.Canceled):
+		return Interrupted
+	default:
+		return Generic
+	}
+}
@@ -0,0 +1,215 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var filesCmd = &cobra.Command{
+	Use:   "files",
+	Short: "Start a VM and inspect the files on the device's file system without starting a network file share. The device is mounted read-only.",
+	Long: `Start a VM, mount the file system read-only, and inspect the files on it. Useful for checking the contents of a drive before deciding what to share or copy.
+
+The path is specified as "<device>:<path>", where the device is in the same syntax as for "linsk run" (e.g. "dev:/dev/sdb"), and the path is absolute, like "dev:/dev/sdb:/home/user".`,
+}
+
+var filesLsCmd = &cobra.Command{
+	Use:   "ls <device>:<path>",
+	Short: "List the directory contents.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runFilesCommand(cmd, args[0], func(ctx context.Context, fm *vm.FileManager, p string) error {
+			isDir, err := fm.IsDir(ctx, p)
+			if err != nil {
+				return errors.Wrap(err, "check path")
+			}
+
+			var files []vm.FileInfo
+			if isDir {
+				files, err = fm.ReadDir(ctx, p)
+				if err != nil {
+					return errors.Wrap(err, "read directory")
+				}
+			} else {
+				fi, err := fm.Stat(ctx, p)
+				if err != nil {
+					return errors.Wrap(err, "stat file")
+				}
+
+				files = []vm.FileInfo{fi}
+			}
+
+			if jsonOutputFlag {
+				if files == nil {
+					files = []vm.FileInfo{}
+				}
+
+				return json.NewEncoder(os.Stdout).Encode(files)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+			for _, fi := range files {
+				name := fi.Name
+				if fi.Mode.IsDir() {
+					name += "/"
+				}
+
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", fi.Mode, fi.Owner, fi.Group, formatFileSize(fi.Size), fi.ModTime.Format("2006-01-02 15:04"), name)
+			}
+
+			return tw.Flush()
+		}))
+	},
+}
+
+var filesCatCmd = &cobra.Command{
+	Use:   "cat <device>:<path>",
+	Short: "Print the file contents.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runFilesCommand(cmd, args[0], func(ctx context.Context, fm *vm.FileManager, p string) error {
+			isDir, err := fm.IsDir(ctx, p)
+			if err != nil {
+				return errors.Wrap(err, "check path")
+			}
+
+			if isDir {
+				return fmt.Errorf("'%v' is a directory", p)
+			}
+
+			return fm.ReadFile(ctx, p, os.Stdout)
+		}))
+	},
+}
+
+var filesStatCmd = &cobra.Command{
+	Use:   "stat <device>:<path>",
+	Short: "Print the file or directory information.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runFilesCommand(cmd, args[0], func(ctx context.Context, fm *vm.FileManager, p string) error {
+			fi, err := fm.Stat(ctx, p)
+			if err != nil {
+				return errors.Wrap(err, "stat file")
+			}
+
+			if jsonOutputFlag {
+				return json.NewEncoder(os.Stdout).Encode(fi)
+			}
+
+			fmt.Printf("Name: %v\n", fi.Name)
+			if fi.LinkTarget != "" {
+				fmt.Printf("Link target: %v\n", fi.LinkTarget)
+			}
+			fmt.Printf("Type: %v\nSize: %v (%v bytes)\nMode: %v\nOwner: %v\nGroup: %v\nModified: %v\n", getFileTypeName(fi), humanize.IBytes(uint64(fi.Size)), fi.Size, fi.Mode, fi.Owner, fi.Group, fi.ModTime.Format("2006-01-02 15:04:05 -0700"))
+
+			return nil
+		}))
+	},
+}
+
+var filesHumanReadableFlag bool
+
+func init() {
+	initVMRuntimeFlags(filesCmd.PersistentFlags())
+	initFileAccessFlags(filesCmd.PersistentFlags())
+
+	filesLsCmd.Flags().BoolVarP(&filesHumanReadableFlag, "human-readable", "H", false, "Print the file sizes in the human-readable form, like 1.5 MiB.")
+
+	filesCmd.AddCommand(filesLsCmd)
+	filesCmd.AddCommand(filesCatCmd)
+	filesCmd.AddCommand(filesStatCmd)
+}
+
+// runFilesCommand starts the VM with the device from the "<device>:<path>"
+// argument, mounts it read-only, and calls fn with the path.
+func runFilesCommand(cmd *cobra.Command, arg string, fn func(ctx context.Context, fm *vm.FileManager, p string) error) int {
+	passthroughArg, p, ok := parseVMPathArg(arg)
+	if !ok {
+		slog.Error(`Bad path. Please specify it in the "<device>:<path>" form, where the path is absolute.`, "value", arg)
+		return exitcode.Usage
+	}
+
+	// Browsing the files must have no side effects.
+	readOnlyFlag = true
+
+	configureVMRuntimeFlags()
+
+	if vmRuntimeLUKSContainerDevice != "" && !cmd.Flags().Changed("vm-device") {
+		slog.Error("Cannot use the default (entire) device with a LUKS container. Please specify the in-VM device name with --vm-device.")
+		return exitcode.Usage
+	}
+
+	return runVM(passthroughArg, func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+		err := mountForFileAccess(ctx, fm)
+		if err != nil {
+			slog.Error("Failed to mount the disk inside the VM", "error", err.Error())
+			return exitcode.ForError(err)
+		}
+
+		err = fn(ctx, fm, p)
+		if err != nil {
+			slog.Error("Failed to access the file", "path", p, "error", err.Error())
+			return 1
+		}
+
+		return 0
+	}, nil, false, false)
+}
+
+func formatFileSize(size int64) string {
+	if filesHumanReadableFlag {
+		return humanize.IBytes(uint64(size))
+	}
+
+	return fmt.Sprint(size)
+}
+
+func getFileTypeName(fi vm.FileInfo) string {
+	switch {
+	case fi.Mode.IsDir():
+		return "directory"
+	case fi.Mode.IsRegular():
+		return "regular file"
+	case fi.Mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	case fi.Mode&os.ModeCharDevice != 0:
+		return "character device"
+	case fi.Mode&os.ModeDevice != 0:
+		return "block device"
+	case fi.Mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case fi.Mode&os.ModeSocket != 0:
+		return "socket"
+	default:
+		return "unknown"
+	}
+}
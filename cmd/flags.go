@@ -17,9 +17,12 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 
+	"github.com/AlexSSD7/linsk/vm"
 	"github.com/spf13/pflag"
 )
 
@@ -45,24 +48,133 @@ func getLUKSContainerDevice() string {
 var (
 	vmRuntimeLUKSContainerFlag            string
 	vmRuntimeLUKSContainerEntireDriveFlag bool
+	vmRuntimeLUKSKeyFileFlag              string
+	vmRuntimeLUKSPasswordFileFlag         string
+	vmRuntimeLUKSHeaderFlag               string
+	vmRuntimeLUKSOffsetFlag               uint64
+	vmRuntimeLUKSKeySlotFlag              int
+	vmRuntimeExtraDevicesFlag             []string
+	vmRuntimeUSBRedirectFlag              bool
+	vmRuntimeRAIDFlag                     bool
+	vmRuntimeVeraCryptFlag                bool
+	vmRuntimeVeraCryptHiddenFlag          bool
+	vmRuntimeVeraCryptPIMFlag             uint32
+	vmRuntimePlainCryptFlag               bool
+	vmRuntimePlainCipherFlag              string
+	vmRuntimePlainHashFlag                string
+	vmRuntimePlainKeySizeFlag             uint32
+	vmRuntimePlainSkipFlag                uint64
+	imageFormatFlag                       string
+	vmRuntimeRAIDAllowDegradedFlag        bool
 
 	// These are for internal use by the initVMRuntimeFlags and configureVMRuntimeFlags functions.
 	vmRuntimeInternalAllowLUKSLowMemoryFlag bool
 
 	// These are to be initialized (set) by the initVMRuntimeFlags function.
 	vmRuntimeLUKSContainerDevice string
+	vmRuntimeLUKSPassword        []byte
 )
 
 func initVMRuntimeFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&vmRuntimeLUKSContainerFlag, "luks-container", "", `Specifies a device path (without "dev/" prefix) to preopen as a LUKS container (password will be prompted). Useful for accessing LVM partitions behind LUKS.`)
 	flags.BoolVarP(&vmRuntimeLUKSContainerEntireDriveFlag, "luks-container-entire-drive", "c", false, `Similar to --luks-container, but this assumes that the entire passed-through volume is a LUKS container (password will be prompted).`)
+	flags.StringVar(&vmRuntimeLUKSKeyFileFlag, "luks-keyfile", "", "Specifies a key file to open LUKS devices with instead of prompting for the password. The key file is transferred into the VM memory and shredded right after use.")
+	flags.StringVar(&vmRuntimeLUKSPasswordFileFlag, "luks-password-file", "", `Specifies a file to read the password to open LUKS devices with instead of prompting for it, for unattended use. Only the first line is read. The file must be accessible by its owner only. Use "-" to read the password from stdin. The password can also be set with the `+luksPasswordEnv+` environment variable.`)
+	flags.StringVar(&vmRuntimeLUKSHeaderFlag, "luks-header", "", "Specifies a detached LUKS header file to open LUKS devices with. The file is transferred into the VM memory.")
+	flags.Uint64Var(&vmRuntimeLUKSOffsetFlag, "luks-offset", 0, "Specifies the start offset of the encrypted data in 512-byte sectors. Passed to cryptsetup --offset.")
+	flags.IntVar(&vmRuntimeLUKSKeySlotFlag, "luks-key-slot", -1, "Specifies the LUKS key slot to try. All key slots are tried by default.")
+	flags.StringArrayVar(&vmRuntimeExtraDevicesFlag, "extra-device", nil, `Specifies an additional device to pass through, in the same syntax as the main one. Can be repeated. The devices appear in the VM as "vdc", "vdd", and so on. Useful for attaching all members of a RAID array.`)
+	flags.BoolVar(&vmRuntimeUSBRedirectFlag, "usb-redirect", false, `Redirect the USB devices passed with "usb:<vendor ID>,<product ID>" to the VM. By default, the disk of the USB device is attached as a much faster virtio-blk drive instead (appearing as "vdb" rather than "sda"), and the USB device is redirected only if the disk cannot be found or accessed.`)
+	flags.BoolVar(&vmRuntimeVeraCryptFlag, "veracrypt", false, "Open VeraCrypt/TrueCrypt volumes instead of LUKS ones. Applies to --luks and --luks-container (password will be prompted).")
+	flags.BoolVar(&vmRuntimeVeraCryptHiddenFlag, "veracrypt-hidden", false, "Open the hidden volume within the VeraCrypt volume. Implies --veracrypt.")
+	flags.Uint32Var(&vmRuntimeVeraCryptPIMFlag, "veracrypt-pim", 0, "Specifies the VeraCrypt Personal Iterations Multiplier (PIM) if a custom one was set. Implies --veracrypt.")
+	flags.BoolVar(&vmRuntimePlainCryptFlag, "plain-crypt", false, "Open plain (non-LUKS) dm-crypt mappings instead of LUKS ones. Applies to --luks and --luks-container (password will be prompted). As plain mode has no header, specify the parameters used to create the mapping with --plain-cipher, --plain-hash, --plain-key-size, --plain-skip and --luks-offset.")
+	flags.StringVar(&vmRuntimePlainCipherFlag, "plain-cipher", "", `Specifies the plain dm-crypt cipher (e.g. "aes-cbc-essiv:sha256"). The cryptsetup default is used if empty.`)
+	flags.StringVar(&vmRuntimePlainHashFlag, "plain-hash", "", `Specifies the plain dm-crypt passphrase hash (e.g. "ripemd160"). The cryptsetup default is used if empty.`)
+	flags.Uint32Var(&vmRuntimePlainKeySizeFlag, "plain-key-size", 0, "Specifies the plain dm-crypt key size in bits. The cryptsetup default is used if zero.")
+	flags.Uint64Var(&vmRuntimePlainSkipFlag, "plain-skip", 0, "Specifies the number of 512-byte sectors to skip at the beginning for the plain dm-crypt IV calculation.")
+	flags.StringVar(&imageFormatFlag, "image-format", "", `Specifies the format of the disk image files passed with "img:<path>" (available "qcow2", "raw", "vdi", "vmdk", "vhdx", "vpc"). Detected by the file extension by default.`)
+	flags.BoolVar(&vmRuntimeRAIDFlag, "raid", false, `Assemble the mdadm RAID arrays found on the attached devices. The resulting md devices (like "md127") can be mounted then.`)
+	flags.BoolVar(&vmRuntimeRAIDAllowDegradedFlag, "raid-allow-degraded", false, "Start RAID arrays even if some of their members are missing. Implies --raid. Writing to a degraded array is risky, consider --read-only.")
 	flags.BoolVar(&vmRuntimeInternalAllowLUKSLowMemoryFlag, "allow-luks-low-memory", false, "Allow VM memory allocation lower than 2048 MiB when LUKS is enabled.")
 }
 
+func getLUKSOptions() vm.LUKSOptions {
+	opts := vm.LUKSOptions{
+		KeyFile:  vmRuntimeLUKSKeyFileFlag,
+		Password: vmRuntimeLUKSPassword,
+		Header:   vmRuntimeLUKSHeaderFlag,
+		Offset:   vmRuntimeLUKSOffsetFlag,
+
+		VeraCrypt:       vmRuntimeVeraCryptFlag || vmRuntimeVeraCryptHiddenFlag || vmRuntimeVeraCryptPIMFlag != 0,
+		VeraCryptHidden: vmRuntimeVeraCryptHiddenFlag,
+		VeraCryptPIM:    vmRuntimeVeraCryptPIMFlag,
+	}
+
+	if vmRuntimePlainCryptFlag {
+		opts.Plain = &vm.PlainCryptOptions{
+			Cipher:  vmRuntimePlainCipherFlag,
+			Hash:    vmRuntimePlainHashFlag,
+			KeySize: vmRuntimePlainKeySizeFlag,
+			Skip:    vmRuntimePlainSkipFlag,
+		}
+	}
+
+	if vmRuntimeLUKSKeySlotFlag >= 0 {
+		keySlot := vmRuntimeLUKSKeySlotFlag
+		opts.KeySlot = &keySlot
+	}
+
+	return opts
+}
+
+// assembleRAIDIfRequested assembles the RAID arrays if --raid
+// or --raid-allow-degraded was specified.
+func assembleRAIDIfRequested(ctx context.Context, fm *vm.FileManager) error {
+	if !vmRuntimeRAIDFlag && !vmRuntimeRAIDAllowDegradedFlag {
+		return nil
+	}
+
+	slog.Info("Assembling RAID arrays", "allow-degraded", vmRuntimeRAIDAllowDegradedFlag)
+
+	mdstat, err := fm.AssembleRAID(ctx, vmRuntimeRAIDAllowDegradedFlag, readOnlyFlag)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stderr, string(mdstat))
+
+	return nil
+}
+
 func configureVMRuntimeFlags() {
 	vmRuntimeLUKSContainerDevice = getLUKSContainerDevice()
 
-	if (luksFlag || vmRuntimeLUKSContainerDevice != "") && !vmRuntimeInternalAllowLUKSLowMemoryFlag {
+	for _, path := range []string{vmRuntimeLUKSKeyFileFlag, vmRuntimeLUKSHeaderFlag} {
+		if path == "" {
+			continue
+		}
+
+		_, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Failed to stat LUKS file", "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var err error
+	vmRuntimeLUKSPassword, err = getLUKSPassword()
+	if err != nil {
+		slog.Error("Failed to get LUKS password", "error", err.Error())
+		os.Exit(1)
+	}
+
+	if vmRuntimeLUKSPassword != nil && vmRuntimeLUKSKeyFileFlag != "" {
+		slog.Error("--luks-keyfile cannot be used together with the LUKS password")
+		os.Exit(1)
+	}
+
+	if (luksFlag || fsckLUKSFlag || fileAccessLUKSFlag || vmRuntimeLUKSContainerDevice != "") && !vmRuntimeInternalAllowLUKSLowMemoryFlag {
 		if vmMemAllocFlag < defaultMemAllocLUKS {
 			if vmMemAllocFlag != defaultMemAlloc {
 				slog.Warn("Enforcing minimum LUKS memory allocation. Please add --allow-luks-low-memory to disable this.", "min", vmMemAllocFlag, "specified", vmMemAllocFlag)
@@ -0,0 +1,105 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/spf13/cobra"
+)
+
+var fsckCmd = &cobra.Command{
+	Use:               "fsck",
+	Short:             "Start a VM and check (or repair with --repair) the file system on the device. Supports ext2/3/4, btrfs, XFS, F2FS, JFS and ReiserFS.",
+	Args:              cobra.RangeArgs(1, 3),
+	ValidArgsFunction: completeVMCommandArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configureVMRuntimeFlags()
+
+		vmDevName := defaultVMMountDevName
+		if len(args) > 1 {
+			vmDevName = args[1]
+		} else if vmRuntimeLUKSContainerDevice != "" {
+			slog.Error("Cannot use the default (entire) device with a LUKS container. Please specify the in-VM device name to check as a second positional argument.")
+			os.Exit(1)
+		}
+
+		var fsTypeOverride string
+		if len(args) > 2 {
+			fsTypeOverride = args[2]
+		}
+
+		if fsckRepairFlag {
+			slog.Warn("Running in repair mode. The checker will write to the device.")
+		} else {
+			// Passing the device through read-only guarantees
+			// that checking has no side effects.
+			readOnlyFlag = true
+		}
+
+		os.Exit(runVM(args[0], func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+			err := assembleRAIDIfRequested(ctx, fm)
+			if err != nil {
+				slog.Error("Failed to assemble RAID arrays", "error", err.Error())
+				return 1
+			}
+
+			exitCode, err := fm.Fsck(ctx, vmDevName, vm.FsckConfig{
+				LUKSContainerPreopen: vmRuntimeLUKSContainerDevice,
+				LUKSOptions:          getLUKSOptions(),
+
+				FSTypeOverride: fsTypeOverride,
+				LUKS:           fsckLUKSFlag,
+				Repair:         fsckRepairFlag,
+
+				Stdout: os.Stdout,
+				Stderr: os.Stderr,
+
+				Progress: getProgressFunc(),
+			})
+			if err != nil {
+				slog.Error("Failed to run file system checker", "error", err.Error())
+				return exitcode.ForError(err)
+			}
+
+			if exitCode != 0 {
+				slog.Warn("File system checker exited with a non-zero code. Please refer to the checker output above.", "code", exitCode)
+			} else {
+				slog.Info("File system checker finished successfully")
+			}
+
+			return exitCode
+		}, nil, false, false))
+	},
+}
+
+var (
+	fsckRepairFlag bool
+	fsckLUKSFlag   bool
+)
+
+func init() {
+	initVMRuntimeFlags(fsckCmd.Flags())
+
+	fsckCmd.Flags().BoolVar(&fsckRepairFlag, "repair", false, "Repair the found problems. The file system is only checked by default, and the device is passed through read-only.")
+	fsckCmd.Flags().BoolVarP(&fsckLUKSFlag, "luks", "l", false, "Use cryptsetup to open a LUKS volume (password will be prompted).")
+}
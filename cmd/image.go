@@ -0,0 +1,243 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/imgbuilder"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Manage and inspect the VM image.",
+}
+
+var imageInspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Boot the VM image briefly and report the capabilities of the guest (supported file systems, tool versions, available space).",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runVM("", func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+			caps, err := fm.InspectCapabilities(ctx)
+			if err != nil {
+				slog.Error("Failed to inspect guest capabilities", "error", err.Error())
+				return 1
+			}
+
+			if jsonOutputFlag {
+				err = json.NewEncoder(os.Stdout).Encode(caps)
+				if err != nil {
+					slog.Error("Failed to encode guest capabilities", "error", err.Error())
+					return 1
+				}
+
+				return 0
+			}
+
+			printGuestCapabilities(caps)
+
+			return 0
+		}, nil, false, false))
+	},
+}
+
+var imageBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build the VM image locally from the Alpine base image, installing the packages and configuring the services Linsk needs.",
+	Long: `Build the VM image locally from the Alpine base image, installing the packages and configuring the services Linsk needs. ` +
+		`No prebuilt artifact is downloaded. For air-gapped environments, supply a local Alpine ISO with --base-image ` +
+		`and a local package mirror with --apk-repository.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var baseImageHash []byte
+		if imageBuildBaseImageSHA256Flag != "" {
+			if imageBuildBaseImageFlag == "" {
+				slog.Error("--base-image-sha256 requires --base-image")
+				os.Exit(1)
+			}
+
+			var err error
+			baseImageHash, err = hex.DecodeString(imageBuildBaseImageSHA256Flag)
+			if err != nil || len(baseImageHash) != sha256.Size {
+				slog.Error("Bad base image SHA-256 hash", "value", imageBuildBaseImageSHA256Flag)
+				os.Exit(1)
+			}
+		}
+
+		store := createStoreOrExit()
+
+		ctx, ctxCancel := newInterruptContext()
+		exitCode := store.RunCLIImageBuildWithOptions(ctx, storage.ImageBuildOptions{
+			ShowBuilderVMDisplay: vmDebugFlag,
+			Overwrite:            imageBuildOverwriteFlag,
+			OutPath:              imageBuildOutputFlag,
+			BaseImagePath:        imageBuildBaseImageFlag,
+			BaseImageHash:        baseImageHash,
+			Builder: imgbuilder.BuildOptions{
+				Repositories:  imageBuildAPKRepositoryFlags,
+				ExtraPackages: imageBuildExtraPackageFlags,
+			},
+		})
+		ctxCancel()
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+		outPath := imageBuildOutputFlag
+		if outPath == "" {
+			outPath = store.GetVMImagePath()
+		}
+
+		slog.Info("VM image built successfully", "path", outPath)
+	},
+}
+
+var imageUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update the VM image to the latest version supported by this Linsk binary and pin it.",
+	Long: `Update the VM image to the latest version supported by this Linsk binary and pin it. ` +
+		`Once pinned, Linsk keeps using the pinned image version even after an upgrade, until this command is run again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		pinned, err := store.GetPinnedVMImageVersion()
+		if err != nil {
+			slog.Error("Failed to get pinned VM image version", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if imageUpdatePinFlag != "" {
+			p, version, err := store.ResolveVMImage(imageUpdatePinFlag)
+			if err != nil {
+				slog.Error("Failed to resolve VM image", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if p == "" {
+				slog.Error("VM image of the specified version does not exist in the data directory", "version", version)
+				os.Exit(1)
+			}
+
+			err = store.SetPinnedVMImageVersion(version)
+			if err != nil {
+				slog.Error("Failed to pin VM image version", "error", err.Error())
+				os.Exit(1)
+			}
+
+			slog.Info("Pinned VM image version", "version", version, "previous", pinned)
+			return
+		}
+
+		latest := storage.LatestVMImageVersion()
+
+		latestPath, err := store.CheckVMImageExists()
+		if err != nil {
+			slog.Error("Failed to check whether VM image exists", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if imageUpdateCheckFlag {
+			if pinned != 0 && pinned < latest {
+				fmt.Printf("A newer VM image is available: pinned %v, latest %v.\n", pinned, latest)
+			} else if latestPath == "" {
+				fmt.Printf("VM image version %v is not built yet.\n", latest)
+			} else {
+				fmt.Printf("VM image is up to date (version %v).\n", latest)
+			}
+
+			return
+		}
+
+		if latestPath == "" {
+			slog.Info("Building the latest VM image", "version", latest)
+
+			ctx, ctxCancel := newInterruptContext()
+			exitCode := store.RunCLIImageBuild(ctx, vmDebugFlag, false)
+			ctxCancel()
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
+		}
+
+		err = store.SetPinnedVMImageVersion(latest)
+		if err != nil {
+			slog.Error("Failed to pin VM image version", "error", err.Error())
+			os.Exit(1)
+		}
+
+		slog.Info("VM image is up to date", "version", latest, "previous", pinned, "path", store.GetVMImagePath())
+	},
+}
+
+var (
+	imageUpdateCheckFlag bool
+	imageUpdatePinFlag   string
+
+	imageBuildOverwriteFlag       bool
+	imageBuildOutputFlag          string
+	imageBuildBaseImageFlag       string
+	imageBuildBaseImageSHA256Flag string
+	imageBuildAPKRepositoryFlags  []string
+	imageBuildExtraPackageFlags   []string
+)
+
+func init() {
+	imageCmd.AddCommand(imageInspectCmd)
+	imageCmd.AddCommand(imageBuildCmd)
+	imageCmd.AddCommand(imageUpdateCmd)
+
+	imageUpdateCmd.Flags().BoolVar(&imageUpdateCheckFlag, "check", false, "Only report whether a newer VM image is available.")
+	imageUpdateCmd.Flags().StringVar(&imageUpdatePinFlag, "pin", "", "Pin the specified VM image version instead of updating to the latest one.")
+
+	imageBuildCmd.Flags().BoolVar(&imageBuildOverwriteFlag, "overwrite", false, "Specifies whether the VM image should be overwritten with the build.")
+	imageBuildCmd.Flags().StringVarP(&imageBuildOutputFlag, "output", "o", "", "Write the built image to the specified path instead of the data directory. Use it later with --image-path.")
+	imageBuildCmd.Flags().StringVar(&imageBuildBaseImageFlag, "base-image", "", "Use a local Alpine Linux virt ISO instead of downloading one. It is validated against the pinned hash unless --base-image-sha256 is specified.")
+	imageBuildCmd.Flags().StringVar(&imageBuildBaseImageSHA256Flag, "base-image-sha256", "", "Specifies the expected hex-encoded SHA-256 hash of the image supplied with --base-image.")
+	imageBuildCmd.Flags().StringArrayVar(&imageBuildAPKRepositoryFlags, "apk-repository", nil, "Use the specified Alpine package repository instead of the public mirror. Can be specified multiple times.")
+	imageBuildCmd.Flags().StringArrayVar(&imageBuildExtraPackageFlags, "extra-package", nil, "Install an additional Alpine package into the image. Can be specified multiple times.")
+}
+
+func printGuestCapabilities(caps *vm.GuestCapabilities) {
+	orNotInstalled := func(s string) string {
+		if s == "" {
+			return "<not installed>"
+		}
+
+		return s
+	}
+
+	fmt.Printf("Kernel version:      %v\n", caps.KernelVersion)
+	fmt.Printf("File systems:        %v\n", strings.Join(caps.Filesystems, ", "))
+	fmt.Printf("cryptsetup:          %v\n", orNotInstalled(caps.CryptsetupVersion))
+	fmt.Printf("LVM:                 %v\n", orNotInstalled(caps.LVMVersion))
+	fmt.Printf("mdadm:               %v\n", orNotInstalled(caps.MdadmVersion))
+	fmt.Printf("Root FS space:       %v available of %v\n", humanize.IBytes(caps.RootFSAvailBytes), humanize.IBytes(caps.RootFSTotalBytes))
+}
@@ -0,0 +1,132 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+var imageExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Export the VM image in use into a single archive file, to be imported with `linsk image import` on another (possibly offline) machine.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		outPath := filepath.Clean(args[0])
+
+		f, err := os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			slog.Error("Failed to create output file", "error", err.Error(), "path", outPath)
+			os.Exit(1)
+		}
+
+		manifest, err := store.ExportImageBundle(f)
+		err = multierr.Combine(err, f.Close())
+		if err != nil {
+			_ = os.Remove(outPath)
+			slog.Error("Failed to export VM image", "error", err.Error())
+			os.Exit(1)
+		}
+
+		slog.Info("Exported VM image", "path", outPath, "files", len(manifest.Files))
+	},
+}
+
+var imageImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a VM image archive produced by `linsk image export` into the data directory. Works fully offline.",
+	Long: `Import a VM image archive produced by "linsk image export" into the data directory. Works fully offline.
+
+The BIOS image is checked against its known hash, and the signed custom images against the trusted public keys (see --image-pubkey). The locally built VM images are not signed, so the archive is trusted as-is: only import archives from sources you trust.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		inPath := filepath.Clean(args[0])
+
+		f, err := os.Open(inPath)
+		if err != nil {
+			slog.Error("Failed to open input file", "error", err.Error(), "path", inPath)
+			os.Exit(1)
+		}
+
+		defer func() { _ = f.Close() }()
+
+		manifest, err := store.ImportImageBundle(f, imageImportOverwriteFlag, imagePubKeyFlags)
+		if err != nil {
+			if errors.Is(err, storage.ErrImageAlreadyExists) {
+				slog.Error("Image already exists in the data directory. Use --overwrite to replace it")
+			} else {
+				slog.Error("Failed to import VM image", "error", err.Error())
+			}
+			os.Exit(1)
+		}
+
+		slog.Info("Imported VM image", "files", len(manifest.Files), "exported-by", manifest.LinskVersion)
+	},
+}
+
+var imageBlockIndexCmd = &cobra.Command{
+	Use:   "block-index <image>",
+	Short: "Compute the block index of an image for publishing alongside it, enabling delta downloads. Prints JSON to stdout.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(filepath.Clean(args[0]))
+		if err != nil {
+			slog.Error("Failed to open image", "error", err.Error())
+			os.Exit(1)
+		}
+
+		defer func() { _ = f.Close() }()
+
+		idx, err := storage.BuildBlockIndex(f, imageBlockIndexBlockSizeFlag)
+		if err != nil {
+			slog.Error("Failed to build block index", "error", err.Error())
+			os.Exit(1)
+		}
+
+		err = json.NewEncoder(os.Stdout).Encode(idx)
+		if err != nil {
+			slog.Error("Failed to encode block index", "error", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	imageImportOverwriteFlag     bool
+	imageBlockIndexBlockSizeFlag int
+)
+
+func init() {
+	imageCmd.AddCommand(imageExportCmd)
+	imageCmd.AddCommand(imageImportCmd)
+	imageCmd.AddCommand(imageBlockIndexCmd)
+
+	imageBlockIndexCmd.Flags().IntVar(&imageBlockIndexBlockSizeFlag, "block-size", storage.DefaultBlockIndexBlockSize, "Specifies the block size in bytes. Should match the qcow2 cluster size of the image.")
+
+	imageImportCmd.Flags().BoolVar(&imageImportOverwriteFlag, "overwrite", false, "Overwrite existing images in the data directory.")
+}
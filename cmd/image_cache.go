@@ -0,0 +1,160 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var imageListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the images and related files cached in the data directory.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		files, err := store.ListCachedFiles()
+		if err != nil {
+			slog.Error("Failed to list cached files", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if jsonOutputFlag {
+			if files == nil {
+				files = []storage.CachedFile{}
+			}
+
+			err = json.NewEncoder(os.Stdout).Encode(files)
+			if err != nil {
+				slog.Error("Failed to encode cached files", "error", err.Error())
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		printCachedFiles(files)
+	},
+}
+
+var imagePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached images that are not in use, i.e. VM images other than the latest or pinned one and leftover base images.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		candidates, err := store.PruneCachedFiles(imagePruneAllFlag, true)
+		if err != nil {
+			slog.Error("Failed to find cached files to prune", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if len(candidates) == 0 {
+			fmt.Println("Nothing to prune.")
+			return
+		}
+
+		printCachedFiles(candidates)
+
+		if imagePruneDryRunFlag {
+			return
+		}
+
+		if !imagePruneYesFlag {
+			proceed, err := promptYesNo(fmt.Sprintf("Will permanently remove %v file(s). Proceed?", len(candidates)))
+			if err != nil {
+				slog.Error("Failed to read answer", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if !proceed {
+				fmt.Fprintf(os.Stderr, "Aborted.\n")
+				os.Exit(exitcode.Aborted)
+			}
+		}
+
+		pruned, err := store.PruneCachedFiles(imagePruneAllFlag, false)
+		if err != nil {
+			slog.Error("Failed to prune cached files", "error", err.Error())
+			os.Exit(1)
+		}
+
+		var freed int64
+		for _, f := range pruned {
+			freed += f.Size
+		}
+
+		slog.Info("Pruned cached files", "count", len(pruned), "freed", humanize.Bytes(uint64(freed)))
+	},
+}
+
+var (
+	imagePruneAllFlag    bool
+	imagePruneDryRunFlag bool
+	imagePruneYesFlag    bool
+)
+
+func init() {
+	imageCmd.AddCommand(imageListCmd)
+	imageCmd.AddCommand(imagePruneCmd)
+
+	imagePruneCmd.Flags().BoolVar(&imagePruneAllFlag, "all", false, "Also remove custom images downloaded with --image-url or --image-metadata-url.")
+	imagePruneCmd.Flags().BoolVar(&imagePruneDryRunFlag, "dry-run", false, "Only list the files that would be removed.")
+	imagePruneCmd.Flags().BoolVarP(&imagePruneYesFlag, "yes", "y", false, "Do not ask for confirmation.")
+}
+
+func printCachedFiles(files []storage.CachedFile) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprint(tw, "KIND\tVERSION\tFLAVOR\tSIZE\tIN USE\tPATH\n")
+
+	var total int64
+	for _, f := range files {
+		version := "-"
+		if f.Version != 0 {
+			version = fmt.Sprint(f.Version)
+		}
+
+		flavor := f.Flavor
+		if flavor == "" {
+			flavor = "-"
+		}
+
+		inUse := "no"
+		if f.InUse {
+			inUse = "yes"
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", f.Kind, version, flavor, humanize.Bytes(uint64(f.Size)), inUse, f.Path)
+
+		total += f.Size
+	}
+
+	_ = tw.Flush()
+
+	fmt.Printf("\nTotal: %v\n", humanize.Bytes(uint64(total)))
+}
@@ -0,0 +1,157 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/config"
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Guided first-run setup. Checks prerequisites, configures defaults, and builds the VM image.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprintf(os.Stderr, "Checking prerequisites...\n")
+
+		err := checkInitPrerequisites()
+		if err != nil {
+			slog.Error("Prerequisites check failed. Please refer to the installation instructions for your OS in the README.", "error", err.Error())
+			os.Exit(1)
+		}
+
+		configPath := getConfigPathOrExit()
+
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			slog.Error("Failed to load config file", "error", err.Error(), "path", configPath)
+			os.Exit(1)
+		}
+
+		// The data dir flag holds either the explicitly specified, config file, or the default value.
+		cfg.DataDir, err = promptString("Data directory to store VM images in", dataDirFlag)
+		if err != nil {
+			slog.Error("Failed to read data directory", "error", err.Error())
+			os.Exit(1)
+		}
+
+		defaultBackend := cfg.ShareBackend
+		if defaultBackend == "" {
+			defaultBackend = share.GetDefaultBackendID()
+		}
+
+		for {
+			cfg.ShareBackend, err = promptString("Default file share backend ("+strings.Join(share.ListBackendIDs(), ", ")+")", defaultBackend)
+			if err != nil {
+				slog.Error("Failed to read share backend", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if share.GetBackend(cfg.ShareBackend) != nil {
+				break
+			}
+
+			fmt.Fprintf(os.Stderr, "Unknown file share backend '%v'.\n", cfg.ShareBackend)
+		}
+
+		err = cfg.Save(configPath)
+		if err != nil {
+			slog.Error("Failed to save config file", "error", err.Error(), "path", configPath)
+			os.Exit(1)
+		}
+
+		slog.Info("Saved configuration", "path", configPath)
+
+		store, err := storage.NewStorage(slog.With("caller", "storage"), cfg.DataDir)
+		if err != nil {
+			slog.Error("Failed to create Linsk data storage", "error", err.Error(), "data-dir", cfg.DataDir)
+			os.Exit(1)
+		}
+
+		vmImagePath, err := store.CheckVMImageExists()
+		if err != nil {
+			slog.Error("Failed to check whether VM image exists", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if vmImagePath != "" {
+			slog.Info("VM image already exists, you're all set", "path", vmImagePath)
+			return
+		}
+
+		build, err := promptYesNo("The VM image is not built yet. Download the base image and build it now?")
+		if err != nil {
+			slog.Error("Failed to read answer", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if !build {
+			fmt.Fprintf(os.Stderr, "Skipped. Please run `linsk build` before using Linsk.\n")
+			return
+		}
+
+		ctx, ctxCancel := newInterruptContext()
+		exitCode := store.RunCLIImageBuild(ctx, vmDebugFlag, false)
+		ctxCancel()
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+		slog.Info("VM image built successfully, you're all set", "path", store.GetVMImagePath())
+	},
+}
+
+func checkInitPrerequisites() error {
+	qemuSystemCmd, err := vm.GetQEMUSystemBaseCmd()
+	if err != nil {
+		return errors.Wrap(err, "get qemu system base cmd")
+	}
+
+	for _, bin := range []string{qemuSystemCmd, qemucli.GetImgBaseCmd()} {
+		p, err := exec.LookPath(bin)
+		if err != nil {
+			return errors.Wrapf(err, "look up '%v' binary", bin)
+		}
+
+		fmt.Fprintf(os.Stderr, "  [OK] %v (%v)\n", bin, p)
+	}
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer ctxCancel()
+
+	qemuVersion, err := qemucli.ProbeVersion(ctx, qemuSystemCmd)
+	if err != nil {
+		return errors.Wrap(err, "probe qemu version")
+	}
+
+	fmt.Fprintf(os.Stderr, "  [OK] QEMU version %v\n", qemuVersion)
+
+	return nil
+}
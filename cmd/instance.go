@@ -0,0 +1,186 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+// invokedCommandName is the name of the command being run, like "run" or "shell".
+var invokedCommandName string
+
+// The instance of this process in the runtime registry, if it runs a VM.
+var (
+	runtimeInstanceMu    sync.Mutex
+	runtimeInstance      *storage.Instance
+	runtimeInstanceStore *storage.Storage
+)
+
+// registerRuntimeInstance records the running VM in the runtime registry
+// for `linsk status`. The registry is informational only, so the failures
+// are logged instead of being fatal.
+func registerRuntimeInstance(store *storage.Storage, vi *vm.VM, passthroughArg string) (unregister func()) {
+	id := instanceNameFlag
+	if id == "" {
+		id = vi.Hostname()
+	}
+
+	inst := &storage.Instance{
+		ID:        id,
+		PID:       os.Getpid(),
+		Command:   invokedCommandName,
+		Target:    passthroughArg,
+		StartedAt: time.Now(),
+
+		AttachedDevices: []storage.InstanceDevice{},
+		Mounts:          []storage.InstanceMount{},
+		Shares:          []storage.InstanceShare{},
+	}
+
+	for _, dev := range vi.AttachedBlockDevices() {
+		inst.AttachedDevices = append(inst.AttachedDevices, storage.InstanceDevice{
+			DevName: dev.DevName,
+			Source:  dev.Source.Path,
+		})
+	}
+
+	err := store.SaveInstance(inst)
+	if err != nil {
+		slog.Warn("Failed to record the instance in the runtime registry", "error", err.Error())
+		return func() {}
+	}
+
+	runtimeInstanceMu.Lock()
+	runtimeInstance = inst
+	runtimeInstanceStore = store
+	runtimeInstanceMu.Unlock()
+
+	return func() {
+		runtimeInstanceMu.Lock()
+		defer runtimeInstanceMu.Unlock()
+
+		runtimeInstance = nil
+
+		err := store.RemoveInstance(inst.ID)
+		if err != nil {
+			slog.Warn("Failed to remove the instance from the runtime registry", "error", err.Error())
+		}
+
+		err = store.ReleasePortReservations()
+		if err != nil {
+			slog.Warn("Failed to release the share port reservations", "error", err.Error())
+		}
+	}
+}
+
+// getVMHostname returns the VM hostname set with --vm-hostname,
+// falling back to the instance name.
+func getVMHostname() string {
+	if vmHostnameFlag != "" {
+		return vmHostnameFlag
+	}
+
+	return instanceNameFlag
+}
+
+// checkInstanceNameAvailable checks that the instance name is valid
+// and is not used by another running instance.
+func checkInstanceNameAvailable(store *storage.Storage, name string) error {
+	if !utils.ValidateHostname(name) {
+		return fmt.Errorf("bad instance name '%v': must be a valid hostname", name)
+	}
+
+	instances, err := store.ListInstances()
+	if err != nil {
+		return errors.Wrap(err, "list running instances")
+	}
+
+	for _, inst := range instances {
+		if inst.ID == name {
+			return fmt.Errorf("an instance with the name '%v' is already running (PID %v)", name, inst.PID)
+		}
+	}
+
+	return nil
+}
+
+// updateRuntimeInstance applies fn to the runtime registry record of this
+// process, if it has one.
+func updateRuntimeInstance(fn func(store *storage.Storage, inst *storage.Instance)) {
+	runtimeInstanceMu.Lock()
+	defer runtimeInstanceMu.Unlock()
+
+	if runtimeInstance == nil {
+		return
+	}
+
+	fn(runtimeInstanceStore, runtimeInstance)
+
+	err := runtimeInstanceStore.SaveInstance(runtimeInstance)
+	if err != nil {
+		slog.Warn("Failed to update the instance in the runtime registry", "error", err.Error())
+	}
+}
+
+// recordRuntimeInstanceShares records the active shares in the runtime registry.
+// The credentials are kept in a separate file readable only by the current user.
+func recordRuntimeInstanceShares(activeShares []share.ActiveShare, creds string) {
+	updateRuntimeInstance(func(store *storage.Storage, inst *storage.Instance) {
+		inst.Shares = inst.Shares[:0]
+		for _, as := range activeShares {
+			inst.Shares = append(inst.Shares, storage.InstanceShare{
+				Backend: as.BackendID,
+				URL:     as.URL,
+			})
+		}
+
+		credsPath, err := store.SaveInstanceCredentials(inst.ID, creds)
+		if err != nil {
+			slog.Warn("Failed to save the share credentials for the runtime registry", "error", err.Error())
+			return
+		}
+
+		inst.CredentialsPath = credsPath
+	})
+}
+
+// findRunningInstance returns the running instance with the name,
+// or nil if there is none.
+func findRunningInstance(store *storage.Storage, name string) (*storage.Instance, error) {
+	instances, err := store.ListInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "list running instances")
+	}
+
+	for _, inst := range instances {
+		if inst.ID == name {
+			return &inst, nil
+		}
+	}
+
+	return nil, nil
+}
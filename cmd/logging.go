@@ -0,0 +1,243 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+const logFileMode = 0600
+
+// stderrLogWriter is where the logs printed to stderr go. The progress
+// bar takes it over while shown, so that the logs don't mix up with it.
+var stderrLogWriter = &switchableWriter{w: os.Stderr}
+
+type switchableWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *switchableWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	w := sw.w
+	sw.mu.Unlock()
+
+	return w.Write(p)
+}
+
+func (sw *switchableWriter) setOutput(w io.Writer) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.w = w
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(strings.ToUpper(s)))
+	if err != nil {
+		return 0, fmt.Errorf("bad log level '%v', expected debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+// setupLogging sets up the default logger according to --log-level,
+// --log-file and --json. The log file receives the records in JSON
+// format regardless of --json, so that it can be processed by tools.
+// The log file is rotated if --log-file-max-size is set.
+func setupLogging() {
+	level, err := parseLogLevel(logLevelFlag)
+	if err != nil {
+		slog.Error("Bad --log-level value", "error", err.Error())
+		os.Exit(1)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if jsonOutputFlag {
+		handler = &errorRecordHandler{
+			Handler: slog.NewJSONHandler(stderrLogWriter, opts),
+		}
+	} else {
+		handler = slog.NewTextHandler(stderrLogWriter, opts)
+	}
+
+	if logFileFlag != "" {
+		// The file is kept open for the lifetime of the process.
+		f, err := openRotatingFile(logFileFlag, int64(logFileMaxSizeFlag)*1024*1024, logFileMaxBackupsFlag)
+		if err != nil {
+			slog.Error("Failed to open log file", "error", err.Error(), "path", logFileFlag)
+			os.Exit(1)
+		}
+
+		handler = &multiHandler{
+			handlers: []slog.Handler{handler, slog.NewJSONHandler(f, opts)},
+		}
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+// multiHandler passes the log records to all of the handlers.
+type multiHandler struct {
+	handlers []slog.Handler
+}
+
+func (h *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, sub := range h.handlers {
+		if sub.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+
+	for _, sub := range h.handlers {
+		if sub.Enabled(ctx, r.Level) {
+			errs = append(errs, sub.Handle(ctx, r.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	ret := &multiHandler{}
+	for _, sub := range h.handlers {
+		ret.handlers = append(ret.handlers, sub.WithAttrs(attrs))
+	}
+
+	return ret
+}
+
+func (h *multiHandler) WithGroup(name string) slog.Handler {
+	ret := &multiHandler{}
+	for _, sub := range h.handlers {
+		ret.handlers = append(ret.handlers, sub.WithGroup(name))
+	}
+
+	return ret
+}
+
+// rotatingFile is the log file that is renamed to "<path>.1" once it grows
+// past the max size, with the older backups shifted to "<path>.2" and so on.
+// The file is never rotated if the max size is zero.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFileMode)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = stat.Size()
+
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the backups and starts a new file. The file is closed
+// before being renamed, as open files cannot be renamed on Windows.
+func (rf *rotatingFile) rotate() error {
+	err := rf.f.Close()
+	if err != nil {
+		return err
+	}
+
+	if rf.maxBackups <= 0 {
+		err = os.Remove(rf.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return rf.open()
+	}
+
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		err = os.Rename(fmt.Sprintf("%v.%v", rf.path, i), fmt.Sprintf("%v.%v", rf.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	err = os.Rename(rf.path, rf.path+".1")
+	if err != nil {
+		return err
+	}
+
+	return rf.open()
+}
@@ -18,41 +18,142 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
 	"github.com/AlexSSD7/linsk/share"
 	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
 var lsCmd = &cobra.Command{
-	Use:   "ls",
-	Short: "Start a VM and list all user drives within the VM. Uses lsblk command under the hood.",
-	Args:  cobra.ExactArgs(1),
+	Use:               "ls",
+	Short:             "Start a VM and list all user drives within the VM, with their file systems, encryption status, and whether they can be mounted. Uses lsblk command under the hood.",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeVMCommandArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		configureVMRuntimeFlags()
 
 		os.Exit(runVM(args[0], func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+			err := assembleRAIDIfRequested(ctx, fm)
+			if err != nil {
+				slog.Error("Failed to assemble RAID arrays", "error", err.Error())
+				return 1
+			}
+
 			if vmRuntimeLUKSContainerDevice != "" {
-				err := fm.PreopenLUKSContainer(vmRuntimeLUKSContainerDevice)
+				err := fm.PreopenLUKSContainer(ctx, vmRuntimeLUKSContainerDevice, getLUKSOptions())
 				if err != nil {
 					slog.Error("Failed to preopen LUKS container", "error", err.Error())
-					return 1
+					return exitcode.ForError(err)
 				}
 			}
 
-			lsblkOut, err := fm.Lsblk()
+			attached := i.AttachedBlockDevices()
+
+			devs, err := fm.ListBlockDevices(ctx)
 			if err != nil {
 				slog.Error("Failed to list block devices in the VM", "error", err.Error())
 				return 1
 			}
 
-			if len(lsblkOut) == 0 {
-				fmt.Printf("<empty lsblk output>\n")
+			err = createStoreOrExit().SaveDiscoveredDevices(args[0], flattenBlockDeviceNames(devs))
+			if err != nil {
+				slog.Warn("Failed to save the discovered devices for shell completion", "error", err.Error())
+			}
+
+			if lsProbeUsageFlag {
+				err := fm.ProbeUsage(ctx, devs)
+				if err != nil {
+					slog.Error("Failed to probe file system usage in the VM", "error", err.Error())
+					return 1
+				}
+			}
+
+			if jsonOutputFlag {
+				err := printLsJSON(attached, devs)
+				if err != nil {
+					slog.Error("Failed to print JSON output", "error", err.Error())
+					return 1
+				}
+
+				return 0
+			}
+
+			if len(attached) != 0 {
+				fmt.Print("Attached devices:\n")
+				for _, dev := range attached {
+					source := dev.Source.Path
+					if dev.Source.ImageFormat != "" {
+						source += " (" + string(dev.Source.ImageFormat) + " image)"
+					}
+
+					fmt.Printf("%v: %v\n", dev.DevName, source)
+				}
+
+				fmt.Print("\n")
+			}
+
+			if len(devs) == 0 {
+				fmt.Printf("<no block devices found>\n")
 			} else {
-				fmt.Print(string(lsblkOut))
+				printBlockDevices(devs)
+			}
+
+			detectedFS, err := fm.DetectExtraFilesystems(ctx)
+			if err != nil {
+				slog.Error("Failed to detect file systems in the VM", "error", err.Error())
+				return 1
+			}
+
+			if len(detectedFS) != 0 {
+				fmt.Print("\nDetected file systems with dedicated tooling:\n")
+				for _, dfs := range detectedFS {
+					repairCmd := dfs.Tooling.RepairCmd
+					if repairCmd == "" {
+						repairCmd = "<none>"
+					}
+
+					fmt.Printf("%v: %v (package %v, repair utility %v)\n", dfs.DevName, dfs.Tooling.FSType, dfs.Tooling.Package, repairCmd)
+				}
+			}
+
+			if !vmRuntimeRAIDFlag && !vmRuntimeRAIDAllowDegradedFlag {
+				raidOut, err := fm.ScanRAID(ctx)
+				if err != nil {
+					slog.Error("Failed to scan for RAID arrays in the VM", "error", err.Error())
+					return 1
+				}
+
+				if len(raidOut) != 0 {
+					fmt.Print("\nDetected RAID arrays (use --raid to assemble):\n" + string(raidOut))
+				}
+			}
+
+			if lsSubvolsFlag {
+				subvolsOut, err := fm.ListBtrfsSubvolumes(ctx)
+				if err != nil {
+					slog.Error("Failed to list btrfs subvolumes in the VM", "error", err.Error())
+					return 1
+				}
+
+				fmt.Print("\n" + string(subvolsOut))
+			}
+
+			if lsLVMFlag {
+				lvsOut, err := fm.ListLVs(ctx)
+				if err != nil {
+					slog.Error("Failed to list LVM logical volumes in the VM", "error", err.Error())
+					return 1
+				}
+
+				fmt.Print("\n" + string(lvsOut))
 			}
 
 			return 0
@@ -60,6 +161,91 @@ var lsCmd = &cobra.Command{
 	},
 }
 
+var (
+	lsLVMFlag        bool
+	lsSubvolsFlag    bool
+	lsProbeUsageFlag bool
+)
+
 func init() {
+	lsCmd.Flags().BoolVar(&lsLVMFlag, "lvm", false, "Also list LVM logical volumes, including snapshots and thin pools.")
+	lsCmd.Flags().BoolVar(&lsSubvolsFlag, "subvols", false, "Also list the subvolumes of btrfs file systems.")
+	lsCmd.Flags().BoolVar(&lsProbeUsageFlag, "probe-usage", false, "Report the used and free space of the file systems. Every file system is briefly mounted read-only (without a journal replay) for that.")
 	initVMRuntimeFlags(lsCmd.Flags())
 }
+
+type lsJSONAttachedDevice struct {
+	DevName     string `json:"dev_name"`
+	Source      string `json:"source"`
+	ImageFormat string `json:"image_format,omitempty"`
+}
+
+func printLsJSON(attached []vm.AttachedBlockDevice, devs []vm.BlockDevice) error {
+	out := struct {
+		AttachedDevices []lsJSONAttachedDevice `json:"attached_devices"`
+		BlockDevices    []vm.BlockDevice       `json:"block_devices"`
+	}{
+		AttachedDevices: []lsJSONAttachedDevice{},
+		BlockDevices:    devs,
+	}
+
+	if out.BlockDevices == nil {
+		out.BlockDevices = []vm.BlockDevice{}
+	}
+
+	for _, dev := range attached {
+		out.AttachedDevices = append(out.AttachedDevices, lsJSONAttachedDevice{
+			DevName:     dev.DevName,
+			Source:      dev.Source.Path,
+			ImageFormat: string(dev.Source.ImageFormat),
+		})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(out)
+}
+
+func printBlockDevices(devs []vm.BlockDevice) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprint(tw, "NAME\tSIZE\tUSED\tFREE\tFSTYPE\tLABEL\tUUID\tENCRYPTION\tKIND\tNOTE\n")
+
+	var printTree func(devs []vm.BlockDevice, depth int)
+	printTree = func(devs []vm.BlockDevice, depth int) {
+		for i := range devs {
+			dev := &devs[i]
+
+			name := dev.Name
+			if depth != 0 {
+				name = strings.Repeat("  ", depth-1) + "└─" + name
+			}
+
+			c := dev.Classification
+
+			used, free := "-", "-"
+			if dev.UsedBytes != nil && dev.FreeBytes != nil {
+				used, free = humanize.IBytes(*dev.UsedBytes), humanize.IBytes(*dev.FreeBytes)
+			}
+
+			encryption := dev.Encryption
+			if encryption == "" {
+				encryption = "-"
+			}
+
+			note := c.Hint
+			if !c.Mountable {
+				note = "not mountable: " + note
+			}
+
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, humanize.IBytes(dev.Size), used, free, dev.FSType, dev.Label, dev.UUID, encryption, c.Kind, note)
+
+			printTree(dev.Children, depth+1)
+		}
+	}
+
+	printTree(devs, 0)
+
+	_ = tw.Flush()
+}
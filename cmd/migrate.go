@@ -0,0 +1,102 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/config"
+	"github.com/spf13/cobra"
+)
+
+var migrateDataCmd = &cobra.Command{
+	Use:   "migrate-data <new-dir>",
+	Short: "Move the data directory (VM images, overlays, etc.) to another location and make it the default.",
+	Long: `Move the data directory (VM images, overlays, etc.) to another location and make it the default. ` +
+		`Useful if the drive holding the home directory is too small for VM images. Make sure no Linsk instances are running.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		oldDir := store.DataDirPath()
+
+		if !migrateDataYesFlag {
+			proceed, err := promptYesNo(fmt.Sprintf("Will move '%v' to '%v'. Proceed?", oldDir, args[0]))
+			if err != nil {
+				slog.Error("Failed to read answer", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if !proceed {
+				fmt.Fprintf(os.Stderr, "Aborted.\n")
+				os.Exit(exitcode.Aborted)
+			}
+		}
+
+		ctx, ctxCancel := newInterruptContext()
+		err := store.MigrateTo(ctx, args[0])
+		ctxCancel()
+		if err != nil {
+			slog.Error("Failed to migrate data directory", "error", err.Error())
+			os.Exit(exitcode.ForError(err))
+		}
+
+		newDir := store.DataDirPath()
+
+		slog.Info("Migrated data directory", "from", oldDir, "to", newDir)
+
+		if migrateDataNoConfigFlag {
+			slog.Info("Config file was not updated. Use --data-dir or set " + dataDirEnv + " to use the new data directory")
+			return
+		}
+
+		configPath := getConfigPathOrExit()
+
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			slog.Error("Failed to load config file", "error", err.Error(), "path", configPath)
+			os.Exit(1)
+		}
+
+		cfg.DataDir = newDir
+
+		err = cfg.Save(configPath)
+		if err != nil {
+			slog.Error("Failed to save config file", "error", err.Error(), "path", configPath)
+			os.Exit(1)
+		}
+
+		slog.Info("Updated the default data directory in the config file", "path", configPath)
+
+		if os.Getenv(dataDirEnv) != "" {
+			slog.Warn(dataDirEnv + " is set and takes precedence over the config file. Update it to point to the new data directory")
+		}
+	},
+}
+
+var (
+	migrateDataYesFlag      bool
+	migrateDataNoConfigFlag bool
+)
+
+func init() {
+	migrateDataCmd.Flags().BoolVarP(&migrateDataYesFlag, "yes", "y", false, "Do not ask for confirmation.")
+	migrateDataCmd.Flags().BoolVar(&migrateDataNoConfigFlag, "no-config-update", false, "Do not set the new data directory as the default in the config file.")
+}
@@ -0,0 +1,127 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/spf13/cobra"
+)
+
+var nbdCmd = &cobra.Command{
+	Use:               "nbd",
+	Short:             "Start a VM and export the raw block device over NBD. Useful for running forensic or imaging tools on the host.",
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: completeVMCommandArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configureVMRuntimeFlags()
+
+		vmDevName := defaultVMMountDevName
+		if len(args) > 1 {
+			vmDevName = args[1]
+		}
+
+		listenIP := net.ParseIP(nbdListenIPFlag)
+		if listenIP == nil {
+			slog.Error("Invalid NBD listen IP", "value", nbdListenIPFlag)
+			os.Exit(1)
+		}
+
+		// NBD has no authentication, anyone who can reach the port can access the device.
+		if !listenIP.IsLoopback() {
+			if !nbdAllowRemoteFlag {
+				slog.Error("Refusing to export the device over NBD on a non-loopback IP, as anyone on the network could access it. Use --nbd-allow-remote to override", "listen-ip", listenIP)
+				os.Exit(1)
+			}
+
+			if nbdWritableFlag {
+				slog.Warn("The NBD export has no authentication and is served in read-write mode on a non-loopback IP. Anyone who can reach it can modify the device", "listen-ip", listenIP)
+			} else {
+				slog.Warn("The NBD export has no authentication and is served on a non-loopback IP. Anyone who can reach it can read the device", "listen-ip", listenIP)
+			}
+		}
+
+		ports := []vm.PortForwardingRule{{
+			HostIP:   listenIP,
+			HostPort: nbdPortFlag,
+			VMPort:   vm.NBDPort,
+		}}
+
+		os.Exit(runVM(args[0], func(ctx context.Context, i *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+			err := assembleRAIDIfRequested(ctx, fm)
+			if err != nil {
+				slog.Error("Failed to assemble RAID arrays", "error", err.Error())
+				return 1
+			}
+
+			if vmRuntimeLUKSContainerDevice != "" {
+				err := fm.PreopenLUKSContainer(ctx, vmRuntimeLUKSContainerDevice, getLUKSOptions())
+				if err != nil {
+					slog.Error("Failed to preopen LUKS container", "error", err.Error())
+					return exitcode.ForError(err)
+				}
+			}
+
+			if nbdWritableFlag {
+				slog.Warn("Exporting the device in read-write mode. Any writes from the host will be applied to the device directly.")
+			}
+
+			vmDevName, err := fm.ResolveDevName(ctx, vmDevName)
+			if err != nil {
+				slog.Error("Failed to resolve device", "error", err.Error())
+				return exitcode.ForError(err)
+			}
+
+			err = fm.StartNBD(ctx, vmDevName, !nbdWritableFlag)
+			if err != nil {
+				slog.Error("Failed to start NBD server", "error", err.Error())
+				return 1
+			}
+
+			nbdURI := "nbd://" + net.JoinHostPort(listenIP.String(), fmt.Sprint(nbdPortFlag)) + "/" + vm.NBDExportName
+
+			fmt.Fprintf(os.Stderr, "===========================\n[NBD Export]\nThe device '%v' is exported over NBD (read-only: %v).\n\nURL: %v\nExport name: %v\n===========================\n", vmDevName, !nbdWritableFlag, nbdURI, vm.NBDExportName)
+
+			<-ctx.Done()
+
+			return 0
+		}, ports, false, false))
+	},
+}
+
+var (
+	nbdListenIPFlag    string
+	nbdPortFlag        uint16
+	nbdWritableFlag    bool
+	nbdAllowRemoteFlag bool
+)
+
+func init() {
+	initVMRuntimeFlags(nbdCmd.Flags())
+
+	nbdCmd.Flags().StringVar(&nbdListenIPFlag, "nbd-listen", share.GetDefaultListenIPStr(), "Specifies the IP to bind the NBD port to.")
+	nbdCmd.Flags().Uint16Var(&nbdPortFlag, "nbd-port", vm.NBDPort, "Specifies the host port to bind the NBD server to.")
+	nbdCmd.Flags().BoolVar(&nbdAllowRemoteFlag, "nbd-allow-remote", false, "Allow binding the NBD port to a non-loopback IP with --nbd-listen. NBD has no authentication, so anyone who can reach the port can access the device.")
+	nbdCmd.Flags().BoolVar(&nbdWritableFlag, "writable", false, "Export the device in read-write mode. The export is read-only by default.")
+}
@@ -0,0 +1,252 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/vm"
+)
+
+// In JSON output mode, stdout carries machine-readable records (one JSON
+// object per line) and stderr carries the logs in JSON format.
+const (
+	recordTypeShare = "share"
+	recordTypeReady = "ready"
+	recordTypeError = "error"
+
+	// The boot, command and traffic records feed the daemon metrics.
+	recordTypeBoot    = "boot"
+	recordTypeCommand = "command"
+	recordTypeTraffic = "traffic"
+
+	// The health records feed the daemon readiness checks.
+	recordTypeHealth = "health"
+)
+
+const trafficRecordInterval = time.Second * 10
+
+type outputRecord struct {
+	Type string `json:"type"`
+	Data any    `json:"data,omitempty"`
+}
+
+type errorRecordData struct {
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+var outputMu sync.Mutex
+
+func emitJSONRecord(recordType string, data any) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	// Nothing can be done if stdout is broken, hence the ignored error.
+	_ = json.NewEncoder(os.Stdout).Encode(outputRecord{
+		Type: recordType,
+		Data: data,
+	})
+}
+
+// errorRecordHandler emits an "error" record to stdout for every error log.
+type errorRecordHandler struct {
+	slog.Handler
+}
+
+func (h *errorRecordHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		data := errorRecordData{
+			Message: r.Message,
+		}
+
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "error" {
+				data.Error = a.Value.String()
+				return false
+			}
+
+			return true
+		})
+
+		emitJSONRecord(recordTypeError, data)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *errorRecordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &errorRecordHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *errorRecordHandler) WithGroup(name string) slog.Handler {
+	return &errorRecordHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type shareRecordData struct {
+	Session  string `json:"session"`
+	Backend  string `json:"backend"`
+	URL      string `json:"url"`
+	ReadOnly bool   `json:"read_only"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// emitShareRecords emits a "share" record for every active share,
+// followed by a "ready" record.
+func emitShareRecords(session string, readOnly bool, activeShares []share.ActiveShare) {
+	for _, as := range activeShares {
+		emitJSONRecord(recordTypeShare, shareRecordData{
+			Session:  session,
+			Backend:  as.BackendID,
+			URL:      as.URL,
+			ReadOnly: readOnly,
+			Username: as.Username,
+			Password: as.Password,
+		})
+	}
+
+	emitJSONRecord(recordTypeReady, nil)
+}
+
+type bootRecordData struct {
+	Seconds float64 `json:"seconds"`
+}
+
+type commandRecordData struct {
+	Command string  `json:"command"`
+	Seconds float64 `json:"seconds"`
+	Failed  bool    `json:"failed,omitempty"`
+}
+
+// trafficRecordData holds the VM network traffic counters since the
+// boot. Sent is mostly the data read from the file share, and received
+// is mostly the data written to it.
+type trafficRecordData struct {
+	SentBytes     uint64 `json:"sent_bytes"`
+	ReceivedBytes uint64 `json:"received_bytes"`
+}
+
+type healthRecordData struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// emitHealthRecord emits a "health" record on every share health change.
+func emitHealthRecord(backendID string, err error) {
+	data := healthRecordData{
+		Backend: backendID,
+		Healthy: err == nil,
+	}
+
+	if err != nil {
+		data.Error = err.Error()
+	}
+
+	emitJSONRecord(recordTypeHealth, data)
+}
+
+// commandRecordRunner emits a "command" record with
+// the run time of every guest command it runs.
+type commandRecordRunner struct {
+	sshutil.CommandRunner
+}
+
+func (r *commandRecordRunner) Run(ctx context.Context, cmd sshutil.Command) ([]byte, error) {
+	start := time.Now()
+
+	out, err := r.CommandRunner.Run(ctx, cmd)
+
+	emitJSONRecord(recordTypeCommand, commandRecordData{
+		Command: guestCommandName(cmd.Cmd),
+		Seconds: time.Since(start).Seconds(),
+		Failed:  err != nil,
+	})
+
+	return out, err
+}
+
+// guestCommandName returns the name of the program the shell command
+// starts with. The arguments are left out as they may carry secrets.
+func guestCommandName(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return path.Base(fields[0])
+}
+
+// startTrafficRecords emits a "traffic" record periodically until the
+// returned function is called, which emits the last one.
+func startTrafficRecords(ctx context.Context, vi *vm.VM) func() {
+	ctx, ctxCancel := context.WithCancel(ctx)
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(trafficRecordInterval):
+				emitTrafficRecord(ctx, vi)
+			}
+		}
+	}()
+
+	return func() {
+		ctxCancel()
+		<-doneCh
+
+		// The VM may be shutting down already.
+		lastCtx, lastCtxCancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer lastCtxCancel()
+
+		emitTrafficRecord(lastCtx, vi)
+	}
+}
+
+func emitTrafficRecord(ctx context.Context, vi *vm.VM) {
+	stats, err := vi.GuestNetStats(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Debug("Failed to get VM network stats", "error", err.Error())
+		}
+
+		return
+	}
+
+	var data trafficRecordData
+	for _, st := range stats {
+		data.SentBytes += st.TxBytes
+		data.ReceivedBytes += st.RxBytes
+	}
+
+	emitJSONRecord(recordTypeTraffic, data)
+}
@@ -0,0 +1,216 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// The maximum number of items shown at once, the list scrolls past that.
+const pickerMaxVisibleItems = 15
+
+var errPickerCanceled = errors.New("canceled by user")
+
+type pickerItem struct {
+	Label string
+
+	// Dimmed items are shown in grey, but can still be picked.
+	Dimmed bool
+}
+
+// canUsePicker reports whether the interactive picker can be shown,
+// i.e. both the input and the output are attached to a terminal.
+func canUsePicker() bool {
+	return !jsonOutputFlag && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// pickItem shows the items in the terminal and lets the user choose one
+// with the arrow keys. The index of the chosen item is returned.
+func pickItem(title string, items []pickerItem) (int, error) {
+	if len(items) == 0 {
+		return 0, fmt.Errorf("nothing to choose from")
+	}
+
+	err := osspecifics.EnableTerminalEscapes(os.Stderr)
+	if err != nil {
+		return 0, errors.Wrap(err, "enable terminal escapes")
+	}
+
+	stdinFD := int(os.Stdin.Fd())
+
+	termState, err := term.MakeRaw(stdinFD)
+	if err != nil {
+		return 0, errors.Wrap(err, "make terminal raw")
+	}
+
+	defer func() {
+		_ = term.Restore(stdinFD, termState)
+	}()
+
+	visible := min(len(items), pickerMaxVisibleItems)
+	selected, offset := 0, 0
+
+	render := func(redraw bool) {
+		sb := new(strings.Builder)
+
+		if redraw {
+			// Moving up to the first item line.
+			fmt.Fprintf(sb, "\x1b[%dA", visible)
+		} else {
+			fmt.Fprintf(sb, "%v (arrow keys to move, Enter to choose, q to cancel)\r\n", title)
+		}
+
+		for i := offset; i < offset+visible; i++ {
+			sb.WriteString("\r\x1b[2K")
+
+			switch {
+			case i == selected:
+				sb.WriteString("\x1b[7m> " + items[i].Label + "\x1b[0m")
+			case items[i].Dimmed:
+				sb.WriteString("\x1b[2m  " + items[i].Label + "\x1b[0m")
+			default:
+				sb.WriteString("  " + items[i].Label)
+			}
+
+			sb.WriteString("\r\n")
+		}
+
+		fmt.Fprint(os.Stderr, sb.String())
+	}
+
+	render(false)
+
+	buf := make([]byte, 16)
+
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return 0, errors.Wrap(err, "read key")
+		}
+
+		switch key := string(buf[:n]); key {
+		case "\x1b[A", "\x1bOA", "k":
+			if selected > 0 {
+				selected--
+			}
+		case "\x1b[B", "\x1bOB", "j":
+			if selected < len(items)-1 {
+				selected++
+			}
+		case "\x1b[5~":
+			selected = max(selected-visible, 0)
+		case "\x1b[6~":
+			selected = min(selected+visible, len(items)-1)
+		case "\r", "\n":
+			return selected, nil
+		case "q", "\x1b", "\x03", "\x04":
+			return 0, errPickerCanceled
+		default:
+			continue
+		}
+
+		if selected < offset {
+			offset = selected
+		} else if selected >= offset+visible {
+			offset = selected - visible + 1
+		}
+
+		render(true)
+	}
+}
+
+// pickHostDevice lets the user choose one of the disks attached to the host.
+// The passthrough argument for the chosen disk is returned.
+func pickHostDevice() (string, error) {
+	devs, err := osspecifics.ListHostBlockDevices()
+	if err != nil {
+		return "", errors.Wrap(err, "list host block devices")
+	}
+
+	if len(devs) == 0 {
+		return "", fmt.Errorf("no block devices were found on the host")
+	}
+
+	items := make([]pickerItem, 0, len(devs))
+	for _, dev := range devs {
+		items = append(items, pickerItem{Label: dev})
+	}
+
+	i, err := pickItem("Choose the disk to pass through to the VM", items)
+	if err != nil {
+		return "", err
+	}
+
+	return "dev:" + devs[i], nil
+}
+
+// pickVMDevice lets the user choose one of the block devices found in the VM,
+// showing them as a tree with their sizes, file systems and labels.
+func pickVMDevice(devs []vm.BlockDevice) (string, error) {
+	var flat []*vm.BlockDevice
+	var items []pickerItem
+
+	var walk func(devs []vm.BlockDevice, depth int)
+	walk = func(devs []vm.BlockDevice, depth int) {
+		for i := range devs {
+			dev := &devs[i]
+
+			flat = append(flat, dev)
+			items = append(items, pickerItem{
+				Label:  strings.Repeat("  ", depth) + formatPickerBlockDevice(dev),
+				Dimmed: !dev.Classification.Mountable,
+			})
+
+			walk(dev.Children, depth+1)
+		}
+	}
+
+	walk(devs, 0)
+
+	i, err := pickItem("Choose the device to mount", items)
+	if err != nil {
+		return "", err
+	}
+
+	return flat[i].Name, nil
+}
+
+func formatPickerBlockDevice(dev *vm.BlockDevice) string {
+	details := []string{humanize.IBytes(dev.Size), string(dev.Classification.Kind)}
+
+	if dev.FSType != "" {
+		details = append(details, dev.FSType)
+	}
+
+	if dev.Label != "" {
+		details = append(details, fmt.Sprintf("label %q", dev.Label))
+	}
+
+	if !dev.Classification.Mountable && dev.Classification.Hint != "" {
+		details = append(details, "not mountable: "+dev.Classification.Hint)
+	}
+
+	return dev.Name + " (" + strings.Join(details, ", ") + ")"
+}
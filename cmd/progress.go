@@ -0,0 +1,212 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexSSD7/linsk/progress"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/term"
+)
+
+const (
+	progressModeAuto = "auto"
+	progressModeBar  = "bar"
+	progressModeJSON = "json"
+	progressModeLog  = "log"
+	progressModeNone = "none"
+
+	recordTypeProgress = "progress"
+
+	progressBarWidth          = 30
+	progressBarRedrawInterval = time.Millisecond * 100
+	progressJSONInterval      = time.Millisecond * 500
+)
+
+var (
+	progressFuncOnce sync.Once
+	progressFunc     progress.Func
+)
+
+// getProgressFunc returns the progress reporter selected with --progress.
+// A nil function is returned in the log mode, which makes the operations
+// fall back to logging their progress.
+func getProgressFunc() progress.Func {
+	progressFuncOnce.Do(func() {
+		mode := progressFlag
+		if mode == progressModeAuto {
+			switch {
+			case jsonOutputFlag:
+				mode = progressModeJSON
+			case term.IsTerminal(int(os.Stderr.Fd())):
+				mode = progressModeBar
+			default:
+				mode = progressModeLog
+			}
+		}
+
+		switch mode {
+		case progressModeBar:
+			bar := &progressBar{w: os.Stderr}
+
+			// The logs are written to stderr too. The bar is cleared before
+			// every log line and redrawn after, so that they don't mix up.
+			stderrLogWriter.setOutput(bar)
+
+			progressFunc = throttleProgress(bar.draw, progressBarRedrawInterval)
+		case progressModeJSON:
+			progressFunc = throttleProgress(func(u progress.Update) {
+				emitJSONRecord(recordTypeProgress, u)
+			}, progressJSONInterval)
+		case progressModeLog:
+			progressFunc = nil
+		case progressModeNone:
+			progressFunc = func(progress.Update) {}
+		default:
+			slog.Error("Bad --progress value", "value", progressFlag)
+			os.Exit(1)
+		}
+	})
+
+	return progressFunc
+}
+
+// throttleProgress drops the updates that come in more often than the interval.
+// The updates that start a new operation or complete one always pass through.
+func throttleProgress(fn progress.Func, interval time.Duration) progress.Func {
+	var mu sync.Mutex
+	var last progress.Update
+	var lastTime time.Time
+
+	return func(u progress.Update) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sameOp := u.Op == last.Op && u.Stage == last.Stage && u.Name == last.Name
+		complete := u.Total > 0 && u.Done >= u.Total
+		if sameOp && !complete && time.Since(lastTime) < interval {
+			return
+		}
+
+		last = u
+		lastTime = time.Now()
+
+		fn(u)
+	}
+}
+
+// progressBar renders the progress updates as a single, constantly
+// redrawn line. ANSI escape sequences are avoided on purpose, as they
+// are not supported by all Windows terminals.
+type progressBar struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	line     string
+	lastOp   progress.Update
+	drawnLen int
+}
+
+func (b *progressBar) draw(u progress.Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.line != "" && (u.Op != b.lastOp.Op || u.Name != b.lastOp.Name) {
+		// A different operation has started, keep the previous one's final state.
+		_, _ = fmt.Fprint(b.w, "\n")
+		b.drawnLen = 0
+	}
+
+	b.lastOp = u
+	b.line = formatProgressLine(u)
+	b.redraw()
+
+	if u.Total > 0 && u.Done >= u.Total {
+		_, _ = fmt.Fprint(b.w, "\n")
+		b.line = ""
+		b.drawnLen = 0
+	}
+}
+
+func (b *progressBar) redraw() {
+	pad := ""
+	if b.drawnLen > len(b.line) {
+		pad = strings.Repeat(" ", b.drawnLen-len(b.line))
+	}
+
+	_, _ = fmt.Fprint(b.w, "\r"+b.line+pad)
+	b.drawnLen = len(b.line)
+}
+
+func (b *progressBar) clear() {
+	if b.drawnLen != 0 {
+		_, _ = fmt.Fprint(b.w, "\r"+strings.Repeat(" ", b.drawnLen)+"\r")
+		b.drawnLen = 0
+	}
+}
+
+// Write writes a log line, keeping the bar below it.
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clear()
+
+	n, err := b.w.Write(p)
+
+	if b.line != "" {
+		b.redraw()
+	}
+
+	return n, err
+}
+
+func formatProgressLine(u progress.Update) string {
+	label := u.Op
+	if u.Name != "" {
+		label += " " + u.Name
+	}
+
+	if u.Stage != "" {
+		label += " (" + u.Stage + ")"
+	}
+
+	formatAmount := func(v int64) string {
+		if u.Unit == progress.UnitBytes {
+			return humanize.Bytes(uint64(v))
+		}
+
+		return fmt.Sprint(v)
+	}
+
+	percent := u.Percent()
+	if percent < 0 {
+		return label + " " + formatAmount(u.Done)
+	}
+
+	filled := int(percent / 100 * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	return fmt.Sprintf("%v [%v] %5.1f%% %v/%v", label, bar, percent, formatAmount(u.Done), formatAmount(u.Total))
+}
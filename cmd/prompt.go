@@ -0,0 +1,58 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func promptString(question string, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(os.Stderr, "%v [%v] > ", question, defaultValue)
+	} else {
+		fmt.Fprintf(os.Stderr, "%v > ", question)
+	}
+
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "read answer")
+	}
+
+	answer = strings.TrimSpace(utils.ClearUnprintableChars(answer, false))
+	if answer == "" {
+		return defaultValue, nil
+	}
+
+	return answer, nil
+}
+
+func promptYesNo(question string) (bool, error) {
+	answer, err := promptString(question+" (y/n)", "")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.ToLower(answer) == "y", nil
+}
@@ -19,11 +19,12 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"log/slog"
 
-	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
 	"github.com/spf13/cobra"
 )
 
@@ -34,12 +35,19 @@ var rootCmd = &cobra.Command{
 		`to no support for Linux's wide range of file systems, mainly aiming macOS and Windows. Linsk does not reimplement any file system. Instead, Linsk ` +
 		`utilizes a lightweight Alpine Linux VM to tap into the native Linux software ecosystem. The files are then exposed to the host via fast and widely-supported FTP, ` +
 		`operating at near-hardware speeds.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		invokedCommandName = cmd.Name()
+
+		setupLogging()
+
+		applyUserConfig(cmd)
+	},
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitcode.Usage)
 	}
 }
 
@@ -47,9 +55,35 @@ var (
 	vmDebugFlag                bool
 	unrestrictedNetworkingFlag bool
 	vmMemAllocFlag             uint32
+	vmCPUsFlag                 uint32
 	vmSSHSetupTimeoutFlag      uint32
 	vmOSUpTimeoutFlag          uint32
+	vmHostnameFlag             string
+	autoCleanFlag              bool
+	printQEMUCmdFlag           bool
 	dataDirFlag                string
+	imagePathFlag              string
+	imageURLFlag               string
+	imageSHA256Flag            string
+	imageMetadataURLFlag       string
+	imagePubKeyFlags           []string
+	imageVersionFlag           string
+	offlineFlag                bool
+	imageFlavorFlag            string
+	persistVMFlag              bool
+	skipImageCheckFlag         bool
+	vmAccelFlag                string
+	vmDriveCacheFlag           string
+	vmDriveAIOFlag             string
+	alpineMirrorFlags          []string
+	jsonOutputFlag             bool
+	logLevelFlag               string
+	logFileFlag                string
+	logFileMaxSizeFlag         uint32
+	logFileMaxBackupsFlag      int
+	configPathFlag             string
+	progressFlag               string
+	instanceNameFlag           string
 )
 
 const (
@@ -60,33 +94,73 @@ const (
 func init() {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
 
+	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(lsCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(nbdCmd)
+	rootCmd.AddCommand(fsckCmd)
 	rootCmd.AddCommand(shellCmd)
+	rootCmd.AddCommand(cpCmd)
+	rootCmd.AddCommand(filesCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(attachCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(doctorCmd)
 	rootCmd.AddCommand(cleanCmd)
 	rootCmd.AddCommand(buildCmd)
+	rootCmd.AddCommand(imageCmd)
+	rootCmd.AddCommand(vmCmd)
+	rootCmd.AddCommand(migrateDataCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(serviceCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(copyrightCmd)
 
+	rootCmd.PersistentFlags().StringVar(&configPathFlag, "config", "", "Specifies the config file to read the defaults from. The CLI flags take precedence over the config file values. (default is OS-specific, e.g. ~/.config/linsk/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutputFlag, "json", false, "Print machine-readable output. Command results and events are printed to stdout as JSON objects (one per line), and logs are printed to stderr in JSON format.")
+	rootCmd.PersistentFlags().StringVar(&logLevelFlag, "log-level", "info", "Specifies the minimum level of the logs to print: debug, info, warn, or error. The debug level includes the QEMU, VM serial console, and in-VM command output.")
+	rootCmd.PersistentFlags().StringVar(&logFileFlag, "log-file", "", "Also write the logs to the specified file in JSON format. The file is appended to.")
+	rootCmd.PersistentFlags().Uint32Var(&logFileMaxSizeFlag, "log-file-max-size", 0, `Specifies the size in MiB after which the log file is rotated: renamed to "<file>.1", with the older backups shifted to "<file>.2" and so on. 0 disables the rotation.`)
+	rootCmd.PersistentFlags().IntVar(&logFileMaxBackupsFlag, "log-file-max-backups", 3, "Specifies the number of rotated log files to keep.")
+	rootCmd.PersistentFlags().StringVar(&progressFlag, "progress", progressModeAuto, "Specifies how the progress of downloads, VM boot and file system checks is reported: auto (bar on terminals, json with --json, log otherwise), bar, json (\"progress\" records on stdout), log, or none.")
 	rootCmd.PersistentFlags().BoolVar(&vmDebugFlag, "vm-debug", false, "Enables the VM debug mode. This will open an accessible VM monitor and enable direct QEMU command log passthrough. You can log in with root user and no password.")
 	rootCmd.PersistentFlags().BoolVar(&unrestrictedNetworkingFlag, "vm-unrestricted-networking", false, "Enables unrestricted networking. This will allow the VM to connect to the internet.")
 	rootCmd.PersistentFlags().Uint32Var(&vmMemAllocFlag, "vm-mem-alloc", defaultMemAlloc, fmt.Sprintf("Specifies the VM memory allocation in KiB. (the default is %v in LUKS mode)", defaultMemAllocLUKS))
+	rootCmd.PersistentFlags().Uint32Var(&vmCPUsFlag, "vm-cpus", 0, "Specifies the VM vCPU count. 0 picks half of the host logical CPUs, capped at 8.")
 	rootCmd.PersistentFlags().Uint32Var(&vmOSUpTimeoutFlag, "vm-os-up-timeout", 30, "Specifies the VM OS-up timeout in seconds.")
+	rootCmd.PersistentFlags().StringVar(&instanceNameFlag, "name", "", `Specifies the instance name. Named instances have persistent VM overlays of their own, so several of them can run concurrently. The name is also used as the default VM hostname, and lets the other commands (e.g. "linsk status") target the instance.`)
+	rootCmd.PersistentFlags().StringVar(&vmHostnameFlag, "vm-hostname", "", `Specifies the VM hostname. It is also advertised by the network file share servers. A unique "linsk-<session ID>" hostname is generated by default.`)
+	rootCmd.PersistentFlags().StringVar(&vmAccelFlag, "vm-accel", string(vm.AccelAuto), "Specifies the VM acceleration mode: auto (use hardware acceleration if available, fall back to slow software emulation otherwise), hw (require hardware acceleration), or tcg (force software emulation).")
+	rootCmd.PersistentFlags().StringVar(&vmDriveCacheFlag, "vm-drive-cache", vm.DriveIOAuto, "Specifies the host cache mode of the passed-through devices and disk images: auto, none, writeback, writethrough, directsync, or unsafe. With auto, the block devices bypass the host cache on Linux (none), and QEMU's default is used otherwise.")
+	rootCmd.PersistentFlags().StringVar(&vmDriveAIOFlag, "vm-drive-aio", vm.DriveIOAuto, "Specifies the asynchronous I/O mode of the passed-through devices and disk images: auto, threads, native (Linux and Windows, implies --vm-drive-cache=none if unset), or io_uring (Linux, QEMU 5.0 or newer). With auto, the block devices use native on Linux, and QEMU's default is used otherwise.")
 	rootCmd.PersistentFlags().Uint32Var(&vmSSHSetupTimeoutFlag, "vm-ssh-setup-timeout", 60, "Specifies the VM SSH server setup timeout in seconds. This cannot be lower than the OS-up timeout.")
 
-	defaultDataDir := "linsk-data-dir"
+	rootCmd.PersistentFlags().BoolVar(&persistVMFlag, "persist-vm", false, `Keep the changes made in the VM (installed packages, SSH host keys, etc.) between runs in a persistent overlay. Use "linsk vm reset" to discard them.`)
+
+	rootCmd.PersistentFlags().BoolVar(&skipImageCheckFlag, "skip-image-check", false, "Skip verifying the VM image integrity before boot.")
+
+	rootCmd.PersistentFlags().BoolVar(&autoCleanFlag, "auto-clean", false, "Remove leftovers from crashed previous sessions (network taps, temporary directories, dangling QEMU processes) at startup.")
+
+	rootCmd.PersistentFlags().BoolVar(&printQEMUCmdFlag, "print-qemu-cmd", false, "Print the fully assembled QEMU command and exit without starting the VM. Useful for reproducing issues.")
 
-	homeDir, err := os.UserHomeDir()
+	defaultDataDir, err := storage.GetDefaultDataDir()
 	if err != nil {
 		slog.Error("Failed to get user home directory, will use a local directory as a fallback", "error", err.Error(), "dir", defaultDataDir)
-	} else {
-		homeDirName := ".linsk"
-		if osspecifics.IsWindows() {
-			homeDirName = "Linsk"
-		}
-
-		defaultDataDir = filepath.Join(homeDir, homeDirName)
 	}
 
-	rootCmd.PersistentFlags().StringVarP(&dataDirFlag, "data-dir", "d", defaultDataDir, "Specifies the data directory (folder) to use. VM images and related work files will be stored here.")
+	rootCmd.PersistentFlags().StringVar(&imagePathFlag, "image-path", "", `Use a custom prebuilt qcow2 VM image at the specified path instead of the one produced by "linsk build". The image must be derived from the Linsk VM image.`)
+	rootCmd.PersistentFlags().StringVar(&imageURLFlag, "image-url", "", `Download and use a custom prebuilt qcow2 VM image from the specified URL instead of the one produced by "linsk build". The image is cached in the data directory.`)
+	rootCmd.PersistentFlags().StringVar(&imageSHA256Flag, "image-sha256", "", "Specifies the expected hex-encoded SHA-256 hash of the custom VM image. Strongly recommended with --image-path or --image-url.")
+
+	rootCmd.PersistentFlags().StringVar(&imageMetadataURLFlag, "image-metadata-url", "", "Download and use a signed prebuilt VM image described by the release metadata at the specified URL. The image must carry a valid minisign signature.")
+	rootCmd.PersistentFlags().StringArrayVar(&imagePubKeyFlags, "image-pubkey", nil, "Trust an additional minisign public key for verifying images from --image-metadata-url. Can be specified multiple times.")
+
+	rootCmd.PersistentFlags().StringVar(&imageVersionFlag, "image-version", "", `Use the locally built VM image of the specified version instead of the pinned (or latest) one. Pin a version persistently with "linsk image update --pin".`)
+
+	rootCmd.PersistentFlags().StringVar(&imageFlavorFlag, "image-flavor", "standard", "Specifies the VM image flavor to build and use: minimal (ext4, btrfs, LUKS and LVM only), standard, or recovery (adds ZFS, ddrescue, testdisk and smartmontools).")
+	rootCmd.PersistentFlags().StringArrayVar(&alpineMirrorFlags, "alpine-mirror", nil, "Specifies the base URL of an Alpine Linux mirror (e.g. https://dl-cdn.alpinelinux.org/alpine) to try first when downloading the base image. The built-in mirrors are used as a fallback. Can be specified multiple times.")
+	rootCmd.PersistentFlags().BoolVar(&offlineFlag, "offline", false, `Never access the network. Fail immediately if a download would be required. Use "linsk image import" to bring images onto disconnected machines.`)
+
+	rootCmd.PersistentFlags().StringVarP(&dataDirFlag, "data-dir", "d", defaultDataDir, "Specifies the data directory (folder) to use. VM images and related work files will be stored here. Can also be set with the "+dataDirEnv+" environment variable or in the config file.")
 }
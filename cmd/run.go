@@ -17,29 +17,104 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/lifecycle"
 	"github.com/AlexSSD7/linsk/osspecifics"
 	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/utils"
 	"github.com/AlexSSD7/linsk/vm"
-	"github.com/sethvargo/go-password/password"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "Start a VM and expose an FTP file share.",
-	Args:  cobra.RangeArgs(1, 3),
+	Use:               "run",
+	Short:             "Start a VM and expose a network file share.",
+	Args:              cobra.RangeArgs(0, 3),
+	ValidArgsFunction: completeVMCommandArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if detachFlag {
+			runDetached(args)
+			return
+		}
+
 		configureVMRuntimeFlags()
 
 		vmMountDevName := defaultVMMountDevName
 
-		if len(args) > 1 {
+		// Without the device argument, the user picks the host disk now,
+		// and the device to mount once the VM has started.
+		pickVMDevName := false
+
+		if len(args) == 0 {
+			if !canUsePicker() {
+				slog.Error("No device was specified. The interactive device picker is available only when running in a terminal.")
+				os.Exit(1)
+			}
+
+			if dryRunFlag {
+				slog.Error("--dry-run requires the device to be specified")
+				os.Exit(1)
+			}
+
+			passthroughArg, err := pickHostDevice()
+			if err != nil {
+				slog.Error("Failed to choose the device", "error", err.Error())
+				os.Exit(1)
+			}
+
+			args = []string{passthroughArg}
+			pickVMDevName = !autoResolveFlag && len(mountFlag) == 0 && lvmSnapshotFlag == "" && vmRuntimeLUKSContainerDevice == ""
+		}
+
+		var mountTargets []vm.MountTarget
+
+		if autoResolveFlag && (luksFlag || vmRuntimeLUKSContainerDevice != "" || len(mountFlag) != 0 || lvmSnapshotFlag != "") {
+			slog.Error("--auto-resolve cannot be used together with --luks, --luks-container, --mount or --lvm-snapshot")
+			os.Exit(1)
+		}
+
+		if len(mountFlag) != 0 {
+			if len(args) > 1 || lvmSnapshotFlag != "" {
+				slog.Error("Cannot specify the in-VM device name to mount or --lvm-snapshot together with --mount")
+				os.Exit(1)
+			}
+
+			var err error
+			mountTargets, err = parseMountTargets(mountFlag)
+			if err != nil {
+				slog.Error("Failed to parse --mount values", "error", err.Error())
+				os.Exit(1)
+			}
+
+			vmMountDevName = strings.Join(mountFlag, ", ")
+		} else if lvmSnapshotFlag != "" {
+			if len(args) > 1 {
+				slog.Error("Cannot specify the in-VM device name to mount together with --lvm-snapshot")
+				os.Exit(1)
+			}
+
+			var err error
+			vmMountDevName, err = vm.LVMDevName(lvmSnapshotFlag)
+			if err != nil {
+				slog.Error("Failed to resolve LVM snapshot device", "error", err.Error())
+				os.Exit(1)
+			}
+		} else if len(args) > 1 {
 			vmMountDevName = args[1]
 		} else if vmRuntimeLUKSContainerDevice != "" {
 			slog.Error("Cannot use the default (entire) device with a LUKS container. Please specify the in-VM device name to mount as a second positional argument.")
@@ -51,30 +126,68 @@ var runCmd = &cobra.Command{
 			fsTypeOverride = args[2]
 		}
 
-		newBackendFunc := share.GetBackend(shareBackendFlag)
-		if newBackendFunc == nil {
-			slog.Error("Unknown file share backend", "type", shareBackendFlag)
-			os.Exit(1)
+		if sharePasswordFileFlag != "" {
+			pwd, err := readSecretFile(sharePasswordFileFlag)
+			if err != nil {
+				slog.Error("Failed to read share password file", "error", err.Error())
+				os.Exit(1)
+			}
+
+			sharePasswordFlag = string(pwd)
 		}
 
+		backendIDs := strings.Split(shareBackendFlag, ",")
+
 		cfg, err := share.RawUserConfiguration{
 			ListenIP: shareListenIPFlag,
 
 			FTPExtIP:   ftpExtIPFlag,
 			SMBExtMode: smbUseExternAddrFlag,
-		}.Process(shareBackendFlag, slog.With("caller", "share-config"))
+			WebDAVTLS:  webDAVTLSFlag,
+			FTPTLS:     ftpTLSFlag,
+
+			NFSAllowRemote: nfsAllowRemoteFlag,
+
+			TLSCertPath: tlsCertFlag,
+			TLSKeyPath:  tlsKeyFlag,
+
+			HostMountPoint: hostMountPointFlag,
+			HostAutoMount:  autoMountFlag,
+
+			ShareUser:     shareUserFlag,
+			SharePassword: sharePasswordFlag,
+
+			ShareProfile: shareProfileFlag,
+			ReadOnly:     readOnlyFlag,
+		}.Process(backendIDs, slog.With("caller", "share-config"))
 		if err != nil {
 			slog.Error("Failed to process raw configuration", "error", err.Error())
 			os.Exit(1)
 		}
 
-		backend, vmOpts, err := newBackendFunc(cfg)
+		// Reserving the share ports across processes, so that the concurrently
+		// started instances don't pick the same ones. Dry runs never release
+		// the reservations, so they only probe for free ports.
+		if !dryRunFlag {
+			share.SetPortReserver(createStoreOrExit().ReservePort)
+		}
+
+		supervisor, vmOpts, err := share.NewSupervisor(backendIDs, cfg)
 		if err != nil {
-			slog.Error("Failed to initialize share backend", "backend", shareBackendFlag, "error", err.Error())
+			slog.Error("Failed to initialize share backends", "backends", backendIDs, "error", err.Error())
 			os.Exit(1)
 		}
 
+		if dryRunFlag {
+			printRunDryRunPlan(vmMountDevName, fsTypeOverride, vmOpts)
+		}
+
 		os.Exit(runVM(args[0], func(ctx context.Context, i *vm.VM, fm *vm.FileManager, tapCtx *share.NetTapRuntimeContext) int {
+			if jsonOutputFlag {
+				emitJSONRecord(recordTypeBoot, bootRecordData{Seconds: i.BootDuration().Seconds()})
+				fm.SetCommandRunner(&commandRecordRunner{CommandRunner: sshutil.NewDialingRunner(i.DialSSH)})
+			}
+
 			fsToLog := "<auto>"
 			if fsTypeOverride != "" {
 				fsToLog = fsTypeOverride
@@ -85,40 +198,138 @@ var runCmd = &cobra.Command{
 				mountOptionsToLog = mountOptionsFlag
 			}
 
-			slog.Info("Mounting the device", "dev", vmMountDevName, "fs", fsToLog, "luks", luksFlag, "mountoptions", mountOptionsToLog)
+			hooks := new(lifecycle.Hooks)
+			registerRunHooks(hooks, i, fm, mountTargets)
+
+			if pickVMDevName {
+				devs, err := fm.ListBlockDevices(ctx)
+				if err != nil {
+					slog.Error("Failed to list block devices in the VM", "error", err.Error())
+					return 1
+				}
+
+				vmMountDevName, err = pickVMDevice(devs)
+				if err != nil {
+					slog.Error("Failed to choose the device to mount", "error", err.Error())
+					return exitcode.ForError(err)
+				}
+			}
+
+			slog.Info("Mounting the device", "dev", vmMountDevName, "fs", fsToLog, "luks", luksFlag, "mountoptions", mountOptionsToLog, "read-only", readOnlyFlag, "subvol", subvolFlag)
+
+			err := assembleRAIDIfRequested(ctx, fm)
+			if err != nil {
+				slog.Error("Failed to assemble RAID arrays", "error", err.Error())
+				return 1
+			}
+
+			if autoResolveFlag {
+				vmMountDevName, err = fm.ResolveStack(ctx, vmMountDevName, vm.StackResolveConfig{
+					LUKSOptions: getLUKSOptions(),
+					ReadOnly:    readOnlyFlag,
+					Choose:      promptChooseDevice,
+				})
+				if err != nil {
+					slog.Error("Failed to resolve the storage stack", "error", err.Error())
+					return exitcode.ForError(err)
+				}
+
+				slog.Info("Resolved the device to mount", "dev", vmMountDevName)
+			}
+
+			if len(mountTargets) != 0 {
+				for _, t := range mountTargets {
+					warnIfNotMountable(ctx, fm, t.DevName)
+				}
+			} else if !luksFlag {
+				warnIfNotMountable(ctx, fm, vmMountDevName)
+			}
 
-			err := fm.Mount(vmMountDevName, vm.MountConfig{
+			mc := vm.MountConfig{
 				LUKSContainerPreopen: vmRuntimeLUKSContainerDevice,
+				LUKSOptions:          getLUKSOptions(),
+				LVMActivate:          lvmSnapshotFlag,
+				BtrfsSubvolume:       subvolFlag,
 				FSTypeOverride:       fsTypeOverride,
 				LUKS:                 luksFlag,
 				MountOptions:         mountOptionsFlag,
-			})
+				ReadOnly:             readOnlyFlag,
+			}
+
+			if len(mountTargets) != 0 {
+				err = fm.MountMultiple(ctx, mountTargets, mc)
+			} else {
+				err = fm.Mount(ctx, vmMountDevName, mc)
+			}
 			if err != nil {
 				slog.Error("Failed to mount the disk inside the VM", "error", err.Error())
-				return 1
+				return exitcode.ForError(err)
 			}
 
-			sharePWD, err := password.Generate(16, 10, 0, false, false)
-			if err != nil {
-				slog.Error("Failed to generate ephemeral password for the network file share", "error", err.Error())
-				return 1
+			mountedDevNames := []string{vmMountDevName}
+			if len(mountTargets) != 0 {
+				mountedDevNames = nil
+				for _, t := range mountTargets {
+					mountedDevNames = append(mountedDevNames, t.DevName)
+				}
 			}
 
-			lg := slog.With("backend", shareBackendFlag)
+			for _, devName := range mountedDevNames {
+				err = hooks.RunMount(ctx, lifecycle.Mount{Device: devName, FSType: fsTypeOverride, ReadOnly: readOnlyFlag})
+				if err != nil {
+					slog.Error("Failed to run the mount hooks", "error", err.Error())
+					return 1
+				}
+			}
 
-			shareURI, err := backend.Apply(sharePWD, &share.VMShareContext{
+			activeShares, err := supervisor.Apply(ctx, &share.VMShareContext{
 				Instance:    i,
 				FileManager: fm,
 				NetTapCtx:   tapCtx,
 			})
+
+			defer func() {
+				err := supervisor.Close()
+				if err != nil {
+					slog.Error("Failed to close file share backends", "error", err.Error())
+				}
+			}()
+
+			// Running the shutdown hooks while the file shares are still up.
+			defer func() {
+				err := hooks.RunShutdown(context.Background())
+				if err != nil {
+					slog.Error("Failed to run the shutdown hooks", "error", err.Error())
+				}
+			}()
+
+			if err != nil {
+				slog.Error("Failed to apply (start) file share backends", "error", err.Error())
+				return exitcode.ShareFailed
+			}
+
+			slog.Info("Started the network shares successfully", "backends", backendIDs)
+
+			err = hooks.RunShareReady(ctx, activeShares)
 			if err != nil {
-				lg.Error("Failed to apply (start) file share backend", "error", err.Error())
+				slog.Error("Failed to run the share ready hooks", "error", err.Error())
 				return 1
 			}
 
-			lg.Info("Started the network share successfully")
+			if vm.ShareProfile(shareProfileFlag) == vm.ShareProfileTimeMachine {
+				slog.Info("The file share is ready for Time Machine. Bonjour discovery does not work through port forwarding, so set the backup destination with `sudo tmutil setdestination -a <URL with credentials>`, e.g. \"smb://<username>:<password>@127.0.0.1:<port>/linsk\".")
+			}
+
+			if shareHealthIntervalFlag > 0 {
+				monitorCtx, monitorCtxCancel := context.WithCancel(ctx)
+				defer monitorCtxCancel()
+
+				if jsonOutputFlag {
+					supervisor.OnHealthChange(emitHealthRecord)
+				}
 
-			fmt.Fprintf(os.Stderr, "===========================\n[Network File Share Config]\nThe network file share was started. Please use the credentials below to connect to the file server.\n\nType: "+strings.ToUpper(shareBackendFlag)+"\nURL: %v\nUsername: linsk\nPassword: %v\n===========================\n", shareURI, sharePWD)
+				go supervisor.Monitor(monitorCtx, fm, shareHealthIntervalFlag, slog.With("caller", "share-supervisor"))
+			}
 
 			ctxWait := true
 
@@ -142,33 +353,333 @@ var runCmd = &cobra.Command{
 }
 
 var (
-	luksFlag             bool
-	shareListenIPFlag    string
-	ftpExtIPFlag         string
-	shareBackendFlag     string
-	smbUseExternAddrFlag bool
-	debugShellFlag       bool
-	mountOptionsFlag     string
+	luksFlag                bool
+	shareListenIPFlag       string
+	ftpExtIPFlag            string
+	shareBackendFlag        string
+	smbUseExternAddrFlag    bool
+	debugShellFlag          bool
+	mountOptionsFlag        string
+	dryRunFlag              bool
+	detachFlag              bool
+	webDAVTLSFlag           bool
+	nfsAllowRemoteFlag      bool
+	hostMountPointFlag      string
+	readOnlyFlag            bool
+	shareUserFlag           string
+	autoMountFlag           bool
+	shareProfileFlag        string
+	shareHealthIntervalFlag time.Duration
+	lvmSnapshotFlag         string
+	subvolFlag              string
+	mountFlag               []string
+	autoResolveFlag         bool
+	sharePasswordFlag       string
+	sharePasswordFileFlag   string
+	ftpTLSFlag              bool
+	tlsCertFlag             string
+	tlsKeyFlag              string
 )
 
 func init() {
 	runCmd.Flags().BoolVarP(&luksFlag, "luks", "l", false, "Use cryptsetup to open a LUKS volume (password will be prompted).")
 	runCmd.Flags().BoolVar(&debugShellFlag, "debug-shell", false, "Start a VM shell when the network file share is active.")
+	runCmd.Flags().BoolVar(&readOnlyFlag, "read-only", false, "Mount the file system read-only and make the file share reject writes. Passed through block devices are attached read-only as well.")
+	runCmd.Flags().BoolVar(&detachFlag, "detach", false, `Run the VM and the file share in the background and return once the share is started. The instance is named with --name, or gets a generated name otherwise. Use "linsk attach <name>" to follow its output and "linsk stop <name>" to shut it down.`)
+	runCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Resolve the devices and build the entire VM configuration, then print what would be booted, passed through, mounted, shared, and downloaded without starting the VM or downloading anything.")
 
 	initVMRuntimeFlags(runCmd.Flags())
 
-	var defaultShareType string
-	switch {
-	case osspecifics.IsMacOS():
-		defaultShareType = "afp"
-	default:
-		defaultShareType = "smb"
-	}
+	runCmd.Flags().StringVar(&shareBackendFlag, "share-backend", share.GetDefaultBackendID(), `Specifies the file share backend to use. Multiple comma-separated backends can be started at once (e.g. "ftp,smb"). The default value is OS-specific. (available "`+strings.Join(share.ListBackendIDs(), `", "`)+`")`)
+	runCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		// "--share" is accepted as a shorthand for "--share-backend", and
+		// "--mount-opts" is accepted as a shorthand for "--mount-options".
+		switch name {
+		case "share":
+			name = "share-backend"
+		case "mount-opts":
+			name = "mount-options"
+		}
 
-	runCmd.Flags().StringVar(&shareBackendFlag, "share-backend", defaultShareType, `Specifies the file share backend to use. The default value is OS-specific. (available "smb", "afp", "ftp")`)
+		return pflag.NormalizedName(name)
+	})
 	runCmd.Flags().StringVar(&shareListenIPFlag, "share-listen", share.GetDefaultListenIPStr(), "Specifies the IP to bind the network share port to. NOTE: For FTP, changing the bind address is not enough to connect remotely. You should also specify --ftp-extip.")
 
 	runCmd.Flags().StringVar(&ftpExtIPFlag, "ftp-extip", share.GetDefaultListenIPStr(), "Specifies the external IP the FTP server should advertise.")
 	runCmd.Flags().BoolVar(&smbUseExternAddrFlag, "smb-extern", share.IsSMBExtModeDefault(), "Specifies whether Linsk should emulate external networking for the VM's SMB server. This is the default for Windows as there is no way to specify ports in Windows SMB client.")
-	runCmd.Flags().StringVar(&mountOptionsFlag, "mount-options", "", "Specifies the mount options to be passed to the -o flag of the mount.")
+	runCmd.Flags().BoolVar(&ftpTLSFlag, "ftp-tls", false, "Require explicit TLS (FTPS) for FTP logins and data transfers. A self-signed certificate is generated unless --tls-cert and --tls-key are specified.")
+	runCmd.Flags().BoolVar(&nfsAllowRemoteFlag, "nfs-allow-remote", false, "Allow serving the NFS share on a non-loopback IP with --share-listen. NFS shares have no authentication, so anyone who can reach the share can read and modify the files.")
+	runCmd.Flags().StringVar(&tlsCertFlag, "tls-cert", "", "Specifies the PEM certificate file to use for --ftp-tls and --webdav-tls instead of a generated self-signed one.")
+	runCmd.Flags().StringVar(&tlsKeyFlag, "tls-key", "", "Specifies the PEM private key file for --tls-cert.")
+	runCmd.Flags().BoolVar(&webDAVTLSFlag, "webdav-tls", false, "Serve WebDAV over HTTPS. A self-signed certificate is generated unless --tls-cert and --tls-key are specified.")
+	runCmd.Flags().StringVar(&shareUserFlag, "share-user", "", `Specifies the file share username instead of the default "`+vm.DefaultShareUser+`". Can also be set with the `+shareUserEnv+` environment variable.`)
+	runCmd.Flags().StringVar(&sharePasswordFlag, "share-password", "", "Specifies the file share password instead of a generated one. Prefer the "+sharePasswordEnv+" environment variable or the config file, as command line arguments are visible to other processes.")
+	runCmd.Flags().StringVar(&sharePasswordFileFlag, "share-password-file", "", `Specifies a file to read the file share password from, for unattended use. Only the first line is read. The file must be accessible by its owner only. Use "-" to read the password from stdin. Takes precedence over --share-password.`)
+	runCmd.Flags().StringVar(&hostMountPointFlag, "host-mountpoint", "", `Specifies the host directory (or drive letter like "L:" on Windows) to mount the file system at with the "sshfs" backend or --auto-mount. The default for --auto-mount on macOS is "/Volumes/<label>" when running as root (named after the file system label or the session), and "~/Linsk/<label>" otherwise.`)
+	runCmd.Flags().StringVar(&shareProfileFlag, "share-profile", "", `Specifies the profile to tune the file share for. Use "timemachine" to make SMB and AFP shares usable as macOS Time Machine backup destinations.`)
+	runCmd.Flags().DurationVar(&shareHealthIntervalFlag, "share-health-interval", share.DefaultHealthCheckInterval, "Specifies the interval of the file share health checks. Unhealthy file share servers are restarted automatically. Set to 0 to disable.")
+	runCmd.Flags().BoolVar(&autoMountFlag, "auto-mount", false, "Mount the file share on the host with the host-native tools once it is started, and open it in Finder/Explorer. The share is unmounted on shutdown. Supported for SMB on Windows (with --smb-extern), where the share is mapped to a drive letter (the next free one unless --host-mountpoint is set), and for SMB, AFP and NFS on macOS.")
+	runCmd.Flags().StringVar(&lvmSnapshotFlag, "lvm-snapshot", "", `Specifies an LVM snapshot (or any other logical volume) in the "<volume group>/<logical volume>" form to activate and mount instead of a device name. Thin snapshots are activated too. Combine with --read-only to leave the snapshot untouched as well.`)
+	runCmd.Flags().BoolVar(&autoResolveFlag, "auto-resolve", false, "Walk through the partition tables, LUKS containers (password will be prompted) and LVM volumes on the device automatically until a file system is reached. You will be asked to choose if there are multiple candidates.")
+	runCmd.Flags().StringArrayVar(&mountFlag, "mount", nil, `Specifies an in-VM device to mount into a subdirectory of the share root, in the "<device>[:<name>]" form (e.g. "vdb2:root"). Can be repeated to expose several partitions in a single share. The name defaults to the device name.`)
+	runCmd.Flags().StringVar(&subvolFlag, "subvol", "", `Specifies the btrfs subvolume (like "@home") to mount instead of the default one. Use "linsk ls --subvols" to list the subvolumes.`)
+	runCmd.Flags().StringVar(&mountOptionsFlag, "mount-options", "", `Specifies the mount options to be passed to the -o flag of the mount, like "noatime,uid=1000,gid=1000". Can also be specified as --mount-opts. Passing "ro" has the same effect as --read-only for the file share.`)
+}
+
+// registerRunHooks registers the hooks doing the post-mount work of the run
+// command: recording the instance state in the runtime registry, printing
+// the file share details, and mounting the file share on the host.
+func registerRunHooks(hooks *lifecycle.Hooks, vi *vm.VM, fm *vm.FileManager, mountTargets []vm.MountTarget) {
+	hooks.OnMount(func(_ context.Context, m lifecycle.Mount) error {
+		updateRuntimeInstance(func(_ *storage.Storage, inst *storage.Instance) {
+			inst.Mounts = append(inst.Mounts, storage.InstanceMount{Device: m.Device, FSType: m.FSType, ReadOnly: m.ReadOnly})
+		})
+
+		return nil
+	})
+
+	hooks.OnShareReady(func(ctx context.Context, activeShares []share.ActiveShare) error {
+		shareModeStr := "read-write"
+		if readOnlyFlag {
+			shareModeStr = "READ-ONLY (writes are rejected)"
+		}
+
+		sb := new(strings.Builder)
+		fmt.Fprintf(sb, "===========================\n[Network File Share Config]\nThe network file share was started. Please use the details below to connect to the file server.\n\nSession: %v\nMode: %v\n", vi.Hostname(), shareModeStr)
+
+		for _, as := range activeShares {
+			fmt.Fprintf(sb, "\nType: %v\nURL: %v\n", strings.ToUpper(as.BackendID), as.URL)
+			if as.Password == "" {
+				fmt.Fprintf(sb, "No credentials are needed.\n")
+			} else {
+				fmt.Fprintf(sb, "Username: %v\nPassword: %v\n", as.Username, as.Password)
+			}
+		}
+
+		sb.WriteString("===========================\n")
+
+		recordRuntimeInstanceShares(activeShares, sb.String())
+
+		if jsonOutputFlag {
+			emitShareRecords(vi.Hostname(), readOnlyFlag, activeShares)
+
+			stopTrafficRecords := startTrafficRecords(ctx, vi)
+			hooks.OnShutdown(func(_ context.Context) error {
+				stopTrafficRecords()
+				return nil
+			})
+		} else {
+			fmt.Fprint(os.Stderr, sb.String())
+		}
+
+		return nil
+	})
+
+	if !autoMountFlag {
+		return
+	}
+
+	var mountedDevName string
+
+	hooks.OnMount(func(_ context.Context, m lifecycle.Mount) error {
+		mountedDevName = m.Device
+		return nil
+	})
+
+	hooks.OnShareReady(func(ctx context.Context, activeShares []share.ActiveShare) error {
+		volumeName := vi.Hostname()
+		if len(mountTargets) == 0 {
+			volumeName = getHostVolumeName(ctx, fm, mountedDevName, volumeName)
+		}
+
+		hm := autoMountShare(activeShares, volumeName)
+		if hm != nil {
+			hooks.OnShutdown(func(_ context.Context) error {
+				return errors.Wrapf(hm.Unmount(), "unmount the file share from the host at '%v'", hm.Path())
+			})
+		}
+
+		return nil
+	})
+}
+
+// autoMountShare mounts the first share supported by the host-native tools.
+// Failures are not fatal as the share can still be mounted manually.
+func autoMountShare(activeShares []share.ActiveShare, volumeName string) *share.HostMount {
+	for _, as := range activeShares {
+		if !share.CanMountOnHost(as) {
+			continue
+		}
+
+		mountPoint := hostMountPointFlag
+		if mountPoint == "" && !osspecifics.IsWindows() {
+			var err error
+			mountPoint, err = getDefaultHostMountPoint(volumeName)
+			if err != nil {
+				slog.Error("Failed to get the default host mount point", "error", err.Error())
+				return nil
+			}
+		}
+
+		hm, err := share.MountOnHost(as, mountPoint)
+		if err != nil {
+			slog.Error("Failed to mount the file share on the host", "backend", as.BackendID, "error", err.Error())
+			return nil
+		}
+
+		slog.Info("Mounted the file share on the host", "backend", as.BackendID, "path", hm.Path())
+
+		err = share.OpenInFileManager(hm.Path())
+		if err != nil {
+			slog.Warn("Failed to open the mounted file share", "error", err.Error())
+		}
+
+		return hm
+	}
+
+	slog.Warn("None of the started file shares can be mounted on this host automatically")
+
+	return nil
+}
+
+// getHostVolumeName returns the label of the mounted file system to name
+// the host mount point after, falling back to the provided name.
+func getHostVolumeName(ctx context.Context, fm *vm.FileManager, devName string, fallback string) string {
+	devs, err := fm.ListBlockDevices(ctx)
+	if err != nil {
+		slog.Warn("Failed to list block devices to get the file system label", "error", err.Error())
+		return fallback
+	}
+
+	dev := vm.FindBlockDevice(devs, devName)
+	if dev == nil || dev.Label == "" {
+		return fallback
+	}
+
+	// Slashes and colons cannot appear in macOS volume names.
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(utils.ClearUnprintableChars(dev.Label, false))
+	if strings.Trim(name, ". ") == "" {
+		return fallback
+	}
+
+	return name
+}
+
+// getDefaultHostMountPoint returns "/Volumes/<name>" when running as root,
+// so that the share shows up in Finder like an external drive. A number is
+// appended to the name if it is taken, the same way Finder does it. Only
+// root can create directories in /Volumes, so "~/Linsk/<name>" is used
+// otherwise.
+func getDefaultHostMountPoint(name string) (string, error) {
+	if os.Geteuid() != 0 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "get user home dir")
+		}
+
+		return filepath.Join(homeDir, "Linsk", name), nil
+	}
+
+	for n := 1; ; n++ {
+		p := filepath.Join("/Volumes", name)
+		if n != 1 {
+			p += " " + fmt.Sprint(n)
+		}
+
+		_, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		} else if err != nil {
+			return "", errors.Wrapf(err, "stat '%v'", p)
+		}
+	}
+}
+
+// parseMountTargets parses the --mount flag values.
+func parseMountTargets(vals []string) ([]vm.MountTarget, error) {
+	targets := make([]vm.MountTarget, 0, len(vals))
+
+	for _, val := range vals {
+		devName, name := val, ""
+		if i := strings.LastIndex(val, ":"); i != -1 {
+			devName, name = val[:i], val[i+1:]
+		}
+
+		if name == "" {
+			// Use the tag value for the tag references like "LABEL=home".
+			name = strings.TrimPrefix(devName, "mapper/")
+			if vm.IsDevSpec(name) {
+				_, name, _ = strings.Cut(name, "=")
+			}
+		}
+
+		if devName == "" {
+			return nil, fmt.Errorf("empty device name in '%v'", val)
+		}
+
+		targets = append(targets, vm.MountTarget{
+			DevName: devName,
+			Name:    name,
+		})
+	}
+
+	return targets, nil
+}
+
+// warnIfNotMountable warns if the device holds something that makes no
+// sense to mount, like swap. Devices that do not exist yet (e.g., the ones
+// behind a LUKS container which is opened during mount) are not checked.
+func warnIfNotMountable(ctx context.Context, fm *vm.FileManager, devName string) {
+	devs, err := fm.ListBlockDevices(ctx)
+	if err != nil {
+		slog.Warn("Failed to list block devices to check the device to mount", "error", err.Error())
+		return
+	}
+
+	dev := vm.FindBlockDevice(devs, devName)
+	if dev == nil {
+		return
+	}
+
+	if c := dev.Classify(); !c.Mountable {
+		slog.Warn("The device to mount does not seem to hold a mountable file system, mounting will likely fail", "dev", devName, "kind", c.Kind, "hint", c.Hint)
+	}
+}
+
+// promptChooseDevice asks the user to choose one of the devices.
+func promptChooseDevice(candidates []vm.BlockDevice) (int, error) {
+	if canUsePicker() {
+		items := make([]pickerItem, 0, len(candidates))
+		for i := range candidates {
+			items = append(items, pickerItem{Label: formatPickerBlockDevice(&candidates[i])})
+		}
+
+		return pickItem("Multiple devices were found, please choose one", items)
+	}
+
+	fmt.Fprint(os.Stderr, "Multiple devices were found, please choose one:\n")
+	for i, c := range candidates {
+		fmt.Fprintf(os.Stderr, "  [%v] %v (%v, %v", i+1, c.Name, humanize.IBytes(c.Size), c.Classification.Kind)
+		if c.FSType != "" {
+			fmt.Fprintf(os.Stderr, ", %v", c.FSType)
+		}
+		if c.Label != "" {
+			fmt.Fprintf(os.Stderr, ", label %q", c.Label)
+		}
+		fmt.Fprint(os.Stderr, ")\n")
+	}
+
+	fmt.Fprint(os.Stderr, "Enter number: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return 0, errors.Wrap(err, "read choice")
+	}
+
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return 0, fmt.Errorf("invalid choice '%v'", strings.TrimSpace(line))
+	}
+
+	return choice - 1, nil
 }
@@ -18,7 +18,6 @@ package runvm
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -26,6 +25,7 @@ import (
 
 	"log/slog"
 
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
 	"github.com/AlexSSD7/linsk/share"
 	"github.com/AlexSSD7/linsk/vm"
 )
@@ -86,14 +86,15 @@ func RunVM(vi *vm.VM, initFileManager bool, tapRuntimeCtx *share.NetTapRuntimeCo
 		select {
 		case err := <-runErrCh:
 			if err == nil {
-				err = fmt.Errorf("operation canceled by user")
+				slog.Error("Failed to start the VM", "error", "operation canceled by user")
+				return exitcode.Interrupted
 			}
 
 			slog.Error("Failed to start the VM", "error", err.Error())
-			return 1
+			return exitcode.ForError(err)
 		case <-vi.SSHUpNotifyChan():
 			if fm != nil {
-				err := fm.InitLVM()
+				err := fm.InitLVM(ctx)
 				if err != nil {
 					slog.Error("Failed to initialize File Manager LVM", "error", err.Error())
 					return 1
@@ -103,7 +104,7 @@ func RunVM(vi *vm.VM, initFileManager bool, tapRuntimeCtx *share.NetTapRuntimeCo
 			startupFailed := false
 
 			if tapRuntimeCtx != nil {
-				err := vi.ConfigureInterfaceStaticNet(context.Background(), "eth1", tapRuntimeCtx.Net.GuestCIDR)
+				err := vi.ConfigureInterfaceStaticNet(ctx, "eth1", tapRuntimeCtx.Net.GuestCIDR)
 				if err != nil {
 					slog.Error("Failed to configure tag interface network", "error", err.Error())
 					startupFailed = true
@@ -130,7 +131,7 @@ func RunVM(vi *vm.VM, initFileManager bool, tapRuntimeCtx *share.NetTapRuntimeCo
 			case err := <-runErrCh:
 				if err != nil {
 					slog.Error("Failed to run the VM", "error", err.Error())
-					return 1
+					return exitcode.ForError(err)
 				}
 			default:
 			}
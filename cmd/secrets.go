@@ -0,0 +1,105 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+)
+
+const (
+	luksPasswordEnv = "LINSK_LUKS_PASSWORD"
+
+	// secretFileStdin is the secret file path value to read the secret from stdin.
+	secretFileStdin = "-"
+)
+
+var secretStdinUsed bool
+
+// readSecretFile reads the secret (like a password) from the first line of
+// the file, or from stdin if the path is "-". The file must be accessible by
+// its owner only. As there is only one stdin, it can be used once.
+func readSecretFile(path string) ([]byte, error) {
+	var r io.Reader
+
+	if path == secretFileStdin {
+		if secretStdinUsed {
+			return nil, fmt.Errorf("stdin cannot be used for more than one secret")
+		}
+
+		secretStdinUsed = true
+		r = os.Stdin
+	} else {
+		err := osspecifics.CheckSecretFilePermissions(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "check file permissions")
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "open file")
+		}
+
+		defer func() { _ = f.Close() }()
+
+		r = f
+	}
+
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "read secret")
+	}
+
+	secret := bytes.TrimRight(line, "\r\n")
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the secret is empty")
+	}
+
+	return secret, nil
+}
+
+// getLUKSPassword returns the password specified with --luks-password-file
+// or the environment variable, or nil if the password is to be prompted.
+func getLUKSPassword() ([]byte, error) {
+	if vmRuntimeLUKSPasswordFileFlag != "" {
+		pwd, err := readSecretFile(vmRuntimeLUKSPasswordFileFlag)
+		if err != nil {
+			return nil, errors.Wrap(err, "read password file")
+		}
+
+		return pwd, nil
+	}
+
+	pwd := os.Getenv(luksPasswordEnv)
+	if pwd == "" {
+		return nil, nil
+	}
+
+	// Not passing the password down to the child processes like QEMU.
+	err := os.Unsetenv(luksPasswordEnv)
+	if err != nil {
+		return nil, errors.Wrap(err, "unset environment variable")
+	}
+
+	return []byte(pwd), nil
+}
@@ -0,0 +1,280 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/AlexSSD7/linsk/service"
+	"github.com/spf13/cobra"
+)
+
+const serviceLogsDirName = "logs"
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the daemon service started on boot (or login).",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install [-- daemon flags]",
+	Short: "Register the daemon as a service started on boot (or login).",
+	Long: `Register "linsk daemon" as a Windows service, or as a launchd agent on macOS (a launchd daemon if run as root), and start it. The daemon is started on boot (or login) and restarted if it crashes.
+
+The global flags (like --data-dir or --vm-mem-alloc) and the flags after "--" are passed on to the daemon. The daemon logs are written to the logs directory in the data directory, and rotated according to --log-max-size and --log-max-backups.
+
+Installing the Windows service requires admin privileges. The service runs as the LocalSystem account, so the data directory is always passed on to it explicitly. Uninstall the service with "linsk service uninstall" before installing it again with a different configuration.`,
+	Example: `  linsk service install
+  linsk service install -- --listen 127.0.0.1:9500 --grpc-listen ""`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 && cmd.ArgsLenAtDash() != 0 {
+			slog.Error(`The daemon flags must follow "--"`)
+			os.Exit(exitcode.Usage)
+		}
+
+		store := createStoreOrExit()
+
+		dataDir, err := filepath.Abs(store.DataDirPath())
+		if err != nil {
+			slog.Error("Failed to get absolute data dir path", "error", err.Error())
+			os.Exit(1)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			slog.Error("Failed to get the Linsk executable path", "error", err.Error())
+			os.Exit(1)
+		}
+
+		logsDir := filepath.Join(dataDir, serviceLogsDirName)
+
+		err = os.MkdirAll(logsDir, 0700)
+		if err != nil {
+			slog.Error("Failed to create logs dir", "error", err.Error(), "path", logsDir)
+			os.Exit(1)
+		}
+
+		logFile := filepath.Join(logsDir, "daemon.log")
+
+		// The explicitly set flags come last to take precedence.
+		daemonArgs := append([]string{"daemon"}, getChildProcessBaseArgs(cmd)...)
+		daemonArgs = append(daemonArgs,
+			"--data-dir="+dataDir,
+			"--log-file="+logFile,
+			fmt.Sprintf("--log-file-max-size=%v", serviceLogMaxSizeFlag),
+			fmt.Sprintf("--log-file-max-backups=%v", serviceLogMaxBackupsFlag),
+		)
+		daemonArgs = append(daemonArgs, args...)
+
+		err = service.Install(service.Config{
+			Executable: exe,
+			Args:       daemonArgs,
+			StderrPath: filepath.Join(logsDir, "daemon.stderr.log"),
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrAlreadyInstalled):
+				slog.Error(`The daemon service is already installed, uninstall it first with "linsk service uninstall"`)
+			case errors.Is(err, errors.ErrUnsupported):
+				slog.Error(`Installing the daemon service is not supported on this OS, generate a systemd unit with "linsk service generate-systemd" instead`)
+			default:
+				slog.Error("Failed to install the daemon service", "error", err.Error())
+			}
+
+			os.Exit(exitcode.ForError(err))
+		}
+
+		slog.Info("Installed and started the daemon service", "log-file", logFile)
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop the daemon service and unregister it.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := service.Uninstall()
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrNotInstalled):
+				slog.Error("The daemon service is not installed")
+			case errors.Is(err, errors.ErrUnsupported):
+				slog.Error("Uninstalling the daemon service is not supported on this OS")
+			default:
+				slog.Error("Failed to uninstall the daemon service", "error", err.Error())
+			}
+
+			os.Exit(exitcode.ForError(err))
+		}
+
+		slog.Info("Uninstalled the daemon service")
+	},
+}
+
+var serviceGenerateSystemdCmd = &cobra.Command{
+	Use:   "generate-systemd [-- command]",
+	Short: "Generate a sandboxed systemd unit running the daemon, or a timer running a Linsk command on a schedule.",
+	Long: `Generate a sandboxed systemd unit running the daemon, or a timer running a Linsk command on a schedule.
+
+The unit runs "linsk daemon" by default, restarted on failure. The Linsk command after "--" (without "linsk" itself) is run instead if specified. With --schedule, the command is run as a oneshot service started by a timer unit, which is useful for the scheduled backups.
+
+The global flags (like --data-dir or --vm-mem-alloc) are passed on to the command. The logs go to the journal. The command runs as root unless --user is set, with the file system read-only besides the data directory and the --rw-path directories, and no access to the devices besides KVM and the block devices. Any SCSI, SATA, USB and NVMe disk is accessible, unless narrowed down with --allow-device.
+
+The units are printed to stdout unless --output-dir is set.`,
+	Example: `  linsk service generate-systemd --output-dir /etc/systemd/system
+  linsk service generate-systemd --schedule daily --unit-name linsk-backup --allow-device /dev/disk/by-id/usb-Backup_Disk --devices-read-only --rw-path /srv/backup -- cp dev:/dev/disk/by-id/usb-Backup_Disk:/ /srv/backup`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 0 && cmd.ArgsLenAtDash() != 0 {
+			slog.Error(`The command must follow "--"`)
+			os.Exit(exitcode.Usage)
+		}
+
+		if serviceScheduleFlag != "" && len(args) == 0 {
+			slog.Error(`A scheduled command must be specified after "--"`)
+			os.Exit(exitcode.Usage)
+		}
+
+		store := createStoreOrExit()
+
+		dataDir, err := filepath.Abs(store.DataDirPath())
+		if err != nil {
+			slog.Error("Failed to get absolute data dir path", "error", err.Error())
+			os.Exit(1)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			slog.Error("Failed to get the Linsk executable path", "error", err.Error())
+			os.Exit(1)
+		}
+
+		command := args
+		description := "Linsk daemon"
+
+		if len(command) == 0 {
+			command = []string{"daemon"}
+		} else {
+			description = fmt.Sprintf("Linsk %v", command[0])
+		}
+
+		unitName := serviceUnitNameFlag
+		if unitName == "" {
+			unitName = "linsk-" + command[0]
+		}
+
+		// The global flags go in front of the command, so
+		// that the command flags take precedence.
+		unitArgs := append([]string{command[0]}, getChildProcessBaseArgs(cmd)...)
+		unitArgs = append(unitArgs, "--data-dir="+dataDir)
+		unitArgs = append(unitArgs, command[1:]...)
+
+		svcUnit, timerUnit, err := service.GenerateSystemdUnits(service.SystemdUnitConfig{
+			Description: description,
+
+			Executable: exe,
+			Args:       unitArgs,
+
+			Schedule: serviceScheduleFlag,
+			User:     serviceUserFlag,
+
+			DataDir:        dataDir,
+			ReadWritePaths: serviceRWPathFlags,
+
+			Devices:         serviceAllowDeviceFlags,
+			DevicesReadOnly: serviceDevicesReadOnlyFlag,
+		})
+		if err != nil {
+			slog.Error("Failed to generate systemd units", "error", err.Error())
+			os.Exit(exitcode.Usage)
+		}
+
+		units := []struct {
+			fileName string
+			data     []byte
+		}{{unitName + ".service", svcUnit}}
+
+		if timerUnit != nil {
+			units = append(units, struct {
+				fileName string
+				data     []byte
+			}{unitName + ".timer", timerUnit})
+		}
+
+		if serviceOutputDirFlag == "" {
+			for i, unit := range units {
+				if i != 0 {
+					fmt.Println()
+				}
+
+				fmt.Printf("# %v\n%s", unit.fileName, unit.data)
+			}
+
+			return
+		}
+
+		for _, unit := range units {
+			p := filepath.Join(serviceOutputDirFlag, unit.fileName)
+
+			err := os.WriteFile(p, unit.data, 0644)
+			if err != nil {
+				slog.Error("Failed to write systemd unit", "error", err.Error(), "path", p)
+				os.Exit(exitcode.ForError(err))
+			}
+
+			slog.Info("Wrote systemd unit", "path", p)
+		}
+
+		slog.Info("Enable the unit with `systemctl daemon-reload && systemctl enable --now " + units[len(units)-1].fileName + "`")
+	},
+}
+
+var (
+	serviceLogMaxSizeFlag    uint32
+	serviceLogMaxBackupsFlag int
+
+	serviceScheduleFlag        string
+	serviceUnitNameFlag        string
+	serviceUserFlag            string
+	serviceOutputDirFlag       string
+	serviceAllowDeviceFlags    []string
+	serviceDevicesReadOnlyFlag bool
+	serviceRWPathFlags         []string
+)
+
+func init() {
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	serviceCmd.AddCommand(serviceGenerateSystemdCmd)
+
+	serviceInstallCmd.Flags().Uint32Var(&serviceLogMaxSizeFlag, "log-max-size", 10, "Specifies the size in MiB after which the daemon log file is rotated.")
+	serviceInstallCmd.Flags().IntVar(&serviceLogMaxBackupsFlag, "log-max-backups", 5, "Specifies the number of rotated daemon log files to keep.")
+
+	serviceGenerateSystemdCmd.Flags().StringVar(&serviceScheduleFlag, "schedule", "", `Run the command on the schedule specified as a systemd calendar event expression, like "daily" or "Mon *-*-* 03:00:00". A timer unit is generated too.`)
+	serviceGenerateSystemdCmd.Flags().StringVar(&serviceUnitNameFlag, "unit-name", "", `Specifies the unit name, without the suffix. (default is "linsk-<command>", like "linsk-daemon")`)
+	serviceGenerateSystemdCmd.Flags().StringVar(&serviceUserFlag, "user", "", `Run the command as the specified user instead of root. The user is added to the "disk" and "kvm" groups.`)
+	serviceGenerateSystemdCmd.Flags().StringVar(&serviceOutputDirFlag, "output-dir", "", `Write the units to the specified directory (like /etc/systemd/system) instead of printing them.`)
+	serviceGenerateSystemdCmd.Flags().StringArrayVar(&serviceAllowDeviceFlags, "allow-device", nil, "Allow access only to the specified block device (like /dev/disk/by-id/...). Can be specified multiple times.")
+	serviceGenerateSystemdCmd.Flags().BoolVar(&serviceDevicesReadOnlyFlag, "devices-read-only", false, "Allow only reading the block devices.")
+	serviceGenerateSystemdCmd.Flags().StringArrayVar(&serviceRWPathFlags, "rw-path", nil, "Make the specified directory writable by the command, like the backup destination. Can be specified multiple times.")
+}
@@ -32,9 +32,10 @@ import (
 )
 
 var shellCmd = &cobra.Command{
-	Use:   "shell",
-	Short: "Start a VM and access the shell. Useful for formatting drives and debugging.",
-	Args:  cobra.RangeArgs(0, 1),
+	Use:               "shell",
+	Short:             "Start a VM and access the shell. Useful for formatting drives and debugging.",
+	Args:              cobra.RangeArgs(0, 1),
+	ValidArgsFunction: completeVMCommandArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		var passthroughArg string
 		if len(args) > 0 {
@@ -97,6 +98,11 @@ func runVMShell(ctx context.Context, vi *vm.VM) error {
 	defer func() { _ = sess.Close() }()
 
 	termFD := int(os.Stdin.Fd())
+	if !term.IsTerminal(termFD) {
+		// Likely piped input. A PTY would only get in the way.
+		return runVMShellNoPTY(ctx, sess)
+	}
+
 	termState, err := term.MakeRaw(termFD)
 	if err != nil {
 		return errors.Wrap(err, "make raw terminal")
@@ -120,6 +126,27 @@ func runVMShell(ctx context.Context, vi *vm.VM) error {
 		return errors.Wrap(err, "get terminal size")
 	}
 
+	resizeCtx, resizeCtxCancel := context.WithCancel(ctx)
+	defer resizeCtxCancel()
+
+	go func() {
+		width, height := termWidth, termHeight
+
+		for range osspecifics.NotifyTerminalResize(resizeCtx) {
+			newWidth, newHeight, err := term.GetSize(termFDGetSize)
+			if err != nil || (newWidth == width && newHeight == height) {
+				continue
+			}
+
+			width, height = newWidth, newHeight
+
+			err = sess.WindowChange(height, width)
+			if err != nil {
+				slog.Warn("Failed to propagate terminal resize to the VM", "error", err.Error())
+			}
+		}
+	}()
+
 	termModes := ssh.TerminalModes{
 		ssh.ECHO:          1,
 		ssh.TTY_OP_ISPEED: 14400,
@@ -140,7 +167,19 @@ func runVMShell(ctx context.Context, vi *vm.VM) error {
 	sess.Stdout = os.Stdout
 	sess.Stderr = os.Stderr
 
-	err = sess.Shell()
+	return startAndWaitVMShell(ctx, sess)
+}
+
+func runVMShellNoPTY(ctx context.Context, sess *ssh.Session) error {
+	sess.Stdin = os.Stdin
+	sess.Stdout = os.Stdout
+	sess.Stderr = os.Stderr
+
+	return startAndWaitVMShell(ctx, sess)
+}
+
+func startAndWaitVMShell(ctx context.Context, sess *ssh.Session) error {
+	err := sess.Shell()
 	if err != nil {
 		return errors.Wrap(err, "start vm ssh shell")
 	}
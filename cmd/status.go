@@ -0,0 +1,137 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:               "status [name]",
+	Short:             "Show the running Linsk instances (or only the named one) with their attached devices, mounts, and file shares.",
+	Args:              cobra.RangeArgs(0, 1),
+	ValidArgsFunction: completeInstanceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		instances, err := store.ListInstances()
+		if err != nil {
+			slog.Error("Failed to list running instances", "error", err.Error())
+			os.Exit(1)
+		}
+
+		name := instanceNameFlag
+		if len(args) != 0 {
+			name = args[0]
+		}
+
+		if name != "" {
+			instances = slices.DeleteFunc(instances, func(inst storage.Instance) bool {
+				return inst.ID != name
+			})
+
+			if len(instances) == 0 {
+				slog.Error("No running instance with the name", "name", name)
+				os.Exit(1)
+			}
+		}
+
+		if jsonOutputFlag {
+			if instances == nil {
+				instances = []storage.Instance{}
+			}
+
+			err = json.NewEncoder(os.Stdout).Encode(instances)
+			if err != nil {
+				slog.Error("Failed to encode running instances", "error", err.Error())
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		if len(instances) == 0 {
+			fmt.Print("<no running instances>\n")
+			return
+		}
+
+		for i, inst := range instances {
+			if i != 0 {
+				fmt.Print("\n")
+			}
+
+			printInstance(inst)
+		}
+	},
+}
+
+func printInstance(inst storage.Instance) {
+	fmt.Printf("%v (linsk %v, PID %v)\n", inst.ID, inst.Command, inst.PID)
+	fmt.Printf("  Uptime: %v\n", time.Since(inst.StartedAt).Round(time.Second))
+
+	if inst.Target != "" {
+		fmt.Printf("  Target: %v\n", inst.Target)
+	}
+
+	if len(inst.AttachedDevices) != 0 {
+		fmt.Print("  Attached devices:\n")
+		for _, dev := range inst.AttachedDevices {
+			fmt.Printf("    %v: %v\n", dev.DevName, dev.Source)
+		}
+	}
+
+	if len(inst.Mounts) != 0 {
+		fmt.Print("  Mounts:\n")
+		for _, m := range inst.Mounts {
+			var opts []string
+			if m.FSType != "" {
+				opts = append(opts, m.FSType)
+			}
+
+			if m.ReadOnly {
+				opts = append(opts, "read-only")
+			}
+
+			line := "    " + m.Device
+			if len(opts) != 0 {
+				line += " (" + strings.Join(opts, ", ") + ")"
+			}
+
+			fmt.Println(line)
+		}
+	}
+
+	if len(inst.Shares) != 0 {
+		fmt.Print("  Shares:\n")
+		for _, sh := range inst.Shares {
+			fmt.Printf("    %v: %v\n", strings.ToUpper(sh.Backend), sh.URL)
+		}
+	}
+
+	if inst.CredentialsPath != "" {
+		fmt.Printf("  Credentials: %v\n", inst.CredentialsPath)
+	}
+}
@@ -0,0 +1,101 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/shirou/gopsutil/process"
+	"github.com/spf13/cobra"
+)
+
+var stopCmd = &cobra.Command{
+	Use:               "stop <name>",
+	Short:             "Gracefully shut down a running instance, e.g. one started with \"linsk run --detach\".",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+		name := args[0]
+
+		inst, err := findRunningInstance(store, name)
+		if err != nil {
+			slog.Error("Failed to find the instance", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if inst == nil {
+			slog.Error("No running instance with the name", "name", name)
+			os.Exit(1)
+		}
+
+		slog.Info("Stopping the instance", "name", name, "pid", inst.PID)
+
+		stopped := false
+
+		err = osspecifics.InterruptProcess(inst.PID)
+		if err != nil {
+			slog.Warn("Failed to interrupt the instance, terminating it instead", "error", err.Error())
+		} else {
+			stopped = waitProcessExit(inst.PID, stopTimeoutFlag)
+			if !stopped {
+				slog.Warn("The instance did not shut down in time, terminating it", "timeout", stopTimeoutFlag)
+			}
+		}
+
+		if !stopped {
+			err = osspecifics.TerminateProcess(inst.PID)
+			if err != nil {
+				slog.Error("Failed to terminate the instance", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if !waitProcessExit(inst.PID, stopTimeoutFlag) {
+				slog.Error("The instance is still running after being terminated", "pid", inst.PID)
+				os.Exit(1)
+			}
+		}
+
+		slog.Info("The instance was stopped", "name", name)
+	},
+}
+
+var stopTimeoutFlag time.Duration
+
+func init() {
+	stopCmd.Flags().DurationVar(&stopTimeoutFlag, "timeout", time.Minute, "How long to wait for the instance to shut down gracefully before terminating it.")
+}
+
+// waitProcessExit waits for the process to exit, reporting
+// whether it did so before the timeout.
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		running, err := process.PidExists(int32(pid))
+		if err == nil && !running {
+			return true
+		}
+
+		time.Sleep(detachedPollInterval)
+	}
+
+	return false
+}
@@ -20,20 +20,27 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"log/slog"
 
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
 	"github.com/AlexSSD7/linsk/cmd/runvm"
+	"github.com/AlexSSD7/linsk/imgbuilder"
 	"github.com/AlexSSD7/linsk/nettap"
 	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/qemucli"
 	"github.com/AlexSSD7/linsk/share"
 	"github.com/AlexSSD7/linsk/storage"
 	"github.com/AlexSSD7/linsk/vm"
 	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func createStoreOrExit() *storage.Storage {
@@ -43,16 +50,164 @@ func createStoreOrExit() *storage.Storage {
 		os.Exit(1)
 	}
 
+	store.SetOffline(offlineFlag)
+	store.SetAlpineMirrors(alpineMirrorFlags)
+
+	flavor, err := imgbuilder.ParseFlavor(imageFlavorFlag)
+	if err != nil {
+		slog.Error("Bad image flavor", "error", err.Error())
+		os.Exit(1)
+	}
+
+	store.SetImageFlavor(flavor)
+	store.SetProgress(getProgressFunc())
+
 	return store
 }
 
+// checkLeftovers reports the resources left behind by crashed previous sessions,
+// and removes them if requested to. These leftovers often cause confusing
+// "device busy" or "address in use" failures.
+func checkLeftovers(store *storage.Storage, clean bool) {
+	var tm *nettap.TapManager
+	if nettap.Available() {
+		var err error
+		tm, err = nettap.NewTapManager(slog.With("caller", "nettap-manager"))
+		if err != nil {
+			slog.Warn("Failed to create network tap manager, will not check for leftover network taps", "error", err.Error())
+			tm = nil
+		}
+	}
+
+	leftovers, err := store.FindLeftovers(tm)
+	if err != nil {
+		slog.Warn("Failed to check for leftovers from previous sessions", "error", err.Error())
+		return
+	}
+
+	if leftovers.Empty() {
+		return
+	}
+
+	slog.Warn("Found leftovers from crashed previous sessions", "dangling-taps", len(leftovers.DanglingTapAllocs), "orphaned-taps", len(leftovers.OrphanedTaps), "stale-temp-dirs", len(leftovers.StaleTempDirs), "dangling-qemu-processes", leftovers.DanglingQEMUPIDs)
+
+	if !clean {
+		slog.Warn("Leftovers from previous sessions may cause \"device busy\" or \"address in use\" failures. Add --auto-clean to remove them.")
+		return
+	}
+
+	err = store.CleanLeftovers(tm, leftovers)
+	if err != nil {
+		slog.Error("Failed to clean up leftovers from previous sessions", "error", err.Error())
+		return
+	}
+
+	slog.Info("Cleaned up leftovers from previous sessions")
+}
+
+// newInterruptContext returns a context which is canceled on Ctrl-C (SIGINT)
+// or SIGTERM. The signals are handled as usual again once it is canceled.
+func newInterruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// getVMImagePath resolves the VM image to use. In dry runs, a custom image is
+// not downloaded. Instead, the URL it would be downloaded from is returned.
+func getVMImagePath(ctx context.Context, store *storage.Storage) (string, string, error) {
+	customImage := storage.CustomVMImageConfig{
+		Path:   imagePathFlag,
+		URL:    imageURLFlag,
+		SHA256: imageSHA256Flag,
+
+		MetadataURL: imageMetadataURLFlag,
+		PublicKeys:  imagePubKeyFlags,
+	}
+
+	if !customImage.IsSet() {
+		if imageSHA256Flag != "" {
+			return "", "", fmt.Errorf("--image-sha256 requires --image-path, --image-url or --image-metadata-url")
+		}
+
+		p, version, err := store.ResolveVMImage(imageVersionFlag)
+		if err != nil {
+			return "", "", errors.Wrap(err, "resolve vm image")
+		}
+
+		if p == "" && version != storage.LatestVMImageVersion() {
+			return "", "", fmt.Errorf("pinned VM image version %v does not exist in the data directory", version)
+		}
+
+		if p != "" && !skipImageCheckFlag {
+			// Dry runs must not have any side effects, hence the hash
+			// of the older images is not recorded.
+			if dryRunFlag {
+				err = store.CheckVMImage(p)
+			} else {
+				err = store.VerifyVMImage(p)
+			}
+			if err != nil {
+				if errors.Is(err, storage.ErrImageCorrupted) {
+					return "", "", fmt.Errorf("%w. Rebuild it with `linsk build --overwrite`, or skip this check with --skip-image-check", err)
+				}
+
+				return "", "", errors.Wrap(err, "verify vm image")
+			}
+		}
+
+		if version < storage.LatestVMImageVersion() {
+			slog.Warn("A newer VM image with additional file system support is available. Run `linsk image update` to switch to it", "pinned", version, "latest", storage.LatestVMImageVersion())
+		}
+
+		return p, "", nil
+	}
+
+	if dryRunFlag {
+		p, downloadURL, err := store.PlanCustomVMImage(ctx, customImage)
+		if err != nil {
+			return "", "", errors.Wrap(err, "plan custom vm image")
+		}
+
+		return p, downloadURL, nil
+	}
+
+	p, err := store.CheckCustomVMImage(ctx, customImage)
+	if err != nil {
+		return "", "", errors.Wrap(err, "check custom vm image")
+	}
+
+	return p, "", nil
+}
+
 func runVM(passthroughArg string, fn runvm.Func, forwardPortsRules []vm.PortForwardingRule, unrestrictedNetworking bool, withNetTap bool) int {
 	store := createStoreOrExit()
 
-	vmImagePath, err := store.CheckVMImageExists()
+	if instanceNameFlag != "" && !dryRunFlag {
+		err := checkInstanceNameAvailable(store, instanceNameFlag)
+		if err != nil {
+			slog.Error("Cannot use the instance name", "error", err.Error(), "name", instanceNameFlag)
+			return 1
+		}
+	}
+
+	// Dry runs must not have any side effects.
+	checkLeftovers(store, autoCleanFlag && !dryRunFlag)
+
+	// Ctrl-C stops the preparation (like the image and BIOS downloads)
+	// gracefully. Once started, the VM handles the interrupts on its own.
+	prepCtx, prepCtxCancel := newInterruptContext()
+	defer prepCtxCancel()
+
+	// Dry runs must not download anything, hence these are only reported.
+	var pendingDownloads []string
+
+	vmImagePath, imageDownloadURL, err := getVMImagePath(prepCtx, store)
 	if err != nil {
 		slog.Error("Failed to check whether VM image exists", "error", err.Error())
-		return 1
+		return exitcode.ForError(err)
+	}
+
+	if imageDownloadURL != "" {
+		pendingDownloads = append(pendingDownloads, imageDownloadURL)
 	}
 
 	if vmImagePath == "" {
@@ -60,24 +215,87 @@ func runVM(passthroughArg string, fn runvm.Func, forwardPortsRules []vm.PortForw
 		return 1
 	}
 
-	biosPath, err := store.CheckDownloadVMBIOS(context.Background())
+	accelMode, err := vm.ParseAccelMode(vmAccelFlag)
+	if err != nil {
+		slog.Error("Bad VM acceleration mode", "error", err.Error())
+		return 1
+	}
+
+	driveCache, err := vm.ParseDriveCache(vmDriveCacheFlag)
+	if err != nil {
+		slog.Error("Bad VM drive cache mode", "error", err.Error())
+		return 1
+	}
+
+	driveAIO, err := vm.ParseDriveAIO(vmDriveAIOFlag)
 	if err != nil {
-		slog.Error("Failed to check/download VM BIOS", "error", err.Error())
+		slog.Error("Bad VM drive AIO mode", "error", err.Error())
 		return 1
 	}
 
+	// Dry runs must not have any side effects, hence no overlay is created.
+	driveSnapshotMode := true
+	if persistVMFlag && !dryRunFlag {
+		vmImagePath, err = store.CheckCreateVMOverlay(prepCtx, vmImagePath, instanceNameFlag)
+		if err != nil {
+			slog.Error("Failed to check/create persistent VM overlay", "error", err.Error())
+			return exitcode.ForError(err)
+		}
+
+		driveSnapshotMode = false
+	}
+
+	var biosPath string
+
+	if dryRunFlag {
+		var biosDownloadURL string
+
+		biosPath, biosDownloadURL, err = store.PlanVMBIOS()
+		if err != nil {
+			slog.Error("Failed to check VM BIOS", "error", err.Error())
+			return exitcode.ForError(err)
+		}
+
+		if biosDownloadURL != "" {
+			pendingDownloads = append(pendingDownloads, biosDownloadURL)
+		}
+	} else {
+		biosPath, err = store.CheckDownloadVMBIOS(prepCtx)
+		if err != nil {
+			slog.Error("Failed to check/download VM BIOS", "error", err.Error())
+			return exitcode.ForError(err)
+		}
+	}
+
 	var passthroughConfig vm.PassthroughConfig
 
 	if passthroughArg != "" {
 		passthroughConfigPtr, err := getDevicePassthroughConfig(passthroughArg)
 		if err != nil {
 			slog.Error("Failed to get device passthrough config", "error", err.Error())
-			return 1
+			return exitcode.ForError(err)
 		}
 
 		passthroughConfig = *passthroughConfigPtr
 	}
 
+	for _, extraDevice := range vmRuntimeExtraDevicesFlag {
+		extraConfig, err := getDevicePassthroughConfig(extraDevice)
+		if err != nil {
+			slog.Error("Failed to get extra device passthrough config", "error", err.Error(), "value", extraDevice)
+			return exitcode.ForError(err)
+		}
+
+		passthroughConfig.USB = append(passthroughConfig.USB, extraConfig.USB...)
+		passthroughConfig.Block = append(passthroughConfig.Block, extraConfig.Block...)
+	}
+
+	if readOnlyFlag {
+		for i := range passthroughConfig.Block {
+			passthroughConfig.Block[i].ReadOnly = true
+		}
+	}
+
 	if len(passthroughConfig.USB) != 0 {
 		// Log USB-related warnings.
 
@@ -97,7 +315,19 @@ func runVM(passthroughArg string, fn runvm.Func, forwardPortsRules []vm.PortForw
 	var tapRuntimeCtx *share.NetTapRuntimeContext
 	var tapsConfig []vm.TapConfig
 
-	if withNetTap {
+	if withNetTap && (dryRunFlag || printQEMUCmdFlag) {
+		// Not creating the tap for real, as it requires admin rights, but
+		// still generating a name to show the resulting QEMU configuration.
+		tapNameToUse, err := nettap.NewUniqueTapName()
+		if err != nil {
+			slog.Error("Failed to generate new network tap name", "error", err.Error())
+			return 1
+		}
+
+		tapsConfig = []vm.TapConfig{{
+			Name: tapNameToUse,
+		}}
+	} else if withNetTap {
 		tapManager, err := nettap.NewTapManager(slog.With("caller", "nettap-manager"))
 		if err != nil {
 			slog.Error("Failed to create new network tap manager", "error", err.Error())
@@ -191,21 +421,33 @@ func runVM(passthroughArg string, fn runvm.Func, forwardPortsRules []vm.PortForw
 	vmCfg := vm.Config{
 		Drives: []vm.DriveConfig{{
 			Path:         vmImagePath,
-			SnapshotMode: true,
+			SnapshotMode: driveSnapshotMode,
 		}},
 
 		MemoryAlloc: vmMemAllocFlag,
+		CPUs:        vmCPUsFlag,
 		BIOSPath:    biosPath,
 
 		PassthroughConfig:        passthroughConfig,
 		ExtraPortForwardingRules: forwardPortsRules,
 
+		DriveIO: vm.DriveIOConfig{
+			Cache: driveCache,
+			AIO:   driveAIO,
+		},
+
+		Hostname: getVMHostname(),
+
 		UnrestrictedNetworking: unrestrictedNetworking,
 		Taps:                   tapsConfig,
 
 		OSUpTimeout:  time.Duration(vmOSUpTimeoutFlag) * time.Second,
 		SSHUpTimeout: time.Duration(vmSSHSetupTimeoutFlag) * time.Second,
 
+		Accel: accelMode,
+
+		Progress: getProgressFunc(),
+
 		Debug: vmDebugFlag,
 	}
 
@@ -215,17 +457,68 @@ func runVM(passthroughArg string, fn runvm.Func, forwardPortsRules []vm.PortForw
 		return 1
 	}
 
-	return runvm.RunVM(vi, true, tapRuntimeCtx, fn)
+	if dryRunFlag {
+		printDryRunPlan(vmCfg, vi, pendingDownloads)
+		return 0
+	}
+
+	if printQEMUCmdFlag {
+		fmt.Println(qemucli.RenderCommandLine(vi.QEMUCommandLine()))
+		return 0
+	}
+
+	prepCtxCancel()
+
+	return runvm.RunVM(vi, true, tapRuntimeCtx, func(ctx context.Context, vi *vm.VM, fm *vm.FileManager, trc *share.NetTapRuntimeContext) int {
+		unregister := registerRuntimeInstance(store, vi, passthroughArg)
+		defer unregister()
+
+		return fn(ctx, vi, fm, trc)
+	})
+}
+
+func getImagePassthroughConfig(path string) (*vm.PassthroughConfig, error) {
+	path = filepath.Clean(path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: image file '%v' does not exist", vm.ErrDeviceNotFound, path)
+		}
+
+		return nil, errors.Wrap(err, "stat image file")
+	}
+
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("'%v' is not a regular file", path)
+	}
+
+	format := qemucli.ImgFormat(imageFormatFlag)
+	if format == "" {
+		format = qemucli.ImgFormatByExt(path)
+	}
+
+	return &vm.PassthroughConfig{Block: []vm.BlockDevicePassthroughConfig{{
+		Path:        path,
+		BlockSize:   512,
+		ImageFormat: format,
+	}}}, nil
 }
 
 func getDevicePassthroughConfig(val string) (*vm.PassthroughConfig, error) {
+	// Disk image files do not require root privileges. The path is
+	// not split any further, as Windows paths contain colons.
+	if imgPath, ok := strings.CutPrefix(val, "img:"); ok {
+		return getImagePassthroughConfig(imgPath)
+	}
+
 	isRoot, err := osspecifics.CheckRunAsRoot()
 	if err != nil {
 		return nil, errors.Wrap(err, "check whether the program is run as root")
 	}
 
 	if !isRoot {
-		return nil, fmt.Errorf("device passthrough of any type requires root (admin) privileges")
+		return nil, fmt.Errorf("%w: device passthrough of any type requires root (admin) privileges", vm.ErrPermissionDenied)
 	}
 
 	valSplit := strings.Split(val, ":")
@@ -250,6 +543,13 @@ func getDevicePassthroughConfig(val string) (*vm.PassthroughConfig, error) {
 			return nil, fmt.Errorf("bad usb product id '%v'", usbValsSplit[1])
 		}
 
+		if !vmRuntimeUSBRedirectFlag {
+			cfg := getUSBDiskPassthroughConfig(uint16(vendorID), uint16(productID))
+			if cfg != nil {
+				return cfg, nil
+			}
+		}
+
 		return &vm.PassthroughConfig{
 			USB: []vm.USBDevicePassthroughConfig{{
 				VendorID:  uint16(vendorID),
@@ -261,6 +561,10 @@ func getDevicePassthroughConfig(val string) (*vm.PassthroughConfig, error) {
 
 		err := osspecifics.CheckValidDevicePath(devPath)
 		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w: '%v'", vm.ErrDeviceNotFound, devPath)
+			}
+
 			return nil, errors.Wrapf(err, "check whether device path is valid '%v'", devPath)
 		}
 
@@ -290,6 +594,10 @@ func getDevicePassthroughConfig(val string) (*vm.PassthroughConfig, error) {
 
 		err := osspecifics.CheckValidDevicePath(devPath)
 		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w: '%v'", vm.ErrDeviceNotFound, devPath)
+			}
+
 			return nil, errors.Wrapf(err, "check whether device path is valid '%v'", devPath)
 		}
 
@@ -301,3 +609,89 @@ func getDevicePassthroughConfig(val string) (*vm.PassthroughConfig, error) {
 		return nil, fmt.Errorf("unknown device passthrough type '%v'", val)
 	}
 }
+
+// getUSBDiskPassthroughConfig returns the block device passthrough config
+// for the disk of the USB device, which is attached to the VM as a virtio-blk
+// drive. This is much faster and more stable than redirecting the whole USB
+// device. Nil is returned if the disk cannot be passed through this way, in
+// which case the USB device is to be redirected instead.
+func getUSBDiskPassthroughConfig(vendorID uint16, productID uint16) *vm.PassthroughConfig {
+	lg := slog.With("vendor-id", fmt.Sprintf("%04x", vendorID), "product-id", fmt.Sprintf("%04x", productID))
+
+	devPaths, err := osspecifics.FindUSBBlockDevices(vendorID, productID)
+	if err != nil {
+		lg.Warn("Failed to find the disk of the USB device, falling back to USB redirection", "error", err.Error())
+		return nil
+	}
+
+	if len(devPaths) != 1 {
+		// A card reader has a disk per slot, and there may be
+		// several devices of the same model plugged in.
+		lg.Info("No single disk found for the USB device, falling back to USB redirection", "disks", strings.Join(devPaths, ", "))
+		return nil
+	}
+
+	devPath := devPaths[0]
+	lg = lg.With("dev", devPath)
+
+	// The raw block device passthrough refuses the disks in use by the host,
+	// while the USB redirection takes them away from it.
+	seemsMounted, err := osspecifics.CheckDeviceSeemsMounted(devPath)
+	if err == nil && seemsMounted {
+		err = fmt.Errorf("device seems to be mounted in the host system")
+	}
+
+	if err == nil {
+		err = osspecifics.CheckDeviceExclusiveAccess(devPath)
+	}
+
+	if err != nil {
+		lg.Warn("The disk of the USB device cannot be passed through as a block device, falling back to USB redirection", "error", err.Error())
+		return nil
+	}
+
+	blockSize, err := osspecifics.GetDeviceLogicalBlockSize(devPath)
+	if err != nil {
+		lg.Warn("Failed to get the logical block size of the USB device disk, falling back to USB redirection", "error", err.Error())
+		return nil
+	}
+
+	lg.Info(`Attaching the disk of the USB device as a virtio-blk drive. It appears in the VM as a "vd*" device instead of a "sd*" one. Use --usb-redirect to redirect the USB device instead`)
+
+	return &vm.PassthroughConfig{Block: []vm.BlockDevicePassthroughConfig{{
+		Path:      devPath,
+		BlockSize: blockSize,
+	}}}
+}
+
+// getChildProcessBaseArgs forwards the explicitly set global flags
+// (like --data-dir) to the Linsk processes started by the command.
+func getChildProcessBaseArgs(cmd *cobra.Command) []string {
+	var args []string
+
+	// InheritedFlags returns a new flag set, which does not track the flags set
+	// on the command line, hence VisitAll and the check of the shared flags.
+	cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+
+		switch f.Name {
+		case "json", "progress":
+			// These are set by the caller.
+			return
+		}
+
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, fmt.Sprintf("--%v=%v", f.Name, v))
+			}
+
+			return
+		}
+
+		args = append(args, fmt.Sprintf("--%v=%v", f.Name, f.Value.String()))
+	})
+
+	return args
+}
@@ -0,0 +1,81 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/spf13/cobra"
+)
+
+var vmCmd = &cobra.Command{
+	Use:   "vm",
+	Short: "Manage the persistent VM state.",
+}
+
+var vmResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Discard the persistent VM overlays created with --persist-vm, reverting the VM to its pristine image. Only the named instance's overlays are discarded if --name is set.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := createStoreOrExit()
+
+		if !vmResetYesFlag {
+			msg := "Will discard all changes made in the persistent VM. Proceed?"
+			if instanceNameFlag != "" {
+				msg = fmt.Sprintf("Will discard all changes made in the persistent VM of instance '%v'. Proceed?", instanceNameFlag)
+			}
+
+			proceed, err := promptYesNo(msg)
+			if err != nil {
+				slog.Error("Failed to read answer", "error", err.Error())
+				os.Exit(1)
+			}
+
+			if !proceed {
+				fmt.Fprintf(os.Stderr, "Aborted.\n")
+				os.Exit(exitcode.Aborted)
+			}
+		}
+
+		removed, err := store.ResetVMOverlays(instanceNameFlag)
+		if err != nil {
+			slog.Error("Failed to reset persistent VM overlays", "error", err.Error())
+			os.Exit(1)
+		}
+
+		if len(removed) == 0 {
+			slog.Info("No persistent VM overlays to reset")
+			return
+		}
+
+		for _, p := range removed {
+			slog.Info("Removed persistent VM overlay", "path", p)
+		}
+	},
+}
+
+var vmResetYesFlag bool
+
+func init() {
+	vmCmd.AddCommand(vmResetCmd)
+
+	vmResetCmd.Flags().BoolVarP(&vmResetYesFlag, "yes", "y", false, "Do not ask for confirmation.")
+}
@@ -0,0 +1,286 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexSSD7/linsk/config"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// The file systems that the host can usually access on its own.
+var watchSkippedFSTypes = map[string]bool{
+	"vfat":      true,
+	"exfat":     true,
+	"ntfs":      true,
+	"hfsplus":   true,
+	"apfs":      true,
+	"iso9660":   true,
+	"udf":       true,
+	"BitLocker": true,
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [-- <run flags>]",
+	Short: "Watch for newly attached disks with Linux file systems, and offer to share them with \"linsk run --detach\". The disks attached before the start are ignored.",
+	Run: func(cmd *cobra.Command, args []string) {
+		runArgs := args
+		if len(runArgs) == 0 {
+			configPath := getConfigPathOrExit()
+
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				slog.Error("Failed to load config file", "error", err.Error(), "path", configPath)
+				os.Exit(1)
+			}
+
+			runArgs = cfg.WatchRunArgs
+		}
+
+		if !watchAutoRunFlag && !canUsePicker() {
+			slog.Warn("Not running in a terminal, so the newly attached disks will only be reported. Use --auto-run to share them without asking.")
+		}
+
+		exePath, err := os.Executable()
+		if err != nil {
+			slog.Error("Failed to get the path to the Linsk executable", "error", err.Error())
+			os.Exit(1)
+		}
+
+		w := &diskWatcher{
+			exePath:  exePath,
+			baseArgs: getChildProcessBaseArgs(cmd),
+			runArgs:  runArgs,
+		}
+
+		known, err := listHostBlockDevicesSet()
+		if err != nil {
+			slog.Error("Failed to list host block devices", "error", err.Error())
+			os.Exit(1)
+		}
+
+		ctx, ctxCancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer ctxCancel()
+
+		slog.Info("Watching for newly attached disks", "interval", watchIntervalFlag, "auto-run", watchAutoRunFlag)
+
+		ticker := time.NewTicker(watchIntervalFlag)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := listHostBlockDevicesSet()
+			if err != nil {
+				slog.Warn("Failed to list host block devices", "error", err.Error())
+				continue
+			}
+
+			// Updating the known devices first, so that a disk that is removed and
+			// attached again during the handling of another one is not missed.
+			prev := known
+			known = current
+
+			for dev := range current {
+				if prev[dev] {
+					continue
+				}
+
+				w.handleNewDisk(dev)
+
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}
+	},
+}
+
+var (
+	watchAutoRunFlag  bool
+	watchIntervalFlag time.Duration
+)
+
+func init() {
+	watchCmd.Flags().BoolVar(&watchAutoRunFlag, "auto-run", false, `Share the Linux file systems on the newly attached disks without asking. Can be set with "watch_auto_run" in the config file.`)
+	watchCmd.Flags().DurationVar(&watchIntervalFlag, "interval", 2*time.Second, "How often to check for newly attached disks.")
+}
+
+type diskWatcher struct {
+	exePath  string
+	baseArgs []string
+
+	// runArgs are the extra arguments to start `linsk run` with.
+	runArgs []string
+}
+
+func listHostBlockDevicesSet() (map[string]bool, error) {
+	devs, err := osspecifics.ListHostBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool, len(devs))
+	for _, dev := range devs {
+		ret[dev] = true
+	}
+
+	return ret, nil
+}
+
+func (w *diskWatcher) handleNewDisk(dev string) {
+	target := "dev:" + dev
+
+	slog.Info("New disk attached, looking for Linux file systems on it", "dev", dev)
+
+	devs, err := w.inspectDisk(target)
+	if err != nil {
+		slog.Error("Failed to inspect the new disk", "dev", dev, "error", err.Error())
+		return
+	}
+
+	candidates := findLinuxFilesystems(devs)
+	if len(candidates) == 0 {
+		slog.Info("No Linux file systems were found on the new disk", "dev", dev)
+		return
+	}
+
+	if !watchAutoRunFlag {
+		if !canUsePicker() {
+			names := make([]string, 0, len(candidates))
+			for _, c := range candidates {
+				names = append(names, c.Name)
+			}
+
+			slog.Info("Found Linux file systems on the new disk", "dev", dev, "filesystems", names)
+
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "Found Linux file systems on %v:\n", dev)
+		for _, c := range candidates {
+			fmt.Fprintf(os.Stderr, "  %v\n", formatPickerBlockDevice(c))
+		}
+
+		ok, err := promptYesNo("Mount and share them?")
+		if err != nil {
+			slog.Error("Failed to prompt", "error", err.Error())
+			return
+		}
+
+		if !ok {
+			return
+		}
+	}
+
+	args := append(append([]string{}, w.baseArgs...), "run", "--detach", target)
+	if len(candidates) == 1 {
+		args = append(args, candidates[0].Name)
+	} else {
+		for _, c := range candidates {
+			args = append(args, "--mount="+c.Name)
+		}
+	}
+
+	args = append(args, w.runArgs...)
+
+	slog.Info("Sharing the new disk", "dev", dev)
+
+	c := exec.Command(w.exePath, args...) //#nosec G204 // This is the Linsk executable itself.
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	err = c.Run()
+	if err != nil {
+		slog.Error("Failed to share the new disk", "dev", dev, "error", err.Error())
+	}
+}
+
+// inspectDisk lists the block devices on the disk with `linsk ls`.
+func (w *diskWatcher) inspectDisk(target string) ([]vm.BlockDevice, error) {
+	args := append(append([]string{}, w.baseArgs...), "ls", target, "--json", "--progress=none")
+
+	stderr := new(bytes.Buffer)
+
+	c := exec.Command(w.exePath, args...) //#nosec G204 // This is the Linsk executable itself.
+	c.Stderr = stderr
+
+	out, err := c.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "run linsk ls (%v)", lastLine(stderr.String()))
+	}
+
+	var ls struct {
+		BlockDevices []vm.BlockDevice `json:"block_devices"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		err := dec.Decode(&ls)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, errors.Wrap(err, "decode linsk ls output")
+		}
+	}
+
+	return ls.BlockDevices, nil
+}
+
+// findLinuxFilesystems returns the mountable file systems the host
+// is unlikely to be able to access on its own.
+func findLinuxFilesystems(devs []vm.BlockDevice) []*vm.BlockDevice {
+	var ret []*vm.BlockDevice
+
+	for i := range devs {
+		dev := &devs[i]
+
+		if dev.Classification.Kind == vm.DeviceKindFilesystem && dev.Classification.Mountable && !watchSkippedFSTypes[dev.FSType] {
+			ret = append(ret, dev)
+		}
+
+		ret = append(ret, findLinuxFilesystems(dev.Children)...)
+	}
+
+	return ret
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,119 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the user defaults. The CLI flags take precedence over
+// the values set here. Blank values are ignored.
+type Config struct {
+	DataDir      string `yaml:"data_dir,omitempty"`
+	ShareBackend string `yaml:"share_backend,omitempty"`
+	ShareListen  string `yaml:"share_listen,omitempty"`
+	ImageFlavor  string `yaml:"image_flavor,omitempty"`
+
+	VMMemAlloc        uint32 `yaml:"vm_mem_alloc,omitempty"`
+	VMCPUs            uint32 `yaml:"vm_cpus,omitempty"`
+	VMOSUpTimeout     uint32 `yaml:"vm_os_up_timeout,omitempty"`
+	VMSSHSetupTimeout uint32 `yaml:"vm_ssh_setup_timeout,omitempty"`
+	VMAccel           string `yaml:"vm_accel,omitempty"`
+	VMDriveCache      string `yaml:"vm_drive_cache,omitempty"`
+	VMDriveAIO        string `yaml:"vm_drive_aio,omitempty"`
+
+	// AlpineMirror is the base URL of an Alpine Linux mirror that is
+	// tried first when downloading the base image.
+	AlpineMirror string `yaml:"alpine_mirror,omitempty"`
+
+	ShareUser     string `yaml:"share_user,omitempty"`
+	SharePassword string `yaml:"share_password,omitempty"`
+
+	// WatchAutoRun makes `linsk watch` share the newly attached
+	// disks without asking.
+	WatchAutoRun bool `yaml:"watch_auto_run,omitempty"`
+
+	// WatchRunArgs are the extra `linsk run` arguments used by `linsk watch`,
+	// like ["--read-only", "--auto-mount"].
+	WatchRunArgs []string `yaml:"watch_run_args,omitempty"`
+}
+
+func GetDefaultPath() (string, error) {
+	if osspecifics.IsWindows() {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", errors.Wrap(err, "get user config dir")
+		}
+
+		return filepath.Join(configDir, "Linsk", "config.yaml"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "get user home dir")
+	}
+
+	return filepath.Join(homeDir, ".config", "linsk", "config.yaml"), nil
+}
+
+// Load reads the config file. An empty config is
+// returned if the file does not exist.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Config{}, nil
+		}
+
+		return nil, errors.Wrap(err, "read config file")
+	}
+
+	var cfg Config
+
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal yaml")
+	}
+
+	return &cfg, nil
+}
+
+func (cfg *Config) Save(path string) error {
+	path = filepath.Clean(path)
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "marshal yaml")
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir all config dir")
+	}
+
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "write config file")
+	}
+
+	return nil
+}
@@ -19,6 +19,7 @@ package constants
 import "github.com/AlexSSD7/linsk/utils"
 
 const aarch64EFIImageBZ2URL = "https://github.com/qemu/qemu/raw/92ec7805190313c9e628f8fc4eb4f932c15247bd/pc-bios/edk2-aarch64-code.fd.bz2"
+const aarch64EFIImageBZ2FallbackURL = "https://gitlab.com/qemu-project/qemu/-/raw/92ec7805190313c9e628f8fc4eb4f932c15247bd/pc-bios/edk2-aarch64-code.fd.bz2"
 const aarch64EFIImageName = "edk2-aarch64-code.fd"
 
 var aarch64EFIImageHash []byte
@@ -35,6 +36,11 @@ func GetAarch64EFIImageBZ2URL() string {
 	return aarch64EFIImageBZ2URL
 }
 
+// GetAarch64EFIImageBZ2URLs returns the primary and the fallback URLs of the EFI image.
+func GetAarch64EFIImageBZ2URLs() []string {
+	return []string{aarch64EFIImageBZ2URL, aarch64EFIImageBZ2FallbackURL}
+}
+
 func GetAarch64EFIImageHash() []byte {
 	// Making a copy so that remote caller cannot modify the original variable.
 	tmp := make([]byte, len(aarch64EFIImageHash))
@@ -18,6 +18,7 @@ package constants
 
 import (
 	"runtime"
+	"strings"
 
 	"github.com/AlexSSD7/linsk/utils"
 )
@@ -28,8 +29,15 @@ const baseAlpineVersionCombined = baseAlpineVersionMajor + "." + baseAlpineVersi
 
 const LinskVMImageVersion = "1"
 
+// alpineMirrors are tried in order when downloading the base image.
+var alpineMirrors = []string{
+	"https://dl-cdn.alpinelinux.org/alpine",
+	"https://mirrors.edge.kernel.org/alpine",
+	"https://uk.alpinelinux.org/alpine",
+}
+
 var baseAlpineArch string
-var baseImageURL string
+var baseImageMirrorPath string
 var alpineBaseImageHash []byte
 
 func init() {
@@ -40,11 +48,23 @@ func init() {
 		alpineBaseImageHash = utils.MustDecodeHex("dbd0c2eaa0bfa39e18d075dae07760a9055ffdee0a338c8a35059413b0f76fec")
 	}
 
-	baseImageURL = "https://dl-cdn.alpinelinux.org/alpine/v" + baseAlpineVersionMajor + "/releases/" + baseAlpineArch + "/alpine-virt-" + baseAlpineVersionCombined + "-" + baseAlpineArch + ".iso"
+	baseImageMirrorPath = "/v" + baseAlpineVersionMajor + "/releases/" + baseAlpineArch + "/alpine-virt-" + baseAlpineVersionCombined + "-" + baseAlpineArch + ".iso"
 }
 
 func GetAlpineBaseImageURL() string {
-	return baseImageURL
+	return alpineMirrors[0] + baseImageMirrorPath
+}
+
+// GetAlpineBaseImageURLs returns the base image URLs on all known
+// mirrors. The user-supplied mirrors (base URLs of Alpine mirrors,
+// e.g. "https://dl-cdn.alpinelinux.org/alpine") are tried first.
+func GetAlpineBaseImageURLs(userMirrors []string) []string {
+	var ret []string
+	for _, mirror := range append(append([]string{}, userMirrors...), alpineMirrors...) {
+		ret = append(ret, strings.TrimSuffix(mirror, "/")+baseImageMirrorPath)
+	}
+
+	return ret
 }
 
 func GetAlpineBaseImageTags() string {
@@ -65,3 +85,7 @@ func GetAlpineBaseImageHash() []byte {
 	copy(tmp, alpineBaseImageHash)
 	return tmp
 }
+
+func GetVMImageArch() string {
+	return baseAlpineArch
+}
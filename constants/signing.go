@@ -0,0 +1,33 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package constants
+
+// imageSigningPublicKey is the minisign public key used to authenticate
+// VM images distributed via release metadata. Release builds set it with
+// -ldflags "-X github.com/AlexSSD7/linsk/constants.imageSigningPublicKey=<key>".
+var imageSigningPublicKey string
+
+// GetImageSigningPublicKeys returns the built-in minisign public keys that
+// are trusted to sign VM images. The release builds always have one (see
+// build-binaries.sh), the result may be empty for development builds.
+func GetImageSigningPublicKeys() []string {
+	if imageSigningPublicKey == "" {
+		return nil
+	}
+
+	return []string{imageSigningPublicKey}
+}
@@ -0,0 +1,183 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogEntry is a log line of a session process.
+type LogEntry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// parseLogEntry parses a log line printed by a session process in the JSON
+// output mode. Lines that are not JSON logs are kept as-is in the message.
+func parseLogEntry(line []byte) LogEntry {
+	var raw map[string]any
+
+	err := json.Unmarshal(line, &raw)
+	if err != nil {
+		return LogEntry{
+			Time:    time.Now(),
+			Level:   "INFO",
+			Message: string(line),
+		}
+	}
+
+	e := LogEntry{
+		Time:  time.Now(),
+		Attrs: make(map[string]string),
+	}
+
+	for k, v := range raw {
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+
+		switch k {
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err == nil {
+				e.Time = t
+			}
+		case "level":
+			e.Level = s
+		case "msg":
+			e.Message = s
+		default:
+			e.Attrs[k] = s
+		}
+	}
+
+	return e
+}
+
+// maxSessionLogEntries is the number of the latest log entries kept for every session.
+const maxSessionLogEntries = 1000
+
+// broadcaster fans the published values out to the subscribers.
+type broadcaster[T any] struct {
+	mu     sync.Mutex
+	subs   map[chan T]struct{}
+	closed bool
+}
+
+func newBroadcaster[T any]() *broadcaster[T] {
+	return &broadcaster[T]{
+		subs: make(map[chan T]struct{}),
+	}
+}
+
+// subscribe returns a channel receiving the published values, which is
+// closed once the broadcaster is closed, and a function to unsubscribe.
+func (b *broadcaster[T]) subscribe() (<-chan T, func()) {
+	ch := make(chan T, 64)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subs[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *broadcaster[T]) publish(v T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- v:
+		default:
+			// The value gets discarded if the subscriber is not keeping up.
+		}
+	}
+}
+
+func (b *broadcaster[T]) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+
+	b.closed = true
+}
+
+// sessionLogs keeps the latest log entries of a session,
+// and publishes the new ones to the subscribers.
+type sessionLogs struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	bc      *broadcaster[LogEntry]
+}
+
+func newSessionLogs() *sessionLogs {
+	return &sessionLogs{
+		bc: newBroadcaster[LogEntry](),
+	}
+}
+
+func (sl *sessionLogs) add(e LogEntry) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.entries = append(sl.entries, e)
+	if len(sl.entries) > maxSessionLogEntries {
+		sl.entries = sl.entries[len(sl.entries)-maxSessionLogEntries:]
+	}
+
+	sl.bc.publish(e)
+}
+
+// follow returns the kept log entries, and subscribes to the new ones.
+func (sl *sessionLogs) follow() ([]LogEntry, <-chan LogEntry, func()) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	ch, unsubscribe := sl.bc.subscribe()
+
+	return append([]LogEntry(nil), sl.entries...), ch, unsubscribe
+}
+
+// end closes the subscriptions, as no more entries will be added.
+func (sl *sessionLogs) end() {
+	sl.bc.close()
+}
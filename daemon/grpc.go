@@ -0,0 +1,279 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	linskv1 "github.com/AlexSSD7/linsk/api/linsk/v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type grpcServer struct {
+	linskv1.UnimplementedLinskServiceServer
+
+	srv *Server
+}
+
+// NewGRPCServer creates the gRPC control API server. It shares the token,
+// sessions and devices with the REST API server.
+func (srv *Server) NewGRPCServer() *grpc.Server {
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			err := srv.checkGRPCToken(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(s any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := srv.checkGRPCToken(ss.Context())
+			if err != nil {
+				return err
+			}
+
+			return handler(s, ss)
+		}),
+	)
+
+	linskv1.RegisterLinskServiceServer(gs, &grpcServer{srv: srv})
+
+	return gs
+}
+
+func (srv *Server) checkGRPCToken(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "bad or missing token")
+}
+
+func (gs *grpcServer) ListDevices(ctx context.Context, req *linskv1.ListDevicesRequest) (*linskv1.ListDevicesResponse, error) {
+	devs, err := gs.srv.listDevices()
+	if err != nil {
+		gs.srv.logger.Error("Failed to list devices", "error", err.Error())
+		return nil, status.Error(codes.Internal, "failed to list devices")
+	}
+
+	resp := &linskv1.ListDevicesResponse{}
+	for _, dev := range devs {
+		resp.Devices = append(resp.Devices, &linskv1.Device{
+			Path:       dev.Path,
+			Target:     dev.Target,
+			Discovered: dev.Discovered,
+		})
+	}
+
+	return resp, nil
+}
+
+func (gs *grpcServer) ListSessions(ctx context.Context, req *linskv1.ListSessionsRequest) (*linskv1.ListSessionsResponse, error) {
+	resp := &linskv1.ListSessionsResponse{}
+	for _, s := range gs.srv.sessions.List() {
+		resp.Sessions = append(resp.Sessions, sessionToProto(s))
+	}
+
+	return resp, nil
+}
+
+func (gs *grpcServer) GetSession(ctx context.Context, req *linskv1.GetSessionRequest) (*linskv1.GetSessionResponse, error) {
+	s, ok := gs.srv.sessions.Get(req.GetId())
+	if !ok {
+		return nil, status.Error(codes.NotFound, ErrSessionNotFound.Error())
+	}
+
+	return &linskv1.GetSessionResponse{Session: sessionToProto(s)}, nil
+}
+
+func (gs *grpcServer) StartSession(ctx context.Context, req *linskv1.StartSessionRequest) (*linskv1.StartSessionResponse, error) {
+	spec := req.GetSpec()
+
+	sr := SessionRequest{
+		Target:       spec.GetTarget(),
+		Name:         spec.GetName(),
+		Device:       spec.GetDevice(),
+		FSType:       spec.GetFsType(),
+		ShareBackend: spec.GetShareBackend(),
+		ReadOnly:     spec.GetReadOnly(),
+		LUKS:         spec.GetLuks(),
+		LUKSPassword: spec.GetLuksPassword(),
+	}
+
+	err := sr.validate()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	s, err := gs.srv.sessions.Start(sr)
+	if err != nil {
+		gs.srv.logger.Error("Failed to start session", "error", err.Error())
+		return nil, status.Error(codes.Internal, "failed to start session")
+	}
+
+	return &linskv1.StartSessionResponse{Session: sessionToProto(s)}, nil
+}
+
+func (gs *grpcServer) StopSession(ctx context.Context, req *linskv1.StopSessionRequest) (*linskv1.StopSessionResponse, error) {
+	s, err := gs.srv.sessions.Stop(req.GetId())
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+
+		gs.srv.logger.Error("Failed to stop session", "id", req.GetId(), "error", err.Error())
+		return nil, status.Error(codes.Internal, "failed to stop session")
+	}
+
+	return &linskv1.StopSessionResponse{Session: sessionToProto(s)}, nil
+}
+
+func (gs *grpcServer) WatchSessions(req *linskv1.WatchSessionsRequest, stream linskv1.LinskService_WatchSessionsServer) error {
+	// Subscribing before taking the snapshot, so that no change is missed in between.
+	updates, unsubscribe := gs.srv.sessions.Subscribe()
+	defer unsubscribe()
+
+	send := func(s Session) error {
+		if req.GetId() != "" && s.ID != req.GetId() {
+			return nil
+		}
+
+		return stream.Send(&linskv1.WatchSessionsResponse{Session: sessionToProto(s)})
+	}
+
+	for _, s := range gs.srv.sessions.List() {
+		err := send(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case s, ok := <-updates:
+			if !ok {
+				return nil
+			}
+
+			err := send(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (gs *grpcServer) StreamLogs(req *linskv1.StreamLogsRequest, stream linskv1.LinskService_StreamLogsServer) error {
+	entries, ch, unsubscribe, err := gs.srv.sessions.FollowLogs(req.GetId())
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+
+		return status.Error(codes.Internal, "failed to follow session logs")
+	}
+
+	defer unsubscribe()
+
+	for _, e := range entries {
+		err := stream.Send(&linskv1.StreamLogsResponse{Entry: logEntryToProto(e)})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			err := stream.Send(&linskv1.StreamLogsResponse{Entry: logEntryToProto(e)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var sessionStateToProto = map[SessionState]linskv1.SessionState{
+	SessionStateStarting: linskv1.SessionState_SESSION_STATE_STARTING,
+	SessionStateReady:    linskv1.SessionState_SESSION_STATE_READY,
+	SessionStateStopping: linskv1.SessionState_SESSION_STATE_STOPPING,
+	SessionStateExited:   linskv1.SessionState_SESSION_STATE_EXITED,
+	SessionStateFailed:   linskv1.SessionState_SESSION_STATE_FAILED,
+}
+
+func sessionToProto(s Session) *linskv1.Session {
+	ret := &linskv1.Session{
+		Id: s.ID,
+		Spec: &linskv1.SessionSpec{
+			Target:       s.Request.Target,
+			Name:         s.Request.Name,
+			Device:       s.Request.Device,
+			FsType:       s.Request.FSType,
+			ShareBackend: s.Request.ShareBackend,
+			ReadOnly:     s.Request.ReadOnly,
+			Luks:         s.Request.LUKS,
+		},
+		State:     sessionStateToProto[s.State],
+		Pid:       int64(s.PID),
+		StartedAt: timestamppb.New(s.StartedAt),
+		Error:     s.Error,
+	}
+
+	for _, sh := range s.Shares {
+		ret.Shares = append(ret.Shares, &linskv1.Share{
+			Backend:  sh.Backend,
+			Url:      sh.URL,
+			ReadOnly: sh.ReadOnly,
+			Username: sh.Username,
+			Password: sh.Password,
+
+			HealthError: sh.HealthError,
+		})
+	}
+
+	return ret
+}
+
+func logEntryToProto(e LogEntry) *linskv1.LogEntry {
+	return &linskv1.LogEntry{
+		Time:    timestamppb.New(e.Time),
+		Level:   e.Level,
+		Message: e.Message,
+		Attrs:   e.Attrs,
+	}
+}
@@ -0,0 +1,139 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout is the time the session manager has to respond
+// to the health checks before the daemon is considered wedged.
+const healthCheckTimeout = time.Second * 5
+
+type healthRecordData struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error"`
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
+}
+
+// Responsive reports whether the session manager can be locked within the
+// timeout. A session manager that cannot be is stuck, and so is the daemon.
+func (sm *SessionManager) Responsive(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for !sm.mu.TryLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	sm.mu.Unlock()
+
+	return true
+}
+
+// SharesHealthy reports whether the file shares of all ready
+// sessions passed their last health check.
+func (sm *SessionManager) SharesHealthy() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, s := range sm.sessions {
+		if s.State != SessionStateReady {
+			continue
+		}
+
+		for _, sh := range s.Shares {
+			if sh.HealthError != "" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// setShareHealth records the result of a session share health
+// check reported by a "health" record. The shares are copied, as
+// the published session snapshots share them.
+func setShareHealth(s *Session, h healthRecordData) {
+	shares := make([]Share, len(s.Shares))
+	copy(shares, s.Shares)
+
+	for i := range shares {
+		if shares[i].Backend != h.Backend {
+			continue
+		}
+
+		if h.Healthy {
+			shares[i].HealthError = ""
+		} else {
+			shares[i].HealthError = h.Error
+		}
+	}
+
+	s.Shares = shares
+}
+
+// handleHealthz reports whether the daemon is alive. It fails once the
+// daemon is stuck, so that the supervisors can restart it.
+func (srv *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	if !srv.sessions.Responsive(healthCheckTimeout) {
+		srv.logger.Error("The session manager is not responding to the liveness check")
+		writeJSON(w, http.StatusServiceUnavailable, healthStatus{Status: "unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// handleReadyz reports whether the daemon is alive and the file shares
+// of the ready sessions pass the health checks, which covers their VMs
+// too, as the checks are run through them.
+func (srv *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	if !srv.sessions.Responsive(healthCheckTimeout) {
+		srv.logger.Error("The session manager is not responding to the readiness check")
+		writeJSON(w, http.StatusServiceUnavailable, healthStatus{Status: "unavailable"})
+		return
+	}
+
+	// The probes are not authenticated, hence the failing shares
+	// are only reported in the sessions.
+	if !srv.sessions.SharesHealthy() {
+		writeJSON(w, http.StatusServiceUnavailable, healthStatus{Status: "unavailable"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
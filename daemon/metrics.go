@@ -0,0 +1,134 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"net/http"
+	"os/exec"
+
+	"github.com/AlexSSD7/linsk/cmd/exitcode"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the session metrics fed by the session output records.
+type metrics struct {
+	sessionFailures *prometheus.CounterVec
+	bootDuration    prometheus.Histogram
+	shareBytes      *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		sessionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linsk_session_failures_total",
+			Help: "Number of the sessions that ended with an error, by the failure class.",
+		}, []string{"class"}),
+		bootDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "linsk_vm_boot_duration_seconds",
+			Help:    "Time it took the session VMs to boot until the guest SSH server was ready.",
+			Buckets: []float64{5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300},
+		}),
+		shareBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linsk_share_bytes_total",
+			Help: "Bytes the session VMs sent (mostly read from the file share) and received (mostly written to it), by the session name and the file share backend.",
+		}, []string{"name", "backend", "direction"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "linsk_guest_command_duration_seconds",
+			Help:    "Run time of the commands Linsk ran in the session VMs, by the command name and the result (ok or failed).",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+		}, []string{"command", "result"}),
+	}
+}
+
+// sessionFailureClass returns the failure class of the session
+// process exit error, based on the Linsk exit codes.
+func sessionFailureClass(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "generic"
+	}
+
+	switch exitErr.ExitCode() {
+	case exitcode.Usage:
+		return "usage"
+	case exitcode.DeviceNotFound:
+		return "device_not_found"
+	case exitcode.PermissionDenied:
+		return "permission_denied"
+	case exitcode.UnlockFailed:
+		return "unlock_failed"
+	case exitcode.VMBootTimeout:
+		return "boot_timeout"
+	case exitcode.ShareFailed:
+		return "share_failed"
+	case exitcode.Interrupted:
+		return "interrupted"
+	default:
+		return "generic"
+	}
+}
+
+var sessionsDesc = prometheus.NewDesc("linsk_sessions", "Number of the sessions known to the daemon, by the state.", []string{"state"}, nil)
+
+// sessionsCollector reports the number of sessions in every state.
+type sessionsCollector struct {
+	sm *SessionManager
+}
+
+func (c sessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+}
+
+func (c sessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[SessionState]int{
+		SessionStateStarting: 0,
+		SessionStateReady:    0,
+		SessionStateStopping: 0,
+		SessionStateExited:   0,
+		SessionStateFailed:   0,
+	}
+
+	for _, s := range c.sm.List() {
+		counts[s.State]++
+	}
+
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(n), string(state))
+	}
+}
+
+// MetricsHandler returns the handler serving the session metrics, along
+// with the Go runtime and process ones, in the Prometheus format.
+func (sm *SessionManager) MetricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+
+	reg.MustRegister(
+		sessionsCollector{sm: sm},
+		sm.metrics.sessionFailures,
+		sm.metrics.bootDuration,
+		sm.metrics.shareBytes,
+		sm.metrics.commandDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
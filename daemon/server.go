@@ -0,0 +1,222 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Device is a host block device that can be passed through to a session.
+type Device struct {
+	Path string `json:"path"`
+
+	// Target is the value to use as SessionRequest.Target.
+	Target string `json:"target"`
+
+	// Discovered lists the in-VM device names found on the device by
+	// the last `linsk ls` run. They can be used as SessionRequest.Device.
+	Discovered []string `json:"discovered"`
+}
+
+type Server struct {
+	logger *slog.Logger
+
+	token       string
+	sessions    *SessionManager
+	listDevices func() ([]Device, error)
+}
+
+// NewServer creates the REST API server. Every request must carry the token
+// in the "Authorization: Bearer <token>" header, except for the /healthz
+// and /readyz probes.
+func NewServer(logger *slog.Logger, token string, sessions *SessionManager, listDevices func() ([]Device, error)) *Server {
+	return &Server{
+		logger: logger,
+
+		token:       token,
+		sessions:    sessions,
+		listDevices: listDevices,
+	}
+}
+
+// GenerateToken generates a random API token.
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "read random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The supervisors probing the daemon have no token.
+	switch r.URL.Path {
+	case "/healthz":
+		srv.handleHealthz(w, r)
+		return
+	case "/readyz":
+		srv.handleReadyz(w, r)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "bad or missing token")
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "v1/devices":
+		srv.handleDevices(w, r)
+	case path == "v1/sessions":
+		srv.handleSessions(w, r)
+	case len(parts) == 3 && parts[0] == "v1" && parts[1] == "sessions":
+		srv.handleSession(w, r, parts[2])
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "sessions" && parts[3] == "shares":
+		srv.handleSessionShares(w, r, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (srv *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	devs, err := srv.listDevices()
+	if err != nil {
+		srv.logger.Error("Failed to list devices", "error", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to list devices")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, devs)
+}
+
+func (srv *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, srv.sessions.List())
+	case http.MethodPost:
+		var req SessionRequest
+
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: "+err.Error())
+			return
+		}
+
+		err = req.validate()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s, err := srv.sessions.Start(req)
+		if err != nil {
+			srv.logger.Error("Failed to start session", "error", err.Error())
+			writeError(w, http.StatusInternalServerError, "failed to start session")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, s)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (srv *Server) handleSession(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		s, ok := srv.sessions.Get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrSessionNotFound.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, s)
+	case http.MethodDelete:
+		s, err := srv.sessions.Stop(id)
+		if err != nil {
+			if errors.Is(err, ErrSessionNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+
+			srv.logger.Error("Failed to stop session", "id", id, "error", err.Error())
+			writeError(w, http.StatusInternalServerError, "failed to stop session")
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, s)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (srv *Server) handleSessionShares(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	s, ok := srv.sessions.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrSessionNotFound.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.Shares)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// Nothing can be done if the client has gone away, hence the ignored error.
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
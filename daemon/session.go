@@ -0,0 +1,529 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type SessionState string
+
+const (
+	SessionStateStarting SessionState = "starting"
+	SessionStateReady    SessionState = "ready"
+	SessionStateStopping SessionState = "stopping"
+	SessionStateExited   SessionState = "exited"
+	SessionStateFailed   SessionState = "failed"
+)
+
+// SessionRequest describes the file share session to start. It maps
+// onto the arguments and flags of `linsk run`.
+type SessionRequest struct {
+	// Target is the passthrough target, like "dev:/dev/sdb" or "img:disk.qcow2".
+	Target string `json:"target"`
+
+	// Name is the instance name, see `linsk run --name`.
+	Name string `json:"name,omitempty"`
+
+	Device       string `json:"device,omitempty"`
+	FSType       string `json:"fs_type,omitempty"`
+	ShareBackend string `json:"share_backend,omitempty"`
+	ReadOnly     bool   `json:"read_only,omitempty"`
+	LUKS         bool   `json:"luks,omitempty"`
+
+	// LUKSPassword unlocks the LUKS devices, as there is no terminal to
+	// prompt for it. It is passed to the child process in the environment
+	// rather than on the command line, and is not kept in the session.
+	LUKSPassword string `json:"luks_password,omitempty"`
+}
+
+// luksPasswordEnv is the environment variable `linsk run` reads
+// the LUKS password from.
+const luksPasswordEnv = "LINSK_LUKS_PASSWORD"
+
+func (r SessionRequest) validate() error {
+	if r.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+
+	for name, v := range map[string]string{
+		"target":        r.Target,
+		"name":          r.Name,
+		"device":        r.Device,
+		"fs_type":       r.FSType,
+		"share_backend": r.ShareBackend,
+	} {
+		// Values starting with a dash would be parsed as flags by the child process.
+		if strings.HasPrefix(v, "-") {
+			return fmt.Errorf("bad %v '%v'", name, v)
+		}
+	}
+
+	if r.FSType != "" && r.Device == "" {
+		return fmt.Errorf("device is required when fs_type is set")
+	}
+
+	if r.LUKS != (r.LUKSPassword != "") {
+		return fmt.Errorf("luks and luks_password must be set together")
+	}
+
+	return nil
+}
+
+func (r SessionRequest) args() []string {
+	args := []string{"run", r.Target}
+	if r.Device != "" {
+		args = append(args, r.Device)
+	}
+
+	if r.FSType != "" {
+		args = append(args, r.FSType)
+	}
+
+	if r.Name != "" {
+		args = append(args, "--name", r.Name)
+	}
+
+	if r.ShareBackend != "" {
+		args = append(args, "--share-backend", r.ShareBackend)
+	}
+
+	if r.ReadOnly {
+		args = append(args, "--read-only")
+	}
+
+	if r.LUKS {
+		args = append(args, "--luks")
+	}
+
+	return args
+}
+
+type Share struct {
+	Backend  string `json:"backend"`
+	URL      string `json:"url"`
+	ReadOnly bool   `json:"read_only"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// HealthError is the error of the last health check
+	// of the share, if it is failing them.
+	HealthError string `json:"health_error,omitempty"`
+}
+
+type Session struct {
+	ID        string         `json:"id"`
+	Request   SessionRequest `json:"request"`
+	State     SessionState   `json:"state"`
+	PID       int            `json:"pid,omitempty"`
+	StartedAt time.Time      `json:"started_at"`
+	Shares    []Share        `json:"shares"`
+
+	// Error is the last error reported by the session, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// outputRecord is a JSON output record printed by `linsk run --json`.
+type outputRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type errorRecordData struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+type bootRecordData struct {
+	Seconds float64 `json:"seconds"`
+}
+
+type commandRecordData struct {
+	Command string  `json:"command"`
+	Seconds float64 `json:"seconds"`
+	Failed  bool    `json:"failed"`
+}
+
+// trafficRecordData holds the session VM network
+// traffic counters since the boot.
+type trafficRecordData struct {
+	SentBytes     uint64 `json:"sent_bytes"`
+	ReceivedBytes uint64 `json:"received_bytes"`
+}
+
+// SessionManager runs every session as a `linsk run --json` child process,
+// and tracks the sessions' state through the JSON records they print.
+type SessionManager struct {
+	logger *slog.Logger
+
+	exe      string
+	baseArgs []string
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	logs     map[string]*sessionLogs
+	wg       sync.WaitGroup
+
+	updates *broadcaster[Session]
+	metrics *metrics
+}
+
+// NewSessionManager creates a session manager that starts the sessions
+// with the exe executable. The baseArgs (global flags like --data-dir)
+// are added to every session's command line.
+func NewSessionManager(logger *slog.Logger, exe string, baseArgs []string) *SessionManager {
+	return &SessionManager{
+		logger: logger,
+
+		exe:      exe,
+		baseArgs: baseArgs,
+
+		sessions: make(map[string]*Session),
+		logs:     make(map[string]*sessionLogs),
+
+		updates: newBroadcaster[Session](),
+		metrics: newMetrics(),
+	}
+}
+
+// Start starts a new session. It returns once the child process has
+// started, the session becomes ready later.
+func (sm *SessionManager) Start(req SessionRequest) (Session, error) {
+	err := req.validate()
+	if err != nil {
+		return Session{}, errors.Wrap(err, "validate request")
+	}
+
+	args := append(req.args(), "--json", "--progress", "none")
+	args = append(args, sm.baseArgs...)
+
+	// #nosec G204 The executable is Linsk itself, and the arguments are validated.
+	cmd := exec.Command(sm.exe, args...)
+	osspecifics.SetNewProcessGroupCmd(cmd)
+
+	if req.LUKSPassword != "" {
+		cmd.Env = append(os.Environ(), luksPasswordEnv+"="+req.LUKSPassword)
+		req.LUKSPassword = ""
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Session{}, errors.Wrap(err, "create stdout pipe")
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return Session{}, errors.Wrap(err, "create stderr pipe")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return Session{}, errors.Wrap(err, "start session process")
+	}
+
+	s := &Session{
+		ID:        uuid.NewString(),
+		Request:   req,
+		State:     SessionStateStarting,
+		PID:       cmd.Process.Pid,
+		StartedAt: time.Now(),
+		Shares:    []Share{},
+	}
+
+	logs := newSessionLogs()
+
+	sm.mu.Lock()
+	sm.sessions[s.ID] = s
+	sm.logs[s.ID] = logs
+	ret := *s
+	sm.publishLocked(s)
+	sm.mu.Unlock()
+
+	sm.logger.Info("Started session", "id", s.ID, "target", req.Target, "pid", s.PID)
+
+	sm.wg.Add(1)
+	go func() {
+		defer sm.wg.Done()
+
+		// All reads must be complete before waiting for the process.
+		var readWg sync.WaitGroup
+		readWg.Add(2)
+
+		go func() {
+			defer readWg.Done()
+			sm.readRecords(s, stdout)
+		}()
+
+		go func() {
+			defer readWg.Done()
+			readLogs(logs, stderr)
+		}()
+
+		readWg.Wait()
+
+		err := cmd.Wait()
+
+		logs.end()
+
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+
+		if err != nil {
+			s.State = SessionStateFailed
+			if s.Error == "" {
+				s.Error = err.Error()
+			}
+
+			sm.metrics.sessionFailures.WithLabelValues(sessionFailureClass(err)).Inc()
+		} else {
+			s.State = SessionStateExited
+		}
+
+		s.Shares = []Share{}
+		sm.publishLocked(s)
+
+		sm.logger.Info("Session ended", "id", s.ID, "state", s.State)
+	}()
+
+	return ret, nil
+}
+
+func (sm *SessionManager) readRecords(s *Session, r io.Reader) {
+	// The traffic records carry the counters since the boot,
+	// which are turned into the deltas for the metrics.
+	var lastTraffic trafficRecordData
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rec outputRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			sm.logger.Warn("Failed to parse session output record", "id", s.ID, "error", err.Error())
+			continue
+		}
+
+		sm.mu.Lock()
+
+		switch rec.Type {
+		case "share":
+			var sh Share
+			err = json.Unmarshal(rec.Data, &sh)
+			if err == nil {
+				s.Shares = append(s.Shares, sh)
+			}
+		case "ready":
+			if s.State == SessionStateStarting {
+				s.State = SessionStateReady
+			}
+		case "error":
+			var e errorRecordData
+			err = json.Unmarshal(rec.Data, &e)
+			if err == nil {
+				s.Error = e.Message
+				if e.Error != "" {
+					s.Error += ": " + e.Error
+				}
+			}
+		case "boot":
+			var b bootRecordData
+			err = json.Unmarshal(rec.Data, &b)
+			if err == nil {
+				sm.metrics.bootDuration.Observe(b.Seconds)
+			}
+		case "command":
+			var c commandRecordData
+			err = json.Unmarshal(rec.Data, &c)
+			if err == nil {
+				result := "ok"
+				if c.Failed {
+					result = "failed"
+				}
+
+				sm.metrics.commandDuration.WithLabelValues(c.Command, result).Observe(c.Seconds)
+			}
+		case "health":
+			var h healthRecordData
+			err = json.Unmarshal(rec.Data, &h)
+			if err == nil {
+				setShareHealth(s, h)
+			}
+		case "traffic":
+			var t trafficRecordData
+			err = json.Unmarshal(rec.Data, &t)
+			if err == nil {
+				backend := s.shareBackendsLabel()
+				sm.metrics.shareBytes.WithLabelValues(s.Request.Name, backend, "sent").Add(float64(counterDelta(lastTraffic.SentBytes, t.SentBytes)))
+				sm.metrics.shareBytes.WithLabelValues(s.Request.Name, backend, "received").Add(float64(counterDelta(lastTraffic.ReceivedBytes, t.ReceivedBytes)))
+				lastTraffic = t
+			}
+		}
+
+		sm.publishLocked(s)
+		sm.mu.Unlock()
+
+		if err != nil {
+			sm.logger.Warn("Failed to parse session output record data", "id", s.ID, "type", rec.Type, "error", err.Error())
+		}
+	}
+
+	// Drain the rest of the output if the scanner failed (e.g. on a too long line),
+	// so that the child process does not block on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// shareBackendsLabel returns the file share backends of the
+// session, comma-separated, for the metric labels.
+func (s *Session) shareBackendsLabel() string {
+	backends := make([]string, 0, len(s.Shares))
+	for _, sh := range s.Shares {
+		backends = append(backends, sh.Backend)
+	}
+
+	return strings.Join(backends, ",")
+}
+
+// counterDelta returns the increase of a counter. The counter is
+// assumed to have been reset if it went down.
+func counterDelta(prev uint64, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+
+	return cur - prev
+}
+
+func readLogs(logs *sessionLogs, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logs.add(parseLogEntry(scanner.Bytes()))
+	}
+
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// publishLocked notifies the subscribers about the session change.
+// sm.mu must be held.
+func (sm *SessionManager) publishLocked(s *Session) {
+	sm.updates.publish(*s)
+}
+
+// Subscribe returns a channel receiving the session snapshots on every
+// session change, and a function to unsubscribe. The slow subscribers
+// miss updates, so the snapshots should not be treated as deltas.
+func (sm *SessionManager) Subscribe() (<-chan Session, func()) {
+	return sm.updates.subscribe()
+}
+
+// FollowLogs returns the latest log entries of the session, and subscribes
+// to the new ones. The channel is closed once the session ends.
+func (sm *SessionManager) FollowLogs(id string) ([]LogEntry, <-chan LogEntry, func(), error) {
+	sm.mu.Lock()
+	logs, ok := sm.logs[id]
+	sm.mu.Unlock()
+
+	if !ok {
+		return nil, nil, nil, ErrSessionNotFound
+	}
+
+	entries, ch, unsubscribe := logs.follow()
+
+	return entries, ch, unsubscribe, nil
+}
+
+// List returns all sessions, including the ended ones, oldest first.
+func (sm *SessionManager) List() []Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ret := make([]Session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		ret = append(ret, *s)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StartedAt.Before(ret[j].StartedAt)
+	})
+
+	return ret
+}
+
+// Get returns the session with the ID.
+func (sm *SessionManager) Get(id string) (Session, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s, ok := sm.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	return *s, true
+}
+
+// Stop gracefully shuts a running session down, or forgets an ended one.
+func (sm *SessionManager) Stop(id string) (Session, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s, ok := sm.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	switch s.State {
+	case SessionStateExited, SessionStateFailed:
+		delete(sm.sessions, id)
+		delete(sm.logs, id)
+	case SessionStateStopping:
+	default:
+		err := osspecifics.InterruptProcess(s.PID)
+		if err != nil {
+			return Session{}, errors.Wrap(err, "interrupt session process")
+		}
+
+		s.State = SessionStateStopping
+		sm.publishLocked(s)
+	}
+
+	return *s, nil
+}
+
+// StopAll gracefully shuts all sessions down and waits for them to exit.
+func (sm *SessionManager) StopAll() {
+	for _, s := range sm.List() {
+		_, err := sm.Stop(s.ID)
+		if err != nil {
+			sm.logger.Warn("Failed to stop session", "id", s.ID, "error", err.Error())
+		}
+	}
+
+	sm.wg.Wait()
+}
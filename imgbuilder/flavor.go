@@ -0,0 +1,72 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package imgbuilder
+
+import (
+	"fmt"
+
+	"github.com/AlexSSD7/linsk/vm"
+)
+
+// Flavor selects the set of packages installed into the VM image.
+type Flavor string
+
+const (
+	// FlavorMinimal supports ext4 and btrfs (plus LUKS and LVM) only,
+	// making for the smallest image.
+	FlavorMinimal Flavor = "minimal"
+	// FlavorStandard is the default that supports all file systems Linsk knows about.
+	FlavorStandard Flavor = "standard"
+	// FlavorRecovery adds ZFS and data recovery tooling on top of the standard flavor.
+	FlavorRecovery Flavor = "recovery"
+)
+
+var Flavors = []Flavor{FlavorMinimal, FlavorStandard, FlavorRecovery}
+
+func ParseFlavor(s string) (Flavor, error) {
+	if s == "" {
+		return FlavorStandard, nil
+	}
+
+	for _, f := range Flavors {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown image flavor '%v' (available: minimal, standard, recovery)", s)
+}
+
+// Packages returns the Alpine packages to install for the flavor.
+func (f Flavor) Packages() []string {
+	// Packages required for Linsk to function: SSH access, network file
+	// shares, NBD export and the core LUKS/LVM device mapping support.
+	pkgs := []string{"openssh", "lvm2", "btrfs-progs", "util-linux", "cryptsetup", "vsftpd", "samba", "netatalk", "nfs-utils", "lighttpd", "lighttpd-mod_auth", "lighttpd-mod_webdav", "openssl", "nbd"}
+
+	if f == FlavorMinimal {
+		return pkgs
+	}
+
+	pkgs = append(pkgs, "thin-provisioning-tools", "mdadm")
+	pkgs = append(pkgs, vm.ExtraFSToolingPackages()...)
+
+	if f == FlavorRecovery {
+		pkgs = append(pkgs, "zfs", "zfs-virt", "ddrescue", "testdisk", "smartmontools")
+	}
+
+	return pkgs
+}
@@ -21,14 +21,13 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"log/slog"
 
 	"github.com/AlexSSD7/linsk/cmd/runvm"
-	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/qemucli"
 	"github.com/AlexSSD7/linsk/share"
 	"github.com/AlexSSD7/linsk/utils"
 	"github.com/AlexSSD7/linsk/vm"
@@ -40,10 +39,21 @@ import (
 type BuildContext struct {
 	logger *slog.Logger
 
-	vi *vm.VM
+	vi   *vm.VM
+	opts BuildOptions
 }
 
-func NewBuildContext(logger *slog.Logger, baseISOPath string, outPath string, debug bool, biosPath string) (*BuildContext, error) {
+type BuildOptions struct {
+	// Repositories replace the default public Alpine package mirror.
+	// Useful for air-gapped environments with a local mirror.
+	Repositories []string
+	// ExtraPackages are installed in addition to the flavor's package set.
+	ExtraPackages []string
+	// Flavor selects the package set. Defaults to FlavorStandard.
+	Flavor Flavor
+}
+
+func NewBuildContext(logger *slog.Logger, baseISOPath string, outPath string, debug bool, biosPath string, opts BuildOptions) (*BuildContext, error) {
 	baseISOPath = filepath.Clean(baseISOPath)
 	outPath = filepath.Clean(outPath)
 
@@ -83,19 +93,18 @@ func NewBuildContext(logger *slog.Logger, baseISOPath string, outPath string, de
 	return &BuildContext{
 		logger: logger,
 
-		vi: vi,
+		vi:   vi,
+		opts: opts,
 	}, nil
 }
 
 func createQEMUImg(outPath string) error {
-	outPath = filepath.Clean(outPath)
-	baseCmd := "qemu-img"
-
-	if osspecifics.IsWindows() {
-		baseCmd += ".exe"
+	imgCmd, err := qemucli.NewImgCreateCommand(qemucli.ImgFormatQCOW2, filepath.Clean(outPath), 1<<30)
+	if err != nil {
+		return errors.Wrap(err, "create qemu-img create cmd")
 	}
 
-	err := exec.Command(baseCmd, "create", "-f", "qcow2", outPath, "1G").Run()
+	err = imgCmd.ExecCmd(context.Background()).Run()
 	if err != nil {
 		return errors.Wrap(err, "run qemu-img create cmd")
 	}
@@ -113,9 +122,17 @@ func (bc *BuildContext) RunCLIBuild() int {
 
 		defer func() { _ = sc.Close() }()
 
-		bc.logger.Info("VM OS installation in progress")
+		flavor := bc.opts.Flavor
+		if flavor == "" {
+			flavor = FlavorStandard
+		}
+
+		bc.logger.Info("VM OS installation in progress", "flavor", flavor)
 
-		err = runAlpineSetup(sc, []string{"openssh", "lvm2", "util-linux", "cryptsetup", "vsftpd", "samba", "netatalk"})
+		pkgs := flavor.Packages()
+		pkgs = append(pkgs, bc.opts.ExtraPackages...)
+
+		err = runAlpineSetup(ctx, sc, pkgs, bc.opts.Repositories)
 		if err != nil {
 			bc.logger.Error("Failed to set up Alpine Linux", "error", err.Error())
 			return 1
@@ -125,7 +142,7 @@ func (bc *BuildContext) RunCLIBuild() int {
 	})
 }
 
-func runAlpineSetup(sc *ssh.Client, pkgs []string) error {
+func runAlpineSetup(ctx context.Context, sc *ssh.Client, pkgs []string, repos []string) error {
 	sess, err := sc.NewSession()
 	if err != nil {
 		return errors.Wrap(err, "new session")
@@ -138,7 +155,33 @@ func runAlpineSetup(sc *ssh.Client, pkgs []string) error {
 		_ = sess.Close()
 	}()
 
-	cmd := "ifconfig eth0 up && ifconfig lo up && udhcpc && true > /etc/apk/repositories && setup-apkrepos -c -1 && printf 'y' | setup-disk -m sys /dev/vda"
+	done := make(chan struct{})
+	defer close(done)
+
+	// The setup has no timeout as downloading the packages can take
+	// long, but it is stopped once the build is interrupted.
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = sess.Close()
+		case <-done:
+		}
+	}()
+
+	cmd := "ifconfig eth0 up && ifconfig lo up && udhcpc && true > /etc/apk/repositories"
+
+	if len(repos) != 0 {
+		reposQuoted := make([]string, len(repos))
+		for i, repo := range repos {
+			reposQuoted[i] = shellescape.Quote(repo)
+		}
+
+		cmd += " && printf '%s\\n' " + strings.Join(reposQuoted, " ") + " > /etc/apk/repositories && apk update"
+	} else {
+		cmd += " && setup-apkrepos -c -1"
+	}
+
+	cmd += " && printf 'y' | setup-disk -m sys /dev/vda"
 
 	if len(pkgs) != 0 {
 		pkgsQuoted := make([]string, len(pkgs))
@@ -0,0 +1,152 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package lifecycle provides the hooks run at the points of a VM session
+// lifecycle. It is the one mechanism shared by the linsk command and the
+// embedders of package linsk.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// Mount describes a device mounted in the VM.
+type Mount struct {
+	Device   string
+	FSType   string
+	ReadOnly bool
+}
+
+type (
+	BootFunc       func(ctx context.Context) error
+	MountFunc      func(ctx context.Context, m Mount) error
+	ShareReadyFunc func(ctx context.Context, activeShares []share.ActiveShare) error
+	ShutdownFunc   func(ctx context.Context) error
+)
+
+// Hooks holds the hooks registered for a session. The zero value is
+// ready to use. It is safe for concurrent use, and the hooks may
+// register other hooks (like an OnShutdown one to undo the work).
+//
+// The boot, mount and share ready hooks run in the order they were
+// registered, and the first error stops the rest from running. The
+// shutdown hooks run in the reverse order, like deferred calls, and
+// all of them run regardless of the errors.
+type Hooks struct {
+	mu sync.Mutex
+
+	onBoot       []BootFunc
+	onMount      []MountFunc
+	onShareReady []ShareReadyFunc
+	onShutdown   []ShutdownFunc
+}
+
+// OnBoot registers the hook run once the VM has booted and is accessible over SSH.
+func (h *Hooks) OnBoot(fn BootFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onBoot = append(h.onBoot, fn)
+}
+
+// OnMount registers the hook run after a device is mounted in the VM.
+func (h *Hooks) OnMount(fn MountFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onMount = append(h.onMount, fn)
+}
+
+// OnShareReady registers the hook run once the network file shares are started.
+func (h *Hooks) OnShareReady(fn ShareReadyFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onShareReady = append(h.onShareReady, fn)
+}
+
+// OnShutdown registers the hook run when the session is shutting
+// down, while the VM and the file shares are still running.
+func (h *Hooks) OnShutdown(fn ShutdownFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onShutdown = append(h.onShutdown, fn)
+}
+
+// getHooks returns a snapshot of the hooks, so that
+// they can register other hooks while being run.
+func getHooks[T any](h *Hooks, hooks *[]T) []T {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]T(nil), *hooks...)
+}
+
+func (h *Hooks) RunBoot(ctx context.Context) error {
+	for i, fn := range getHooks(h, &h.onBoot) {
+		err := fn(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "run boot hook #%v", i)
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) RunMount(ctx context.Context, m Mount) error {
+	for i, fn := range getHooks(h, &h.onMount) {
+		err := fn(ctx, m)
+		if err != nil {
+			return errors.Wrapf(err, "run mount hook #%v", i)
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) RunShareReady(ctx context.Context, activeShares []share.ActiveShare) error {
+	for i, fn := range getHooks(h, &h.onShareReady) {
+		err := fn(ctx, activeShares)
+		if err != nil {
+			return errors.Wrapf(err, "run share ready hook #%v", i)
+		}
+	}
+
+	return nil
+}
+
+// RunShutdown runs the shutdown hooks. Each hook runs only once,
+// even if RunShutdown is called multiple times.
+func (h *Hooks) RunShutdown(ctx context.Context) error {
+	h.mu.Lock()
+	hooks := h.onShutdown
+	h.onShutdown = nil
+	h.mu.Unlock()
+
+	var err error
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err = multierr.Append(err, errors.Wrapf(hooks[i](ctx), "run shutdown hook #%v", i))
+	}
+
+	return err
+}
@@ -45,21 +45,19 @@ func (a *Alloc) Validate() error {
 	return nil
 }
 
-// The taps removed slice always returns the taps removed, even after
-// an error has occurred sometime while deleting non-first interfaces.
-func (tm *TapManager) PruneTaps(knownAllocs []Alloc) ([]string, error) {
-	var tapsRemoved []string
-
+// FindDanglingAllocs returns the allocations whose owner
+// processes are no longer running.
+func FindDanglingAllocs(knownAllocs []Alloc) ([]Alloc, error) {
 	for i, alloc := range knownAllocs {
 		err := alloc.Validate()
 		if err != nil {
-			return tapsRemoved, errors.Wrapf(err, "validate alloc #%v", i)
+			return nil, errors.Wrapf(err, "validate alloc #%v", i)
 		}
 	}
 
 	runningPids, err := process.Pids()
 	if err != nil {
-		return tapsRemoved, errors.Wrap(err, "get running pids")
+		return nil, errors.Wrap(err, "get running pids")
 	}
 
 	runningPidsMap := make(map[int32]struct{})
@@ -67,15 +65,34 @@ func (tm *TapManager) PruneTaps(knownAllocs []Alloc) ([]string, error) {
 		runningPidsMap[pid] = struct{}{}
 	}
 
-	var tapsToRemove []string
+	var ret []Alloc
 
 	for _, alloc := range knownAllocs {
 		if _, exists := runningPidsMap[int32(alloc.PID)]; !exists {
-			tm.logger.Info("Found a dangling network tap", "name", alloc.TapName, "pid", alloc.PID)
-			tapsToRemove = append(tapsToRemove, alloc.TapName)
+			ret = append(ret, alloc)
 		}
 	}
 
+	return ret, nil
+}
+
+// The taps removed slice always returns the taps removed, even after
+// an error has occurred sometime while deleting non-first interfaces.
+func (tm *TapManager) PruneTaps(knownAllocs []Alloc) ([]string, error) {
+	var tapsRemoved []string
+
+	danglingAllocs, err := FindDanglingAllocs(knownAllocs)
+	if err != nil {
+		return tapsRemoved, errors.Wrap(err, "find dangling allocs")
+	}
+
+	var tapsToRemove []string
+
+	for _, alloc := range danglingAllocs {
+		tm.logger.Info("Found a dangling network tap", "name", alloc.TapName, "pid", alloc.PID)
+		tapsToRemove = append(tapsToRemove, alloc.TapName)
+	}
+
 	for _, tapToRemove := range tapsToRemove {
 		err = tm.DeleteTap(tapToRemove)
 		if err != nil {
@@ -50,6 +50,10 @@ func (tm *TapManager) DeleteTap(_ string) error {
 	return ErrTapManagerUnimplemented
 }
 
+func (tm *TapManager) ListTapNames() ([]string, error) {
+	return nil, ErrTapManagerUnimplemented
+}
+
 func (tm *TapManager) ConfigureNet(_ string, _ string) error {
 	return ErrTapManagerUnimplemented
 }
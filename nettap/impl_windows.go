@@ -95,15 +95,22 @@ func ValidateTapName(s string) error {
 	return nil
 }
 
-func (tm *TapManager) DeleteTap(name string) error {
+type tapListItem struct {
+	UUID uuid.UUID
+	Name string
+}
+
+func (tm *TapManager) listTaps() ([]tapListItem, error) {
 	stderr := bytes.NewBuffer(nil)
 	cmd := exec.Command(tm.tapctlPath, "list")
 	cmd.Stderr = stderr
 	tapList, err := cmd.Output()
 	if err != nil {
-		return errors.Wrapf(err, "exec tapctl list cmd (out '%v')", utils.ClearUnprintableChars(stderr.String(), false))
+		return nil, errors.Wrapf(err, "exec tapctl list cmd (out '%v')", utils.ClearUnprintableChars(stderr.String(), false))
 	}
 
+	var ret []tapListItem
+
 	for _, line := range strings.Split(string(tapList), "\n") {
 		if line == "" {
 			continue
@@ -114,21 +121,56 @@ func (tm *TapManager) DeleteTap(name string) error {
 
 		split := strings.Split(line, " ")
 		if want, have := 2, len(split); want > have {
-			return fmt.Errorf("bad tap list item split length: want %v > have %v (line '%v')", want, have, line)
-		}
-
-		if name != split[1] {
-			continue
+			return nil, fmt.Errorf("bad tap list item split length: want %v > have %v (line '%v')", want, have, line)
 		}
 
 		lineTapUUIDStr := strings.TrimPrefix(split[0], "{")
 		lineTapUUIDStr = strings.TrimSuffix(lineTapUUIDStr, "}")
 		lineTapUUID, err := uuid.Parse(lineTapUUIDStr)
 		if err != nil {
-			return errors.Wrapf(err, "parse found line tap uuid (value '%v', line '%v')", lineTapUUIDStr, line)
+			return nil, errors.Wrapf(err, "parse line tap uuid (value '%v', line '%v')", lineTapUUIDStr, line)
+		}
+
+		ret = append(ret, tapListItem{
+			UUID: lineTapUUID,
+			Name: split[1],
+		})
+	}
+
+	return ret, nil
+}
+
+// ListTapNames returns the names of all existing taps that match
+// the Linsk tap naming scheme.
+func (tm *TapManager) ListTapNames() ([]string, error) {
+	taps, err := tm.listTaps()
+	if err != nil {
+		return nil, errors.Wrap(err, "list taps")
+	}
+
+	var ret []string
+
+	for _, tap := range taps {
+		if ValidateTapName(tap.Name) == nil {
+			ret = append(ret, tap.Name)
+		}
+	}
+
+	return ret, nil
+}
+
+func (tm *TapManager) DeleteTap(name string) error {
+	taps, err := tm.listTaps()
+	if err != nil {
+		return errors.Wrap(err, "list taps")
+	}
+
+	for _, tap := range taps {
+		if name != tap.Name {
+			continue
 		}
 
-		deleteOut, err := exec.Command(tm.tapctlPath, "delete", "{"+lineTapUUID.String()+"}").CombinedOutput()
+		deleteOut, err := exec.Command(tm.tapctlPath, "delete", "{"+tap.UUID.String()+"}").CombinedOutput()
 		if err != nil {
 			return errors.Wrapf(err, "exec tapctl delete (out '%v')", utils.ClearUnprintableChars(string(deleteOut), false))
 		}
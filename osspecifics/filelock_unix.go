@@ -0,0 +1,47 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux || darwin
+
+package osspecifics
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// LockFile takes an exclusive advisory lock on the file, blocking until it
+// is available. The lock is released with UnlockFile, or when the process
+// exits, so it is never left stale.
+func LockFile(f *os.File) error {
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, unix.EINTR) {
+			return errors.Wrap(err, "flock")
+		}
+	}
+}
+
+// UnlockFile releases the lock taken with LockFile.
+func UnlockFile(f *os.File) error {
+	return errors.Wrap(unix.Flock(int(f.Fd()), unix.LOCK_UN), "flock")
+}
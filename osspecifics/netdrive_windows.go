@@ -0,0 +1,120 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+
+package osspecifics
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modMpr = windows.NewLazySystemDLL("mpr.dll")
+
+	procWNetAddConnection2W    = modMpr.NewProc("WNetAddConnection2W")
+	procWNetCancelConnection2W = modMpr.NewProc("WNetCancelConnection2W")
+)
+
+// netResource is NETRESOURCEW.
+type netResource struct {
+	Scope       uint32
+	Type        uint32
+	DisplayType uint32
+	Usage       uint32
+	LocalName   *uint16
+	RemoteName  *uint16
+	Comment     *uint16
+	Provider    *uint16
+}
+
+const resourceTypeDisk = 0x1
+
+// FindFreeDriveLetter returns the last unused drive letter (like "Z:"),
+// which is the one "net use *" picks.
+func FindFreeDriveLetter() (string, error) {
+	drives, err := windows.GetLogicalDrives()
+	if err != nil {
+		return "", errors.Wrap(err, "get logical drives")
+	}
+
+	for c := 'Z'; c >= 'D'; c-- {
+		if drives&(1<<uint(c-'A')) == 0 {
+			return string(c) + ":", nil
+		}
+	}
+
+	return "", fmt.Errorf("no free drive letters")
+}
+
+// MapNetworkDrive maps the UNC path to the drive letter (like "L:"). Unlike
+// with "net use", the credentials are not passed on a command line, which
+// any local user can read. The mapping is not restored on the next logon.
+func MapNetworkDrive(drive string, remote string, username string, password string) error {
+	localName, err := windows.UTF16PtrFromString(drive)
+	if err != nil {
+		return errors.Wrap(err, "convert drive letter")
+	}
+
+	remoteName, err := windows.UTF16PtrFromString(remote)
+	if err != nil {
+		return errors.Wrap(err, "convert remote name")
+	}
+
+	user, err := windows.UTF16PtrFromString(username)
+	if err != nil {
+		return errors.Wrap(err, "convert username")
+	}
+
+	pwd, err := windows.UTF16PtrFromString(password)
+	if err != nil {
+		return errors.Wrap(err, "convert password")
+	}
+
+	nr := netResource{
+		Type:       resourceTypeDisk,
+		LocalName:  localName,
+		RemoteName: remoteName,
+	}
+
+	r, _, _ := procWNetAddConnection2W.Call(uintptr(unsafe.Pointer(&nr)), uintptr(unsafe.Pointer(pwd)), uintptr(unsafe.Pointer(user)), 0) // #nosec G103 It's safe.
+	if r != 0 {
+		return errors.Wrap(syscall.Errno(r), "add network connection")
+	}
+
+	return nil
+}
+
+// UnmapNetworkDrive removes the mapping made with MapNetworkDrive, even
+// if there are open files on the drive.
+func UnmapNetworkDrive(drive string) error {
+	localName, err := windows.UTF16PtrFromString(drive)
+	if err != nil {
+		return errors.Wrap(err, "convert drive letter")
+	}
+
+	r, _, _ := procWNetCancelConnection2W.Call(uintptr(unsafe.Pointer(localName)), 0, 1) // #nosec G103 It's safe.
+	if r != 0 {
+		return errors.Wrap(syscall.Errno(r), "cancel network connection")
+	}
+
+	return nil
+}
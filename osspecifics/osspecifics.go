@@ -19,9 +19,11 @@
 package osspecifics
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strings"
@@ -37,10 +39,30 @@ func SetNewProcessGroupCmd(cmd *exec.Cmd) {
 	}
 }
 
+// SetDetachedProcessCmd makes the child process outlive the current
+// one and its terminal by starting it in a new session.
+func SetDetachedProcessCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+}
+
+// EnableTerminalEscapes makes the terminal interpret ANSI escape sequences
+// written to the file. Unix terminals always do, so this is a no-op.
+func EnableTerminalEscapes(f *os.File) error {
+	return nil
+}
+
 func TerminateProcess(pid int) error {
 	return syscall.Kill(-pid, syscall.SIGTERM)
 }
 
+// InterruptProcess asks the process to shut down gracefully,
+// the same way Ctrl+C in its terminal would.
+func InterruptProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGINT)
+}
+
 // This is never used except for a band-aid that would check
 // that there are no double-mounts.
 func CheckDeviceSeemsMounted(devPathPrefix string) (bool, error) {
@@ -81,6 +103,25 @@ func CheckValidDevicePath(devPath string) error {
 	return nil
 }
 
+// CheckSecretFilePermissions returns an error if the file with a secret
+// (like a password) can be accessed by anyone except its owner.
+func CheckSecretFilePermissions(path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "stat file")
+	}
+
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file")
+	}
+
+	if perm := stat.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("the file is accessible by the group or other users (mode %04o), please restrict the access with \"chmod 600\"", perm)
+	}
+
+	return nil
+}
+
 func CheckRunAsRoot() (bool, error) {
 	currentUser, err := user.Current()
 	if err != nil {
@@ -109,3 +150,46 @@ func GetDeviceLogicalBlockSize(devPath string) (uint64, error) {
 
 	return uint64(bs), nil
 }
+
+// NotifyTerminalResize sends to the returned channel whenever the terminal
+// might have been resized, until the context is done.
+func NotifyTerminalResize(ctx context.Context) <-chan struct{} {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+
+	ch := make(chan struct{}, 1)
+
+	go func() {
+		defer close(ch)
+		defer signal.Stop(sigCh)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigCh:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// FindFreeDriveLetter is not supported outside Windows.
+func FindFreeDriveLetter() (string, error) {
+	return "", errors.New("drive letters are only supported on windows")
+}
+
+// MapNetworkDrive is not supported outside Windows.
+func MapNetworkDrive(drive string, remote string, username string, password string) error {
+	return errors.New("network drives are only supported on windows")
+}
+
+// UnmapNetworkDrive is not supported outside Windows.
+func UnmapNetworkDrive(drive string) error {
+	return errors.New("network drives are only supported on windows")
+}
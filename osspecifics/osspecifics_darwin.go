@@ -19,6 +19,13 @@
 package osspecifics
 
 import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
@@ -31,3 +38,112 @@ func getDeviceLogicalBlockSizeInner(fd uintptr) (int64, error) {
 
 	return int64(bs), nil
 }
+
+// CheckDeviceExclusiveAccess is a no-op on macOS, as macOS does not support
+// exclusive block device opens. CheckDeviceSeemsMounted is used instead.
+func CheckDeviceExclusiveAccess(devPath string) error {
+	return nil
+}
+
+// CheckHardwareAccelerationAvailable reports whether Hypervisor.framework (HVF) is usable.
+func CheckHardwareAccelerationAvailable() (bool, error) {
+	v, err := unix.SysctlUint32("kern.hv_support")
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "sysctl kern.hv_support")
+	}
+
+	return v == 1, nil
+}
+
+var darwinWholeDiskRegexp = regexp.MustCompile(`^disk\d+$`)
+
+// ListHostBlockDevices returns the paths of the whole-disk block devices
+// attached to the host.
+func ListHostBlockDevices() ([]string, error) {
+	entries, err := os.ReadDir("/dev")
+	if err != nil {
+		return nil, errors.Wrap(err, "read /dev")
+	}
+
+	var ret []string
+
+	for _, entry := range entries {
+		if darwinWholeDiskRegexp.MatchString(entry.Name()) {
+			ret = append(ret, "/dev/"+entry.Name())
+		}
+	}
+
+	return ret, nil
+}
+
+// spUSBItem is a USB device (or bus) in the system_profiler SPUSBDataType report.
+type spUSBItem struct {
+	VendorID  string `json:"vendor_id"`
+	ProductID string `json:"product_id"`
+
+	Media []struct {
+		BSDName string `json:"bsd_name"`
+	} `json:"Media"`
+
+	Items []spUSBItem `json:"_items"`
+}
+
+// FindUSBBlockDevices returns the paths of the whole-disk block devices
+// provided by the USB devices with the vendor and product IDs, as
+// reported by system_profiler.
+func FindUSBBlockDevices(vendorID uint16, productID uint16) ([]string, error) {
+	out, err := exec.Command("system_profiler", "-json", "SPUSBDataType").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "run system_profiler")
+	}
+
+	var report struct {
+		Items []spUSBItem `json:"SPUSBDataType"`
+	}
+
+	err = json.Unmarshal(out, &report)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal system_profiler report")
+	}
+
+	var ret []string
+
+	var walk func(items []spUSBItem)
+	walk = func(items []spUSBItem) {
+		for _, item := range items {
+			if parseSPUSBID(item.VendorID) == int(vendorID) && parseSPUSBID(item.ProductID) == int(productID) {
+				for _, m := range item.Media {
+					if darwinWholeDiskRegexp.MatchString(m.BSDName) {
+						ret = append(ret, "/dev/"+m.BSDName)
+					}
+				}
+			}
+
+			walk(item.Items)
+		}
+	}
+
+	walk(report.Items)
+
+	return ret, nil
+}
+
+// parseSPUSBID parses the system_profiler USB IDs like "0x0781  (SanDisk
+// Corporation)". It returns -1 if the ID is missing or malformed.
+func parseSPUSBID(s string) int {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return -1
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 16)
+	if err != nil {
+		return -1
+	}
+
+	return int(id)
+}
@@ -19,6 +19,10 @@
 package osspecifics
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -34,3 +38,105 @@ func getDeviceLogicalBlockSizeInner(fd uintptr) (int64, error) {
 
 	return bs, nil
 }
+
+// CheckDeviceExclusiveAccess checks that the block device is not in use by the
+// host, i.e. neither the device nor any of its partitions is mounted or held by
+// device mapper, md, swap, etc. It relies on the kernel refusing exclusive
+// opens (O_EXCL) of block devices that are claimed by someone else.
+func CheckDeviceExclusiveAccess(devPath string) error {
+	fd, err := unix.Open(devPath, unix.O_RDONLY|unix.O_EXCL|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.EBUSY) {
+			return fmt.Errorf("device is in use by the host (mounted, or held by device mapper, md or swap)")
+		}
+
+		return errors.Wrap(err, "open device exclusively")
+	}
+
+	_ = unix.Close(fd)
+
+	return nil
+}
+
+// CheckHardwareAccelerationAvailable reports whether KVM is usable.
+func CheckHardwareAccelerationAvailable() (bool, error) {
+	fd, err := unix.Open("/dev/kvm", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "open /dev/kvm")
+	}
+
+	_ = unix.Close(fd)
+
+	return true, nil
+}
+
+// ListHostBlockDevices returns the paths of the whole-disk block devices
+// attached to the host. Virtual devices like loop, ram and zram are skipped.
+func ListHostBlockDevices() ([]string, error) {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, errors.Wrap(err, "read /sys/block")
+	}
+
+	var ret []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+
+		ret = append(ret, "/dev/"+name)
+	}
+
+	return ret, nil
+}
+
+// FindUSBBlockDevices returns the paths of the whole-disk block devices
+// provided by the USB devices with the vendor and product IDs.
+func FindUSBBlockDevices(vendorID uint16, productID uint16) ([]string, error) {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, errors.Wrap(err, "read /sys/block")
+	}
+
+	var ret []string
+
+	for _, entry := range entries {
+		// The virtual devices have no device link.
+		devDir, err := filepath.EvalSymlinks(filepath.Join("/sys/block", entry.Name(), "device"))
+		if err != nil {
+			continue
+		}
+
+		if sysfsUSBAncestorMatches(devDir, vendorID, productID) {
+			ret = append(ret, "/dev/"+entry.Name())
+		}
+	}
+
+	return ret, nil
+}
+
+// sysfsUSBAncestorMatches reports whether the closest USB device
+// up the sysfs device path has the vendor and product IDs.
+func sysfsUSBAncestorMatches(dir string, vendorID uint16, productID uint16) bool {
+	for ; dir != "/sys" && dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		v, err := os.ReadFile(filepath.Join(dir, "idVendor"))
+		if err != nil {
+			continue
+		}
+
+		p, err := os.ReadFile(filepath.Join(dir, "idProduct"))
+		if err != nil {
+			return false
+		}
+
+		return strings.TrimSpace(string(v)) == fmt.Sprintf("%04x", vendorID) && strings.TrimSpace(string(p)) == fmt.Sprintf("%04x", productID)
+	}
+
+	return false
+}
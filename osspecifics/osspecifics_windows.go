@@ -19,12 +19,16 @@
 package osspecifics
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"os"
 	"os/exec"
 	"regexp"
 	"strings"
 	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sys/windows"
@@ -37,10 +41,45 @@ func SetNewProcessGroupCmd(cmd *exec.Cmd) {
 	}
 }
 
+// SetDetachedProcessCmd makes the child process outlive the current
+// one and its console by starting it without a console window of its own.
+func SetDetachedProcessCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | windows.CREATE_NO_WINDOW,
+	}
+}
+
+// EnableTerminalEscapes makes the console interpret ANSI escape sequences
+// written to the file, which the legacy Windows console does not by default.
+func EnableTerminalEscapes(f *os.File) error {
+	h := windows.Handle(f.Fd())
+
+	var mode uint32
+
+	err := windows.GetConsoleMode(h, &mode)
+	if err != nil {
+		return errors.Wrap(err, "get console mode")
+	}
+
+	err = windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	if err != nil {
+		return errors.Wrap(err, "set console mode")
+	}
+
+	return nil
+}
+
 func TerminateProcess(pid int) error {
 	return exec.Command("TASKKILL", "/T", "/F", "/PID", fmt.Sprint(pid)).Run()
 }
 
+// InterruptProcess asks the process to shut down gracefully, the same way
+// Ctrl+Break in its console would. The process must have been started
+// with SetNewProcessGroupCmd, as the event is sent to its process group.
+func InterruptProcess(pid int) error {
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+}
+
 var physicalDriveCheckRegexp = regexp.MustCompile(`(?i)^\\\\.\\PhysicalDrive(\d+)$`)
 var physicalDriveFindRegexp = regexp.MustCompile(`(?i)PhysicalDrive(\d+)`)
 
@@ -77,6 +116,43 @@ func CheckValidDevicePath(devPath string) error {
 	return nil
 }
 
+// secretFileBroadACERegexp matches the access-allowed ACEs in the SDDL
+// form for Everyone, Authenticated Users and BUILTIN\Users.
+var secretFileBroadACERegexp = regexp.MustCompile(`\(A;[^;]*;[^;]*;[^;]*;[^;]*;(WD|AU|BU)\)`)
+
+// CheckSecretFilePermissions returns an error if the file with a secret
+// (like a password) can be accessed by everyone or all users.
+func CheckSecretFilePermissions(path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "stat file")
+	}
+
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file")
+	}
+
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return errors.Wrap(err, "get file security info")
+	}
+
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return errors.Wrap(err, "get file dacl")
+	}
+
+	if dacl == nil {
+		return fmt.Errorf("the file has no access control list and is accessible by everyone")
+	}
+
+	if secretFileBroadACERegexp.MatchString(sd.String()) {
+		return fmt.Errorf("the file is accessible by everyone or all users, please remove their access in the file security properties")
+	}
+
+	return nil
+}
+
 func CheckRunAsRoot() (bool, error) {
 	var sid *windows.SID
 
@@ -128,3 +204,151 @@ func GetDeviceLogicalBlockSize(devPath string) (uint64, error) {
 
 	return uint64(bs), nil
 }
+
+// CheckDeviceExclusiveAccess is a no-op on Windows. CheckDeviceSeemsMounted is used instead.
+func CheckDeviceExclusiveAccess(devPath string) error {
+	return nil
+}
+
+var (
+	modWinHvPlatform     = windows.NewLazySystemDLL("WinHvPlatform.dll")
+	procWHvGetCapability = modWinHvPlatform.NewProc("WHvGetCapability")
+)
+
+// whvCapabilityCodeHypervisorPresent is WHvCapabilityCodeHypervisorPresent from WinHvPlatformDefs.h.
+const whvCapabilityCodeHypervisorPresent = 0
+
+// CheckHardwareAccelerationAvailable reports whether Windows Hypervisor Platform (WHPX) is usable.
+func CheckHardwareAccelerationAvailable() (bool, error) {
+	err := procWHvGetCapability.Find()
+	if err != nil {
+		// The Windows Hypervisor Platform feature is not installed.
+		return false, nil
+	}
+
+	var present uint32
+	var written uint32
+
+	hr, _, _ := procWHvGetCapability.Call(
+		whvCapabilityCodeHypervisorPresent,
+		uintptr(unsafe.Pointer(&present)), // #nosec G103 It's safe.
+		unsafe.Sizeof(present),
+		uintptr(unsafe.Pointer(&written)), // #nosec G103 It's safe.
+	)
+	if hr != 0 {
+		return false, fmt.Errorf("WHvGetCapability failed with HRESULT 0x%X", hr)
+	}
+
+	return present != 0, nil
+}
+
+// NotifyTerminalResize sends to the returned channel whenever the terminal
+// might have been resized, until the context is done. Windows has no resize
+// signal, so the terminal size has to be polled.
+func NotifyTerminalResize(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(time.Millisecond * 500)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// maxPhysicalDrives is the number of PhysicalDriveN paths probed by ListHostBlockDevices.
+const maxPhysicalDrives = 32
+
+// ListHostBlockDevices returns the paths of the physical drives attached
+// to the host. Opening a drive with no access rights does not require
+// administrator privileges, so this works for unprivileged users too.
+func ListHostBlockDevices() ([]string, error) {
+	var ret []string
+
+	for i := 0; i < maxPhysicalDrives; i++ {
+		devPath := fmt.Sprintf(`\\.\PhysicalDrive%v`, i)
+
+		diskPath, err := windows.UTF16PtrFromString(devPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "create utf-16 ptr from dev path string")
+		}
+
+		handle, err := windows.CreateFile(diskPath, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err != nil {
+			continue
+		}
+
+		_ = windows.CloseHandle(handle)
+
+		ret = append(ret, devPath)
+	}
+
+	return ret, nil
+}
+
+// usbDeviceIDRegexp matches the USB device IDs in the WMI object
+// paths, where the backslashes are escaped.
+var usbDeviceIDRegexp = regexp.MustCompile(`USB\\\\VID_([0-9A-Fa-f]{4})&PID_([0-9A-Fa-f]{4})\\\\([^"\s]+)`)
+
+// FindUSBBlockDevices returns the paths of the physical drives provided by
+// the USB devices with the vendor and product IDs. The drives are matched
+// to the USB devices by the serial numbers in their instance IDs, so the
+// devices without a serial number are not found.
+func FindUSBBlockDevices(vendorID uint16, productID uint16) ([]string, error) {
+	out, err := exec.Command("wmic", "path", "Win32_USBControllerDevice", "get", "Dependent").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "exec wmic usb devices cmd")
+	}
+
+	serials := make(map[string]bool)
+
+	for _, match := range usbDeviceIDRegexp.FindAllStringSubmatch(string(out), -1) {
+		if strings.EqualFold(match[1], fmt.Sprintf("%04x", vendorID)) && strings.EqualFold(match[2], fmt.Sprintf("%04x", productID)) {
+			serials[strings.ToUpper(match[3])] = true
+		}
+	}
+
+	if len(serials) == 0 {
+		return nil, nil
+	}
+
+	out, err = exec.Command("wmic", "diskdrive", "where", "InterfaceType='USB'", "get", "Index,PNPDeviceID", "/format:csv").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "exec wmic disk drives cmd")
+	}
+
+	var ret []string
+
+	// The lines are "<node>,<index>,<PNP device ID>", and the USB mass storage
+	// instance IDs are "<USB serial number>&<LUN>".
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) != 3 {
+			continue
+		}
+
+		instanceID := fields[2][strings.LastIndex(fields[2], `\`)+1:]
+
+		ampIdx := strings.LastIndex(instanceID, "&")
+		if ampIdx == -1 || !serials[strings.ToUpper(instanceID[:ampIdx])] {
+			continue
+		}
+
+		ret = append(ret, `\\.\PhysicalDrive`+fields[1])
+	}
+
+	return ret, nil
+}
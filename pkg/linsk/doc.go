@@ -0,0 +1,53 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package linsk is the public Go API of Linsk. It lets other Go programs
+// (like backup tools and GUIs) list the host devices, start a VM session with
+// the devices passed through, mount the file systems, and start the network
+// file shares, the same way the linsk command does.
+//
+// Unlike the other packages in this module, which are the internals of the
+// command and change shape freely, this package follows semantic versioning:
+// its exported API is not changed in a backward-incompatible way within the
+// same major version. The types here deliberately do not expose the internal
+// ones.
+//
+// A typical session looks like this:
+//
+//	client, err := linsk.New(linsk.Options{})
+//	...
+//	sess, err := client.StartSession(ctx, linsk.SessionConfig{
+//		Devices: []linsk.Device{{Path: "/dev/sdb"}},
+//		Share:   &linsk.ShareConfig{Backends: []string{"smb"}},
+//	})
+//	...
+//	defer sess.Close()
+//
+//	err = sess.Mount(ctx, "vdb1", linsk.MountOptions{})
+//	...
+//	shares, err := sess.StartShares(ctx)
+//
+// The operations taking a context stop promptly once it is canceled, as well
+// as once the VM shuts down.
+//
+// To run code at the points of the session lifecycle, create the session with
+// Client.NewSession, register the hooks like Session.OnBoot and
+// Session.OnShareReady, and start it with Session.Start.
+//
+// The VM image must be built with "linsk build" first. Package linsktest
+// provides a fake VM backend to test the programs using this package
+// without QEMU installed.
+package linsk
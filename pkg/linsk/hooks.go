@@ -0,0 +1,63 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package linsk
+
+import (
+	"context"
+
+	"github.com/AlexSSD7/linsk/lifecycle"
+	"github.com/AlexSSD7/linsk/share"
+)
+
+// The hooks are run synchronously at the points of the session lifecycle.
+// The boot, mount and share ready hooks run in the order they were
+// registered, and an error returned by one of them stops the rest from
+// running and is returned by the method which ran them (Session.Start,
+// Session.Mount or Session.StartShares). The shutdown hooks run in the
+// reverse order, like deferred calls, and their errors are returned by
+// Session.Close. The hooks may register other hooks.
+
+// OnBoot registers the hook run once the VM has booted, before Session.Start
+// returns. The session is closed if the hook fails. The context is the one
+// passed to Session.Start.
+func (s *Session) OnBoot(fn func(ctx context.Context) error) {
+	s.hooks.OnBoot(fn)
+}
+
+// OnMount registers the hook run after a device is mounted with Session.Mount.
+// The device stays mounted if the hook fails. The context is the one passed
+// to Session.Mount.
+func (s *Session) OnMount(fn func(ctx context.Context, device string) error) {
+	s.hooks.OnMount(func(ctx context.Context, m lifecycle.Mount) error {
+		return fn(ctx, m.Device)
+	})
+}
+
+// OnShareReady registers the hook run once the network file shares are
+// started with Session.StartShares. The shares keep running if the hook
+// fails. The context is the one passed to Session.StartShares.
+func (s *Session) OnShareReady(fn func(ctx context.Context, shares []Share) error) {
+	s.hooks.OnShareReady(func(ctx context.Context, activeShares []share.ActiveShare) error {
+		return fn(ctx, newShares(activeShares))
+	})
+}
+
+// OnShutdown registers the hook run by Session.Close, while the
+// VM and the network file shares are still running.
+func (s *Session) OnShutdown(fn func(ctx context.Context) error) {
+	s.hooks.OnShutdown(fn)
+}
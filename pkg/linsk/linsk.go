@@ -0,0 +1,127 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package linsk
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/storage"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+// The errors returned by the API can be matched against these with errors.Is.
+var (
+	// ErrImageNotFound is returned when the VM image was not built yet.
+	ErrImageNotFound = errors.New("vm image not found")
+
+	ErrBootTimeout      = vm.ErrBootTimeout
+	ErrDeviceNotFound   = vm.ErrDeviceNotFound
+	ErrPermissionDenied = vm.ErrPermissionDenied
+	ErrUnlockFailed     = vm.ErrUnlockFailed
+	ErrHostKeyMismatch  = vm.ErrHostKeyMismatch
+
+	// ErrCommandTimeout is returned when a command run in the VM
+	// (like the mount) does not complete in time.
+	ErrCommandTimeout = sshutil.ErrTimeout
+
+	// ErrImageCorrupted is returned when the VM image does not
+	// match the hash recorded once it was built or downloaded.
+	ErrImageCorrupted = storage.ErrImageCorrupted
+)
+
+// Options configures the Client.
+type Options struct {
+	// DataDir is the Linsk data directory with the VM images. Defaults to
+	// the one used by the linsk command ("~/.linsk", or "Linsk" in the
+	// user profile directory on Windows).
+	DataDir string
+
+	// Logger receives the logs of all sessions. Defaults to discarding them.
+	Logger *slog.Logger
+}
+
+// Client is the entry point of the API. It is safe for concurrent use.
+type Client struct {
+	logger *slog.Logger
+	store  *storage.Storage
+	vms    *vm.Manager
+
+	// newInstance creates the session VMs. It is nil for the real QEMU
+	// VMs, and set to create the fake ones by package linsktest.
+	newInstance instanceFactory
+}
+
+type instanceFactory func(ctx context.Context, cfg SessionConfig, vmCfg vm.Config) (vm.Instance, error)
+
+// New creates a Client. The data directory is created if it does not exist.
+func New(opts Options) (*Client, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		var err error
+		dataDir, err = storage.GetDefaultDataDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "get default data dir")
+		}
+	}
+
+	store, err := storage.NewStorage(logger.With("caller", "storage"), dataDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "create storage")
+	}
+
+	return &Client{
+		logger: logger,
+		store:  store,
+		vms: vm.NewManager(logger.With("caller", "vm-manager"), vm.ManagerOptions{
+			PortReserver: store.ReservePort,
+		}),
+	}, nil
+}
+
+// HostDevice is a block device attached to the host.
+type HostDevice struct {
+	// Path is the device path to pass to Device.Path,
+	// like "/dev/disk2" or `\\.\PhysicalDrive1`.
+	Path string
+}
+
+// ListHostDevices returns the block devices attached to the host.
+// Listing does not require root (admin) privileges on macOS and Linux,
+// while passing the devices through does.
+func (c *Client) ListHostDevices() ([]HostDevice, error) {
+	paths, err := osspecifics.ListHostBlockDevices()
+	if err != nil {
+		return nil, errors.Wrap(err, "list host block devices")
+	}
+
+	ret := make([]HostDevice, 0, len(paths))
+	for _, p := range paths {
+		ret = append(ret, HostDevice{Path: p})
+	}
+
+	return ret, nil
+}
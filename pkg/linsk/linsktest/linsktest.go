@@ -0,0 +1,160 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package linsktest provides a fake VM backend for testing the programs built
+// on package linsk without QEMU installed or the devices attached.
+//
+// The sessions of the client returned by NewClient run on in-process fake VMs.
+// The commands Linsk runs in the fake VMs succeed with no output, unless
+// scripted otherwise with Backend.Handle. Like package linsk, this package
+// follows semantic versioning, but the commands Linsk runs in the VM do not.
+package linsktest
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexSSD7/linsk/pkg/linsk"
+	"github.com/AlexSSD7/linsk/pkg/linsk/internal/testhooks"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/AlexSSD7/linsk/vm/vmtest"
+	"github.com/pkg/errors"
+)
+
+// RunFunc handles a command run in the fake VM. It returns the exit status
+// of the command.
+type RunFunc func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+
+// Output returns a RunFunc which writes the output and exits with status 0.
+func Output(stdout string) RunFunc {
+	return RunFunc(vmtest.Output(stdout))
+}
+
+// Fail returns a RunFunc which writes the message to stderr and exits with the status.
+func Fail(status int, stderr string) RunFunc {
+	return RunFunc(vmtest.Fail(status, stderr))
+}
+
+// Config configures the fake VMs.
+type Config struct {
+	// Logger receives the logs of the client and the fake VMs.
+	// Defaults to discarding them.
+	Logger *slog.Logger
+
+	// BootDelay is how long the fake VMs take to boot.
+	BootDelay time.Duration
+
+	// SerialOutput is the scripted serial console output of the fake VMs.
+	SerialOutput []string
+
+	// BootError makes the fake VMs fail to boot with the error,
+	// like linsk.ErrBootTimeout.
+	BootError error
+}
+
+type handler struct {
+	prefix string
+	fn     RunFunc
+}
+
+// Backend creates the fake VMs for the sessions. It is safe for concurrent use.
+type Backend struct {
+	logger *slog.Logger
+	cfg    Config
+
+	mu       sync.Mutex
+	handlers []handler
+	fakes    []*vmtest.Fake
+
+	fakeSeq atomic.Uint64
+}
+
+// NewClient returns a client with the sessions running on the fake VMs
+// created by the returned backend.
+func NewClient(cfg Config) (*linsk.Client, *Backend) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	b := &Backend{
+		logger: logger,
+		cfg:    cfg,
+	}
+
+	return testhooks.NewClient(logger, b.newInstance).(*linsk.Client), b
+}
+
+func (b *Backend) newInstance(vmCfg vm.Config) (vm.Instance, error) {
+	hostname := vmCfg.Hostname
+	if hostname == "" {
+		// Like the real VMs, the sessions get unique hostnames by default.
+		hostname = "linsk-fake-" + fmt.Sprint(b.fakeSeq.Add(1))
+	}
+
+	f, err := vmtest.New(b.logger.With("caller", "fake-vm"), vmtest.Config{
+		Hostname: hostname,
+
+		BootDelay:    b.cfg.BootDelay,
+		SerialOutput: b.cfg.SerialOutput,
+		BootError:    b.cfg.BootError,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create fake vm")
+	}
+
+	f.HandleDefault(vmtest.Output(""))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, h := range b.handlers {
+		f.Handle(h.prefix, vmtest.RunFunc(h.fn))
+	}
+
+	b.fakes = append(b.fakes, f)
+
+	return f, nil
+}
+
+// Handle registers the handler for the commands starting with the prefix, in
+// the fake VMs of the sessions started afterwards. The handler with the longest
+// matching prefix is used, and the one registered last wins between the
+// handlers with the same prefix.
+func (b *Backend) Handle(prefix string, fn RunFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler{prefix: prefix, fn: fn})
+}
+
+// Commands returns the commands run in the fake VMs so far,
+// in order, with the sessions in the order they were started.
+func (b *Backend) Commands() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ret []string
+	for _, f := range b.fakes {
+		ret = append(ret, f.Commands()...)
+	}
+
+	return ret
+}
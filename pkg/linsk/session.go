@@ -0,0 +1,486 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package linsk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AlexSSD7/linsk/lifecycle"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	defaultMemoryMiB    = 512
+	defaultOSUpTimeout  = 30 * time.Second
+	defaultSSHUpTimeout = 60 * time.Second
+)
+
+// Device is a host device to pass through to the VM. The devices appear
+// in the VM as "vdb", "vdc", and so on, in the order they are specified.
+type Device struct {
+	// Path is the host block device path (see Client.ListHostDevices),
+	// or the disk image file path if Image is set. Passing block devices
+	// through requires root (admin) privileges.
+	Path string
+
+	Image bool
+
+	// ImageFormat is the disk image format, like "qcow2", "raw", "vdi",
+	// "vmdk", "vhdx" or "vpc". Detected by the file extension if empty.
+	ImageFormat string
+}
+
+// ShareConfig configures the network file shares.
+type ShareConfig struct {
+	// Backends are the share backend IDs to start, like "smb", "afp",
+	// "ftp", "nfs", "webdav" or "sftp". Defaults to the OS-specific one.
+	Backends []string
+
+	// ListenIP is the host IP to bind the share ports to. Defaults to 127.0.0.1.
+	ListenIP string
+
+	// Username and Password are the share credentials. The default
+	// username and a generated password are used if they are empty.
+	Username string
+	Password string
+}
+
+// SessionConfig configures the VM session.
+type SessionConfig struct {
+	Devices []Device
+
+	// ReadOnly passes the devices through and mounts the
+	// file systems read-only, and makes the shares reject writes.
+	ReadOnly bool
+
+	// MemoryMiB is the VM memory allocation. Defaults to 512 MiB.
+	// Opening LUKS volumes needs at least 2048 MiB.
+	MemoryMiB uint32
+
+	// CPUs is the VM vCPU count. Defaults to half of the
+	// host logical CPUs, capped at 8.
+	CPUs uint32
+
+	// Hostname is the VM hostname, which is also advertised by the file
+	// share servers. It must be unique among the running sessions of the
+	// client. A unique one is generated if it is empty.
+	Hostname string
+
+	// BootTimeout limits the VM boot, including the SSH server setup.
+	// The default is 90 seconds, scaled up under software emulation.
+	BootTimeout time.Duration
+
+	// Share configures the network file shares to start with
+	// Session.StartShares. It must be set when the session is started,
+	// as the share ports are forwarded to the VM on startup.
+	Share *ShareConfig
+}
+
+// Session is a VM with the devices passed through. Once started, it must
+// be closed with Close. The methods must not be called concurrently,
+// except for the hook registration ones.
+type Session struct {
+	c   *Client
+	cfg SessionConfig
+
+	hooks lifecycle.Hooks
+
+	vi         *vm.ManagedInstance
+	fm         *vm.FileManager
+	supervisor *share.Supervisor
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSession creates a session to be started with Session.Start. This allows
+// registering the hooks, like Session.OnBoot, before the VM is started.
+func (c *Client) NewSession(cfg SessionConfig) *Session {
+	return &Session{
+		c:   c,
+		cfg: cfg,
+	}
+}
+
+// StartSession creates the session and starts it, see Session.Start.
+func (c *Client) StartSession(ctx context.Context, cfg SessionConfig) (*Session, error) {
+	s := c.NewSession(cfg)
+
+	err := s.Start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Start starts the VM and waits for it to boot. Canceling the context
+// stops the VM if it has not booted yet. The session is closed if the
+// boot hooks fail.
+func (s *Session) Start(ctx context.Context) error {
+	if s.vi != nil {
+		return fmt.Errorf("session already started")
+	}
+
+	c, cfg := s.c, s.cfg
+
+	var vmShareOpts share.VMShareOptions
+
+	if cfg.Share != nil {
+		supervisor, opts, err := newShareSupervisor(c, *cfg.Share, cfg.ReadOnly)
+		if err != nil {
+			return errors.Wrap(err, "configure shares")
+		}
+
+		s.supervisor = supervisor
+		vmShareOpts = *opts
+	}
+
+	memoryMiB := cfg.MemoryMiB
+	if memoryMiB == 0 {
+		memoryMiB = defaultMemoryMiB
+	}
+
+	osUpTimeout, sshUpTimeout := defaultOSUpTimeout, defaultSSHUpTimeout
+	if cfg.BootTimeout != 0 {
+		osUpTimeout, sshUpTimeout = cfg.BootTimeout/3, cfg.BootTimeout*2/3
+	}
+
+	newInstance := c.newInstance
+	if newInstance == nil {
+		newInstance = c.newQEMUInstance
+	}
+
+	vmCfg := vm.Config{
+		MemoryAlloc: memoryMiB,
+		CPUs:        cfg.CPUs,
+
+		ExtraPortForwardingRules: vmShareOpts.Ports,
+
+		Hostname: cfg.Hostname,
+
+		OSUpTimeout:  osUpTimeout,
+		SSHUpTimeout: sshUpTimeout,
+
+		Accel: vm.AccelAuto,
+	}
+
+	// The VMs are named by their hostnames in the manager.
+	vi, err := c.vms.Start("", func(sshPort uint16) (vm.Instance, error) {
+		vmCfg.SSHPort = sshPort
+		return newInstance(ctx, cfg, vmCfg)
+	})
+	if err != nil {
+		return errors.Wrap(err, "create vm")
+	}
+
+	select {
+	case <-vi.SSHUpNotifyChan():
+	case <-vi.Exited():
+		err := vi.Wait()
+		if err == nil {
+			err = fmt.Errorf("vm exited unexpectedly")
+		}
+
+		return errors.Wrap(err, "run vm")
+	case <-ctx.Done():
+		_ = vi.Cancel()
+		_ = vi.Wait()
+
+		return ctx.Err()
+	}
+
+	s.vi = vi
+	s.fm = vm.NewFileManager(c.logger.With("caller", "file-manager"), vi)
+
+	err = s.fm.InitLVM(ctx)
+	if err != nil {
+		return multierr.Append(errors.Wrap(err, "init lvm"), s.Close())
+	}
+
+	err = s.hooks.RunBoot(ctx)
+	if err != nil {
+		return multierr.Append(err, s.Close())
+	}
+
+	return nil
+}
+
+// newQEMUInstance creates the QEMU VM with the Linsk VM image booted and the
+// devices passed through. The rest of the VM configuration is set by the caller.
+func (c *Client) newQEMUInstance(ctx context.Context, cfg SessionConfig, vmCfg vm.Config) (vm.Instance, error) {
+	// The sessions started concurrently would download the BIOS to the same file otherwise.
+	defer c.vms.LockCache(c.store.DataDirPath())()
+
+	imagePath, _, err := c.store.ResolveVMImage("")
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve vm image")
+	}
+
+	if imagePath == "" {
+		return nil, ErrImageNotFound
+	}
+
+	err = c.store.VerifyVMImage(imagePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify vm image")
+	}
+
+	biosPath, err := c.store.CheckDownloadVMBIOS(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "check/download vm bios")
+	}
+
+	for _, dev := range cfg.Devices {
+		blockCfg, err := getBlockPassthroughConfig(dev)
+		if err != nil {
+			return nil, errors.Wrapf(err, "configure device '%v'", dev.Path)
+		}
+
+		blockCfg.ReadOnly = cfg.ReadOnly
+		vmCfg.PassthroughConfig.Block = append(vmCfg.PassthroughConfig.Block, blockCfg)
+	}
+
+	vmCfg.Drives = []vm.DriveConfig{{
+		Path:         imagePath,
+		SnapshotMode: true,
+	}}
+	vmCfg.BIOSPath = biosPath
+
+	vi, err := vm.NewVM(c.logger.With("caller", "vm"), vmCfg)
+	if err != nil {
+		// Not returning a typed nil as the interface.
+		return nil, err
+	}
+
+	return vi, nil
+}
+
+func getBlockPassthroughConfig(dev Device) (vm.BlockDevicePassthroughConfig, error) {
+	path := filepath.Clean(dev.Path)
+
+	if dev.Image {
+		_, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return vm.BlockDevicePassthroughConfig{}, fmt.Errorf("%w: image file does not exist", ErrDeviceNotFound)
+			}
+
+			return vm.BlockDevicePassthroughConfig{}, errors.Wrap(err, "stat image file")
+		}
+
+		format := qemucli.ImgFormat(dev.ImageFormat)
+		if format == "" {
+			format = qemucli.ImgFormatByExt(path)
+		}
+
+		return vm.BlockDevicePassthroughConfig{
+			Path:        path,
+			BlockSize:   512,
+			ImageFormat: format,
+		}, nil
+	}
+
+	isRoot, err := osspecifics.CheckRunAsRoot()
+	if err != nil {
+		return vm.BlockDevicePassthroughConfig{}, errors.Wrap(err, "check whether the program is run as root")
+	}
+
+	if !isRoot {
+		return vm.BlockDevicePassthroughConfig{}, fmt.Errorf("%w: device passthrough requires root (admin) privileges", ErrPermissionDenied)
+	}
+
+	err = osspecifics.CheckValidDevicePath(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return vm.BlockDevicePassthroughConfig{}, ErrDeviceNotFound
+		}
+
+		return vm.BlockDevicePassthroughConfig{}, errors.Wrap(err, "check whether device path is valid")
+	}
+
+	blockSize, err := osspecifics.GetDeviceLogicalBlockSize(path)
+	if err != nil {
+		return vm.BlockDevicePassthroughConfig{}, errors.Wrap(err, "get logical block size")
+	}
+
+	return vm.BlockDevicePassthroughConfig{
+		Path:      path,
+		BlockSize: blockSize,
+	}, nil
+}
+
+func newShareSupervisor(c *Client, sc ShareConfig, readOnly bool) (*share.Supervisor, *share.VMShareOptions, error) {
+	backends := sc.Backends
+	if len(backends) == 0 {
+		backends = []string{share.GetDefaultBackendID()}
+	}
+
+	listenIP := sc.ListenIP
+	if listenIP == "" {
+		listenIP = share.GetDefaultListenIPStr()
+	}
+
+	uc, err := share.RawUserConfiguration{
+		ListenIP: listenIP,
+		FTPExtIP: listenIP,
+
+		ShareUser:     sc.Username,
+		SharePassword: sc.Password,
+
+		ReadOnly: readOnly,
+	}.Process(backends, c.logger.With("caller", "share-config"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "process share configuration")
+	}
+
+	supervisor, opts, err := share.NewSupervisor(backends, uc)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "initialize share backends")
+	}
+
+	if opts.EnableTap {
+		return nil, nil, fmt.Errorf("share backends requiring tap networking are not supported")
+	}
+
+	return supervisor, opts, nil
+}
+
+// Name returns the session name, which is the VM hostname.
+func (s *Session) Name() string {
+	return s.vi.Hostname()
+}
+
+// Done returns a channel that is closed when the VM stops.
+func (s *Session) Done() <-chan struct{} {
+	return s.vi.Done()
+}
+
+// BlockDevices returns the block devices in the VM as a tree
+// of disks and the partitions and volumes on them.
+func (s *Session) BlockDevices(ctx context.Context) ([]BlockDevice, error) {
+	devs, err := s.fm.ListBlockDevices(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list block devices")
+	}
+
+	return newBlockDevices(devs), nil
+}
+
+// MountOptions configures Session.Mount.
+type MountOptions struct {
+	// FSType is the file system type. Detected automatically if empty.
+	FSType string
+
+	// LUKS opens the device as a LUKS volume before mounting.
+	LUKS bool
+
+	// LUKSPassword is the password to open the LUKS volume with.
+	// It is read from the terminal if empty.
+	LUKSPassword []byte
+
+	// Subvolume is the btrfs subvolume to mount instead of the default one.
+	Subvolume string
+
+	// Options are the extra mount options, like "noatime".
+	Options string
+}
+
+// Mount mounts the device in the VM, like "vdb" for the first passed-through
+// device or "vdb1" for a partition on it. See Session.BlockDevices.
+func (s *Session) Mount(ctx context.Context, device string, opts MountOptions) error {
+	err := s.fm.Mount(ctx, device, vm.MountConfig{
+		LUKSOptions: vm.LUKSOptions{
+			Password: opts.LUKSPassword,
+		},
+
+		BtrfsSubvolume: opts.Subvolume,
+		FSTypeOverride: opts.FSType,
+		LUKS:           opts.LUKS,
+		MountOptions:   opts.Options,
+		ReadOnly:       s.cfg.ReadOnly,
+	})
+	if err != nil {
+		return errors.Wrap(err, "mount")
+	}
+
+	return s.hooks.RunMount(ctx, lifecycle.Mount{
+		Device:   device,
+		FSType:   opts.FSType,
+		ReadOnly: s.cfg.ReadOnly,
+	})
+}
+
+// StartShares starts the network file shares configured with
+// SessionConfig.Share. The device must be mounted first.
+func (s *Session) StartShares(ctx context.Context) ([]Share, error) {
+	if s.supervisor == nil {
+		return nil, fmt.Errorf("no shares were configured for the session")
+	}
+
+	activeShares, err := s.supervisor.Apply(ctx, &share.VMShareContext{
+		Instance:    s.vi,
+		FileManager: s.fm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "start share backends")
+	}
+
+	err = s.hooks.RunShareReady(ctx, activeShares)
+	if err != nil {
+		return nil, err
+	}
+
+	return newShares(activeShares), nil
+}
+
+// Close runs the shutdown hooks, stops the shares and shuts the VM down.
+// It does nothing if the session was not started. It is safe to call
+// multiple times.
+func (s *Session) Close() error {
+	if s.vi == nil {
+		return nil
+	}
+
+	s.closeOnce.Do(func() {
+		err := s.hooks.RunShutdown(context.Background())
+
+		if s.supervisor != nil {
+			err = multierr.Append(err, errors.Wrap(s.supervisor.Close(), "close share backends"))
+		}
+
+		select {
+		case <-s.vi.Done():
+		default:
+			err = multierr.Append(err, errors.Wrap(s.vi.Cancel(), "cancel vm"))
+		}
+
+		s.closeErr = multierr.Append(err, errors.Wrap(s.vi.Wait(), "run vm"))
+	})
+
+	return s.closeErr
+}
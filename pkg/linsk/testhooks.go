@@ -0,0 +1,38 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package linsk
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/AlexSSD7/linsk/pkg/linsk/internal/testhooks"
+	"github.com/AlexSSD7/linsk/vm"
+)
+
+func init() {
+	testhooks.NewClient = func(logger *slog.Logger, newInstance func(vmCfg vm.Config) (vm.Instance, error)) any {
+		return &Client{
+			logger: logger,
+			vms:    vm.NewManager(logger.With("caller", "vm-manager"), vm.ManagerOptions{}),
+
+			newInstance: func(_ context.Context, _ SessionConfig, vmCfg vm.Config) (vm.Instance, error) {
+				return newInstance(vmCfg)
+			},
+		}
+	}
+}
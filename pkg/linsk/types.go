@@ -0,0 +1,100 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package linsk
+
+import (
+	"github.com/AlexSSD7/linsk/share"
+	"github.com/AlexSSD7/linsk/vm"
+)
+
+// BlockDevice is a block device (disk, partition, or a mapped
+// device like a LUKS or LVM volume) in the VM.
+type BlockDevice struct {
+	// Name is the device name to pass to Session.Mount, like "vdb1".
+	Name string
+
+	// Type is the device type, like "disk", "part", "crypt", "lvm" or "raid1".
+	Type string
+
+	Size   uint64
+	FSType string
+	Label  string
+	UUID   string
+
+	// Encryption is "luks", "bitlocker", etc. for encrypted containers,
+	// and "unlocked" for the opened mappings of them. Empty otherwise.
+	Encryption string
+
+	// Mountable reports whether the device holds a file system that can be
+	// mounted, and Hint describes what is on the device in a human-readable form.
+	Mountable bool
+	Hint      string
+
+	Children []BlockDevice
+}
+
+func newBlockDevices(devs []vm.BlockDevice) []BlockDevice {
+	if devs == nil {
+		return nil
+	}
+
+	ret := make([]BlockDevice, 0, len(devs))
+	for _, dev := range devs {
+		ret = append(ret, BlockDevice{
+			Name:       dev.Name,
+			Type:       dev.Type,
+			Size:       dev.Size,
+			FSType:     dev.FSType,
+			Label:      dev.Label,
+			UUID:       dev.UUID,
+			Encryption: dev.Encryption,
+			Mountable:  dev.Classification.Mountable,
+			Hint:       dev.Classification.Hint,
+			Children:   newBlockDevices(dev.Children),
+		})
+	}
+
+	return ret
+}
+
+// Share is a started network file share.
+type Share struct {
+	// Backend is the share backend ID, like "smb".
+	Backend string
+
+	// URL is the address to connect to the share at.
+	URL string
+
+	// Username and Password are empty if the backend
+	// does not use password authentication.
+	Username string
+	Password string
+}
+
+func newShares(activeShares []share.ActiveShare) []Share {
+	ret := make([]Share, 0, len(activeShares))
+	for _, as := range activeShares {
+		ret = append(ret, Share{
+			Backend:  as.BackendID,
+			URL:      as.URL,
+			Username: as.Username,
+			Password: as.Password,
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,69 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package progress
+
+// Operation names reported in Update.Op.
+const (
+	OpDownload = "download"
+	OpBoot     = "boot"
+	OpFsck     = "fsck"
+)
+
+// Units reported in Update.Unit. An empty unit means
+// that Done and Total count steps.
+const (
+	UnitBytes = "bytes"
+)
+
+// Update is a snapshot of the progress of a long-running operation.
+type Update struct {
+	Op    string `json:"op"`
+	Stage string `json:"stage,omitempty"`
+
+	// Name identifies the subject of the operation, like
+	// the downloaded file or the checked device.
+	Name string `json:"name,omitempty"`
+
+	Done  int64  `json:"done"`
+	Total int64  `json:"total,omitempty"` // 0 if unknown.
+	Unit  string `json:"unit,omitempty"`
+}
+
+// Percent returns the completion percentage, or -1 if the total is unknown.
+func (u Update) Percent() float64 {
+	if u.Total <= 0 {
+		return -1
+	}
+
+	p := float64(u.Done) / float64(u.Total) * 100
+	if p > 100 {
+		p = 100
+	}
+
+	return p
+}
+
+// Func receives the progress updates. It must not block for long, as it
+// is called synchronously by the operation.
+type Func func(Update)
+
+// Report calls fn if it is not nil.
+func (fn Func) Report(u Update) {
+	if fn != nil {
+		fn(u)
+	}
+}
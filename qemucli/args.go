@@ -20,7 +20,6 @@ import (
 	"fmt"
 	"reflect"
 
-	"github.com/alessio/shellescape"
 	"github.com/pkg/errors"
 )
 
@@ -30,25 +29,10 @@ const (
 	ArgAcceptedValueUint     ArgAcceptedValue = "uint"
 	ArgAcceptedValueString   ArgAcceptedValue = "string"
 	ArgAcceptedValueKeyValue ArgAcceptedValue = "kv"
+	ArgAcceptedValueJSON     ArgAcceptedValue = "json"
 	ArgAcceptedValueNone     ArgAcceptedValue = "none"
 )
 
-var safeArgs = map[string]ArgAcceptedValue{
-	"accel":   ArgAcceptedValueKeyValue,
-	"boot":    ArgAcceptedValueString,
-	"m":       ArgAcceptedValueUint,
-	"smp":     ArgAcceptedValueUint,
-	"device":  ArgAcceptedValueKeyValue,
-	"netdev":  ArgAcceptedValueKeyValue,
-	"serial":  ArgAcceptedValueString,
-	"cdrom":   ArgAcceptedValueString,
-	"machine": ArgAcceptedValueKeyValue,
-	"cpu":     ArgAcceptedValueString,
-	"display": ArgAcceptedValueString,
-	"drive":   ArgAcceptedValueKeyValue,
-	"bios":    ArgAcceptedValueString,
-}
-
 type Arg interface {
 	StringKey() string
 	StringValue() string
@@ -97,7 +81,8 @@ func EncodeArg(a Arg) (string, *string, error) {
 		return "", nil, fmt.Errorf("empty string value while declaring non-empty value (type %v)", reflect.TypeOf(a))
 	}
 
-	argVal := shellescape.Quote(argValueStr)
-
-	return "-" + argKey, &argVal, nil
+	// The value is passed as a separate argv entry, so no
+	// quoting is needed here. For displaying the assembled
+	// command line to the user, see RenderCommandLine.
+	return "-" + argKey, &argValueStr, nil
 }
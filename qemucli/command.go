@@ -0,0 +1,344 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Keys that QEMU does not accept more than once (or silently overrides).
+var singleInstanceKeys = map[string]struct{}{
+	"m":       {},
+	"smp":     {},
+	"bios":    {},
+	"cpu":     {},
+	"boot":    {},
+	"display": {},
+	"cdrom":   {},
+}
+
+type ConflictKind string
+
+const (
+	ConflictKindDuplicateKey     ConflictKind = "duplicate-key"
+	ConflictKindDuplicateID      ConflictKind = "duplicate-id"
+	ConflictKindHostFwdCollision ConflictKind = "hostfwd-collision"
+	ConflictKindMalformedHostFwd ConflictKind = "malformed-hostfwd"
+	ConflictKindMalformedJSON    ConflictKind = "malformed-json"
+)
+
+type ConflictingArg struct {
+	Index int
+	Key   string
+	Value string
+}
+
+func (ca ConflictingArg) String() string {
+	return fmt.Sprintf("#%v -%v %v", ca.Index, ca.Key, ca.Value)
+}
+
+// ConflictError is returned when the args of a Command conflict with each other.
+type ConflictError struct {
+	Kind   ConflictKind
+	Detail string
+	Args   []ConflictingArg
+}
+
+func (e *ConflictError) Error() string {
+	argStrs := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		argStrs[i] = "'" + a.String() + "'"
+	}
+
+	return fmt.Sprintf("conflicting args (%v): %v: %v", e.Kind, e.Detail, strings.Join(argStrs, ", "))
+}
+
+// Command collects the args of a QEMU invocation and checks them
+// for conflicts before they get encoded.
+//
+// Args added together with a single Add call form a group. Groups are
+// always emitted contiguously, and are ordered so that the args which
+// define an ID (like -drive or -netdev) come before the args that
+// reference it (like -device).
+type Command struct {
+	baseCmd string
+	groups  [][]Arg
+}
+
+func NewCommand(baseCmd string) *Command {
+	return &Command{
+		baseCmd: baseCmd,
+	}
+}
+
+// Add adds the args as a single group.
+func (c *Command) Add(args ...Arg) {
+	if len(args) == 0 {
+		return
+	}
+
+	group := make([]Arg, len(args))
+	copy(group, args)
+
+	c.groups = append(c.groups, group)
+}
+
+func (c *Command) BaseCmd() string {
+	return c.baseCmd
+}
+
+// Args returns the collected args in the order they were added.
+func (c *Command) Args() []Arg {
+	var ret []Arg
+	for _, group := range c.groups {
+		ret = append(ret, group...)
+	}
+
+	return ret
+}
+
+// Validate returns a *ConflictError if the collected args conflict with
+// each other, or a *MissingReferenceError if an arg references an ID
+// that is not defined by any other arg.
+func (c *Command) Validate() error {
+	args := c.Args()
+
+	err := validateConflicts(args)
+	if err != nil {
+		return err
+	}
+
+	return validateReferences(args)
+}
+
+func validateConflicts(args []Arg) error {
+	seenKeys := make(map[string]ConflictingArg)
+	seenIDs := make(map[string]ConflictingArg)
+	var fwds []hostFwd
+
+	for i, arg := range args {
+		ca := ConflictingArg{
+			Index: i,
+			Key:   arg.StringKey(),
+			Value: arg.StringValue(),
+		}
+
+		if _, ok := singleInstanceKeys[ca.Key]; ok {
+			if prev, ok := seenKeys[ca.Key]; ok {
+				return &ConflictError{
+					Kind:   ConflictKindDuplicateKey,
+					Detail: fmt.Sprintf("'-%v' can be specified only once", ca.Key),
+					Args:   []ConflictingArg{prev, ca},
+				}
+			}
+
+			seenKeys[ca.Key] = ca
+		}
+
+		ids, err := getArgIDs(arg)
+		if err != nil {
+			return &ConflictError{
+				Kind:   ConflictKindMalformedJSON,
+				Detail: err.Error(),
+				Args:   []ConflictingArg{ca},
+			}
+		}
+
+		for _, id := range ids {
+			// IDs are unique per arg type in QEMU. E.g., a drive and
+			// a device can have the same ID.
+			nsID := ca.Key + "/" + id
+			if prev, ok := seenIDs[nsID]; ok {
+				return &ConflictError{
+					Kind:   ConflictKindDuplicateID,
+					Detail: fmt.Sprintf("duplicate '-%v' id '%v'", ca.Key, id),
+					Args:   []ConflictingArg{prev, ca},
+				}
+			}
+
+			seenIDs[nsID] = ca
+		}
+
+		argFwds, err := getArgHostFwds(arg)
+		if err != nil {
+			return &ConflictError{
+				Kind:   ConflictKindMalformedHostFwd,
+				Detail: err.Error(),
+				Args:   []ConflictingArg{ca},
+			}
+		}
+
+		for _, fwd := range argFwds {
+			fwd.arg = ca
+
+			for _, prev := range fwds {
+				if prev.collidesWith(fwd) {
+					return &ConflictError{
+						Kind:   ConflictKindHostFwdCollision,
+						Detail: fmt.Sprintf("host port %v/%v is forwarded more than once", fwd.proto, fwd.hostPort),
+						Args:   []ConflictingArg{prev.arg, fwd.arg},
+					}
+				}
+			}
+
+			fwds = append(fwds, fwd)
+		}
+	}
+
+	return nil
+}
+
+// OrderedArgs validates the collected args and returns
+// them in the order they are to be emitted.
+func (c *Command) OrderedArgs() ([]Arg, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	orderedGroups, err := orderByDependencies(c.groups, func(group []Arg) []Arg { return group })
+	if err != nil {
+		return nil, errors.Wrap(err, "order arg groups")
+	}
+
+	var ret []Arg
+
+	for _, group := range orderedGroups {
+		orderedGroup, err := orderByDependencies(group, func(arg Arg) []Arg { return []Arg{arg} })
+		if err != nil {
+			return nil, errors.Wrap(err, "order args in group")
+		}
+
+		ret = append(ret, orderedGroup...)
+	}
+
+	return ret, nil
+}
+
+// EncodeArgs validates, orders, and encodes the collected args.
+func (c *Command) EncodeArgs() ([]string, error) {
+	args, err := c.OrderedArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeArgs(args)
+}
+
+func getArgIDs(arg Arg) ([]string, error) {
+	switch a := arg.(type) {
+	case *KeyValueArg:
+		var ids []string
+		for _, item := range a.items {
+			if item.Key == "id" || item.Key == "node-name" {
+				ids = append(ids, item.Value)
+			}
+		}
+
+		return ids, nil
+	case *JSONArg:
+		var m map[string]any
+		err := json.Unmarshal([]byte(a.value), &m)
+		if err != nil {
+			return nil, errors.Wrap(err, "unmarshal json value")
+		}
+
+		var ids []string
+		for _, k := range []string{"id", "node-name"} {
+			if id, ok := m[k].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+
+		return ids, nil
+	default:
+		return nil, nil
+	}
+}
+
+type hostFwd struct {
+	arg ConflictingArg
+
+	proto    string
+	hostIP   net.IP
+	hostPort string
+}
+
+func (f hostFwd) collidesWith(other hostFwd) bool {
+	if f.proto != other.proto || f.hostPort != other.hostPort {
+		return false
+	}
+
+	// No IP specified means all interfaces.
+	if f.hostIP == nil || other.hostIP == nil || f.hostIP.IsUnspecified() || other.hostIP.IsUnspecified() {
+		return true
+	}
+
+	return f.hostIP.Equal(other.hostIP)
+}
+
+func getArgHostFwds(arg Arg) ([]hostFwd, error) {
+	kv, ok := arg.(*KeyValueArg)
+	if !ok {
+		return nil, nil
+	}
+
+	var ret []hostFwd
+
+	for _, item := range kv.items {
+		if item.Key != "hostfwd" {
+			continue
+		}
+
+		// Format: [tcp|udp]:[hostaddr]:hostport-[guestaddr]:guestport
+		hostPart, _, ok := strings.Cut(item.Value, "-")
+		if !ok {
+			return nil, fmt.Errorf("bad hostfwd value '%v'", item.Value)
+		}
+
+		split := strings.Split(hostPart, ":")
+		if want, have := 3, len(split); want != have {
+			return nil, fmt.Errorf("bad hostfwd host part split by ':' length: want %v, have %v ('%v')", want, have, item.Value)
+		}
+
+		proto := split[0]
+		if proto == "" {
+			proto = "tcp"
+		}
+
+		var hostIP net.IP
+		if split[1] != "" {
+			hostIP = net.ParseIP(split[1])
+			if hostIP == nil {
+				return nil, fmt.Errorf("bad hostfwd host ip '%v'", split[1])
+			}
+		}
+
+		ret = append(ret, hostFwd{
+			proto:    proto,
+			hostIP:   hostIP,
+			hostPort: split[2],
+		})
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,259 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+type DriveCache string
+
+const (
+	DriveCacheDefault      DriveCache = ""
+	DriveCacheNone         DriveCache = "none"
+	DriveCacheWriteBack    DriveCache = "writeback"
+	DriveCacheWriteThrough DriveCache = "writethrough"
+	DriveCacheDirectSync   DriveCache = "directsync"
+	DriveCacheUnsafe       DriveCache = "unsafe"
+)
+
+type DriveAIO string
+
+const (
+	DriveAIODefault DriveAIO = ""
+	DriveAIOThreads DriveAIO = "threads"
+	// DriveAIONative uses the Linux native AIO (or the overlapped I/O on
+	// Windows). It requires the host page cache to be bypassed.
+	DriveAIONative DriveAIO = "native"
+	// DriveAIOIOUring uses io_uring. Linux only, requires QEMU 5.0 or newer.
+	DriveAIOIOUring DriveAIO = "io_uring"
+)
+
+// Drive is a -drive backend together with the virtio-blk
+// -device frontend that exposes it to the guest.
+type Drive struct {
+	ID       string
+	File     string
+	Format   ImgFormat
+	ReadOnly bool
+	Snapshot bool
+	Cache    DriveCache
+	AIO      DriveAIO
+
+	// BootIndex is omitted if nil.
+	BootIndex *int
+
+	// BlockSize sets both logical and physical block
+	// sizes of the device. Omitted if zero.
+	BlockSize uint64
+}
+
+func (d Drive) Args() ([]Arg, error) {
+	if d.ID == "" {
+		return nil, fmt.Errorf("empty drive id")
+	}
+
+	if d.File == "" {
+		return nil, fmt.Errorf("empty drive file")
+	}
+
+	err := validateImgFormat(d.Format)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate format")
+	}
+
+	props := NewKeyValueProps().
+		Set("file", d.File).
+		Set("format", string(d.Format)).
+		Set("if", "none").
+		Set("id", d.ID)
+
+	if d.ReadOnly {
+		props.SetOnOff("readonly", On)
+	}
+
+	if d.Snapshot {
+		props.SetOnOff("snapshot", On)
+	}
+
+	switch d.Cache {
+	case DriveCacheDefault:
+	case DriveCacheNone, DriveCacheWriteBack, DriveCacheWriteThrough, DriveCacheDirectSync, DriveCacheUnsafe:
+		props.Set("cache", string(d.Cache))
+	default:
+		return nil, fmt.Errorf("unknown drive cache mode '%v'", d.Cache)
+	}
+
+	switch d.AIO {
+	case DriveAIODefault:
+	case DriveAIONative:
+		if d.Cache != DriveCacheNone && d.Cache != DriveCacheDirectSync {
+			return nil, fmt.Errorf("drive aio mode '%v' requires cache mode '%v' or '%v' (have '%v')", d.AIO, DriveCacheNone, DriveCacheDirectSync, d.Cache)
+		}
+
+		props.Set("aio", string(d.AIO))
+	case DriveAIOThreads, DriveAIOIOUring:
+		props.Set("aio", string(d.AIO))
+	default:
+		return nil, fmt.Errorf("unknown drive aio mode '%v'", d.AIO)
+	}
+
+	driveArg, err := NewNestedKeyValueArg("drive", props)
+	if err != nil {
+		return nil, errors.Wrap(err, "create drive arg")
+	}
+
+	devProps := NewKeyValueProps().
+		Set("driver", "virtio-blk-pci").
+		Set("drive", d.ID)
+
+	if d.BootIndex != nil {
+		devProps.Set("bootindex", utils.IntToStr(*d.BootIndex))
+	}
+
+	if d.BlockSize != 0 {
+		devProps.SetUint("logical_block_size", d.BlockSize).SetUint("physical_block_size", d.BlockSize)
+	}
+
+	deviceArg, err := NewNestedKeyValueArg("device", devProps)
+	if err != nil {
+		return nil, errors.Wrap(err, "create device arg")
+	}
+
+	return []Arg{driveArg, deviceArg}, nil
+}
+
+// XHCIController is a USB 3.0 host controller. It is required
+// for USBHostDevice to be attached.
+type XHCIController struct{}
+
+func (XHCIController) Args() ([]Arg, error) {
+	arg, err := NewKeyValueArg("device", []KeyValueArgItem{{Key: "driver", Value: "nec-usb-xhci"}})
+	if err != nil {
+		return nil, errors.Wrap(err, "create device arg")
+	}
+
+	return []Arg{arg}, nil
+}
+
+// USBHostDevice passes a host USB device through to the guest.
+type USBHostDevice struct {
+	VendorID  uint16
+	ProductID uint16
+}
+
+func (d USBHostDevice) Args() ([]Arg, error) {
+	arg, err := NewKeyValueArg("device", []KeyValueArgItem{
+		{Key: "driver", Value: "usb-host"},
+		{Key: "vendorid", Value: "0x" + hex.EncodeToString(utils.Uint16ToBytesBE(d.VendorID))},
+		{Key: "productid", Value: "0x" + hex.EncodeToString(utils.Uint16ToBytesBE(d.ProductID))},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create device arg")
+	}
+
+	return []Arg{arg}, nil
+}
+
+// UserNetForward is a TCP port forwarding rule from the host to the guest.
+type UserNetForward struct {
+	// HostIP is optional. All interfaces are used if nil.
+	HostIP    net.IP
+	HostPort  uint16
+	GuestPort uint16
+}
+
+// UserNet is a user-mode (SLIRP) network backend together
+// with the virtio-net device attached to it.
+type UserNet struct {
+	ID       string
+	Restrict bool
+	Forwards []UserNetForward
+}
+
+func (n UserNet) Args() ([]Arg, error) {
+	if n.ID == "" {
+		return nil, fmt.Errorf("empty netdev id")
+	}
+
+	items := []KeyValueArgItem{
+		{Key: "type", Value: "user"},
+		{Key: "id", Value: n.ID},
+	}
+
+	if n.Restrict {
+		items = append(items, NewOnOffItem("restrict", On))
+	}
+
+	for _, fwd := range n.Forwards {
+		hostIPStr := ""
+		if fwd.HostIP != nil {
+			hostIPStr = fwd.HostIP.String()
+		}
+
+		items = append(items, KeyValueArgItem{
+			Key:   "hostfwd",
+			Value: "tcp:" + hostIPStr + ":" + utils.UintToStr(fwd.HostPort) + "-:" + utils.UintToStr(fwd.GuestPort),
+		})
+	}
+
+	return newNetArgs(n.ID, items)
+}
+
+// TapNet is a tap network backend together with
+// the virtio-net device attached to it.
+type TapNet struct {
+	ID     string
+	IfName string
+}
+
+func (n TapNet) Args() ([]Arg, error) {
+	if n.ID == "" {
+		return nil, fmt.Errorf("empty netdev id")
+	}
+
+	if n.IfName == "" {
+		return nil, fmt.Errorf("empty tap interface name")
+	}
+
+	return newNetArgs(n.ID, []KeyValueArgItem{
+		{Key: "type", Value: "tap"},
+		{Key: "id", Value: n.ID},
+		{Key: "ifname", Value: n.IfName},
+		{Key: "script", Value: "no"},
+		{Key: "downscript", Value: "no"},
+	})
+}
+
+func newNetArgs(netID string, netdevItems []KeyValueArgItem) ([]Arg, error) {
+	netdevArg, err := NewKeyValueArg("netdev", netdevItems)
+	if err != nil {
+		return nil, errors.Wrap(err, "create netdev arg")
+	}
+
+	deviceArg, err := NewKeyValueArg("device", []KeyValueArgItem{{Key: "driver", Value: "virtio-net"}, {Key: "netdev", Value: netID}})
+	if err != nil {
+		return nil, errors.Wrap(err, "create device arg")
+	}
+
+	return []Arg{netdevArg, deviceArg}, nil
+}
@@ -0,0 +1,267 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+type ImgFormat string
+
+const (
+	ImgFormatQCOW2 ImgFormat = "qcow2"
+	ImgFormatRaw   ImgFormat = "raw"
+	ImgFormatVDI   ImgFormat = "vdi"
+	ImgFormatVMDK  ImgFormat = "vmdk"
+	ImgFormatVHDX  ImgFormat = "vhdx"
+	ImgFormatVPC   ImgFormat = "vpc"
+)
+
+var safeImgFormats = map[ImgFormat]struct{}{
+	ImgFormatQCOW2: {},
+	ImgFormatRaw:   {},
+	ImgFormatVDI:   {},
+	ImgFormatVMDK:  {},
+	ImgFormatVHDX:  {},
+	ImgFormatVPC:   {},
+}
+
+// ImgFormatByExt guesses the disk image format by the file extension.
+// Unknown extensions (like ".img" or ".dd") are treated as raw images.
+func ImgFormatByExt(path string) ImgFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".qcow2", ".qcow":
+		return ImgFormatQCOW2
+	case ".vdi":
+		return ImgFormatVDI
+	case ".vmdk":
+		return ImgFormatVMDK
+	case ".vhdx":
+		return ImgFormatVHDX
+	case ".vhd":
+		return ImgFormatVPC
+	default:
+		return ImgFormatRaw
+	}
+}
+
+type ImgSnapshotOp string
+
+const (
+	ImgSnapshotOpCreate ImgSnapshotOp = "-c"
+	ImgSnapshotOpApply  ImgSnapshotOp = "-a"
+	ImgSnapshotOpDelete ImgSnapshotOp = "-d"
+	ImgSnapshotOpList   ImgSnapshotOp = "-l"
+)
+
+// ImgCommand is a validated qemu-img invocation. Unlike the QEMU system
+// args, these are never passed through a shell, so no quoting is applied.
+type ImgCommand struct {
+	args []string
+}
+
+func GetImgBaseCmd() string {
+	baseCmd := "qemu-img"
+	if osspecifics.IsWindows() {
+		baseCmd += ".exe"
+	}
+
+	return baseCmd
+}
+
+func NewImgCreateCommand(format ImgFormat, path string, size uint64) (*ImgCommand, error) {
+	err := validateImgFormat(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate format")
+	}
+
+	err = validateImgPath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate path")
+	}
+
+	if size == 0 {
+		return nil, fmt.Errorf("zero image size")
+	}
+
+	return &ImgCommand{
+		args: []string{"create", "-f", string(format), path, utils.UintToStr(size)},
+	}, nil
+}
+
+func NewImgConvertCommand(srcFormat ImgFormat, srcPath string, dstFormat ImgFormat, dstPath string) (*ImgCommand, error) {
+	err := validateImgFormat(srcFormat)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate source format")
+	}
+
+	err = validateImgFormat(dstFormat)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate destination format")
+	}
+
+	err = validateImgPath(srcPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate source path")
+	}
+
+	err = validateImgPath(dstPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate destination path")
+	}
+
+	return &ImgCommand{
+		args: []string{"convert", "-f", string(srcFormat), "-O", string(dstFormat), srcPath, dstPath},
+	}, nil
+}
+
+// NewImgCreateOverlayCommand creates a qcow2 image that stores only the
+// changes made on top of the backing image.
+func NewImgCreateOverlayCommand(backingFormat ImgFormat, backingPath string, path string) (*ImgCommand, error) {
+	err := validateImgFormat(backingFormat)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate backing format")
+	}
+
+	err = validateImgPath(backingPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate backing path")
+	}
+
+	err = validateImgPath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate path")
+	}
+
+	return &ImgCommand{
+		args: []string{"create", "-f", string(ImgFormatQCOW2), "-F", string(backingFormat), "-b", backingPath, path},
+	}, nil
+}
+
+// NewImgRebaseUnsafeCommand changes the backing file reference of the image
+// without touching its data. The new backing file must have the same contents.
+func NewImgRebaseUnsafeCommand(path string, backingFormat ImgFormat, backingPath string) (*ImgCommand, error) {
+	err := validateImgFormat(backingFormat)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate backing format")
+	}
+
+	err = validateImgPath(backingPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate backing path")
+	}
+
+	err = validateImgPath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate path")
+	}
+
+	return &ImgCommand{
+		args: []string{"rebase", "-u", "-F", string(backingFormat), "-b", backingPath, path},
+	}, nil
+}
+
+var imgSnapshotNameRegexp = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+
+func NewImgSnapshotCommand(op ImgSnapshotOp, path string, snapshotName string) (*ImgCommand, error) {
+	err := validateImgPath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate path")
+	}
+
+	switch op {
+	case ImgSnapshotOpList:
+		if snapshotName != "" {
+			return nil, fmt.Errorf("snapshot name is not accepted when listing snapshots")
+		}
+
+		return &ImgCommand{
+			args: []string{"snapshot", string(op), path},
+		}, nil
+	case ImgSnapshotOpCreate, ImgSnapshotOpApply, ImgSnapshotOpDelete:
+		if !imgSnapshotNameRegexp.MatchString(snapshotName) {
+			return nil, fmt.Errorf("invalid snapshot name '%v'", snapshotName)
+		}
+
+		return &ImgCommand{
+			args: []string{"snapshot", string(op), snapshotName, path},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot op '%v'", op)
+	}
+}
+
+func NewImgResizeCommand(format ImgFormat, path string, size uint64) (*ImgCommand, error) {
+	err := validateImgFormat(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate format")
+	}
+
+	err = validateImgPath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate path")
+	}
+
+	if size == 0 {
+		return nil, fmt.Errorf("zero image size")
+	}
+
+	return &ImgCommand{
+		args: []string{"resize", "-f", string(format), path, utils.UintToStr(size)},
+	}, nil
+}
+
+func (c *ImgCommand) Args() []string {
+	// Making a copy so that remote caller cannot modify the validated args.
+	tmp := make([]string, len(c.args))
+	copy(tmp, c.args)
+	return tmp
+}
+
+func (c *ImgCommand) ExecCmd(ctx context.Context) *exec.Cmd {
+	return exec.CommandContext(ctx, GetImgBaseCmd(), c.Args()...) //#nosec G204 // The args are validated at the creation of the ImgCommand.
+}
+
+func validateImgFormat(format ImgFormat) error {
+	if _, ok := safeImgFormats[format]; !ok {
+		return fmt.Errorf("unknown image format '%v'", format)
+	}
+
+	return nil
+}
+
+func validateImgPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("empty path")
+	}
+
+	if strings.HasPrefix(path, "-") {
+		// This would otherwise be interpreted as a qemu-img option.
+		return fmt.Errorf("path must not start with a dash")
+	}
+
+	return nil
+}
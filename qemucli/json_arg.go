@@ -0,0 +1,81 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// JSONArg represents args in QEMU's JSON syntax, like
+// `-blockdev {"driver":"file","filename":"disk.img"}`. This allows
+// expressing nested structures that can't be expressed in flat
+// key=value form.
+type JSONArg struct {
+	key   string
+	value string
+}
+
+func MustNewJSONArg(key string, value any) *JSONArg {
+	a, err := NewJSONArg(key, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+// NewJSONArg serializes the value into JSON at creation. The value must
+// serialize into a JSON object.
+func NewJSONArg(key string, value any) (*JSONArg, error) {
+	// Preflight arg key/type check.
+	err := validateArgKey(key, ArgAcceptedValueJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate arg key")
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal json value")
+	}
+
+	if !bytes.HasPrefix(b, []byte("{")) {
+		return nil, fmt.Errorf("json value must be an object")
+	}
+
+	return &JSONArg{
+		key:   key,
+		value: string(b),
+	}, nil
+}
+
+func (a *JSONArg) StringKey() string {
+	return a.key
+}
+
+func (a *JSONArg) StringValue() string {
+	// We're not validating anything here because
+	// the value was serialized at the creation of the JSONArg.
+	return a.value
+}
+
+func (a *JSONArg) ValueType() ArgAcceptedValue {
+	return ArgAcceptedValueJSON
+}
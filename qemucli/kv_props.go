@@ -0,0 +1,143 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+// KeyValueProps is a builder for key-value arg values with nested
+// sub-properties. Nested properties are flattened using QEMU's dotted
+// key syntax, e.g. `file.driver=file,file.filename=disk.img`.
+//
+// Errors are accumulated and returned by Items(), so the setters can
+// be chained.
+type KeyValueProps struct {
+	props []kvProp
+	err   error
+}
+
+type kvProp struct {
+	key    string
+	value  string
+	nested *KeyValueProps
+}
+
+func NewKeyValueProps() *KeyValueProps {
+	return &KeyValueProps{}
+}
+
+var kvPropKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (p *KeyValueProps) add(prop kvProp) *KeyValueProps {
+	if p.err != nil {
+		return p
+	}
+
+	if !kvPropKeyRegexp.MatchString(prop.key) {
+		p.err = fmt.Errorf("invalid property key '%v'", prop.key)
+		return p
+	}
+
+	p.props = append(p.props, prop)
+
+	return p
+}
+
+func (p *KeyValueProps) Set(key string, value string) *KeyValueProps {
+	return p.add(kvProp{key: key, value: value})
+}
+
+func (p *KeyValueProps) SetUint(key string, value uint64) *KeyValueProps {
+	return p.add(kvProp{key: key, value: utils.UintToStr(value)})
+}
+
+// SetFlag sets a property without a value, like `kvm` in `-accel kvm`.
+func (p *KeyValueProps) SetFlag(key string) *KeyValueProps {
+	return p.add(kvProp{key: key})
+}
+
+// Nest adds nested sub-properties under the key.
+func (p *KeyValueProps) Nest(key string, nested *KeyValueProps) *KeyValueProps {
+	if nested == nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("nil nested properties for key '%v'", key)
+		}
+
+		return p
+	}
+
+	return p.add(kvProp{key: key, nested: nested})
+}
+
+// Items flattens the properties into key-value arg items.
+func (p *KeyValueProps) Items() ([]KeyValueArgItem, error) {
+	return p.flatten("")
+}
+
+func (p *KeyValueProps) flatten(prefix string) ([]KeyValueArgItem, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	var ret []KeyValueArgItem
+
+	for _, prop := range p.props {
+		key := prefix + prop.key
+
+		if prop.nested != nil {
+			nestedItems, err := prop.nested.flatten(key + ".")
+			if err != nil {
+				return nil, errors.Wrapf(err, "flatten nested properties of '%v'", key)
+			}
+
+			if len(nestedItems) == 0 {
+				return nil, fmt.Errorf("empty nested properties for key '%v'", key)
+			}
+
+			ret = append(ret, nestedItems...)
+
+			continue
+		}
+
+		ret = append(ret, KeyValueArgItem{Key: key, Value: prop.value})
+	}
+
+	return ret, nil
+}
+
+func MustNewNestedKeyValueArg(key string, props *KeyValueProps) *KeyValueArg {
+	a, err := NewNestedKeyValueArg(key, props)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+func NewNestedKeyValueArg(key string, props *KeyValueProps) (*KeyValueArg, error) {
+	items, err := props.Items()
+	if err != nil {
+		return nil, errors.Wrap(err, "flatten properties")
+	}
+
+	return NewKeyValueArg(key, items)
+}
@@ -0,0 +1,102 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// OnOff is a QEMU boolean value, rendered as "on" or "off".
+type OnOff bool
+
+const (
+	On  OnOff = true
+	Off OnOff = false
+)
+
+func (v OnOff) String() string {
+	if v {
+		return "on"
+	}
+
+	return "off"
+}
+
+func ParseOnOff(s string) (OnOff, error) {
+	switch s {
+	case "on":
+		return On, nil
+	case "off":
+		return Off, nil
+	default:
+		return Off, fmt.Errorf("bad on/off value '%v'", s)
+	}
+}
+
+// NewOnOffItem creates a key-value arg item with an on/off value.
+func NewOnOffItem(key string, v OnOff) KeyValueArgItem {
+	return KeyValueArgItem{Key: key, Value: v.String()}
+}
+
+func (p *KeyValueProps) SetOnOff(key string, v OnOff) *KeyValueProps {
+	return p.Set(key, v.String())
+}
+
+// OnOffArg represents args that accept a single on/off value.
+type OnOffArg struct {
+	key   string
+	value OnOff
+}
+
+func MustNewOnOffArg(key string, value OnOff) *OnOffArg {
+	a, err := NewOnOffArg(key, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+func NewOnOffArg(key string, value OnOff) (*OnOffArg, error) {
+	a := &OnOffArg{
+		key:   key,
+		value: value,
+	}
+
+	// Preflight arg key/type check.
+	err := validateArgKey(a.key, a.ValueType())
+	if err != nil {
+		return nil, errors.Wrap(err, "validate arg key")
+	}
+
+	return a, nil
+}
+
+func (a *OnOffArg) StringKey() string {
+	return a.key
+}
+
+func (a *OnOffArg) StringValue() string {
+	return a.value.String()
+}
+
+func (a *OnOffArg) ValueType() ArgAcceptedValue {
+	// On/off values are plain strings as far as QEMU is concerned.
+	return ArgAcceptedValueString
+}
@@ -0,0 +1,195 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"fmt"
+)
+
+// The ID namespaces an arg item can reference, keyed by the arg key and the item key.
+var argRefs = map[string]map[string][]string{
+	"device": {
+		"drive":  {"drive", "blockdev"},
+		"netdev": {"netdev"},
+	},
+}
+
+// MissingReferenceError is returned when an arg references an ID that is not defined.
+type MissingReferenceError struct {
+	Arg    ConflictingArg
+	RefKey string
+	ID     string
+}
+
+func (e *MissingReferenceError) Error() string {
+	return fmt.Sprintf("arg '%v' references undefined %v id '%v'", e.Arg, e.RefKey, e.ID)
+}
+
+type argRef struct {
+	namespaces []string
+	refKey     string
+	id         string
+}
+
+func getArgRefs(arg Arg) []argRef {
+	kv, ok := arg.(*KeyValueArg)
+	if !ok {
+		return nil
+	}
+
+	keyRefs, ok := argRefs[kv.key]
+	if !ok {
+		return nil
+	}
+
+	var ret []argRef
+
+	for _, item := range kv.items {
+		if namespaces, ok := keyRefs[item.Key]; ok {
+			ret = append(ret, argRef{
+				namespaces: namespaces,
+				refKey:     item.Key,
+				id:         item.Value,
+			})
+		}
+	}
+
+	return ret
+}
+
+func getArgDefinedIDs(arg Arg) map[string]struct{} {
+	ids, err := getArgIDs(arg)
+	if err != nil {
+		// Malformed args are caught by the conflict validation.
+		return nil
+	}
+
+	ret := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		ret[arg.StringKey()+"/"+id] = struct{}{}
+	}
+
+	return ret
+}
+
+func validateReferences(args []Arg) error {
+	defined := make(map[string]struct{})
+	for _, arg := range args {
+		for nsID := range getArgDefinedIDs(arg) {
+			defined[nsID] = struct{}{}
+		}
+	}
+
+	for i, arg := range args {
+		for _, ref := range getArgRefs(arg) {
+			var found bool
+			for _, ns := range ref.namespaces {
+				if _, ok := defined[ns+"/"+ref.id]; ok {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return &MissingReferenceError{
+					Arg: ConflictingArg{
+						Index: i,
+						Key:   arg.StringKey(),
+						Value: arg.StringValue(),
+					},
+					RefKey: ref.refKey,
+					ID:     ref.id,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// orderByDependencies performs a stable topological sort of the items so that
+// the items defining IDs come before the items referencing them. The original
+// order is preserved as much as possible.
+func orderByDependencies[T any](items []T, getArgs func(T) []Arg) ([]T, error) {
+	n := len(items)
+
+	defs := make([]map[string]struct{}, n)
+	for i, item := range items {
+		defs[i] = make(map[string]struct{})
+		for _, arg := range getArgs(item) {
+			for nsID := range getArgDefinedIDs(arg) {
+				defs[i][nsID] = struct{}{}
+			}
+		}
+	}
+
+	// deps[i] is the set of items that must be emitted before item i.
+	deps := make([]map[int]struct{}, n)
+	for i, item := range items {
+		deps[i] = make(map[int]struct{})
+		for _, arg := range getArgs(item) {
+			for _, ref := range getArgRefs(arg) {
+				for j := range items {
+					if j == i {
+						continue
+					}
+
+					for _, ns := range ref.namespaces {
+						if _, ok := defs[j][ns+"/"+ref.id]; ok {
+							deps[i][j] = struct{}{}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	ret := make([]T, 0, n)
+	emitted := make([]bool, n)
+
+	for len(ret) < n {
+		progress := false
+
+		// Always emitting the first ready item keeps the sort stable.
+		for i := 0; i < n; i++ {
+			if emitted[i] {
+				continue
+			}
+
+			ready := true
+			for j := range deps[i] {
+				if !emitted[j] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				ret = append(ret, items[i])
+				emitted[i] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			return nil, fmt.Errorf("circular id references detected")
+		}
+	}
+
+	return ret, nil
+}
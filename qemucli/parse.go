@@ -0,0 +1,198 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseArgs parses the QEMU argv (without the executable) back into the
+// args, which is the inverse of EncodeArgs. The keys must be registered, and
+// the values are checked the same way as if the args were created directly.
+//
+// The value type of a key registered with multiple ones is picked by the
+// value: JSON if it looks like an object, then uint, string, and key-value,
+// whichever is registered and accepts it. A key registered as a flag takes
+// no value if it is the last one or followed by another key.
+func ParseArgs(argv []string) ([]Arg, error) {
+	var ret []Arg
+
+	for i := 0; i < len(argv); i++ {
+		key, ok := parseArgKey(argv[i])
+		if !ok {
+			return nil, fmt.Errorf("expected a key at #%v, have '%v'", i, argv[i])
+		}
+
+		types, ok := LookupKey(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown safe arg '%v' at #%v", key, i)
+		}
+
+		hasValue := i+1 < len(argv)
+		if hasValue && slices.Contains(types, ArgAcceptedValueNone) {
+			_, nextIsKey := parseArgKey(argv[i+1])
+			hasValue = !nextIsKey
+		}
+
+		if !hasValue {
+			if !slices.Contains(types, ArgAcceptedValueNone) {
+				return nil, fmt.Errorf("missing value for '-%v' at #%v", key, i)
+			}
+
+			arg, err := NewFlagArg(key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse arg #%v ('%v')", i, key)
+			}
+
+			ret = append(ret, arg)
+
+			continue
+		}
+
+		arg, err := parseArgValue(key, types, argv[i+1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse arg #%v ('%v')", i, key)
+		}
+
+		ret = append(ret, arg)
+		i++
+	}
+
+	return ret, nil
+}
+
+// ParseCommand parses the full QEMU argv, including the executable,
+// into a Command. The args are added as a single group.
+func ParseCommand(argv []string) (*Command, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty argv")
+	}
+
+	args, err := ParseArgs(argv[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCommand(argv[0])
+	c.Add(args...)
+
+	return c, nil
+}
+
+// parseArgKey returns the key of the "-key" argv entry. QEMU
+// accepts the "--key" form too.
+func parseArgKey(s string) (string, bool) {
+	if !strings.HasPrefix(s, "-") {
+		return "", false
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "-")
+	if !argKeyRegexp.MatchString(key) {
+		return "", false
+	}
+
+	return key, true
+}
+
+func parseArgValue(key string, types []ArgAcceptedValue, value string) (Arg, error) {
+	if slices.Contains(types, ArgAcceptedValueJSON) && strings.HasPrefix(value, "{") {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid json value")
+		}
+
+		return NewJSONArg(key, json.RawMessage(value))
+	}
+
+	if slices.Contains(types, ArgAcceptedValueUint) {
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err == nil {
+			return NewUintArg(key, v)
+		}
+	}
+
+	if slices.Contains(types, ArgAcceptedValueString) && validateArgStrValue(value) == nil {
+		return NewStringArg(key, value)
+	}
+
+	if slices.Contains(types, ArgAcceptedValueKeyValue) {
+		// The generated values never contain commas, so the
+		// QEMU ",," escape sequence is not supported.
+		if strings.Contains(value, ",,") {
+			return nil, fmt.Errorf("escaped commas are not supported")
+		}
+
+		items, err := parseKeyValueItems(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse key-value items")
+		}
+
+		return NewKeyValueArg(key, items)
+	}
+
+	return nil, fmt.Errorf("value '%v' is not accepted, want one of '%v'", value, types)
+}
+
+// ArgsDiff is the difference between two sets of args. The args are
+// compared by their encoded form, and the order is not taken into account.
+type ArgsDiff struct {
+	// Added are the args present in the new set only.
+	Added []Arg
+
+	// Removed are the args present in the old set only.
+	Removed []Arg
+}
+
+// Empty reports whether the sets are the same.
+func (d ArgsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// DiffArgs compares the args, like the generated ones against the parsed
+// user-supplied ones. The duplicates are counted, so an arg present twice
+// in the new set and once in the old one is reported as added once.
+func DiffArgs(oldArgs []Arg, newArgs []Arg) ArgsDiff {
+	oldCounts := make(map[serializedArg]int)
+	for _, sArg := range serializeArgs(oldArgs) {
+		oldCounts[sArg]++
+	}
+
+	var d ArgsDiff
+
+	for i, sArg := range serializeArgs(newArgs) {
+		if oldCounts[sArg] > 0 {
+			oldCounts[sArg]--
+			continue
+		}
+
+		d.Added = append(d.Added, newArgs[i])
+	}
+
+	for i, sArg := range serializeArgs(oldArgs) {
+		if oldCounts[sArg] > 0 {
+			oldCounts[sArg]--
+			d.Removed = append(d.Removed, oldArgs[i])
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,205 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func mustArgs(t *testing.T, d interface{ Args() ([]Arg, error) }) []Arg {
+	t.Helper()
+
+	args, err := d.Args()
+	if err != nil {
+		t.Fatalf("create args: %v", err)
+	}
+
+	return args
+}
+
+func assertArgsEqual(t *testing.T, want []Arg, have []Arg) {
+	t.Helper()
+
+	sWant, sHave := serializeArgs(want), serializeArgs(have)
+	if !slices.Equal(sWant, sHave) {
+		t.Errorf("args mismatch:\nwant %+v\nhave %+v", sWant, sHave)
+	}
+}
+
+func TestParseArgsRoundTrip(t *testing.T) {
+	bootIndex := 0
+
+	// Built the same way as the VM command.
+	c := NewCommand("qemu-system-x86_64")
+	c.Add(
+		MustNewStringArg("serial", "stdio"),
+		MustNewUintArg("m", 512),
+		MustNewUintArg("smp", 4),
+		MustNewKeyValueArg("accel", []KeyValueArgItem{{Key: "kvm"}}),
+		MustNewKeyValueArg("machine", []KeyValueArgItem{
+			{Key: "type", Value: "virt"},
+			NewOnOffItem("highmem", Off),
+		}),
+		MustNewStringArg("bios", "/usr/share/qemu/edk2-aarch64-code.fd"),
+		MustNewStringArg("display", "none"),
+	)
+	c.Add(mustArgs(t, UserNet{
+		ID:       "net0",
+		Restrict: true,
+		Forwards: []UserNetForward{{HostIP: net.IPv4(127, 0, 0, 1), HostPort: 2222, GuestPort: 22}},
+	})...)
+	c.Add(mustArgs(t, Drive{
+		ID:        "drive0",
+		File:      "/var/lib/linsk/alpine.qcow2",
+		Format:    ImgFormatQCOW2,
+		Snapshot:  true,
+		BootIndex: &bootIndex,
+	})...)
+	c.Add(mustArgs(t, Drive{
+		ID:        "drive1",
+		File:      "/dev/sdb",
+		Format:    ImgFormatRaw,
+		ReadOnly:  true,
+		Cache:     DriveCacheNone,
+		AIO:       DriveAIONative,
+		BlockSize: 4096,
+	})...)
+
+	args, err := c.OrderedArgs()
+	if err != nil {
+		t.Fatalf("order args: %v", err)
+	}
+
+	argv, err := EncodeArgs(args)
+	if err != nil {
+		t.Fatalf("encode args: %v", err)
+	}
+
+	parsed, err := ParseArgs(argv)
+	if err != nil {
+		t.Fatalf("parse args: %v", err)
+	}
+
+	assertArgsEqual(t, args, parsed)
+
+	reencoded, err := EncodeArgs(parsed)
+	if err != nil {
+		t.Fatalf("re-encode args: %v", err)
+	}
+
+	if !slices.Equal(argv, reencoded) {
+		t.Errorf("argv mismatch:\nwant %q\nhave %q", argv, reencoded)
+	}
+}
+
+func TestParseArgsFlagOrValue(t *testing.T) {
+	const key = "linsk-test-flag-or-value"
+
+	for _, typ := range []ArgAcceptedValue{ArgAcceptedValueNone, ArgAcceptedValueString} {
+		err := RegisterKey(key, typ)
+		if err != nil {
+			t.Fatalf("register key: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		argv []string
+		want []Arg
+	}{{
+		name: "value",
+		argv: []string{"-" + key, "value"},
+		want: []Arg{MustNewStringArg(key, "value")},
+	}, {
+		name: "flag followed by a key",
+		argv: []string{"-" + key, "-m", "512"},
+		want: []Arg{MustNewFlagArg(key), MustNewUintArg("m", 512)},
+	}, {
+		name: "flag last",
+		argv: []string{"-m", "512", "--" + key},
+		want: []Arg{MustNewUintArg("m", 512), MustNewFlagArg(key)},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			have, err := ParseArgs(tc.argv)
+			if err != nil {
+				t.Fatalf("parse args: %v", err)
+			}
+
+			assertArgsEqual(t, tc.want, have)
+		})
+	}
+}
+
+func TestParseArgsValueTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		argv []string
+		want []Arg
+	}{{
+		name: "json",
+		argv: []string{"-netdev", `{"id":"net0","type":"user"}`},
+		want: []Arg{MustNewJSONArg("netdev", map[string]any{"type": "user", "id": "net0"})},
+	}, {
+		name: "key-value where json is accepted",
+		argv: []string{"-netdev", "type=user,id=net0,restrict=on"},
+		want: []Arg{MustNewKeyValueArg("netdev", []KeyValueArgItem{
+			{Key: "type", Value: "user"},
+			{Key: "id", Value: "net0"},
+			NewOnOffItem("restrict", On),
+		})},
+	}, {
+		name: "key-value with a bare key",
+		argv: []string{"-accel", "tcg,thread=multi"},
+		want: []Arg{MustNewKeyValueArg("accel", []KeyValueArgItem{{Key: "tcg"}, {Key: "thread", Value: "multi"}})},
+	}, {
+		name: "uint",
+		argv: []string{"-smp", "8", "-m", "2048"},
+		want: []Arg{MustNewUintArg("smp", 8), MustNewUintArg("m", 2048)},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			have, err := ParseArgs(tc.argv)
+			if err != nil {
+				t.Fatalf("parse args: %v", err)
+			}
+
+			assertArgsEqual(t, tc.want, have)
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		argv []string
+	}{
+		{name: "unknown key", argv: []string{"-linsk-unknown", "x"}},
+		{name: "missing value", argv: []string{"-m"}},
+		{name: "value without key", argv: []string{"512"}},
+		{name: "bad uint", argv: []string{"-m", "lots"}},
+		{name: "invalid json", argv: []string{"-object", "{bad"}},
+		{name: "escaped comma", argv: []string{"-drive", "file=a,,b,if=none"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseArgs(tc.argv)
+			if err == nil {
+				t.Error("want an error, have nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+var safeArgsMu sync.RWMutex
+
+var safeArgs = map[string][]ArgAcceptedValue{
+	"accel":    {ArgAcceptedValueKeyValue},
+	"boot":     {ArgAcceptedValueString},
+	"m":        {ArgAcceptedValueUint},
+	"smp":      {ArgAcceptedValueUint},
+	"device":   {ArgAcceptedValueKeyValue},
+	"netdev":   {ArgAcceptedValueKeyValue, ArgAcceptedValueJSON},
+	"serial":   {ArgAcceptedValueString},
+	"cdrom":    {ArgAcceptedValueString},
+	"machine":  {ArgAcceptedValueKeyValue},
+	"cpu":      {ArgAcceptedValueString},
+	"display":  {ArgAcceptedValueString},
+	"drive":    {ArgAcceptedValueKeyValue},
+	"bios":     {ArgAcceptedValueString},
+	"blockdev": {ArgAcceptedValueJSON},
+	"object":   {ArgAcceptedValueJSON},
+}
+
+var safeArgsMinVersion = map[string]Version{
+	// The standalone -accel option with properties was introduced in QEMU 4.2.
+	"accel": {Major: 4, Minor: 2},
+}
+
+var argKeyRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// RegisterKey extends the list of QEMU arg keys that are permitted to be
+// encoded. This is meant for embedders and new backends that need
+// flags not known to this package. A key can accept multiple value
+// types, in which case RegisterKey is to be called for each of them.
+// Registering an already-known key/value type pair is a no-op.
+func RegisterKey(key string, t ArgAcceptedValue) error {
+	if !argKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid arg key '%v'", key)
+	}
+
+	if !isKnownArgAcceptedValue(t) {
+		return fmt.Errorf("unknown arg value type '%v'", t)
+	}
+
+	safeArgsMu.Lock()
+	defer safeArgsMu.Unlock()
+
+	if slices.Contains(safeArgs[key], t) {
+		return nil
+	}
+
+	safeArgs[key] = append(safeArgs[key], t)
+
+	return nil
+}
+
+func MustRegisterKey(key string, t ArgAcceptedValue) {
+	err := RegisterKey(key, t)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// LookupKey returns the value types registered for the key. The second
+// return value is false if the key is not registered.
+func LookupKey(key string) ([]ArgAcceptedValue, bool) {
+	safeArgsMu.RLock()
+	defer safeArgsMu.RUnlock()
+
+	t, ok := safeArgs[key]
+	return slices.Clone(t), ok
+}
+
+// RegisterKeyMinVersion sets the minimum QEMU version that supports
+// the key. The key must be registered beforehand.
+func RegisterKeyMinVersion(key string, v Version) error {
+	safeArgsMu.Lock()
+	defer safeArgsMu.Unlock()
+
+	if _, ok := safeArgs[key]; !ok {
+		return fmt.Errorf("unknown safe arg '%v'", key)
+	}
+
+	safeArgsMinVersion[key] = v
+
+	return nil
+}
+
+func lookupKeyMinVersion(key string) (Version, bool) {
+	safeArgsMu.RLock()
+	defer safeArgsMu.RUnlock()
+
+	v, ok := safeArgsMinVersion[key]
+	return v, ok
+}
+
+func isKnownArgAcceptedValue(t ArgAcceptedValue) bool {
+	switch t {
+	case ArgAcceptedValueUint, ArgAcceptedValueString, ArgAcceptedValueKeyValue, ArgAcceptedValueJSON, ArgAcceptedValueNone:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/alessio/shellescape"
+)
+
+// RenderCommandLine joins the argv into a single command line string that
+// can be pasted into the host's shell. Values containing spaces or other
+// special characters are quoted using the platform-appropriate rules.
+func RenderCommandLine(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = QuoteArg(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// QuoteArg quotes a single argument for the host's shell.
+func QuoteArg(s string) string {
+	if osspecifics.IsWindows() {
+		return quoteWindowsArg(s)
+	}
+
+	return shellescape.Quote(s)
+}
+
+// quoteWindowsArg follows the rules CommandLineToArgvW uses to split
+// the command line, which is what QEMU on Windows relies on.
+func quoteWindowsArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+
+	slashes := 0
+	for _, c := range []byte(s) {
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes preceding a quote need to be escaped,
+			// and so does the quote itself.
+			sb.WriteString(strings.Repeat("\\", slashes*2+1))
+			slashes = 0
+		default:
+			sb.WriteString(strings.Repeat("\\", slashes))
+			slashes = 0
+		}
+
+		if c != '\\' {
+			sb.WriteByte(c)
+		}
+	}
+
+	// Backslashes right before the closing quote need to be escaped too.
+	sb.WriteString(strings.Repeat("\\", slashes*2))
+	sb.WriteByte('"')
+
+	return sb.String()
+}
@@ -0,0 +1,163 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type serializedArg struct {
+	Key   string           `json:"key"`
+	Type  ArgAcceptedValue `json:"type"`
+	Value string           `json:"value,omitempty"`
+}
+
+type serializedCommand struct {
+	BaseCmd string          `json:"base_cmd"`
+	Args    []serializedArg `json:"args"`
+}
+
+func serializeArgs(args []Arg) []serializedArg {
+	ret := make([]serializedArg, len(args))
+	for i, arg := range args {
+		ret[i] = serializedArg{
+			Key:   arg.StringKey(),
+			Type:  arg.ValueType(),
+			Value: arg.StringValue(),
+		}
+	}
+
+	return ret
+}
+
+// MarshalArgs serializes the args into JSON.
+func MarshalArgs(args []Arg) ([]byte, error) {
+	return json.Marshal(serializeArgs(args))
+}
+
+// UnmarshalArgs deserializes the args from JSON. All args are
+// validated the same way as if they were created directly.
+func UnmarshalArgs(data []byte) ([]Arg, error) {
+	var sArgs []serializedArg
+
+	err := json.Unmarshal(data, &sArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal json")
+	}
+
+	return deserializeArgs(sArgs)
+}
+
+func deserializeArgs(sArgs []serializedArg) ([]Arg, error) {
+	ret := make([]Arg, len(sArgs))
+
+	for i, sArg := range sArgs {
+		arg, err := deserializeArg(sArg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "deserialize arg #%v ('%v')", i, sArg.Key)
+		}
+
+		ret[i] = arg
+	}
+
+	return ret, nil
+}
+
+func deserializeArg(sArg serializedArg) (Arg, error) {
+	switch sArg.Type {
+	case ArgAcceptedValueNone:
+		if sArg.Value != "" {
+			return nil, fmt.Errorf("value is not accepted for flag args")
+		}
+
+		return NewFlagArg(sArg.Key)
+	case ArgAcceptedValueUint:
+		v, err := strconv.ParseUint(sArg.Value, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse uint value")
+		}
+
+		return NewUintArg(sArg.Key, v)
+	case ArgAcceptedValueString:
+		return NewStringArg(sArg.Key, sArg.Value)
+	case ArgAcceptedValueKeyValue:
+		items, err := parseKeyValueItems(sArg.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse key-value items")
+		}
+
+		return NewKeyValueArg(sArg.Key, items)
+	case ArgAcceptedValueJSON:
+		if !json.Valid([]byte(sArg.Value)) {
+			return nil, fmt.Errorf("invalid json value")
+		}
+
+		return NewJSONArg(sArg.Key, json.RawMessage(sArg.Value))
+	default:
+		return nil, fmt.Errorf("unknown arg value type '%v'", sArg.Type)
+	}
+}
+
+func parseKeyValueItems(s string) ([]KeyValueArgItem, error) {
+	var items []KeyValueArgItem
+
+	for _, rawItem := range strings.Split(s, ",") {
+		if rawItem == "" {
+			return nil, fmt.Errorf("empty item")
+		}
+
+		key, value, _ := strings.Cut(rawItem, "=")
+		items = append(items, KeyValueArgItem{
+			Key:   key,
+			Value: value,
+		})
+	}
+
+	return items, nil
+}
+
+func (c *Command) MarshalJSON() ([]byte, error) {
+	return json.Marshal(serializedCommand{
+		BaseCmd: c.baseCmd,
+		Args:    serializeArgs(c.Args()),
+	})
+}
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var sCmd serializedCommand
+
+	err := json.Unmarshal(data, &sCmd)
+	if err != nil {
+		return errors.Wrap(err, "unmarshal json")
+	}
+
+	args, err := deserializeArgs(sCmd.Args)
+	if err != nil {
+		return errors.Wrap(err, "deserialize args")
+	}
+
+	c.baseCmd = sCmd.BaseCmd
+	c.groups = nil
+	c.Add(args...)
+
+	return nil
+}
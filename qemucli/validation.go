@@ -18,17 +18,19 @@ package qemucli
 
 import (
 	"fmt"
+	"slices"
 	"strings"
+	"unicode"
 )
 
 func validateArgKey(key string, t ArgAcceptedValue) error {
-	allowedValue, ok := safeArgs[key]
+	allowedValues, ok := LookupKey(key)
 	if !ok {
 		return fmt.Errorf("unknown safe arg '%v'", key)
 	}
 
-	if want, have := allowedValue, t; want != have {
-		return fmt.Errorf("bad arg value type: want '%v', have '%v'", allowedValue, t)
+	if !slices.Contains(allowedValues, t) {
+		return fmt.Errorf("bad arg value type: want one of '%v', have '%v'", allowedValues, t)
 	}
 
 	return nil
@@ -49,5 +51,11 @@ func validateArgStrValue(s string) error {
 		return fmt.Errorf("equals sign is not allowed")
 	}
 
+	// Spaces are fine (e.g. "C:/Program Files/..."), but control characters
+	// like newlines cannot be reliably passed through on every platform.
+	if strings.ContainsFunc(s, unicode.IsControl) {
+		return fmt.Errorf("control characters are not allowed")
+	}
+
 	return nil
 }
@@ -0,0 +1,179 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+type Version struct {
+	Major uint64
+	Minor uint64
+	Micro uint64
+}
+
+func (v Version) String() string {
+	return utils.UintToStr(v.Major) + "." + utils.UintToStr(v.Minor) + "." + utils.UintToStr(v.Micro)
+}
+
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Micro < other.Micro
+}
+
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+var versionOutputRegexp = regexp.MustCompile(`QEMU emulator version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+func ParseVersionOutput(out string) (Version, error) {
+	match := versionOutputRegexp.FindStringSubmatch(out)
+	if match == nil {
+		return Version{}, fmt.Errorf("no version string found in output '%v'", utils.ClearUnprintableChars(out, false))
+	}
+
+	var v Version
+	var err error
+
+	v.Major, err = strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return Version{}, errors.Wrap(err, "parse major version")
+	}
+
+	v.Minor, err = strconv.ParseUint(match[2], 10, 64)
+	if err != nil {
+		return Version{}, errors.Wrap(err, "parse minor version")
+	}
+
+	if match[3] != "" {
+		v.Micro, err = strconv.ParseUint(match[3], 10, 64)
+		if err != nil {
+			return Version{}, errors.Wrap(err, "parse micro version")
+		}
+	}
+
+	return v, nil
+}
+
+// ProbeVersion runs the QEMU binary with the -version flag and parses the output.
+func ProbeVersion(ctx context.Context, baseCmd string) (Version, error) {
+	out, err := exec.CommandContext(ctx, baseCmd, "-version").Output() //#nosec G204 // The base command is never user-supplied.
+	if err != nil {
+		return Version{}, errors.Wrap(err, "run qemu version cmd")
+	}
+
+	v, err := ParseVersionOutput(string(out))
+	if err != nil {
+		return Version{}, errors.Wrap(err, "parse version output")
+	}
+
+	return v, nil
+}
+
+// AdaptArgsForVersion checks the args against the minimum QEMU versions
+// registered for their keys. Args that are not supported by the given
+// version are transparently downgraded if there is a downgrade rule
+// for the key. Otherwise, an error is returned.
+func AdaptArgsForVersion(args []Arg, v Version) ([]Arg, error) {
+	var ret []Arg
+
+	for i, arg := range args {
+		key := arg.StringKey()
+
+		minVersion, ok := lookupKeyMinVersion(key)
+		if !ok || !v.Less(minVersion) {
+			ret = append(ret, arg)
+			continue
+		}
+
+		downgrade, ok := downgradeRules[key]
+		if !ok {
+			return nil, fmt.Errorf("arg #%v '-%v' requires qemu version %v or newer (have %v)", i, key, minVersion, v)
+		}
+
+		downgraded, err := downgrade(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "downgrade arg #%v '-%v' for qemu version %v", i, key, v)
+		}
+
+		ret = append(ret, downgraded...)
+	}
+
+	return ret, nil
+}
+
+type downgradeFunc func(Arg) ([]Arg, error)
+
+// machineAccelProps maps the -accel properties to how they are
+// handled when the accelerator is downgraded to a machine property.
+// Only the listed properties are known to be valid on the machine,
+// the ones mapped to an empty string are dropped.
+var machineAccelProps = map[string]string{
+	"kernel-irqchip": "kernel-irqchip",
+	// Multi-threaded TCG is the default wherever it is supported,
+	// and older QEMU versions reject "thread" as a machine property.
+	"thread": "",
+}
+
+var downgradeRules = map[string]downgradeFunc{
+	// Older QEMU versions accept the accelerator only as a machine
+	// property. Multiple -machine args are merged by QEMU, so this does
+	// not conflict with the machine type specification.
+	"accel": func(a Arg) ([]Arg, error) {
+		kv, ok := a.(*KeyValueArg)
+		if !ok || len(kv.items) == 0 {
+			return nil, fmt.Errorf("unexpected accel arg value")
+		}
+
+		items := []KeyValueArgItem{{Key: "accel", Value: kv.items[0].Key}}
+
+		for _, item := range kv.items[1:] {
+			machineKey, ok := machineAccelProps[item.Key]
+			if !ok {
+				return nil, fmt.Errorf("accel property '%v' has no machine property equivalent", item.Key)
+			}
+
+			if machineKey == "" {
+				continue
+			}
+
+			items = append(items, KeyValueArgItem{Key: machineKey, Value: item.Value})
+		}
+
+		machineArg, err := NewKeyValueArg("machine", items)
+		if err != nil {
+			return nil, errors.Wrap(err, "create machine key-value arg")
+		}
+
+		return []Arg{machineArg}, nil
+	},
+}
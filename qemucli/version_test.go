@@ -0,0 +1,79 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package qemucli
+
+import (
+	"testing"
+)
+
+func TestAdaptArgsForVersionAccelDowngrade(t *testing.T) {
+	oldVersion := Version{Major: 4, Minor: 1}
+
+	cases := []struct {
+		name  string
+		accel []KeyValueArgItem
+		want  []Arg
+	}{
+		{
+			name: "tcg",
+			// Built the same way as the VM command.
+			accel: []KeyValueArgItem{{Key: "tcg"}, {Key: "thread", Value: "multi"}},
+			want: []Arg{MustNewKeyValueArg("machine", []KeyValueArgItem{
+				{Key: "accel", Value: "tcg"},
+			})},
+		},
+		{
+			name:  "whpx",
+			accel: []KeyValueArgItem{{Key: "whpx"}, NewOnOffItem("kernel-irqchip", Off)},
+			want: []Arg{MustNewKeyValueArg("machine", []KeyValueArgItem{
+				{Key: "accel", Value: "whpx"},
+				NewOnOffItem("kernel-irqchip", Off),
+			})},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := AdaptArgsForVersion([]Arg{MustNewKeyValueArg("accel", c.accel)}, oldVersion)
+			if err != nil {
+				t.Fatalf("adapt args: %v", err)
+			}
+
+			assertArgsEqual(t, c.want, args)
+		})
+	}
+}
+
+func TestAdaptArgsForVersionAccelUnchanged(t *testing.T) {
+	args := []Arg{MustNewKeyValueArg("accel", []KeyValueArgItem{{Key: "tcg"}, {Key: "thread", Value: "multi"}})}
+
+	adapted, err := AdaptArgsForVersion(args, Version{Major: 4, Minor: 2})
+	if err != nil {
+		t.Fatalf("adapt args: %v", err)
+	}
+
+	assertArgsEqual(t, args, adapted)
+}
+
+func TestAdaptArgsForVersionAccelUnknownProp(t *testing.T) {
+	args := []Arg{MustNewKeyValueArg("accel", []KeyValueArgItem{{Key: "kvm"}, {Key: "dirty-ring-size", Value: "4096"}})}
+
+	_, err := AdaptArgsForVersion(args, Version{Major: 4, Minor: 1})
+	if err == nil {
+		t.Fatal("expected an error for a property with no machine equivalent")
+	}
+}
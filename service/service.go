@@ -0,0 +1,60 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package service registers the Linsk daemon with the OS service manager,
+// so that it is started on boot (or login) and restarted if it crashes.
+// The daemon is registered as a Windows service on Windows, and as a
+// launchd agent (or a launchd daemon if installed as root) on macOS.
+package service
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	// Name is the Windows service name.
+	Name = "linsk"
+
+	// DisplayName and Description are shown in the Windows service list.
+	DisplayName = "Linsk daemon"
+	Description = "Serves the Linsk REST and gRPC APIs for managing the file share sessions."
+
+	// Label is the launchd job label.
+	Label = "com.github.alexssd7.linsk.daemon"
+)
+
+var (
+	// ErrAlreadyInstalled is returned by Install when the service is already
+	// registered. It must be uninstalled first to change the configuration.
+	ErrAlreadyInstalled = errors.New("service already installed")
+
+	// ErrNotInstalled is returned by Uninstall when the service is not registered.
+	ErrNotInstalled = errors.New("service not installed")
+)
+
+// Config describes the daemon command run by the service manager.
+type Config struct {
+	// Executable is the absolute path of the Linsk executable.
+	Executable string
+
+	// Args are the command-line arguments, starting with "daemon".
+	Args []string
+
+	// StderrPath receives the output the daemon does not log, like the
+	// panics. The service manager does not rotate it. Only used by launchd,
+	// as the output of the Windows services is discarded.
+	StderrPath string
+}
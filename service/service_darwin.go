@@ -0,0 +1,167 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build darwin
+
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+// Install registers the daemon as a launchd agent of the current user, or as
+// a system-wide launchd daemon if run as root, and starts it. The job is
+// started at login (or boot) and restarted if it exits with an error.
+func Install(cfg Config) error {
+	plistPath, domain, err := getLaunchdJobLocation()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(plistPath)
+	if err == nil {
+		return errors.Wrapf(ErrAlreadyInstalled, "check plist '%v'", plistPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "stat plist")
+	}
+
+	err = os.MkdirAll(filepath.Dir(plistPath), 0755)
+	if err != nil {
+		return errors.Wrap(err, "create plist dir")
+	}
+
+	err = os.WriteFile(plistPath, buildLaunchdPlist(cfg), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write plist")
+	}
+
+	err = runLaunchctl("bootstrap", domain, plistPath)
+	if err != nil {
+		_ = os.Remove(plistPath)
+		return errors.Wrap(err, "load launchd job")
+	}
+
+	return nil
+}
+
+// Uninstall stops the launchd job and removes its plist.
+func Uninstall() error {
+	plistPath, domain, err := getLaunchdJobLocation()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(plistPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(ErrNotInstalled, "check plist '%v'", plistPath)
+		}
+
+		return errors.Wrap(err, "stat plist")
+	}
+
+	// The job may be already unloaded, like after a failed bootstrap.
+	err = runLaunchctl("bootout", domain+"/"+Label)
+	if err != nil {
+		slog.Warn("Failed to unload launchd job", "error", err.Error())
+	}
+
+	err = os.Remove(plistPath)
+	if err != nil {
+		return errors.Wrap(err, "remove plist")
+	}
+
+	return nil
+}
+
+// getLaunchdJobLocation returns the plist path and the launchd domain of the job.
+func getLaunchdJobLocation() (string, string, error) {
+	isRoot, err := osspecifics.CheckRunAsRoot()
+	if err != nil {
+		return "", "", errors.Wrap(err, "check whether the program is run as root")
+	}
+
+	if isRoot {
+		return filepath.Join("/Library/LaunchDaemons", Label+".plist"), "system", nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", errors.Wrap(err, "get user home dir")
+	}
+
+	return filepath.Join(home, "Library", "LaunchAgents", Label+".plist"), fmt.Sprintf("gui/%v", os.Getuid()), nil
+}
+
+func runLaunchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return utils.WrapErrWithLog(err, "run launchctl "+args[0], strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// buildLaunchdPlist returns the job definition. KeepAlive restarts the
+// daemon only if it exits with an error, so that stopping it with
+// "launchctl kill" is possible.
+func buildLaunchdPlist(cfg Config) []byte {
+	var b bytes.Buffer
+
+	writeString := func(key string, value string) {
+		b.WriteString("\t<key>" + key + "</key>\n\t<string>")
+		_ = xml.EscapeText(&b, []byte(value))
+		b.WriteString("</string>\n")
+	}
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+
+	writeString("Label", Label)
+
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{cfg.Executable}, cfg.Args...) {
+		b.WriteString("\t\t<string>")
+		_ = xml.EscapeText(&b, []byte(arg))
+		b.WriteString("</string>\n")
+	}
+	b.WriteString("\t</array>\n")
+
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+
+	writeString("ProcessType", "Background")
+
+	if cfg.StderrPath != "" {
+		writeString("StandardErrorPath", cfg.StderrPath)
+	}
+
+	b.WriteString("</dict>\n</plist>\n")
+
+	return b.Bytes()
+}
@@ -0,0 +1,187 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Install registers the daemon as a Windows service starting automatically
+// on boot, and starts it. The service is restarted if it crashes. This
+// requires admin privileges. The service runs as LocalSystem, so the data
+// directory must be passed to the daemon explicitly.
+func Install(cfg Config) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "connect to service manager")
+	}
+
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.OpenService(Name)
+	if err == nil {
+		_ = s.Close()
+		return errors.Wrapf(ErrAlreadyInstalled, "open service '%v'", Name)
+	}
+
+	s, err = m.CreateService(Name, cfg.Executable, mgr.Config{
+		DisplayName:      DisplayName,
+		Description:      Description,
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, cfg.Args...)
+	if err != nil {
+		return errors.Wrap(err, "create service")
+	}
+
+	defer func() { _ = s.Close() }()
+
+	// Restarting the crashed service with a backoff. The failure
+	// count is reset after a day without failures.
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Second * 5},
+		{Type: mgr.ServiceRestart, Delay: time.Second * 30},
+		{Type: mgr.ServiceRestart, Delay: time.Minute * 5},
+	}, uint32((time.Hour * 24).Seconds()))
+	if err != nil {
+		_ = s.Delete()
+		return errors.Wrap(err, "set recovery actions")
+	}
+
+	err = s.Start()
+	if err != nil {
+		_ = s.Delete()
+		return errors.Wrap(err, "start service")
+	}
+
+	return nil
+}
+
+// Uninstall stops the service and removes it. This requires admin privileges.
+func Uninstall() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return errors.Wrap(err, "connect to service manager")
+	}
+
+	defer func() { _ = m.Disconnect() }()
+
+	s, err := m.OpenService(Name)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return errors.Wrapf(ErrNotInstalled, "open service '%v'", Name)
+		}
+
+		return errors.Wrap(err, "open service")
+	}
+
+	defer func() { _ = s.Close() }()
+
+	// The service is removed once it stops.
+	_, err = s.Control(svc.Stop)
+	if err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		slog.Warn("Failed to stop the service", "error", err.Error())
+	}
+
+	err = s.Delete()
+	if err != nil {
+		return errors.Wrap(err, "delete service")
+	}
+
+	return nil
+}
+
+// Notify reports the daemon status to the Windows service manager if the
+// process is run as a service. The returned context is canceled once the
+// service is asked to stop, and done must be called after the daemon has
+// shut down. It is a no-op if the process is not run as a service.
+func Notify(ctx context.Context) (context.Context, func(), error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "check whether run as windows service")
+	}
+
+	if !isService {
+		return ctx, func() {}, nil
+	}
+
+	ctx, ctxCancel := context.WithCancel(ctx)
+
+	h := &serviceHandler{
+		stop:     ctxCancel,
+		doneCh:   make(chan struct{}),
+		exitedCh: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(h.exitedCh)
+
+		err := svc.Run(Name, h)
+		if err != nil {
+			slog.Error("Failed to run the Windows service handler", "error", err.Error())
+			ctxCancel()
+		}
+	}()
+
+	var doneOnce sync.Once
+
+	return ctx, func() {
+		doneOnce.Do(func() {
+			ctxCancel()
+			close(h.doneCh)
+			<-h.exitedCh
+		})
+	}, nil
+}
+
+type serviceHandler struct {
+	stop     context.CancelFunc
+	doneCh   chan struct{}
+	exitedCh chan struct{}
+}
+
+func (h *serviceHandler) Execute(_ []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case req := <-reqs:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.stop()
+				<-h.doneCh
+
+				return false, 0
+			}
+		case <-h.doneCh:
+			return false, 0
+		}
+	}
+}
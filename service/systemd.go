@@ -0,0 +1,250 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SystemdUnitConfig configures the systemd units generated by GenerateSystemdUnits.
+type SystemdUnitConfig struct {
+	// Description is the unit description.
+	Description string
+
+	// Executable and Args is the command to run. The executable
+	// path must be absolute, as systemd does not search PATH.
+	Executable string
+	Args       []string
+
+	// Schedule is the systemd calendar event expression (like "daily" or
+	// "Mon *-*-* 03:00:00") to run the command on. The command is run as a
+	// long-running service restarted on failure if it is empty, and as a
+	// oneshot service started by a timer otherwise.
+	Schedule string
+
+	// User is the user to run the command as. The command is run as root if
+	// it is empty, which the block device passthrough needs unless the user
+	// has access to the devices.
+	User string
+
+	// DataDir is the Linsk data directory. It is the only directory writable
+	// by the command, besides ReadWritePaths and the private /tmp.
+	DataDir        string
+	ReadWritePaths []string
+
+	// Devices are the block devices (like "/dev/sdb", or the stable
+	// "/dev/disk/by-id/..." links) the command can access. Any SCSI, SATA,
+	// USB and NVMe disk is accessible if it is empty.
+	Devices []string
+
+	// DevicesReadOnly allows only reading the devices.
+	DevicesReadOnly bool
+}
+
+// The device groups (see /proc/devices) of the disks accessible by default.
+var defaultSystemdDeviceGroups = []string{"block-sd", "block-nvme", "block-blkext"}
+
+// GenerateSystemdUnits returns the contents of the service unit and, if the
+// schedule is set, of the timer unit starting it. The service is sandboxed,
+// with the file system read-only besides the data directory, and no devices
+// accessible besides KVM and the passed-through block devices. The memory
+// is not made non-executable, as the QEMU software emulation needs it.
+func GenerateSystemdUnits(cfg SystemdUnitConfig) ([]byte, []byte, error) {
+	if !filepath.IsAbs(cfg.Executable) {
+		return nil, nil, fmt.Errorf("executable path '%v' is not absolute", cfg.Executable)
+	}
+
+	paths := []string{cfg.DataDir}
+	paths = append(paths, cfg.ReadWritePaths...)
+	paths = append(paths, cfg.Devices...)
+
+	// The directives taking the paths are space-separated lists.
+	for _, p := range paths {
+		if !filepath.IsAbs(p) || strings.ContainsAny(p, " \t\n\r") {
+			return nil, nil, fmt.Errorf("path '%v' is not absolute or contains whitespace", p)
+		}
+	}
+
+	for _, v := range []string{cfg.Description, cfg.Schedule, cfg.User} {
+		if strings.ContainsAny(v, "\n\r") {
+			return nil, nil, fmt.Errorf("value '%v' contains a line break", v)
+		}
+	}
+
+	var svc bytes.Buffer
+
+	writeSystemdUnitSection(&svc, "Unit", [][2]string{
+		{"Description", cfg.Description},
+		{"Documentation", "https://github.com/AlexSSD7/linsk"},
+		{"After", "network.target"},
+	})
+
+	serviceDirectives := [][2]string{}
+
+	if cfg.Schedule == "" {
+		serviceDirectives = append(serviceDirectives,
+			[2]string{"Type", "simple"},
+			[2]string{"Restart", "on-failure"},
+			[2]string{"RestartSec", "5s"},
+		)
+	} else {
+		serviceDirectives = append(serviceDirectives,
+			[2]string{"Type", "oneshot"},
+			// Copying the whole disks can take hours.
+			[2]string{"TimeoutStartSec", "infinity"},
+		)
+	}
+
+	execStart, err := quoteSystemdCommandLine(append([]string{cfg.Executable}, cfg.Args...))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "quote command line")
+	}
+
+	serviceDirectives = append(serviceDirectives,
+		[2]string{"ExecStart", execStart},
+		// Lets the VMs shut down gracefully.
+		[2]string{"TimeoutStopSec", "90s"},
+	)
+
+	if cfg.User != "" {
+		serviceDirectives = append(serviceDirectives,
+			[2]string{"User", cfg.User},
+			[2]string{"SupplementaryGroups", "disk kvm"},
+		)
+	}
+
+	serviceDirectives = append(serviceDirectives,
+		[2]string{"UMask", "0077"},
+		[2]string{"NoNewPrivileges", "yes"},
+		[2]string{"ProtectSystem", "strict"},
+		[2]string{"ProtectHome", "read-only"},
+		[2]string{"PrivateTmp", "yes"},
+		[2]string{"ReadWritePaths", cfg.DataDir},
+	)
+
+	for _, p := range cfg.ReadWritePaths {
+		serviceDirectives = append(serviceDirectives, [2]string{"ReadWritePaths", p})
+	}
+
+	devMode := "rw"
+	if cfg.DevicesReadOnly {
+		devMode = "r"
+	}
+
+	serviceDirectives = append(serviceDirectives,
+		[2]string{"DevicePolicy", "closed"},
+		[2]string{"DeviceAllow", "/dev/kvm rw"},
+	)
+
+	devices := cfg.Devices
+	if len(devices) == 0 {
+		devices = defaultSystemdDeviceGroups
+	}
+
+	for _, dev := range devices {
+		serviceDirectives = append(serviceDirectives, [2]string{"DeviceAllow", dev + " " + devMode})
+	}
+
+	serviceDirectives = append(serviceDirectives,
+		[2]string{"ProtectKernelTunables", "yes"},
+		[2]string{"ProtectKernelModules", "yes"},
+		[2]string{"ProtectKernelLogs", "yes"},
+		[2]string{"ProtectControlGroups", "yes"},
+		[2]string{"ProtectClock", "yes"},
+		[2]string{"ProtectHostname", "yes"},
+		[2]string{"RestrictNamespaces", "yes"},
+		[2]string{"RestrictRealtime", "yes"},
+		[2]string{"RestrictSUIDSGID", "yes"},
+		[2]string{"RestrictAddressFamilies", "AF_UNIX AF_INET AF_INET6 AF_NETLINK"},
+		[2]string{"LockPersonality", "yes"},
+		[2]string{"SystemCallArchitectures", "native"},
+	)
+
+	writeSystemdUnitSection(&svc, "Service", serviceDirectives)
+
+	if cfg.Schedule == "" {
+		writeSystemdUnitSection(&svc, "Install", [][2]string{
+			{"WantedBy", "multi-user.target"},
+		})
+
+		return svc.Bytes(), nil, nil
+	}
+
+	var timer bytes.Buffer
+
+	writeSystemdUnitSection(&timer, "Unit", [][2]string{
+		{"Description", cfg.Description + " (schedule)"},
+		{"Documentation", "https://github.com/AlexSSD7/linsk"},
+	})
+
+	writeSystemdUnitSection(&timer, "Timer", [][2]string{
+		{"OnCalendar", cfg.Schedule},
+		// Runs the missed jobs once the host is up again.
+		{"Persistent", "true"},
+	})
+
+	writeSystemdUnitSection(&timer, "Install", [][2]string{
+		{"WantedBy", "timers.target"},
+	})
+
+	return svc.Bytes(), timer.Bytes(), nil
+}
+
+func writeSystemdUnitSection(b *bytes.Buffer, name string, directives [][2]string) {
+	if b.Len() != 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("[" + name + "]\n")
+
+	for _, d := range directives {
+		b.WriteString(d[0] + "=" + d[1] + "\n")
+	}
+}
+
+// quoteSystemdCommandLine quotes the arguments for ExecStart. The specifiers
+// ("%") and the variables ("$") are escaped, so that the arguments are passed
+// as-is.
+func quoteSystemdCommandLine(args []string) (string, error) {
+	quoted := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\n\r") {
+			return "", fmt.Errorf("argument '%v' contains a line break", arg)
+		}
+
+		arg = strings.ReplaceAll(arg, "%", "%%")
+		arg = strings.ReplaceAll(arg, "$", "$$")
+
+		if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+			quoted = append(quoted, arg)
+			continue
+		}
+
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+		arg = strings.ReplaceAll(arg, `"`, `\"`)
+
+		quoted = append(quoted, `"`+arg+`"`)
+	}
+
+	return strings.Join(quoted, " "), nil
+}
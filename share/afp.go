@@ -17,6 +17,7 @@
 package share
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -36,22 +37,37 @@ func NewAFPBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
 	}
 
 	return &AFPBackend{
-			listenIP:  uc.listenIP,
-			sharePort: sharePort,
-		}, &VMShareOptions{
-			Ports: []vm.PortForwardingRule{{
-				HostIP:   uc.listenIP,
-				HostPort: sharePort,
-				VMPort:   548,
-			}},
-		}, nil
+		listenIP:  uc.listenIP,
+		sharePort: sharePort,
+	}, &VMShareOptions{
+		Ports: []vm.PortForwardingRule{{
+			HostIP:   uc.listenIP,
+			HostPort: sharePort,
+			VMPort:   548,
+		}},
+	}, nil
 }
 
-func (b *AFPBackend) Apply(sharePWD string, vc *VMShareContext) (string, error) {
-	err := vc.FileManager.StartAFP(sharePWD)
+func (b *AFPBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
+	err := vc.FileManager.StartAFP(ctx, sharePWD)
 	if err != nil {
 		return "", errors.Wrap(err, "start afp server")
 	}
 
 	return "afp://" + net.JoinHostPort(b.listenIP.String(), fmt.Sprint(b.sharePort)) + "/linsk", nil
 }
+
+// CredentialScope implements CredentialScopedBackend.
+func (b *AFPBackend) CredentialScope() string {
+	return unixCredentialScope
+}
+
+// GuestServices implements HealthCheckedBackend.
+func (b *AFPBackend) GuestServices() []string {
+	return []string{"netatalk"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *AFPBackend) HealthCheckAddr() string {
+	return hostProbeAddr(b.listenIP, b.sharePort)
+}
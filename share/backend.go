@@ -16,19 +16,62 @@
 
 package share
 
+import (
+	"context"
+	"sort"
+)
+
 type NewBackendFunc func(uc *UserConfiguration) (Backend, *VMShareOptions, error)
 
 type Backend interface {
-	Apply(sharePWD string, vc *VMShareContext) (string, error)
+	Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error)
+}
+
+// PasswordlessBackend is an optional interface for backends
+// that do not authenticate the clients with the share password.
+type PasswordlessBackend interface {
+	UsesPassword() bool
+}
+
+// CloserBackend is an optional interface for backends that hold host-side
+// resources which need to be released once the share is no longer used.
+type CloserBackend interface {
+	Close() error
+}
+
+// HealthCheckedBackend is an optional interface for backends served by guest
+// services which the Supervisor can health-check and restart.
+type HealthCheckedBackend interface {
+	// GuestServices returns the names of the guest OpenRC services serving the share.
+	GuestServices() []string
+
+	// HealthCheckAddr returns the TCP address the share can be reached at from
+	// the host. It is called after Apply. An empty string disables the probe.
+	HealthCheckAddr() string
 }
 
 var backends = map[string]NewBackendFunc{
-	"ftp": NewFTPBackend,
-	"smb": NewSMBBackend,
-	"afp": NewAFPBackend,
+	"ftp":    NewFTPBackend,
+	"smb":    NewSMBBackend,
+	"sshfs":  NewSSHFSBackend,
+	"afp":    NewAFPBackend,
+	"nfs":    NewNFSBackend,
+	"sftp":   NewSFTPBackend,
+	"webdav": NewWebDAVBackend,
 }
 
 // Will return nil if no backend is found.
 func GetBackend(id string) NewBackendFunc {
 	return backends[id]
 }
+
+func ListBackendIDs() []string {
+	ids := make([]string, 0, len(backends))
+	for id := range backends {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
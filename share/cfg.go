@@ -19,6 +19,15 @@ package share
 import (
 	"fmt"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
 
 	"log/slog"
 )
@@ -28,6 +37,20 @@ type UserConfiguration struct {
 	ftpExtIP net.IP
 
 	smbExtMode bool
+	webDAVTLS  bool
+	ftpTLS     bool
+
+	// Optional. A self-signed certificate is generated if not set.
+	tlsCertPEM []byte
+	tlsKeyPEM  []byte
+
+	hostMountPoint string
+
+	// Optional. The defaults are used if not set.
+	shareUser     string
+	sharePassword string
+
+	shareProfile vm.ShareProfile
 }
 
 type RawUserConfiguration struct {
@@ -36,9 +59,29 @@ type RawUserConfiguration struct {
 	// Backend-specific
 	FTPExtIP   string
 	SMBExtMode bool
+	WebDAVTLS  bool
+	FTPTLS     bool
+
+	// NFSAllowRemote allows the unauthenticated NFS share
+	// to listen on a non-loopback IP.
+	NFSAllowRemote bool
+
+	TLSCertPath string
+	TLSKeyPath  string
+
+	HostMountPoint string
+	HostAutoMount  bool
+
+	ShareUser     string
+	SharePassword string
+
+	ShareProfile string
+
+	// ReadOnly is used for validation only.
+	ReadOnly bool
 }
 
-func (rc RawUserConfiguration) Process(backend string, warnLogger *slog.Logger) (*UserConfiguration, error) {
+func (rc RawUserConfiguration) Process(backends []string, warnLogger *slog.Logger) (*UserConfiguration, error) {
 	listenIP := net.ParseIP(rc.ListenIP)
 	if listenIP == nil {
 		return nil, fmt.Errorf("invalid listen ip '%v'", rc.ListenIP)
@@ -49,23 +92,122 @@ func (rc RawUserConfiguration) Process(backend string, warnLogger *slog.Logger)
 		return nil, fmt.Errorf("invalid ftp ext ip '%v'", rc.FTPExtIP)
 	}
 
-	if backend == "ftp" {
+	if slices.Contains(backends, "ftp") {
 		if !listenIP.Equal(defaultListenIP) && ftpExtIP.Equal(defaultListenIP) {
 			warnLogger.Warn("No external FTP IP address via --ftp-extip was configured. This is a requirement in almost all scenarios if you want to connect remotely.")
 		}
 	} else {
 		if !ftpExtIP.Equal(defaultListenIP) {
-			warnLogger.Warn("FTP external IP address specification is ineffective with non-FTP backends", "selected", backend)
+			warnLogger.Warn("FTP external IP address specification is ineffective with non-FTP backends", "selected", backends)
 		}
 	}
 
-	if rc.SMBExtMode && backend != "smb" && !IsSMBExtModeDefault() {
+	if slices.Contains(backends, "nfs") && !listenIP.IsLoopback() {
+		if !rc.NFSAllowRemote {
+			return nil, fmt.Errorf("refusing to serve the unauthenticated nfs share on non-loopback ip '%v' as anyone on the network could access it, use --nfs-allow-remote to override", listenIP)
+		}
+
+		warnLogger.Warn("The NFS share has no authentication and is served on a non-loopback IP. Anyone who can reach it can read and modify the files", "listen-ip", listenIP)
+	} else if rc.NFSAllowRemote && !slices.Contains(backends, "nfs") {
+		warnLogger.Warn("NFS remote access specification is ineffective with non-NFS backends", "selected", backends)
+	}
+
+	if rc.SMBExtMode && !slices.Contains(backends, "smb") && !IsSMBExtModeDefault() {
 		warnLogger.Warn("SMB external mode specification is ineffective with non-SMB backends")
 	}
 
+	if rc.WebDAVTLS && !slices.Contains(backends, "webdav") {
+		warnLogger.Warn("WebDAV TLS specification is ineffective with non-WebDAV backends", "selected", backends)
+	}
+
+	if rc.FTPTLS && !slices.Contains(backends, "ftp") {
+		warnLogger.Warn("FTP TLS specification is ineffective with non-FTP backends", "selected", backends)
+	}
+
+	if (rc.TLSCertPath == "") != (rc.TLSKeyPath == "") {
+		return nil, fmt.Errorf("both tls certificate and key paths must be specified")
+	}
+
+	var tlsCertPEM, tlsKeyPEM []byte
+	if rc.TLSCertPath != "" {
+		if !rc.FTPTLS && !rc.WebDAVTLS {
+			warnLogger.Warn("TLS certificate specification is ineffective with TLS disabled")
+		}
+
+		var err error
+		tlsCertPEM, err = os.ReadFile(rc.TLSCertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "read tls certificate file")
+		}
+
+		tlsKeyPEM, err = os.ReadFile(rc.TLSKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "read tls key file")
+		}
+	}
+
+	if rc.HostMountPoint != "" && !slices.Contains(backends, "sshfs") && !rc.HostAutoMount {
+		warnLogger.Warn("Host mount point specification is ineffective with non-SSHFS backends", "selected", backends)
+	}
+
+	if rc.HostAutoMount && rc.HostMountPoint != "" && osspecifics.IsWindows() {
+		_, err := parseDriveLetter(rc.HostMountPoint)
+		if err != nil {
+			return nil, errors.Wrap(err, "validate host mount point")
+		}
+	}
+
+	shareProfile := vm.ShareProfile(rc.ShareProfile)
+
+	err := vm.ValidateShareProfile(shareProfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "validate share profile")
+	}
+
+	if shareProfile == vm.ShareProfileTimeMachine {
+		if rc.ReadOnly {
+			return nil, fmt.Errorf("time machine share profile cannot be used in read-only mode")
+		}
+
+		if !slices.Contains(backends, "smb") && !slices.Contains(backends, "afp") {
+			warnLogger.Warn("Time Machine share profile is effective only with SMB and AFP backends", "selected", backends)
+		}
+	}
+
+	if rc.ShareUser != "" && !utils.ValidateUnixUsername(rc.ShareUser) {
+		return nil, fmt.Errorf("invalid share username '%v'", rc.ShareUser)
+	}
+
+	if strings.ContainsFunc(rc.SharePassword, unicode.IsControl) {
+		return nil, fmt.Errorf("share password must not contain control characters")
+	}
+
+	if rc.SharePassword != "" && len(rc.SharePassword) < 8 {
+		warnLogger.Warn("The specified share password is shorter than 8 characters. Please consider using a stronger one.")
+	}
+
 	return &UserConfiguration{
 		listenIP:   listenIP,
 		ftpExtIP:   ftpExtIP,
 		smbExtMode: rc.SMBExtMode,
+		webDAVTLS:  rc.WebDAVTLS,
+		ftpTLS:     rc.FTPTLS,
+
+		tlsCertPEM: tlsCertPEM,
+		tlsKeyPEM:  tlsKeyPEM,
+
+		hostMountPoint: rc.HostMountPoint,
+
+		shareUser:     rc.ShareUser,
+		sharePassword: rc.SharePassword,
+
+		shareProfile: shareProfile,
 	}, nil
 }
+
+func (uc *UserConfiguration) getShareTLSConfig() *vm.ShareTLSConfig {
+	return &vm.ShareTLSConfig{
+		CertPEM: uc.tlsCertPEM,
+		KeyPEM:  uc.tlsKeyPEM,
+	}
+}
@@ -31,3 +31,11 @@ var defaultListenIP = net.ParseIP("127.0.0.1")
 func GetDefaultListenIPStr() string {
 	return defaultListenIP.String()
 }
+
+func GetDefaultBackendID() string {
+	if osspecifics.IsMacOS() {
+		return "afp"
+	}
+
+	return "smb"
+}
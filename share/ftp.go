@@ -17,7 +17,9 @@
 package share
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 
 	"github.com/AlexSSD7/linsk/vm"
@@ -25,9 +27,11 @@ import (
 )
 
 type FTPBackend struct {
+	listenIP         net.IP
 	sharePort        uint16
 	passivePortCount uint16
 	extIP            net.IP
+	tlsCfg           *vm.ShareTLSConfig
 }
 
 func NewFTPBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
@@ -54,24 +58,50 @@ func NewFTPBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
 		})
 	}
 
+	var tlsCfg *vm.ShareTLSConfig
+	if uc.ftpTLS {
+		tlsCfg = uc.getShareTLSConfig()
+	}
+
 	return &FTPBackend{
-			sharePort:        sharePort,
-			passivePortCount: passivePortCount,
-			extIP:            uc.ftpExtIP,
-		}, &VMShareOptions{
-			Ports: ports,
-		}, nil
+		listenIP:         uc.listenIP,
+		sharePort:        sharePort,
+		passivePortCount: passivePortCount,
+		extIP:            uc.ftpExtIP,
+		tlsCfg:           tlsCfg,
+	}, &VMShareOptions{
+		Ports: ports,
+	}, nil
 }
 
-func (b *FTPBackend) Apply(sharePWD string, vc *VMShareContext) (string, error) {
+func (b *FTPBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
 	if vc.NetTapCtx != nil {
 		return "", fmt.Errorf("net taps are unsupported in ftp")
 	}
 
-	err := vc.FileManager.StartFTP(sharePWD, b.sharePort+1, b.passivePortCount, b.extIP)
+	fingerprint, err := vc.FileManager.StartFTP(ctx, sharePWD, b.sharePort+1, b.passivePortCount, b.extIP, b.tlsCfg)
 	if err != nil {
 		return "", errors.Wrap(err, "start ftp server")
 	}
 
+	if b.tlsCfg != nil {
+		slog.Info("FTP server requires explicit TLS (FTPS). Please verify the certificate fingerprint when connecting.", "sha256-fingerprint", fingerprint)
+	}
+
 	return "ftp://" + b.extIP.String() + ":" + fmt.Sprint(b.sharePort), nil
 }
+
+// CredentialScope implements CredentialScopedBackend.
+func (b *FTPBackend) CredentialScope() string {
+	return unixCredentialScope
+}
+
+// GuestServices implements HealthCheckedBackend.
+func (b *FTPBackend) GuestServices() []string {
+	return []string{"vsftpd"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *FTPBackend) HealthCheckAddr() string {
+	return hostProbeAddr(b.listenIP, b.sharePort)
+}
@@ -0,0 +1,157 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+// DefaultHealthCheckInterval is the default interval between share health checks.
+const DefaultHealthCheckInterval = time.Second * 30
+
+// The number of consecutive restarts after which a share is no longer restarted.
+const maxShareRestarts = 5
+
+// hostProbeAddr returns the address to probe a share listening on the
+// specified host IP at. Unspecified addresses are probed over loopback.
+func hostProbeAddr(listenIP net.IP, port uint16) string {
+	ip := listenIP
+	switch {
+	case ip == nil || ip.Equal(net.IPv4zero):
+		ip = net.IPv4(127, 0, 0, 1)
+	case ip.IsUnspecified():
+		ip = net.IPv6loopback
+	}
+
+	return net.JoinHostPort(ip.String(), fmt.Sprint(port))
+}
+
+func checkShareHealth(ctx context.Context, fm *vm.FileManager, hcb HealthCheckedBackend) error {
+	for _, svc := range hcb.GuestServices() {
+		started, err := fm.IsServiceStarted(ctx, svc)
+		if err != nil {
+			return errors.Wrapf(err, "check service '%v'", svc)
+		}
+
+		if !started {
+			return fmt.Errorf("service '%v' is not running", svc)
+		}
+	}
+
+	if addr := hcb.HealthCheckAddr(); addr != "" {
+		conn, err := net.DialTimeout("tcp", addr, time.Second*5)
+		if err != nil {
+			return errors.Wrapf(err, "probe '%v'", addr)
+		}
+
+		_ = conn.Close()
+	}
+
+	return nil
+}
+
+// HealthFunc is notified about the changes of the share health. The error
+// is nil once an unhealthy share is healthy again.
+type HealthFunc func(backendID string, err error)
+
+// OnHealthChange registers the function notified by Monitor once a share
+// becomes unhealthy, and once it recovers. It must be called before Monitor.
+func (s *Supervisor) OnHealthChange(fn HealthFunc) {
+	s.onHealthChange = fn
+}
+
+func (s *Supervisor) notifyHealthChange(backendID string, err error) {
+	if s.onHealthChange != nil {
+		s.onHealthChange(backendID, err)
+	}
+}
+
+// Monitor periodically checks that the guest services of the started shares
+// are running and answering, and restarts the ones which are not. It blocks
+// until the context is canceled. Apply must be called beforehand.
+func (s *Supervisor) Monitor(ctx context.Context, fm *vm.FileManager, interval time.Duration, logger *slog.Logger) {
+	restarts := make(map[string]int)
+	unhealthy := make(map[string]bool)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, sh := range s.shares {
+			hcb, ok := sh.backend.(HealthCheckedBackend)
+			if !ok {
+				continue
+			}
+
+			err := checkShareHealth(ctx, fm, hcb)
+			if err == nil {
+				if unhealthy[sh.id] {
+					logger.Info("File share is healthy again", "backend", sh.id)
+					delete(unhealthy, sh.id)
+					s.notifyHealthChange(sh.id, nil)
+				}
+
+				// Only the consecutive failures count towards giving up.
+				delete(restarts, sh.id)
+
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !unhealthy[sh.id] {
+				unhealthy[sh.id] = true
+				s.notifyHealthChange(sh.id, err)
+			}
+
+			// The shares given up on are still checked to notice them recover.
+			if restarts[sh.id] >= maxShareRestarts {
+				continue
+			}
+
+			restarts[sh.id]++
+
+			logger.Warn("File share is unhealthy, restarting", "backend", sh.id, "error", err.Error(), "attempt", restarts[sh.id])
+
+			for _, svc := range hcb.GuestServices() {
+				err := fm.RestartService(ctx, svc)
+				if err != nil {
+					logger.Error("Failed to restart file share service", "backend", sh.id, "service", svc, "error", err.Error())
+				}
+			}
+
+			if restarts[sh.id] >= maxShareRestarts {
+				logger.Error("File share keeps failing, giving up on restarting it. Clients may not be able to connect", "backend", sh.id)
+			}
+		}
+	}
+}
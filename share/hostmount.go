@@ -0,0 +1,226 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+// HostMount is a file share mounted on the host with the host-native tools.
+type HostMount struct {
+	path    string
+	cleanup func() error
+}
+
+// Path returns the host path (or drive letter on Windows) the share is mounted at.
+func (m *HostMount) Path() string {
+	return m.path
+}
+
+// Unmount unmounts the share from the host.
+func (m *HostMount) Unmount() error {
+	return m.cleanup()
+}
+
+// CanMountOnHost returns whether MountOnHost supports the share on this host.
+func CanMountOnHost(as ActiveShare) bool {
+	switch {
+	case osspecifics.IsWindows():
+		// Windows SMB client cannot connect to non-standard ports, so only
+		// shares reachable over the net tap (UNC paths) can be mounted.
+		return as.BackendID == "smb" && strings.HasPrefix(as.URL, `\\`)
+	case osspecifics.IsMacOS():
+		switch as.BackendID {
+		case "smb", "afp", "nfs":
+			return true
+		}
+	}
+
+	return false
+}
+
+// MountOnHost mounts the share on the host. On macOS, the share is mounted
+// at mountPoint, which is created if it does not exist. On Windows, the share
+// is mapped to the drive letter in mountPoint (like "L:"), or to the next free
+// drive letter if mountPoint is empty.
+func MountOnHost(as ActiveShare, mountPoint string) (*HostMount, error) {
+	if !CanMountOnHost(as) {
+		return nil, fmt.Errorf("mounting '%v' shares on this host is not supported", as.BackendID)
+	}
+
+	if osspecifics.IsWindows() {
+		return mountOnWindowsHost(as, mountPoint)
+	}
+
+	return mountOnMacOSHost(as, mountPoint)
+}
+
+var driveLetterRegexp = regexp.MustCompile(`(?i)^([A-Z])(:\\?)?$`)
+
+// parseDriveLetter parses a drive letter like "L", "L:" or "L:\"
+// into the "L:" form.
+func parseDriveLetter(s string) (string, error) {
+	m := driveLetterRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return "", fmt.Errorf("bad drive letter '%v', expected a value like \"L:\"", s)
+	}
+
+	return strings.ToUpper(m[1]) + ":", nil
+}
+
+func mountOnWindowsHost(as ActiveShare, mountPoint string) (*HostMount, error) {
+	var drive string
+
+	if mountPoint != "" {
+		var err error
+		drive, err = parseDriveLetter(mountPoint)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(drive + `\`); err == nil {
+			return nil, fmt.Errorf("drive letter %v is already in use", drive)
+		}
+	} else {
+		var err error
+		drive, err = osspecifics.FindFreeDriveLetter()
+		if err != nil {
+			return nil, errors.Wrap(err, "find free drive letter")
+		}
+	}
+
+	// The share is mapped through the API rather than "net use" so that the
+	// password does not end up in a command line visible to other users.
+	err := osspecifics.MapNetworkDrive(drive, as.URL, as.Username, as.Password)
+	if err != nil {
+		return nil, errors.Wrap(err, "map network drive")
+	}
+
+	return &HostMount{
+		path: drive,
+		cleanup: func() error {
+			err := osspecifics.UnmapNetworkDrive(drive)
+			if err != nil {
+				return errors.Wrap(err, "unmap network drive")
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+func mountOnMacOSHost(as ActiveShare, mountPoint string) (*HostMount, error) {
+	if mountPoint == "" {
+		return nil, fmt.Errorf("empty mount point")
+	}
+
+	shareURL, err := url.Parse(as.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse share url")
+	}
+
+	var cmd *exec.Cmd
+
+	switch as.BackendID {
+	case "smb":
+		cmd = exec.Command("mount_smbfs", "//"+url.User(as.Username).String()+"@"+shareURL.Host+shareURL.Path, mountPoint) //#nosec G204 // The args are passed directly without a shell.
+	case "afp":
+		shareURL.User = url.User(as.Username)
+		cmd = exec.Command("mount_afp", "-i", shareURL.String(), mountPoint) //#nosec G204 // The args are passed directly without a shell.
+	case "nfs":
+		cmd = exec.Command("mount", "-t", "nfs", "-o", "vers=4,port="+shareURL.Port(), shareURL.Hostname()+":/", mountPoint) //#nosec G204 // The args are passed directly without a shell.
+	default:
+		return nil, fmt.Errorf("unsupported backend '%v'", as.BackendID)
+	}
+
+	err = os.MkdirAll(mountPoint, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "create mount point")
+	}
+
+	if as.Password != "" {
+		// Both mount_smbfs and mount_afp prompt for the password when it is
+		// not in the URL. Without a controlling terminal, the prompt reads
+		// from stdin, which keeps the password out of the process list.
+		osspecifics.SetDetachedProcessCmd(cmd)
+		cmd.Stdin = strings.NewReader(as.Password + "\n")
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, utils.WrapErrWithLog(err, "run mount cmd", string(out))
+	}
+
+	return &HostMount{
+		path: mountPoint,
+		cleanup: func() error {
+			// Unmounting through diskutil lets Finder know that the volume was
+			// ejected, instead of it complaining about a disconnected server.
+			out, err := exec.Command("diskutil", "unmount", mountPoint).CombinedOutput() //#nosec G204 // The mount point is supplied by the user running Linsk.
+			if err != nil {
+				slog.Debug("Failed to unmount the share with diskutil, falling back to umount", "error", err.Error(), "output", string(out))
+
+				out, err = exec.Command("umount", mountPoint).CombinedOutput() //#nosec G204 // The mount point is supplied by the user running Linsk.
+				if err != nil {
+					return utils.WrapErrWithLog(err, "run umount", string(out))
+				}
+			}
+
+			// Removes the mount point only if it is empty.
+			err = os.Remove(mountPoint)
+			if err != nil {
+				slog.Debug("Failed to remove the host mount point", "error", err.Error(), "path", mountPoint)
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+// OpenInFileManager opens the path in Finder or Explorer.
+func OpenInFileManager(path string) error {
+	var cmd *exec.Cmd
+
+	switch {
+	case osspecifics.IsWindows():
+		cmd = exec.Command("explorer", path) //#nosec G204 // The path is a mount point.
+	case osspecifics.IsMacOS():
+		cmd = exec.Command("open", path) //#nosec G204 // The path is a mount point.
+	default:
+		cmd = exec.Command("xdg-open", path) //#nosec G204 // The path is a mount point.
+	}
+
+	// Explorer returns non-zero exit codes even on success, so we only care whether it started.
+	err := cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "start file manager")
+	}
+
+	go func() { _ = cmd.Wait() }()
+
+	return nil
+}
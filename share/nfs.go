@@ -0,0 +1,86 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+const nfsPort = 2049
+
+type NFSBackend struct {
+	listenIP  net.IP
+	sharePort uint16
+}
+
+func NewNFSBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
+	if osspecifics.IsWindows() {
+		return nil, nil, fmt.Errorf("nfs backend is not supported on windows")
+	}
+
+	sharePort, err := getNetworkSharePort(0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get network share port")
+	}
+
+	return &NFSBackend{
+		listenIP:  uc.listenIP,
+		sharePort: sharePort,
+	}, &VMShareOptions{
+		Ports: []vm.PortForwardingRule{{
+			HostIP:   uc.listenIP,
+			HostPort: sharePort,
+			VMPort:   nfsPort,
+		}},
+	}, nil
+}
+
+func (b *NFSBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
+	if vc.NetTapCtx != nil {
+		return "", fmt.Errorf("net taps are unsupported in nfs")
+	}
+
+	err := vc.FileManager.StartNFS(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "start nfs server")
+	}
+
+	// NFSv4 exports a single pseudo-root, so the path is always "/".
+	return "nfs://" + net.JoinHostPort(b.listenIP.String(), fmt.Sprint(b.sharePort)) + "/", nil
+}
+
+// UsesPassword implements PasswordlessBackend. NFS relies on the
+// forwarded port being reachable only from the listen IP instead.
+func (b *NFSBackend) UsesPassword() bool {
+	return false
+}
+
+// GuestServices implements HealthCheckedBackend.
+func (b *NFSBackend) GuestServices() []string {
+	return []string{"nfs"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *NFSBackend) HealthCheckAddr() string {
+	return hostProbeAddr(b.listenIP, b.sharePort)
+}
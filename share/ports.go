@@ -20,13 +20,80 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"syscall"
 
 	"github.com/pkg/errors"
 )
 
+// The network share ports are looked up starting from this one.
+const networkShareBasePort = 9000
+
+var (
+	// Ports handed out to backends are reserved until the process exits, as
+	// nothing listens on them before the VM starts. Without this, multiple
+	// backends configured at once would be assigned the same ports.
+	reservedPortsMu sync.Mutex
+	reservedPorts   = make(map[uint16]struct{})
+
+	portReserver PortReserver
+)
+
+// PortReserver reserves a port across all concurrently running Linsk
+// instances. It returns false if another instance has reserved the port.
+type PortReserver func(port uint16) (bool, error)
+
+// SetPortReserver sets the function used to reserve the share ports across
+// processes. The in-process reservation alone is not enough to run multiple
+// sessions concurrently, as no process listens on the ports it has handed
+// out until its VM starts.
+func SetPortReserver(r PortReserver) {
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	portReserver = r
+}
+
 func getNetworkSharePort(subsequent uint16) (uint16, error) {
-	return getClosestAvailPortWithSubsequent(9000, subsequent)
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	for {
+		port, err := getClosestAvailPortWithSubsequent(networkShareBasePort, subsequent)
+		if err != nil {
+			return 0, err
+		}
+
+		ok, err := reservePortsExternally(port, subsequent)
+		if err != nil {
+			return 0, errors.Wrap(err, "reserve ports")
+		}
+
+		// The ports are marked as reserved either way, as they are
+		// reserved by another instance if the reservation failed.
+		for i := uint16(0); i <= subsequent; i++ {
+			reservedPorts[port+i] = struct{}{}
+		}
+
+		if ok {
+			return port, nil
+		}
+	}
+}
+
+func reservePortsExternally(port uint16, subsequent uint16) (bool, error) {
+	if portReserver == nil {
+		return true, nil
+	}
+
+	for i := uint16(0); i <= subsequent; i++ {
+		ok, err := portReserver(port + i)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
 }
 
 func getClosestAvailPortWithSubsequent(port uint16, subsequent uint16) (uint16, error) {
@@ -51,6 +118,10 @@ func checkPortAvailable(port uint16, subsequent uint16) (bool, error) {
 	}
 
 	if subsequent == 0 {
+		if _, ok := reservedPorts[port]; ok {
+			return false, nil
+		}
+
 		ln, err := net.Listen("tcp", "127.0.0.1:"+fmt.Sprint(port))
 		if err != nil {
 			opErr := new(net.OpError)
@@ -88,3 +159,12 @@ func checkPortAvailable(port uint16, subsequent uint16) (bool, error) {
 
 	return true, nil
 }
+
+// FindAvailablePort returns the first port available for a network share,
+// without reserving it.
+func FindAvailablePort() (uint16, error) {
+	reservedPortsMu.Lock()
+	defer reservedPortsMu.Unlock()
+
+	return getClosestAvailPortWithSubsequent(networkShareBasePort, 0)
+}
@@ -0,0 +1,77 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+type SFTPBackend struct {
+	listenIP  net.IP
+	sharePort uint16
+}
+
+func NewSFTPBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
+	sharePort, err := getNetworkSharePort(0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get network share port")
+	}
+
+	return &SFTPBackend{
+		listenIP:  uc.listenIP,
+		sharePort: sharePort,
+	}, &VMShareOptions{
+		Ports: []vm.PortForwardingRule{{
+			HostIP:   uc.listenIP,
+			HostPort: sharePort,
+			VMPort:   vm.SFTPPort,
+		}},
+	}, nil
+}
+
+func (b *SFTPBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
+	if vc.NetTapCtx != nil {
+		return "", fmt.Errorf("net taps are unsupported in sftp")
+	}
+
+	err := vc.FileManager.StartSFTP(ctx, sharePWD)
+	if err != nil {
+		return "", errors.Wrap(err, "start sftp server")
+	}
+
+	return "sftp://" + vc.FileManager.ShareUser() + "@" + net.JoinHostPort(b.listenIP.String(), fmt.Sprint(b.sharePort)) + "/linsk", nil
+}
+
+// CredentialScope implements CredentialScopedBackend.
+func (b *SFTPBackend) CredentialScope() string {
+	return unixCredentialScope
+}
+
+// GuestServices implements HealthCheckedBackend.
+func (b *SFTPBackend) GuestServices() []string {
+	return []string{"sshd"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *SFTPBackend) HealthCheckAddr() string {
+	return hostProbeAddr(b.listenIP, b.sharePort)
+}
@@ -17,6 +17,7 @@
 package share
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -31,6 +32,9 @@ const smbPort = 445
 type SMBBackend struct {
 	listenIP  net.IP
 	sharePort *uint16
+
+	// Set in Apply if the share is served over a net tap.
+	tapGuestIP net.IP
 }
 
 func NewSMBBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
@@ -52,15 +56,15 @@ func NewSMBBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
 	}
 
 	return &SMBBackend{
-			listenIP:  uc.listenIP,
-			sharePort: sharePortPtr,
-		}, &VMShareOptions{
-			Ports:     ports,
-			EnableTap: uc.smbExtMode,
-		}, nil
+		listenIP:  uc.listenIP,
+		sharePort: sharePortPtr,
+	}, &VMShareOptions{
+		Ports:     ports,
+		EnableTap: uc.smbExtMode,
+	}, nil
 }
 
-func (b *SMBBackend) Apply(sharePWD string, vc *VMShareContext) (string, error) {
+func (b *SMBBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
 	if b.sharePort != nil && vc.NetTapCtx != nil {
 		return "", fmt.Errorf("conflict: configured to use a forwarded port but a net tap configuration was detected")
 	}
@@ -69,7 +73,7 @@ func (b *SMBBackend) Apply(sharePWD string, vc *VMShareContext) (string, error)
 		return "", fmt.Errorf("no net tap configuration found")
 	}
 
-	err := vc.FileManager.StartSMB(sharePWD)
+	err := vc.FileManager.StartSMB(ctx, sharePWD)
 	if err != nil {
 		return "", errors.Wrap(err, "start smb server")
 	}
@@ -79,6 +83,7 @@ func (b *SMBBackend) Apply(sharePWD string, vc *VMShareContext) (string, error)
 	case b.sharePort != nil:
 		shareURL = "smb://" + net.JoinHostPort(b.listenIP.String(), fmt.Sprint(*b.sharePort)) + "/linsk"
 	case vc.NetTapCtx != nil:
+		b.tapGuestIP = vc.NetTapCtx.Net.GuestIP
 		if osspecifics.IsWindows() {
 			shareURL = `\\` + strings.ReplaceAll(vc.NetTapCtx.Net.GuestIP.String(), ":", "-") + ".ipv6-literal.net" + `\linsk`
 		} else {
@@ -90,3 +95,21 @@ func (b *SMBBackend) Apply(sharePWD string, vc *VMShareContext) (string, error)
 
 	return shareURL, nil
 }
+
+// GuestServices implements HealthCheckedBackend.
+func (b *SMBBackend) GuestServices() []string {
+	return []string{"samba"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *SMBBackend) HealthCheckAddr() string {
+	if b.sharePort != nil {
+		return hostProbeAddr(b.listenIP, *b.sharePort)
+	}
+
+	if b.tapGuestIP != nil {
+		return net.JoinHostPort(b.tapGuestIP.String(), fmt.Sprint(smbPort))
+	}
+
+	return ""
+}
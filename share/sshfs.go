@@ -0,0 +1,216 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHFSBackend mounts the guest filesystem directly on the host using
+// SSHFS over the VM's SSH port forwarding. macFUSE is required on macOS,
+// and WinFsp together with SSHFS-Win are required on Windows.
+type SSHFSBackend struct {
+	mountPoint string
+
+	closeOnce sync.Once
+	closeFunc func() error
+}
+
+func NewSSHFSBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
+	if uc.hostMountPoint == "" {
+		return nil, nil, fmt.Errorf("host mount point must be specified for the sshfs backend")
+	}
+
+	_, err := exec.LookPath(GetSSHFSCmd())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "look up sshfs executable (is SSHFS installed?)")
+	}
+
+	return &SSHFSBackend{
+		mountPoint: uc.hostMountPoint,
+	}, &VMShareOptions{}, nil
+}
+
+// GetSSHFSCmd returns the SSHFS executable to use for the host platform.
+func GetSSHFSCmd() string {
+	if osspecifics.IsWindows() {
+		// SSHFS-Win does not add itself to PATH.
+		sshfsWinPath := filepath.Join(os.Getenv("ProgramFiles"), "SSHFS-Win", "bin", "sshfs.exe")
+		if _, err := os.Stat(sshfsWinPath); err == nil {
+			return sshfsWinPath
+		}
+
+		return "sshfs.exe"
+	}
+
+	return "sshfs"
+}
+
+func (b *SSHFSBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
+	if vc.NetTapCtx != nil {
+		return "", fmt.Errorf("net taps are unsupported in sshfs")
+	}
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", errors.Wrap(err, "generate ssh key")
+	}
+
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return "", errors.Wrap(err, "create ssh public key")
+	}
+
+	privKeyPEM, err := ssh.MarshalPrivateKey(privKey, "")
+	if err != nil {
+		return "", errors.Wrap(err, "marshal private key")
+	}
+
+	err = vc.FileManager.AuthorizeLinskSSHKey(ctx, ssh.MarshalAuthorizedKey(sshPubKey))
+	if err != nil {
+		return "", errors.Wrap(err, "authorize ssh key")
+	}
+
+	keyFile, err := os.CreateTemp("", "linsk-sshfs-*")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp private key file")
+	}
+
+	keyPath := keyFile.Name()
+
+	_, err = keyFile.Write(pem.EncodeToMemory(privKeyPEM))
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(keyPath)
+		return "", errors.Wrap(err, "write temp private key file")
+	}
+
+	nullDev := "/dev/null"
+	if osspecifics.IsWindows() {
+		nullDev = "NUL"
+	}
+
+	args := []string{
+		vc.FileManager.ShareUser() + "@127.0.0.1:/mnt", b.mountPoint,
+		"-f",
+		"-p", fmt.Sprint(vc.Instance.SSHMappedPort()),
+		"-o", "IdentityFile=" + keyPath,
+		"-o", "IdentitiesOnly=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=" + nullDev,
+	}
+
+	if vc.FileManager.ReadOnly() {
+		args = append(args, "-o", "ro")
+	}
+
+	if osspecifics.IsMacOS() {
+		args = append(args, "-o", "volname=Linsk ("+vc.Instance.Hostname()+")")
+	}
+
+	cmd := exec.Command(GetSSHFSCmd(), args...) //#nosec G204 // The mount point is supplied by the user running Linsk.
+	osspecifics.SetNewProcessGroupCmd(cmd)
+
+	err = cmd.Start()
+	if err != nil {
+		_ = os.Remove(keyPath)
+		return "", errors.Wrap(err, "start sshfs")
+	}
+
+	b.closeFunc = func() error {
+		var err error
+
+		b.closeOnce.Do(func() {
+			err = cmd.Process.Kill()
+			if err != nil {
+				err = errors.Wrap(err, "kill sshfs")
+			}
+
+			_ = cmd.Wait()
+
+			unmountHostFUSE(b.mountPoint)
+
+			rmErr := os.Remove(keyPath)
+			if rmErr != nil && err == nil {
+				err = errors.Wrap(rmErr, "remove private key file")
+			}
+		})
+
+		return err
+	}
+
+	go func() {
+		<-vc.Instance.Done()
+
+		err := b.Close()
+		if err != nil {
+			slog.Warn("Failed to clean up sshfs", "error", err.Error())
+		}
+	}()
+
+	return b.mountPoint, nil
+}
+
+// Close implements CloserBackend. It stops SSHFS and unmounts the host mount point.
+func (b *SSHFSBackend) Close() error {
+	if b.closeFunc == nil {
+		return nil
+	}
+
+	return b.closeFunc()
+}
+
+// UsesPassword implements PasswordlessBackend. The
+// SSHFS client authenticates with an ephemeral key.
+func (b *SSHFSBackend) UsesPassword() bool {
+	return false
+}
+
+func unmountHostFUSE(mountPoint string) {
+	var cmd *exec.Cmd
+
+	switch {
+	case osspecifics.IsWindows():
+		// WinFsp unmounts the drive by itself once sshfs exits.
+		return
+	case osspecifics.IsMacOS():
+		cmd = exec.Command("umount", mountPoint) //#nosec G204 // The mount point is supplied by the user running Linsk.
+	default:
+		cmd = exec.Command("fusermount", "-u", mountPoint) //#nosec G204 // The mount point is supplied by the user running Linsk.
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// The mount point could have been already unmounted by sshfs.
+		slog.Debug("Failed to unmount the host mount point", "error", err.Error(), "output", string(out), "mount-point", mountPoint)
+	}
+}
@@ -0,0 +1,190 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-password/password"
+	"go.uber.org/multierr"
+)
+
+// CredentialScopedBackend is an optional interface for backends that share
+// the credentials store with other backends. Backends within the same scope
+// are started with the same password. Every other backend gets its own.
+type CredentialScopedBackend interface {
+	CredentialScope() string
+}
+
+// The credentials scope of the backends authenticating against the guest Unix user.
+const unixCredentialScope = "unix"
+
+// ActiveShare describes a started file share.
+type ActiveShare struct {
+	BackendID string
+	URL       string
+
+	// Username and Password are empty if the backend
+	// does not use password authentication.
+	Username string
+	Password string
+}
+
+type supervisedShare struct {
+	id        string
+	backend   Backend
+	enableTap bool
+}
+
+// Supervisor manages multiple file share backends running
+// simultaneously over the same mounted file system.
+type Supervisor struct {
+	shares []supervisedShare
+
+	// Optional. A user-specified password is used
+	// for all backends instead of generated ones.
+	shareUser     string
+	sharePassword string
+
+	shareProfile vm.ShareProfile
+
+	onHealthChange HealthFunc
+}
+
+func NewSupervisor(ids []string, uc *UserConfiguration) (*Supervisor, *VMShareOptions, error) {
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("no backends specified")
+	}
+
+	s := &Supervisor{
+		shareUser:     uc.shareUser,
+		sharePassword: uc.sharePassword,
+
+		shareProfile: uc.shareProfile,
+	}
+	mergedOpts := &VMShareOptions{}
+
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return nil, nil, fmt.Errorf("duplicate backend '%v'", id)
+		}
+
+		newBackendFunc := GetBackend(id)
+		if newBackendFunc == nil {
+			return nil, nil, fmt.Errorf("unknown backend '%v'", id)
+		}
+
+		backend, opts, err := newBackendFunc(uc)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "initialize backend '%v'", id)
+		}
+
+		mergedOpts.Ports = append(mergedOpts.Ports, opts.Ports...)
+		mergedOpts.EnableTap = mergedOpts.EnableTap || opts.EnableTap
+
+		s.shares = append(s.shares, supervisedShare{
+			id:        id,
+			backend:   backend,
+			enableTap: opts.EnableTap,
+		})
+	}
+
+	return s, mergedOpts, nil
+}
+
+// Apply starts all backends. The backends that did not request a net tap are
+// started without it, as they are reachable through port forwarding only.
+func (s *Supervisor) Apply(ctx context.Context, vc *VMShareContext) ([]ActiveShare, error) {
+	if s.shareUser != "" {
+		err := vc.FileManager.SetShareUser(ctx, s.shareUser)
+		if err != nil {
+			return nil, errors.Wrap(err, "set share user")
+		}
+	}
+
+	err := vc.FileManager.SetShareProfile(s.shareProfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "set share profile")
+	}
+
+	scopePasswords := make(map[string]string)
+
+	var ret []ActiveShare
+
+	for _, sh := range s.shares {
+		scope := sh.id
+		if csb, ok := sh.backend.(CredentialScopedBackend); ok {
+			scope = csb.CredentialScope()
+		}
+
+		pwd, ok := scopePasswords[scope]
+		if s.sharePassword != "" {
+			pwd = s.sharePassword
+		} else if !ok {
+			var err error
+			pwd, err = password.Generate(16, 10, 0, false, false)
+			if err != nil {
+				return nil, errors.Wrap(err, "generate ephemeral password")
+			}
+
+			scopePasswords[scope] = pwd
+		}
+
+		shareVC := *vc
+		if !sh.enableTap {
+			shareVC.NetTapCtx = nil
+		}
+
+		url, err := sh.backend.Apply(ctx, pwd, &shareVC)
+		if err != nil {
+			return nil, errors.Wrapf(err, "apply backend '%v'", sh.id)
+		}
+
+		as := ActiveShare{
+			BackendID: sh.id,
+			URL:       url,
+			Username:  vc.FileManager.ShareUser(),
+			Password:  pwd,
+		}
+
+		if pb, ok := sh.backend.(PasswordlessBackend); ok && !pb.UsesPassword() {
+			as.Username = ""
+			as.Password = ""
+		}
+
+		ret = append(ret, as)
+	}
+
+	return ret, nil
+}
+
+// Close releases the host-side resources held by the backends.
+func (s *Supervisor) Close() error {
+	var err error
+
+	for _, sh := range s.shares {
+		if cb, ok := sh.backend.(CloserBackend); ok {
+			err = multierr.Append(err, errors.Wrapf(cb.Close(), "close backend '%v'", sh.id))
+		}
+	}
+
+	return err
+}
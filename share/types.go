@@ -33,7 +33,7 @@ type VMShareOptions struct {
 }
 
 type VMShareContext struct {
-	Instance    *vm.VM
+	Instance    vm.Instance
 	FileManager *vm.FileManager
 	NetTapCtx   *NetTapRuntimeContext
 }
@@ -0,0 +1,88 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package share
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+)
+
+type WebDAVBackend struct {
+	listenIP  net.IP
+	sharePort uint16
+	tlsCfg    *vm.ShareTLSConfig
+}
+
+func NewWebDAVBackend(uc *UserConfiguration) (Backend, *VMShareOptions, error) {
+	sharePort, err := getNetworkSharePort(0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get network share port")
+	}
+
+	vmPort := uint16(80)
+	var tlsCfg *vm.ShareTLSConfig
+	if uc.webDAVTLS {
+		vmPort = 443
+		tlsCfg = uc.getShareTLSConfig()
+	}
+
+	return &WebDAVBackend{
+		listenIP:  uc.listenIP,
+		sharePort: sharePort,
+		tlsCfg:    tlsCfg,
+	}, &VMShareOptions{
+		Ports: []vm.PortForwardingRule{{
+			HostIP:   uc.listenIP,
+			HostPort: sharePort,
+			VMPort:   vmPort,
+		}},
+	}, nil
+}
+
+func (b *WebDAVBackend) Apply(ctx context.Context, sharePWD string, vc *VMShareContext) (string, error) {
+	if vc.NetTapCtx != nil {
+		return "", fmt.Errorf("net taps are unsupported in webdav")
+	}
+
+	fingerprint, err := vc.FileManager.StartWebDAV(ctx, sharePWD, b.tlsCfg)
+	if err != nil {
+		return "", errors.Wrap(err, "start webdav server")
+	}
+
+	scheme := "http"
+	if b.tlsCfg != nil {
+		scheme = "https"
+		slog.Info("Serving WebDAV over HTTPS. Please verify the certificate fingerprint when connecting.", "sha256-fingerprint", fingerprint)
+	}
+
+	return scheme + "://" + net.JoinHostPort(b.listenIP.String(), fmt.Sprint(b.sharePort)) + "/", nil
+}
+
+// GuestServices implements HealthCheckedBackend.
+func (b *WebDAVBackend) GuestServices() []string {
+	return []string{"lighttpd"}
+}
+
+// HealthCheckAddr implements HealthCheckedBackend.
+func (b *WebDAVBackend) HealthCheckAddr() string {
+	return hostProbeAddr(b.listenIP, b.sharePort)
+}
@@ -0,0 +1,208 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sshutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/maps"
+)
+
+// DefaultCommandTimeout is the run time limit of the commands
+// that do not specify one.
+const DefaultCommandTimeout = time.Second * 15
+
+// NoTimeout disables the run time limit of a command, which
+// is useful for the long-running ones like transfers and fsck.
+const NoTimeout time.Duration = -1
+
+// Command is a shell command to run in the guest.
+type Command struct {
+	Cmd string
+
+	// Env is the environment variables to set for the command.
+	Env map[string]string
+
+	// Stdin is read by the command. The command gets an empty input if nil.
+	Stdin io.Reader
+
+	// Stdout and Stderr receive the output as it is written, which makes it
+	// possible to follow the long-running commands live. Run returns no output
+	// if Stdout is set. Otherwise, the output is buffered and returned. The
+	// buffered stderr is included in the error if the command fails.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Timeout limits the command run time. DefaultCommandTimeout is
+	// used if zero, and the time is not limited if it is NoTimeout.
+	Timeout time.Duration
+}
+
+// CommandRunner runs shell commands in the guest. The in-guest operations
+// take it instead of an SSH connection, so that the runner can be replaced
+// in tests, or wrapped to log or retry the commands.
+type CommandRunner interface {
+	// Run runs the command and returns its stdout. A command exiting
+	// with a non-zero status results in an error.
+	Run(ctx context.Context, cmd Command) ([]byte, error)
+}
+
+// SSHRunner is the CommandRunner running each command in a new session over
+// the SSH connection. The connection is closed once the context is canceled
+// or the command times out, as closing the session does not stop a command
+// that is not reading its input.
+type SSHRunner struct {
+	sc *ssh.Client
+}
+
+var _ CommandRunner = (*SSHRunner)(nil)
+
+func NewSSHRunner(sc *ssh.Client) *SSHRunner {
+	return &SSHRunner{
+		sc: sc,
+	}
+}
+
+func (r *SSHRunner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	line, err := cmd.commandLine()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	var ret []byte
+
+	err = NewSSHSessionWithDelayedTimeout(ctx, timeout, r.sc, func(sess *ssh.Session, startTimeout func(preTimeout func())) error {
+		if timeout != NoTimeout {
+			startTimeout(nil)
+		}
+
+		var stdout, stderr *bytes.Buffer
+
+		sess.Stdin = cmd.Stdin
+
+		sess.Stdout = cmd.Stdout
+		if sess.Stdout == nil {
+			stdout = bytes.NewBuffer(nil)
+			sess.Stdout = stdout
+		}
+
+		sess.Stderr = cmd.Stderr
+		if sess.Stderr == nil {
+			stderr = bytes.NewBuffer(nil)
+			sess.Stderr = stderr
+		}
+
+		err := sess.Run(line)
+		if err != nil {
+			if stderr != nil {
+				return utils.WrapErrWithLog(err, "run cmd", stderr.String())
+			}
+
+			return errors.Wrap(err, "run cmd")
+		}
+
+		if stderr != nil && stderr.Len() != 0 {
+			slog.Debug("Guest command wrote to stderr", "cmd", cmd.Cmd, "stderr", strings.TrimSpace(stderr.String()))
+		}
+
+		if stdout != nil {
+			ret = stdout.Bytes()
+		}
+
+		return nil
+	})
+
+	return ret, err
+}
+
+// DialingRunner is the CommandRunner running each command over a new SSH
+// connection, which is closed once the command completes.
+type DialingRunner struct {
+	dial func() (*ssh.Client, error)
+}
+
+var _ CommandRunner = (*DialingRunner)(nil)
+
+// NewDialingRunner creates a DialingRunner connecting with the dial
+// function, like the DialSSH method of the VM.
+func NewDialingRunner(dial func() (*ssh.Client, error)) *DialingRunner {
+	return &DialingRunner{
+		dial: dial,
+	}
+}
+
+func (r *DialingRunner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	sc, err := r.dial()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	return NewSSHRunner(sc).Run(ctx, cmd)
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// commandLine returns the command with the environment variables exported
+// in front of it. The guest sshd does not accept the variables set with
+// the SSH protocol, as no AcceptEnv patterns are configured.
+func (cmd Command) commandLine() (string, error) {
+	if len(cmd.Env) == 0 {
+		return cmd.Cmd, nil
+	}
+
+	names := maps.Keys(cmd.Env)
+	slices.Sort(names)
+
+	var sb strings.Builder
+
+	for _, name := range names {
+		if !envNameRegexp.MatchString(name) {
+			return "", fmt.Errorf("invalid environment variable name '%v'", name)
+		}
+
+		sb.WriteString("export " + name + "=" + shellescape.Quote(cmd.Env[name]) + "; ")
+	}
+
+	sb.WriteString(cmd.Cmd)
+
+	return sb.String(), nil
+}
+
+// RunCmd runs the command with the default timeout
+// using the runner and returns its stdout.
+func RunCmd(ctx context.Context, r CommandRunner, cmd string) ([]byte, error) {
+	return r.Run(ctx, Command{Cmd: cmd})
+}
@@ -17,14 +17,12 @@
 package sshutil
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"time"
 
-	"github.com/AlexSSD7/linsk/utils"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
@@ -43,26 +41,10 @@ func GenerateSSHKey() (ssh.Signer, []byte, error) {
 	return signer, ssh.MarshalAuthorizedKey(signer.PublicKey()), nil
 }
 
+// RunSSHCmd runs the command over the SSH connection with the
+// default timeout and returns its stdout. See SSHRunner.
 func RunSSHCmd(ctx context.Context, sc *ssh.Client, cmd string) ([]byte, error) {
-	var ret []byte
-	err := NewSSHSession(ctx, time.Second*15, sc, func(sess *ssh.Session) error {
-		stdout := bytes.NewBuffer(nil)
-		stderr := bytes.NewBuffer(nil)
-
-		sess.Stdout = stdout
-		sess.Stderr = stderr
-
-		err := sess.Run(cmd)
-		if err != nil {
-			return utils.WrapErrWithLog(err, "run cmd", stderr.String())
-		}
-
-		ret = stdout.Bytes()
-
-		return nil
-	})
-
-	return ret, err
+	return NewSSHRunner(sc).Run(ctx, Command{Cmd: cmd})
 }
 
 func NewSSHSession(ctx context.Context, timeout time.Duration, sc *ssh.Client, fn func(*ssh.Session) error) error {
@@ -109,7 +91,12 @@ func NewSSHSessionWithDelayedTimeout(ctx context.Context, timeout time.Duration,
 		}()
 	})
 	if timedOut {
-		return fmt.Errorf("timed out (%w)", err)
+		// The session is closed on the context cancelation too.
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w (%w)", ctx.Err(), err)
+		}
+
+		return fmt.Errorf("%w (%w)", ErrTimeout, err)
 	}
 
 	return err
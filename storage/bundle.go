@@ -0,0 +1,395 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/AlexSSD7/linsk/constants"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const (
+	bundleManifestName    = "manifest.json"
+	bundleFormatVersion   = 1
+	bundleImportTmpPrefix = ".import-"
+)
+
+// BundleManifest describes the files in an image bundle produced by
+// ExportImageBundle. It is stored as the last entry of the archive.
+type BundleManifest struct {
+	Format       int          `json:"format"`
+	LinskVersion string       `json:"linsk_version"`
+	Files        []BundleFile `json:"files"`
+}
+
+type BundleFile struct {
+	Name   string         `json:"name"`
+	Kind   CachedFileKind `json:"kind"`
+	Size   int64          `json:"size"`
+	SHA256 string         `json:"sha256"`
+
+	// Minisig is the detached minisign signature of a signed custom image,
+	// verified against the trusted public keys on import.
+	Minisig string `json:"minisig,omitempty"`
+}
+
+var customVMImageFileNameRegexp = regexp.MustCompile(`^custom-[0-9a-f]{16}\.qcow2$`)
+
+// bundleFileNameMatchesKind reports whether the name is the data directory
+// file name of the kind. This keeps the bundles from dropping arbitrary files
+// (like the daemon token or the instance records) into the data directory.
+func bundleFileNameMatchesKind(name string, kind CachedFileKind) bool {
+	switch kind {
+	case CachedFileKindVMImage:
+		return vmImageFileNameRegexp.MatchString(name)
+	case CachedFileKindCustomImage:
+		return customVMImageFileNameRegexp.MatchString(name)
+	case CachedFileKindBIOS:
+		return name == constants.GetAarch64EFIImageName()
+	default:
+		return false
+	}
+}
+
+func isBundleFileName(name string) bool {
+	for _, kind := range []CachedFileKind{CachedFileKindVMImage, CachedFileKindCustomImage, CachedFileKindBIOS} {
+		if bundleFileNameMatchesKind(name, kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExportImageBundle writes the VM image in use (along with the BIOS image,
+// if one is required) into a gzip-compressed tar archive that can be
+// imported on a disconnected machine with ImportImageBundle.
+func (s *Storage) ExportImageBundle(out io.Writer) (*BundleManifest, error) {
+	files, err := s.ListCachedFiles()
+	if err != nil {
+		return nil, errors.Wrap(err, "list cached files")
+	}
+
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	manifest := &BundleManifest{
+		Format:       bundleFormatVersion,
+		LinskVersion: constants.Version,
+	}
+
+	for _, f := range files {
+		// Overlays reference their base image by an absolute
+		// path, so they're not portable.
+		if !f.InUse || f.Kind == CachedFileKindOverlay {
+			continue
+		}
+
+		bf, err := writeBundleFile(tw, f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write '%v'", f.Path)
+		}
+
+		if f.Kind == CachedFileKindCustomImage {
+			sig, err := os.ReadFile(getImageSignaturePath(f.Path))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Wrapf(err, "read signature of '%v'", f.Path)
+			}
+
+			bf.Minisig = string(sig)
+		}
+
+		s.logger.Info("Exported file", "path", f.Path, "sha256", bf.SHA256)
+
+		manifest.Files = append(manifest.Files, bf)
+	}
+
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("no VM image to export, build one first")
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal manifest")
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    bundleManifestName,
+		Mode:    0600,
+		Size:    int64(len(manifestData)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write manifest header")
+	}
+
+	_, err = tw.Write(manifestData)
+	if err != nil {
+		return nil, errors.Wrap(err, "write manifest")
+	}
+
+	err = multierr.Combine(tw.Close(), gw.Close())
+	if err != nil {
+		return nil, errors.Wrap(err, "close archive")
+	}
+
+	return manifest, nil
+}
+
+func writeBundleFile(tw *tar.Writer, f CachedFile) (BundleFile, error) {
+	src, err := os.Open(filepath.Clean(f.Path))
+	if err != nil {
+		return BundleFile{}, errors.Wrap(err, "open file")
+	}
+
+	defer func() { _ = src.Close() }()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return BundleFile{}, errors.Wrap(err, "stat file")
+	}
+
+	name := filepath.Base(f.Path)
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	})
+	if err != nil {
+		return BundleFile{}, errors.Wrap(err, "write header")
+	}
+
+	h := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tw, h), src)
+	if err != nil {
+		return BundleFile{}, errors.Wrap(err, "copy file")
+	}
+
+	return BundleFile{
+		Name:   name,
+		Kind:   f.Kind,
+		Size:   stat.Size(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// ImportImageBundle extracts an image bundle produced by ExportImageBundle
+// into the data directory. All files are verified against the manifest
+// before they are moved into place. The manifest only proves that the
+// archive is consistent, so the BIOS image is checked against its known
+// hash, and the signed custom images against the built-in public keys and
+// the supplied ones. The other images are not authenticated, which is
+// logged as a warning.
+func (s *Storage) ImportImageBundle(in io.Reader, overwrite bool, publicKeys []string) (*BundleManifest, error) {
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "create gzip reader")
+	}
+
+	defer func() { _ = gr.Close() }()
+
+	tr := tar.NewReader(gr)
+
+	hashes := make(map[string]string)
+	var tmpPaths []string
+	var manifest *BundleManifest
+
+	defer func() {
+		for _, p := range tmpPaths {
+			_ = os.Remove(p)
+		}
+	}()
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, errors.Wrap(err, "read archive")
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected archive entry '%v'", hdr.Name)
+		}
+
+		if hdr.Name == bundleManifestName {
+			var buf bytes.Buffer
+			_, err = io.Copy(&buf, io.LimitReader(tr, 1<<20))
+			if err != nil {
+				return nil, errors.Wrap(err, "read manifest")
+			}
+
+			manifest = new(BundleManifest)
+			err = json.Unmarshal(buf.Bytes(), manifest)
+			if err != nil {
+				return nil, errors.Wrap(err, "unmarshal manifest")
+			}
+
+			continue
+		}
+
+		if !isBundleFileName(hdr.Name) {
+			return nil, fmt.Errorf("bad archive entry name '%v'", hdr.Name)
+		}
+
+		if _, ok := hashes[hdr.Name]; ok {
+			return nil, fmt.Errorf("duplicate archive entry '%v'", hdr.Name)
+		}
+
+		tmpPath := filepath.Join(s.path, bundleImportTmpPrefix+hdr.Name)
+		tmpPaths = append(tmpPaths, tmpPath)
+
+		sum, err := extractBundleFile(tr, tmpPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extract '%v'", hdr.Name)
+		}
+
+		hashes[hdr.Name] = sum
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("archive has no manifest")
+	}
+
+	if manifest.Format != bundleFormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format %v", manifest.Format)
+	}
+
+	listed := make(map[string]struct{})
+
+	var unauthenticated []string
+
+	for _, f := range manifest.Files {
+		if !bundleFileNameMatchesKind(f.Name, f.Kind) {
+			return nil, fmt.Errorf("file '%v' listed in manifest is not a valid '%v' file name", f.Name, f.Kind)
+		}
+
+		if _, ok := listed[f.Name]; ok {
+			return nil, fmt.Errorf("file '%v' is listed in manifest twice", f.Name)
+		}
+
+		listed[f.Name] = struct{}{}
+
+		sum, ok := hashes[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("file '%v' listed in manifest is missing", f.Name)
+		}
+
+		if sum != f.SHA256 {
+			return nil, fmt.Errorf("%w for '%v': want '%v', have '%v'", ErrHashMismatch, f.Name, f.SHA256, sum)
+		}
+
+		tmpPath := filepath.Join(s.path, bundleImportTmpPrefix+f.Name)
+
+		switch {
+		case f.Kind == CachedFileKindBIOS:
+			if want := hex.EncodeToString(constants.GetAarch64EFIImageHash()); sum != want {
+				return nil, fmt.Errorf("%w for BIOS image '%v': want '%v', have '%v'", ErrHashMismatch, f.Name, want, sum)
+			}
+		case f.Kind == CachedFileKindCustomImage && f.Minisig != "":
+			trustedComment, err := verifyFileSignature(tmpPath, []byte(f.Minisig), append(constants.GetImageSigningPublicKeys(), publicKeys...))
+			if err != nil {
+				return nil, errors.Wrapf(err, "verify signature of '%v'", f.Name)
+			}
+
+			s.logger.Info("Verified signed VM image", "name", f.Name, "comment", trustedComment)
+		default:
+			unauthenticated = append(unauthenticated, f.Name)
+		}
+	}
+
+	if len(hashes) != len(manifest.Files) {
+		return nil, fmt.Errorf("archive contains files not listed in manifest")
+	}
+
+	if len(unauthenticated) != 0 {
+		s.logger.Warn("The imported images are NOT authenticated, as they are not signed. Anyone who could modify the bundle could have modified them. Only import bundles from sources you trust.", "files", unauthenticated)
+	}
+
+	for _, f := range manifest.Files {
+		dst := filepath.Join(s.path, f.Name)
+
+		_, err := checkExistsOrRemove(dst, overwrite)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check existing '%v'", f.Name)
+		}
+
+		err = os.Rename(filepath.Join(s.path, bundleImportTmpPrefix+f.Name), dst)
+		if err != nil {
+			return nil, errors.Wrapf(err, "move '%v' into place", f.Name)
+		}
+
+		if f.Kind == CachedFileKindVMImage {
+			err = os.WriteFile(getImageHashPath(dst), []byte(f.SHA256+"\n"), 0600)
+			if err != nil {
+				return nil, errors.Wrapf(err, "record hash of '%v'", f.Name)
+			}
+		}
+
+		if f.Minisig != "" {
+			err = recordImageSignature(dst, f.Minisig)
+			if err != nil {
+				return nil, errors.Wrapf(err, "record signature of '%v'", f.Name)
+			}
+		}
+
+		s.logger.Info("Imported file", "path", dst, "sha256", f.SHA256)
+	}
+
+	return manifest, nil
+}
+
+func extractBundleFile(r io.Reader, path string) (string, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "open file")
+	}
+
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return "", errors.Wrap(err, "copy")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return "", errors.Wrap(err, "close file")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,261 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/constants"
+	"github.com/pkg/errors"
+)
+
+type CachedFileKind string
+
+const (
+	CachedFileKindVMImage     CachedFileKind = "vm-image"
+	CachedFileKindCustomImage CachedFileKind = "custom-image"
+	CachedFileKindBaseImage   CachedFileKind = "base-image"
+	CachedFileKindBIOS        CachedFileKind = "bios"
+	CachedFileKindOverlay     CachedFileKind = "overlay"
+	CachedFileKindPartial     CachedFileKind = "partial-download"
+)
+
+// CachedFile is an image (or a related artifact) stored in the data directory.
+type CachedFile struct {
+	Kind CachedFileKind `json:"kind"`
+	Path string         `json:"path"`
+	Size int64          `json:"size"`
+
+	// Version and Flavor are set for VM images only.
+	Version int    `json:"version,omitempty"`
+	Flavor  string `json:"flavor,omitempty"`
+
+	// InUse reports whether the file is used by default, i.e. it is the
+	// latest or the pinned VM image, or a BIOS image required to boot.
+	// Overlays are in use if their base image is.
+	InUse bool `json:"in_use"`
+
+	// custom is set for custom images and overlays not based on a known VM image.
+	custom bool
+}
+
+// ListCachedFiles returns the images and related artifacts stored in
+// the data directory.
+func (s *Storage) ListCachedFiles() ([]CachedFile, error) {
+	pinned, err := s.GetPinnedVMImageVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "get pinned image version")
+	}
+
+	activeVersion := LatestVMImageVersion()
+	if pinned != 0 {
+		activeVersion = pinned
+	}
+
+	vmImages, err := s.ListVMImages()
+	if err != nil {
+		return nil, errors.Wrap(err, "list vm images")
+	}
+
+	var ret []CachedFile
+	activeFound := make(map[string]bool)
+
+	for _, img := range vmImages {
+		// Only the newest Alpine build of the active version is used.
+		// Every flavor has its own active image.
+		inUse := img.Arch == constants.GetVMImageArch() && img.Version == activeVersion && !activeFound[img.Flavor]
+		if inUse {
+			activeFound[img.Flavor] = true
+		}
+
+		ret = append(ret, CachedFile{
+			Kind:    CachedFileKindVMImage,
+			Path:    img.Path,
+			Size:    img.Size,
+			Version: img.Version,
+			Flavor:  img.Flavor,
+			InUse:   inUse,
+		})
+	}
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read data dir")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+
+		var f CachedFile
+		switch {
+		case strings.HasPrefix(name, "custom-") && strings.HasSuffix(name, ".qcow2"):
+			f.Kind = CachedFileKindCustomImage
+			f.custom = true
+		case strings.HasPrefix(name, "alpine-") && strings.HasSuffix(name, ".img"):
+			f.Kind = CachedFileKindBaseImage
+		case strings.HasSuffix(name, ".part"):
+			f.Kind = CachedFileKindPartial
+		case name == constants.GetAarch64EFIImageName():
+			f.Kind = CachedFileKindBIOS
+			f.InUse = constants.GetVMImageArch() == "aarch64"
+		default:
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat '%v'", name)
+		}
+
+		f.Path = filepath.Join(s.path, name)
+		f.Size = info.Size()
+
+		ret = append(ret, f)
+	}
+
+	overlays, err := s.listCachedOverlays(ret)
+	if err != nil {
+		return nil, errors.Wrap(err, "list overlays")
+	}
+
+	ret = append(ret, overlays...)
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Kind < ret[j].Kind
+	})
+
+	return ret, nil
+}
+
+func (s *Storage) listCachedOverlays(images []CachedFile) ([]CachedFile, error) {
+	dirs, err := s.listVMOverlayDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	bases := make(map[string]CachedFile)
+	for _, img := range images {
+		if img.Kind != CachedFileKindVMImage && img.Kind != CachedFileKindCustomImage {
+			continue
+		}
+
+		p, err := s.getVMOverlayPath(img.Path, "")
+		if err != nil {
+			return nil, err
+		}
+
+		bases[filepath.Base(p)] = img
+	}
+
+	var ret []CachedFile
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "read overlays dir")
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".qcow2" {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil, errors.Wrapf(err, "stat '%v'", entry.Name())
+			}
+
+			f := CachedFile{
+				Kind: CachedFileKindOverlay,
+				Path: filepath.Join(dir, entry.Name()),
+				Size: info.Size(),
+			}
+
+			// The overlay names of the named instances are the same, only the directories differ.
+			base, ok := bases[entry.Name()]
+			if ok {
+				f.Version = base.Version
+				f.Flavor = base.Flavor
+				f.InUse = base.InUse
+				f.custom = base.custom
+			} else {
+				// Likely an overlay of a custom image outside the data directory.
+				f.custom = true
+			}
+
+			ret = append(ret, f)
+		}
+	}
+
+	return ret, nil
+}
+
+// PruneCachedFiles removes cached files that are not in use. Custom images
+// (and their overlays) are only removed if includeCustom is set, as there
+// is no way to tell whether they are still needed.
+func (s *Storage) PruneCachedFiles(includeCustom bool, dryRun bool) ([]CachedFile, error) {
+	files, err := s.ListCachedFiles()
+	if err != nil {
+		return nil, errors.Wrap(err, "list cached files")
+	}
+
+	var pruned []CachedFile
+
+	for _, f := range files {
+		if f.InUse || (f.custom && !includeCustom) {
+			continue
+		}
+
+		if !dryRun {
+			// Downloaded images are read-only, which prevents
+			// their removal on Windows.
+			_ = os.Chmod(f.Path, 0600)
+
+			err = os.Remove(f.Path)
+			if err != nil {
+				return pruned, errors.Wrapf(err, "remove '%v'", f.Path)
+			}
+
+			if f.Kind == CachedFileKindVMImage {
+				err = removeImageHash(f.Path)
+				if err != nil {
+					return pruned, errors.Wrapf(err, "remove hash of '%v'", f.Path)
+				}
+			}
+
+			if f.Kind == CachedFileKindCustomImage {
+				err = os.Remove(getImageSignaturePath(f.Path))
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return pruned, errors.Wrapf(err, "remove signature of '%v'", f.Path)
+				}
+			}
+
+			s.logger.Info("Removed cached file", "path", f.Path, "kind", f.Kind)
+		}
+
+		pruned = append(pruned, f)
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,300 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/constants"
+	"github.com/pkg/errors"
+)
+
+// CustomVMImageConfig describes a user-supplied prebuilt VM image that is
+// used instead of the one produced by `linsk build`.
+type CustomVMImageConfig struct {
+	// Path points to a local qcow2 image. Mutually exclusive with URL.
+	Path string
+	// URL points to a qcow2 image that will be downloaded into the data directory.
+	URL string
+	// MetadataURL points to image release metadata (see ImageReleaseMetadata)
+	// that supplies the image URL along with a detached minisign signature.
+	// Mutually exclusive with Path and URL.
+	MetadataURL string
+
+	// PublicKeys are additional minisign public keys trusted to sign
+	// images resolved via MetadataURL. The built-in keys are always trusted.
+	PublicKeys []string
+
+	// SHA256 is an optional hex-encoded hash of the image. When set,
+	// the image is verified against it before every use.
+	SHA256 string
+}
+
+func (c CustomVMImageConfig) IsSet() bool {
+	return c.Path != "" || c.URL != "" || c.MetadataURL != ""
+}
+
+func (c CustomVMImageConfig) decodeHash() ([]byte, error) {
+	if c.SHA256 == "" {
+		return nil, nil
+	}
+
+	hash, err := hex.DecodeString(strings.TrimSpace(c.SHA256))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode hex")
+	}
+
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("bad sha256 hash length: want %v bytes, have %v", sha256.Size, len(hash))
+	}
+
+	return hash, nil
+}
+
+func (s *Storage) getCustomVMImageDownloadPath(url string) string {
+	urlHash := sha256.Sum256([]byte(url))
+	return filepath.Join(s.path, "custom-"+hex.EncodeToString(urlHash[:8])+".qcow2")
+}
+
+// CheckCustomVMImage resolves the custom VM image to a local path,
+// downloading it first if a URL was provided. Images resolved via
+// release metadata must carry a valid signature. Otherwise, if no hash
+// was supplied, the image is used as-is and a warning is logged.
+func (s *Storage) CheckCustomVMImage(ctx context.Context, c CustomVMImageConfig) (string, error) {
+	sources := 0
+	for _, v := range []string{c.Path, c.URL, c.MetadataURL} {
+		if v != "" {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return "", fmt.Errorf("custom image path, url and metadata url are mutually exclusive")
+	}
+
+	if c.MetadataURL != "" {
+		return s.checkSignedVMImage(ctx, c)
+	}
+
+	hash, err := c.decodeHash()
+	if err != nil {
+		return "", errors.Wrap(err, "decode custom image hash")
+	}
+
+	var imagePath string
+
+	switch {
+	case c.Path != "":
+		imagePath = filepath.Clean(c.Path)
+
+		stat, err := os.Stat(imagePath)
+		if err != nil {
+			return "", errors.Wrap(err, "stat custom image path")
+		}
+
+		if stat.IsDir() {
+			return "", fmt.Errorf("custom image path '%v' is a directory", imagePath)
+		}
+	case c.URL != "":
+		imagePath = s.getCustomVMImageDownloadPath(c.URL)
+
+		exists, err := s.checkCachedFileIntegrity(imagePath, hash)
+		if err != nil {
+			return "", errors.Wrap(err, "check downloaded custom image")
+		}
+
+		if !exists {
+			// Image hasn't been downloaded yet (or was corrupted). The hash is verified while downloading.
+			err = s.download(ctx, c.URL, hash, imagePath, nil)
+			if err != nil {
+				return "", errors.Wrap(err, "download custom image")
+			}
+		}
+
+		// The hash has been verified either way.
+		hash = nil
+	default:
+		return "", fmt.Errorf("no custom image specified")
+	}
+
+	if c.SHA256 == "" {
+		s.logger.Warn("Using a custom VM image without hash pinning, its integrity is not verified", "path", imagePath)
+	} else if hash != nil {
+		err = validateFileHash(imagePath, hash)
+		if err != nil {
+			return "", errors.Wrap(err, "validate custom image hash")
+		}
+	}
+
+	s.logger.Info("Using custom VM image", "path", imagePath)
+
+	return imagePath, nil
+}
+
+// PlanCustomVMImage is the dry-run counterpart of CheckCustomVMImage. It
+// returns the path the image would be used from and, if the image has yet
+// to be downloaded, the URL it would be downloaded from. Only the release
+// metadata is fetched, nothing is downloaded or written.
+func (s *Storage) PlanCustomVMImage(ctx context.Context, c CustomVMImageConfig) (string, string, error) {
+	hash, err := c.decodeHash()
+	if err != nil {
+		return "", "", errors.Wrap(err, "decode custom image hash")
+	}
+
+	var url string
+
+	switch {
+	case c.Path != "" && c.URL == "" && c.MetadataURL == "":
+		return filepath.Clean(c.Path), "", nil
+	case c.URL != "" && c.Path == "" && c.MetadataURL == "":
+		url = c.URL
+	case c.MetadataURL != "" && c.Path == "" && c.URL == "":
+		img, err := s.resolveImageReleaseMetadata(ctx, c.MetadataURL)
+		if err != nil {
+			return "", "", errors.Wrap(err, "resolve image release metadata")
+		}
+
+		url = img.URL
+
+		// The signature check covers the image contents.
+		hash = nil
+	default:
+		return "", "", fmt.Errorf("exactly one of custom image path, url and metadata url must be set")
+	}
+
+	imagePath := s.getCustomVMImageDownloadPath(url)
+
+	exists, err := isCachedFileValid(imagePath, hash)
+	if err != nil {
+		return "", "", errors.Wrap(err, "check downloaded custom image")
+	}
+
+	if exists {
+		return imagePath, "", nil
+	}
+
+	return imagePath, url, nil
+}
+
+func (s *Storage) checkSignedVMImage(ctx context.Context, c CustomVMImageConfig) (string, error) {
+	img, err := s.resolveImageReleaseMetadata(ctx, c.MetadataURL)
+	if err != nil {
+		return "", errors.Wrap(err, "resolve image release metadata")
+	}
+
+	if c.SHA256 != "" && img.SHA256 != "" && !strings.EqualFold(strings.TrimSpace(c.SHA256), img.SHA256) {
+		return "", fmt.Errorf("%w: user-supplied hash does not match the one in release metadata", ErrHashMismatch)
+	}
+
+	if c.SHA256 == "" {
+		c.SHA256 = img.SHA256
+	}
+
+	hash, err := c.decodeHash()
+	if err != nil {
+		return "", errors.Wrap(err, "decode image hash")
+	}
+
+	publicKeys := append(constants.GetImageSigningPublicKeys(), c.PublicKeys...)
+
+	imagePath := s.getCustomVMImageDownloadPath(img.URL)
+
+	var seedPath string
+
+	_, err = os.Stat(imagePath)
+	if err == nil {
+		_, err = verifyFileSignature(imagePath, []byte(img.Minisig), publicKeys)
+		if err == nil {
+			err = recordImageSignature(imagePath, img.Minisig)
+			if err != nil {
+				return "", errors.Wrap(err, "record image signature")
+			}
+
+			s.logger.Info("Using signed VM image", "path", imagePath)
+			return imagePath, nil
+		}
+
+		// The release was likely updated in place. Fetch the new image,
+		// reusing the stale one as a delta seed.
+		s.logger.Warn("Cached signed VM image failed verification, downloading it again", "path", imagePath, "error", err.Error())
+
+		seedPath = imagePath + ".seed"
+
+		err = os.Rename(imagePath, seedPath)
+		if err != nil {
+			return "", errors.Wrap(err, "move stale image")
+		}
+
+		defer func() {
+			// Downloaded images are read-only, which prevents
+			// their removal on Windows.
+			_ = os.Chmod(seedPath, 0600)
+			_ = os.Remove(seedPath)
+		}()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", errors.Wrap(err, "stat downloaded image path")
+	}
+
+	if seedPath == "" {
+		seedPath, err = s.findDeltaSeed(imagePath)
+		if err != nil {
+			return "", errors.Wrap(err, "find delta seed")
+		}
+	}
+
+	var deltaDone bool
+
+	if img.BlockIndexURL != "" && seedPath != "" {
+		err = s.downloadDelta(ctx, img.URL, img.BlockIndexURL, hash, seedPath, imagePath)
+		if err != nil {
+			s.logger.Warn("Delta download failed, falling back to full download", "error", err.Error())
+		} else {
+			deltaDone = true
+		}
+	}
+
+	if !deltaDone {
+		err = s.download(ctx, img.URL, hash, imagePath, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "download signed image")
+		}
+	}
+
+	trustedComment, err := verifyFileSignature(imagePath, []byte(img.Minisig), publicKeys)
+	if err != nil {
+		// Downloaded images are read-only, which prevents
+		// their removal on Windows.
+		_ = os.Chmod(imagePath, 0600)
+		_ = os.Remove(imagePath)
+		return "", errors.Wrap(err, "verify image signature")
+	}
+
+	err = recordImageSignature(imagePath, img.Minisig)
+	if err != nil {
+		return "", errors.Wrap(err, "record image signature")
+	}
+
+	s.logger.Info("Verified signed VM image", "path", imagePath, "comment", trustedComment)
+
+	return imagePath, nil
+}
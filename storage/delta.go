@@ -0,0 +1,359 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/progress"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultBlockIndexBlockSize matches the default qcow2 cluster size,
+	// so unchanged clusters line up between image releases.
+	DefaultBlockIndexBlockSize = 64 << 10
+
+	maxBlockIndexSize = 64 << 20
+	// maxDeltaRangeBlocks limits the size of a single HTTP range request.
+	maxDeltaRangeBlocks = 64
+)
+
+// BlockIndex lists the SHA-256 hashes of fixed-size blocks of an image.
+// Published alongside an image, it allows clients to download only the
+// blocks that are not present in a previously downloaded image.
+type BlockIndex struct {
+	BlockSize int      `json:"block_size"`
+	Size      int64    `json:"size"`
+	Blocks    []string `json:"blocks"`
+}
+
+// BuildBlockIndex computes the block index of the image read from r.
+func BuildBlockIndex(r io.Reader, blockSize int) (*BlockIndex, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("bad block size %v", blockSize)
+	}
+
+	idx := &BlockIndex{
+		BlockSize: blockSize,
+	}
+
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			sum := sha256.Sum256(block[:n])
+			idx.Blocks = append(idx.Blocks, hex.EncodeToString(sum[:]))
+			idx.Size += int64(n)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+
+			return nil, errors.Wrap(err, "read block")
+		}
+	}
+
+	return idx, nil
+}
+
+func (idx *BlockIndex) validate() error {
+	if idx.BlockSize <= 0 {
+		return fmt.Errorf("bad block size %v", idx.BlockSize)
+	}
+
+	wantBlocks := (idx.Size + int64(idx.BlockSize) - 1) / int64(idx.BlockSize)
+	if int64(len(idx.Blocks)) != wantBlocks {
+		return fmt.Errorf("block count mismatch: want %v, have %v", wantBlocks, len(idx.Blocks))
+	}
+
+	return nil
+}
+
+func (idx *BlockIndex) blockLen(i int) int64 {
+	if i == len(idx.Blocks)-1 {
+		return idx.Size - int64(i)*int64(idx.BlockSize)
+	}
+
+	return int64(idx.BlockSize)
+}
+
+func (s *Storage) fetchBlockIndex(ctx context.Context, url string) (*BlockIndex, error) {
+	if s.offline {
+		return nil, errors.Wrapf(ErrOffline, "fetch '%v'", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create new http get request")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "http get")
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad http status: %v", resp.Status)
+	}
+
+	var idx BlockIndex
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxBlockIndexSize)).Decode(&idx)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json")
+	}
+
+	err = idx.validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "validate block index")
+	}
+
+	return &idx, nil
+}
+
+// indexSeedBlocks maps the block hashes of the seed file to their offsets.
+func indexSeedBlocks(seedPath string, blockSize int) (map[string]int64, error) {
+	f, err := os.Open(filepath.Clean(seedPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "open seed")
+	}
+
+	defer func() { _ = f.Close() }()
+
+	idx, err := BuildBlockIndex(f, blockSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "build seed block index")
+	}
+
+	ret := make(map[string]int64, len(idx.Blocks))
+	for i, sum := range idx.Blocks {
+		if _, ok := ret[sum]; !ok {
+			ret[sum] = int64(i) * int64(blockSize)
+		}
+	}
+
+	return ret, nil
+}
+
+// findDeltaSeed returns the most recently modified downloaded custom
+// image other than exclude, or an empty string if there is none.
+func (s *Storage) findDeltaSeed(exclude string) (string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return "", errors.Wrap(err, "read data dir")
+	}
+
+	var seed string
+	var seedModTime int64
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "custom-") || !strings.HasSuffix(name, ".qcow2") {
+			continue
+		}
+
+		p := filepath.Join(s.path, name)
+		if p == exclude {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return "", errors.Wrapf(err, "stat '%v'", name)
+		}
+
+		if info.ModTime().UnixNano() > seedModTime {
+			seed = p
+			seedModTime = info.ModTime().UnixNano()
+		}
+	}
+
+	return seed, nil
+}
+
+// downloadDelta reconstructs the image at url into out, copying the blocks
+// that are present in the seed file and fetching only the remaining ones
+// with HTTP range requests.
+func (s *Storage) downloadDelta(ctx context.Context, url string, indexURL string, hash []byte, seedPath string, out string) error {
+	outClean := filepath.Clean(out)
+
+	idx, err := s.fetchBlockIndex(ctx, indexURL)
+	if err != nil {
+		return errors.Wrap(err, "fetch block index")
+	}
+
+	seedBlocks, err := indexSeedBlocks(seedPath, idx.BlockSize)
+	if err != nil {
+		return errors.Wrap(err, "index seed blocks")
+	}
+
+	seed, err := os.Open(filepath.Clean(seedPath))
+	if err != nil {
+		return errors.Wrap(err, "open seed")
+	}
+
+	defer func() { _ = seed.Close() }()
+
+	f, err := os.OpenFile(outClean, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	var success bool
+
+	defer func() {
+		_ = f.Close()
+		if !success {
+			_ = os.Remove(outClean)
+		}
+	}()
+
+	s.logger.Info("Starting delta download", "from", url, "to", outClean, "seed", seedPath)
+
+	var reused, fetched int64
+
+	block := make([]byte, idx.BlockSize)
+
+	for i := 0; i < len(idx.Blocks); {
+		if seedOffset, ok := seedBlocks[idx.Blocks[i]]; ok {
+			n := idx.blockLen(i)
+
+			_, err := seed.ReadAt(block[:n], seedOffset)
+			if err != nil {
+				return errors.Wrapf(err, "read seed block at %v", seedOffset)
+			}
+
+			_, err = f.WriteAt(block[:n], int64(i)*int64(idx.BlockSize))
+			if err != nil {
+				return errors.Wrapf(err, "write block #%v", i)
+			}
+
+			reused += n
+			i++
+
+			continue
+		}
+
+		// Coalesce consecutive missing blocks into a single range request.
+		end := i + 1
+		for end < len(idx.Blocks) && end-i < maxDeltaRangeBlocks {
+			if _, ok := seedBlocks[idx.Blocks[end]]; ok {
+				break
+			}
+			end++
+		}
+
+		n, err := s.fetchBlockRange(ctx, url, idx, i, end, f)
+		if err != nil {
+			return errors.Wrapf(err, "fetch blocks #%v-#%v", i, end-1)
+		}
+
+		fetched += n
+		i = end
+
+		if s.progress != nil {
+			s.progress(progress.Update{
+				Op:    progress.OpDownload,
+				Stage: "delta",
+				Name:  filepath.Base(outClean),
+				Done:  int64(i) * int64(idx.BlockSize),
+				Total: idx.Size,
+				Unit:  progress.UnitBytes,
+			})
+		} else {
+			s.logger.Info("Downloading image delta", "out", outClean, "fetched", humanize.Bytes(uint64(fetched)), "reused", humanize.Bytes(uint64(reused)))
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close file")
+	}
+
+	if hash != nil {
+		err = validateFileHash(outClean, hash)
+		if err != nil {
+			return errors.Wrap(err, "validate reconstructed image hash")
+		}
+	}
+
+	s.logger.Info("Successfully downloaded image delta", "from", url, "to", outClean, "fetched", humanize.Bytes(uint64(fetched)), "reused", humanize.Bytes(uint64(reused)))
+
+	success = true
+
+	return nil
+}
+
+func (s *Storage) fetchBlockRange(ctx context.Context, url string, idx *BlockIndex, start int, end int, f *os.File) (int64, error) {
+	startOffset := int64(start) * int64(idx.BlockSize)
+	endOffset := int64(end-1)*int64(idx.BlockSize) + idx.blockLen(end-1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "create new http get request")
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", startOffset, endOffset-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "http get")
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("server does not support range requests: %v", resp.Status)
+	}
+
+	block := make([]byte, idx.BlockSize)
+
+	for i := start; i < end; i++ {
+		n := idx.blockLen(i)
+
+		_, err := io.ReadFull(resp.Body, block[:n])
+		if err != nil {
+			return 0, errors.Wrapf(err, "read block #%v", i)
+		}
+
+		sum := sha256.Sum256(block[:n])
+		if hex.EncodeToString(sum[:]) != idx.Blocks[i] {
+			return 0, fmt.Errorf("%w: block #%v", ErrHashMismatch, i)
+		}
+
+		_, err = f.WriteAt(block[:n], int64(i)*int64(idx.BlockSize))
+		if err != nil {
+			return 0, errors.Wrapf(err, "write block #%v", i)
+		}
+	}
+
+	return endOffset - startOffset, nil
+}
@@ -0,0 +1,86 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const discoveredDevicesFileName = "discovered-devices.json"
+
+func (s *Storage) getDiscoveredDevicesFilePath() string {
+	return filepath.Join(s.path, discoveredDevicesFileName)
+}
+
+func (s *Storage) readDiscoveredDevices() (map[string][]string, error) {
+	data, err := os.ReadFile(s.getDiscoveredDevicesFilePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string][]string{}, nil
+		}
+
+		return nil, errors.Wrap(err, "read discovered devices file")
+	}
+
+	ret := make(map[string][]string)
+
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal discovered devices file")
+	}
+
+	return ret, nil
+}
+
+// SaveDiscoveredDevices records the in-VM device names found on the
+// passthrough target, so that they can be offered in shell completions
+// without starting a VM.
+func (s *Storage) SaveDiscoveredDevices(passthroughArg string, devNames []string) error {
+	devs, err := s.readDiscoveredDevices()
+	if err != nil {
+		return err
+	}
+
+	devs[passthroughArg] = devNames
+
+	data, err := json.Marshal(devs)
+	if err != nil {
+		return errors.Wrap(err, "marshal discovered devices")
+	}
+
+	err = os.WriteFile(s.getDiscoveredDevicesFilePath(), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "write discovered devices file")
+	}
+
+	return nil
+}
+
+// GetDiscoveredDevices returns the in-VM device names last recorded with
+// SaveDiscoveredDevices for the passthrough target.
+func (s *Storage) GetDiscoveredDevices(passthroughArg string) ([]string, error) {
+	devs, err := s.readDiscoveredDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	return devs[passthroughArg], nil
+}
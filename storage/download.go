@@ -29,20 +29,49 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/AlexSSD7/linsk/progress"
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 )
 
-func (s *Storage) download(ctx context.Context, url string, hash []byte, out string, applyReaderMiddleware func(io.Reader) io.Reader) error {
-	outClean := filepath.Clean(out)
+// downloadWithFailover tries the URLs in order until one succeeds.
+// The hash ensures that all mirrors serve the same file.
+func (s *Storage) downloadWithFailover(ctx context.Context, urls []string, hash []byte, out string, applyReaderMiddleware func(io.Reader) io.Reader) error {
+	var lastErr error
+
+	for i, url := range urls {
+		lastErr = s.download(ctx, url, hash, out, applyReaderMiddleware)
+		if lastErr == nil {
+			return nil
+		}
 
-	var created, success bool
+		if errors.Is(lastErr, ErrOffline) || ctx.Err() != nil {
+			return lastErr
+		}
 
-	defer func() {
-		if created && !success {
-			_ = os.Remove(outClean)
+		if i != len(urls)-1 {
+			s.logger.Warn("Download failed, trying the next mirror", "url", url, "error", lastErr.Error())
 		}
-	}()
+	}
+
+	return errors.Wrapf(lastErr, "all %v mirrors failed, last error", len(urls))
+}
+
+func getPartialDownloadPath(out string) string {
+	return out + ".part"
+}
+
+// download fetches the URL into out. The data is written into a ".part" file
+// first, which is kept if the download is interrupted. The next attempt
+// resumes it with an HTTP range request. Resuming is not possible with a
+// reader middleware, as it transforms the stream (e.g. decompresses it).
+func (s *Storage) download(ctx context.Context, url string, hash []byte, out string, applyReaderMiddleware func(io.Reader) io.Reader) error {
+	outClean := filepath.Clean(out)
+	partPath := getPartialDownloadPath(outClean)
+
+	if s.offline {
+		return errors.Wrapf(ErrOffline, "download '%v'", url)
+	}
 
 	_, err := os.Stat(outClean)
 	if err == nil {
@@ -51,21 +80,26 @@ func (s *Storage) download(ctx context.Context, url string, hash []byte, out str
 		return errors.Wrap(err, "stat out path")
 	}
 
-	f, err := os.OpenFile(outClean, os.O_CREATE|os.O_WRONLY, 0400)
-	if err != nil {
-		return errors.Wrap(err, "open file")
+	var resumeFrom int64
+	if applyReaderMiddleware == nil {
+		stat, err := os.Stat(partPath)
+		if err == nil {
+			resumeFrom = stat.Size()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "stat partial download path")
+		}
 	}
 
-	created = true
-
-	defer func() { _ = f.Close() }()
-
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return errors.Wrap(err, "create new http get request")
 	}
 
-	s.logger.Info("Starting to download file", "from", url, "to", outClean)
+	if resumeFrom != 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", resumeFrom))
+	}
+
+	s.logger.Info("Starting to download file", "from", url, "to", outClean, "resume-from", humanize.Bytes(uint64(resumeFrom)))
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -74,7 +108,34 @@ func (s *Storage) download(ctx context.Context, url string, hash []byte, out str
 
 	defer func() { _ = resp.Body.Close() }()
 
+	openFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	switch {
+	case resumeFrom != 0 && resp.StatusCode == http.StatusPartialContent:
+		openFlags = os.O_WRONLY | os.O_APPEND
+	case resumeFrom != 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial download is likely complete already.
+		return s.finishDownload(partPath, outClean, hash)
+	case resp.StatusCode == http.StatusOK:
+		if resumeFrom != 0 {
+			s.logger.Warn("Server does not support resuming downloads, starting over", "from", url)
+			resumeFrom = 0
+		}
+	default:
+		return fmt.Errorf("bad http status: %v", resp.Status)
+	}
+
+	f, err := os.OpenFile(partPath, openFlags, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open partial download file")
+	}
+
+	defer func() { _ = f.Close() }()
+
 	knownSize := resp.ContentLength
+	if knownSize > 0 {
+		knownSize += resumeFrom
+	}
 
 	var readFrom io.Reader
 	if applyReaderMiddleware != nil {
@@ -84,7 +145,27 @@ func (s *Storage) download(ctx context.Context, url string, hash []byte, out str
 		readFrom = resp.Body
 	}
 
-	n, err := copyWithProgressAndHash(f, readFrom, 1024, hash, func(downloaded int) {
+	// The streaming hash covers only the fresh downloads. The resumed ones
+	// are verified as a whole once complete.
+	streamHash := hash
+	if resumeFrom != 0 {
+		streamHash = nil
+	}
+
+	n, err := copyWithProgressAndHash(f, readFrom, 1024, streamHash, func(downloaded int) {
+		downloaded += int(resumeFrom)
+
+		if s.progress != nil {
+			s.progress(progress.Update{
+				Op:    progress.OpDownload,
+				Name:  filepath.Base(outClean),
+				Done:  int64(downloaded),
+				Total: knownSize,
+				Unit:  progress.UnitBytes,
+			})
+			return
+		}
+
 		var percent float64
 		if knownSize != 0 {
 			percent = float64(downloaded) / float64(knownSize)
@@ -98,12 +179,57 @@ func (s *Storage) download(ctx context.Context, url string, hash []byte, out str
 		}
 	})
 	if err != nil {
+		if applyReaderMiddleware != nil || errors.Is(err, ErrHashMismatch) {
+			// Not resumable.
+			_ = f.Close()
+			_ = os.Remove(partPath)
+		} else {
+			s.logger.Warn("Download interrupted, it will be resumed on the next attempt", "path", partPath)
+		}
+
 		return errors.Wrap(err, "copy resp to file")
 	}
 
-	s.logger.Info("Successfully downloaded file", "from", url, "to", outClean, "out-size", humanize.Bytes(uint64(n)))
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close partial download file")
+	}
+
+	if streamHash != nil {
+		// Already verified while downloading.
+		hash = nil
+	}
+
+	err = s.finishDownload(partPath, outClean, hash)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Successfully downloaded file", "from", url, "to", outClean, "out-size", humanize.Bytes(uint64(resumeFrom)+uint64(n)))
+
+	return nil
+}
 
-	success = true
+// finishDownload verifies the partial download (if the hash is not nil)
+// and moves it into place.
+func (s *Storage) finishDownload(partPath string, out string, hash []byte) error {
+	if hash != nil {
+		err := validateFileHash(partPath, hash)
+		if err != nil {
+			_ = os.Remove(partPath)
+			return errors.Wrap(err, "validate downloaded file hash")
+		}
+	}
+
+	err := os.Chmod(partPath, 0400)
+	if err != nil {
+		return errors.Wrap(err, "chmod downloaded file")
+	}
+
+	err = os.Rename(partPath, out)
+	if err != nil {
+		return errors.Wrap(err, "move downloaded file into place")
+	}
 
 	return nil
 }
@@ -117,6 +243,7 @@ func copyWithProgressAndHash(dst io.Writer, src io.Reader, blockSize int, wantHa
 	}
 
 	var progress int
+	var lastReported int
 
 	for {
 		read, err := src.Read(block)
@@ -140,15 +267,19 @@ func copyWithProgressAndHash(dst io.Writer, src io.Reader, blockSize int, wantHa
 			return progress, errors.Wrap(err, "read")
 		}
 
-		if progress%1000000 == 0 {
+		// Reads are not block-aligned, hence the check for crossing a megabyte boundary.
+		if progress/1000000 != lastReported/1000000 {
 			report(progress)
+			lastReported = progress
 		}
 	}
 
+	report(progress)
+
 	if h != nil {
 		sum := h.Sum(nil)
 		if !bytes.Equal(sum, wantHash) {
-			return progress, fmt.Errorf("hash mismach: want '%v', have '%v'", hex.EncodeToString(wantHash), hex.EncodeToString(sum))
+			return progress, fmt.Errorf("%w: want '%v', have '%v'", ErrHashMismatch, hex.EncodeToString(wantHash), hex.EncodeToString(sum))
 		}
 	}
 
@@ -20,4 +20,13 @@ import "errors"
 
 var (
 	ErrImageAlreadyExists = errors.New("image already exists")
+	ErrOffline            = errors.New("network access is required but offline mode is enabled")
+
+	// ErrHashMismatch is returned when a downloaded or imported
+	// file does not match the expected SHA256 hash.
+	ErrHashMismatch = errors.New("hash mismatch")
+
+	// ErrSignatureInvalid is returned when a file is not signed
+	// by any of the trusted keys, or the signature does not match.
+	ErrSignatureInvalid = errors.New("signature invalid")
 )
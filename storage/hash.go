@@ -28,36 +28,34 @@ import (
 	"github.com/pkg/errors"
 )
 
-func validateFileHash(path string, hash []byte) error {
-	pathClean := filepath.Clean(path)
-
-	f, err := os.OpenFile(pathClean, os.O_RDONLY, 0400)
+func computeFileHash(path string) ([]byte, error) {
+	f, err := os.Open(filepath.Clean(path))
 	if err != nil {
-		return errors.Wrap(err, "open file")
+		return nil, errors.Wrap(err, "open file")
 	}
 
 	defer func() { _ = f.Close() }()
 
 	h := sha256.New()
-	block := make([]byte, 1024)
-	for {
-		read, err := f.Read(block)
-		if read > 0 {
-			h.Write(block[:read])
-		}
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
 
-			return errors.Wrap(err, "read file block")
-		}
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
 	}
 
-	sum := h.Sum(nil)
+	return h.Sum(nil), nil
+}
+
+func validateFileHash(path string, hash []byte) error {
+	pathClean := filepath.Clean(path)
+
+	sum, err := computeFileHash(pathClean)
+	if err != nil {
+		return err
+	}
 
 	if !bytes.Equal(sum, hash) {
-		return fmt.Errorf("hash mismatch: want '%v', have '%v' (path '%v')", hex.EncodeToString(hash), hex.EncodeToString(sum), pathClean)
+		return fmt.Errorf("%w: want '%v', have '%v' (path '%v')", ErrHashMismatch, hex.EncodeToString(hash), hex.EncodeToString(sum), pathClean)
 	}
 
 	return nil
@@ -0,0 +1,261 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	instancesDirName       = "instances"
+	instanceFileExt        = ".json"
+	instanceCredsFileExt   = ".creds"
+	instanceLogFileExt     = ".log"
+	instanceFileMode       = 0600
+	instancesDirFileMode   = 0700
+	maxInstanceIDLength    = 128
+	instanceIDAllowedChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+)
+
+// Instance is a running Linsk instance, as recorded in the runtime registry.
+type Instance struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Target    string    `json:"target,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+
+	AttachedDevices []InstanceDevice `json:"attached_devices"`
+	Mounts          []InstanceMount  `json:"mounts"`
+	Shares          []InstanceShare  `json:"shares"`
+
+	// CredentialsPath is the file holding the share credentials,
+	// readable only by the user running the instance.
+	CredentialsPath string `json:"credentials_path,omitempty"`
+}
+
+type InstanceDevice struct {
+	DevName string `json:"dev_name"`
+	Source  string `json:"source"`
+}
+
+type InstanceMount struct {
+	Device   string `json:"device"`
+	FSType   string `json:"fs_type,omitempty"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+type InstanceShare struct {
+	Backend string `json:"backend"`
+	URL     string `json:"url"`
+}
+
+func validateInstanceID(id string) error {
+	if id == "" || len(id) > maxInstanceIDLength || strings.Trim(id, instanceIDAllowedChars) != "" || strings.HasPrefix(id, ".") {
+		return errors.Errorf("bad instance id '%v'", id)
+	}
+
+	return nil
+}
+
+func (s *Storage) getInstancesDirPath() string {
+	return filepath.Join(s.path, instancesDirName)
+}
+
+func (s *Storage) getInstanceFilePath(id string, ext string) (string, error) {
+	err := validateInstanceID(id)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.getInstancesDirPath(), id+ext), nil
+}
+
+// SaveInstance records the instance in the runtime registry,
+// overwriting the previous record of it.
+func (s *Storage) SaveInstance(inst *Instance) error {
+	p, err := s.getInstanceFilePath(inst.ID, instanceFileExt)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.getInstancesDirPath(), instancesDirFileMode)
+	if err != nil {
+		return errors.Wrap(err, "create instances dir")
+	}
+
+	data, err := json.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal instance")
+	}
+
+	// Writing to a temporary file first so that the readers never see a partially written record.
+	tmpPath := p + ".tmp"
+
+	err = os.WriteFile(tmpPath, data, instanceFileMode)
+	if err != nil {
+		return errors.Wrap(err, "write instance file")
+	}
+
+	err = os.Rename(tmpPath, p)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(err, "move instance file into place")
+	}
+
+	return nil
+}
+
+// SaveInstanceCredentials writes the share credentials of the instance
+// into a file readable only by the current user, and returns its path.
+func (s *Storage) SaveInstanceCredentials(id string, creds string) (string, error) {
+	p, err := s.getInstanceFilePath(id, instanceCredsFileExt)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(s.getInstancesDirPath(), instancesDirFileMode)
+	if err != nil {
+		return "", errors.Wrap(err, "create instances dir")
+	}
+
+	err = os.WriteFile(p, []byte(creds), instanceFileMode)
+	if err != nil {
+		return "", errors.Wrap(err, "write credentials file")
+	}
+
+	return p, nil
+}
+
+// CreateInstanceLog creates (or truncates) the output log of a detached
+// instance and opens it for writing.
+func (s *Storage) CreateInstanceLog(id string) (*os.File, error) {
+	p, err := s.GetInstanceLogPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(s.getInstancesDirPath(), instancesDirFileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "create instances dir")
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, instanceFileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "open instance log file")
+	}
+
+	return f, nil
+}
+
+// GetInstanceLogPath returns the path to the output log of a detached instance.
+// The log is kept after the instance exits, until it is detached again.
+func (s *Storage) GetInstanceLogPath(id string) (string, error) {
+	return s.getInstanceFilePath(id, instanceLogFileExt)
+}
+
+// RemoveInstance removes the instance and its credentials from the runtime registry.
+func (s *Storage) RemoveInstance(id string) error {
+	for _, ext := range []string{instanceFileExt, instanceCredsFileExt} {
+		p, err := s.getInstanceFilePath(id, ext)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "remove '%v'", p)
+		}
+	}
+
+	return nil
+}
+
+// ListInstances returns the running instances, oldest first. The records
+// left behind by the instances that are no longer running are removed.
+func (s *Storage) ListInstances() ([]Instance, error) {
+	entries, err := os.ReadDir(s.getInstancesDirPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "read instances dir")
+	}
+
+	var ret []Instance
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), instanceFileExt)
+		if !ok || entry.IsDir() || validateInstanceID(id) != nil {
+			continue
+		}
+
+		p := filepath.Join(s.getInstancesDirPath(), entry.Name())
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			// Removed in the meantime, like the stale
+			// records by another process listing them.
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, errors.Wrapf(err, "read instance file '%v'", p)
+		}
+
+		var inst Instance
+
+		err = json.Unmarshal(data, &inst)
+		if err != nil {
+			s.logger.Warn("Skipping a malformed instance record", "path", p, "error", err.Error())
+			continue
+		}
+
+		running, err := process.PidExists(int32(inst.PID))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check whether instance process %v exists", inst.PID)
+		}
+
+		if !running || inst.ID != id {
+			s.logger.Debug("Removing a stale instance record", "path", p)
+
+			err := s.RemoveInstance(id)
+			if err != nil {
+				s.logger.Warn("Failed to remove a stale instance record", "path", p, "error", err.Error())
+			}
+
+			continue
+		}
+
+		ret = append(ret, inst)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StartedAt.Before(ret[j].StartedAt)
+	})
+
+	return ret, nil
+}
@@ -0,0 +1,168 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrImageCorrupted = errors.New("image is corrupted")
+
+func getImageHashPath(imagePath string) string {
+	return imagePath + ".sha256"
+}
+
+func getImageSignaturePath(imagePath string) string {
+	return imagePath + ".minisig"
+}
+
+// recordImageSignature saves the verified signature of a signed
+// image next to it, so that it can be exported along with it.
+func recordImageSignature(imagePath string, minisig string) error {
+	err := os.WriteFile(getImageSignaturePath(imagePath), []byte(minisig), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write signature file")
+	}
+
+	return nil
+}
+
+// recordImageHash saves the hash of a locally built (or imported) image
+// next to it, so that it can be verified before every boot.
+func recordImageHash(imagePath string) error {
+	sum, err := computeFileHash(imagePath)
+	if err != nil {
+		return errors.Wrap(err, "compute hash")
+	}
+
+	err = os.WriteFile(getImageHashPath(imagePath), []byte(hex.EncodeToString(sum)+"\n"), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write hash file")
+	}
+
+	return nil
+}
+
+func removeImageHash(imagePath string) error {
+	err := os.Remove(getImageHashPath(imagePath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "remove hash file")
+	}
+
+	return nil
+}
+
+// VerifyVMImage checks the VM image against the hash recorded when it was
+// built. Images built before hashes were recorded get their hash recorded
+// now. ErrImageCorrupted is returned on mismatch.
+func (s *Storage) VerifyVMImage(imagePath string) error {
+	return s.verifyVMImage(imagePath, true)
+}
+
+// CheckVMImage is like VerifyVMImage, but never records the hash, so it has
+// no side effects. Images without a recorded hash are not checked.
+func (s *Storage) CheckVMImage(imagePath string) error {
+	return s.verifyVMImage(imagePath, false)
+}
+
+func (s *Storage) verifyVMImage(imagePath string, recordMissing bool) error {
+	data, err := os.ReadFile(getImageHashPath(imagePath))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrap(err, "read hash file")
+		}
+
+		if !recordMissing {
+			return nil
+		}
+
+		s.logger.Info("Recording VM image hash for future integrity checks", "path", imagePath)
+
+		return recordImageHash(imagePath)
+	}
+
+	want, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.Wrap(err, "decode hash file")
+	}
+
+	err = validateFileHash(imagePath, want)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrImageCorrupted, err.Error())
+	}
+
+	return nil
+}
+
+// isCachedFileValid is like checkCachedFileIntegrity, but leaves
+// a corrupted file in place.
+func isCachedFileValid(path string, hash []byte) (bool, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "stat file")
+	}
+
+	if hash == nil {
+		return true, nil
+	}
+
+	return validateFileHash(path, hash) == nil, nil
+}
+
+// checkCachedFileIntegrity reports whether the cached file exists and matches
+// the hash. A corrupted file is removed so that it can be downloaded again.
+func (s *Storage) checkCachedFileIntegrity(path string, hash []byte) (bool, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "stat file")
+	}
+
+	if hash == nil {
+		return true, nil
+	}
+
+	err = validateFileHash(path, hash)
+	if err == nil {
+		return true, nil
+	}
+
+	s.logger.Warn("Cached file is corrupted, it will be downloaded again", "path", path, "error", err.Error())
+
+	// Downloaded files are read-only, which prevents
+	// their removal on Windows.
+	_ = os.Chmod(path, 0600)
+
+	err = os.Remove(path)
+	if err != nil {
+		return false, errors.Wrap(err, "remove corrupted file")
+	}
+
+	return false, nil
+}
@@ -0,0 +1,223 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/nettap"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+	"go.uber.org/multierr"
+)
+
+// Leftovers are the resources left behind by crashed previous sessions.
+type Leftovers struct {
+	// Taps with allocations owned by processes that are no longer running.
+	DanglingTapAllocs []nettap.Alloc
+	// Taps matching the Linsk naming scheme that have no allocation at all.
+	OrphanedTaps []string
+	// Temporary directories created by processes that are no longer running.
+	StaleTempDirs []string
+	// QEMU processes using the data directory whose Linsk parent process is gone.
+	DanglingQEMUPIDs []int32
+}
+
+func (l *Leftovers) Empty() bool {
+	return len(l.DanglingTapAllocs) == 0 && len(l.OrphanedTaps) == 0 && len(l.StaleTempDirs) == 0 && len(l.DanglingQEMUPIDs) == 0
+}
+
+// FindLeftovers detects resources left behind by crashed previous sessions.
+// The tap manager can be nil, in which case network taps are not checked.
+func (s *Storage) FindLeftovers(tm *nettap.TapManager) (*Leftovers, error) {
+	var l Leftovers
+
+	runningPIDs, err := getRunningPIDs()
+	if err != nil {
+		return nil, errors.Wrap(err, "get running pids")
+	}
+
+	if tm != nil {
+		tapAllocs, err := s.ListNetTapAllocations()
+		if err != nil {
+			return nil, errors.Wrap(err, "list net tap allocations")
+		}
+
+		l.DanglingTapAllocs, err = nettap.FindDanglingAllocs(tapAllocs)
+		if err != nil {
+			return nil, errors.Wrap(err, "find dangling tap allocations")
+		}
+
+		allocatedTaps := make(map[string]struct{})
+		for _, alloc := range tapAllocs {
+			allocatedTaps[alloc.TapName] = struct{}{}
+		}
+
+		tapNames, err := tm.ListTapNames()
+		if err != nil {
+			return nil, errors.Wrap(err, "list tap names")
+		}
+
+		for _, tapName := range tapNames {
+			if _, ok := allocatedTaps[tapName]; !ok {
+				l.OrphanedTaps = append(l.OrphanedTaps, tapName)
+			}
+		}
+	}
+
+	tempDirs, err := s.listTempDirs()
+	if err != nil {
+		return nil, errors.Wrap(err, "list temp dirs")
+	}
+
+	for _, td := range tempDirs {
+		if _, ok := runningPIDs[int32(td.PID)]; !ok {
+			l.StaleTempDirs = append(l.StaleTempDirs, td.Path)
+		}
+	}
+
+	l.DanglingQEMUPIDs, err = s.findDanglingQEMUProcesses(runningPIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "find dangling qemu processes")
+	}
+
+	return &l, nil
+}
+
+// CleanLeftovers removes the leftovers. It attempts to remove everything
+// even if some of the removals fail.
+func (s *Storage) CleanLeftovers(tm *nettap.TapManager, l *Leftovers) error {
+	var errs []error
+
+	for _, pid := range l.DanglingQEMUPIDs {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "find qemu process (pid %v)", pid))
+			continue
+		}
+
+		err = p.Kill()
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "kill qemu process (pid %v)", pid))
+			continue
+		}
+
+		s.logger.Info("Killed dangling QEMU process", "pid", pid)
+	}
+
+	if tm != nil {
+		for _, alloc := range l.DanglingTapAllocs {
+			err := tm.DeleteTap(alloc.TapName)
+			if err != nil && !errors.Is(err, nettap.ErrTapNotFound) {
+				errs = append(errs, errors.Wrapf(err, "delete dangling tap '%v'", alloc.TapName))
+				continue
+			}
+
+			err = s.ReleaseNetTapAllocation(alloc.TapName)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "release dangling tap allocation '%v'", alloc.TapName))
+			}
+		}
+
+		for _, tapName := range l.OrphanedTaps {
+			err := tm.DeleteTap(tapName)
+			if err != nil && !errors.Is(err, nettap.ErrTapNotFound) {
+				errs = append(errs, errors.Wrapf(err, "delete orphaned tap '%v'", tapName))
+			}
+		}
+	}
+
+	for _, p := range l.StaleTempDirs {
+		err := os.RemoveAll(p)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "remove stale temp dir '%v'", p))
+			continue
+		}
+
+		s.logger.Info("Removed stale temp dir", "path", p)
+	}
+
+	return multierr.Combine(errs...)
+}
+
+func (s *Storage) findDanglingQEMUProcesses(runningPIDs map[int32]struct{}) ([]int32, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, errors.Wrap(err, "list processes")
+	}
+
+	// Paths passed to QEMU on Windows have forward slashes.
+	dataDirPaths := []string{s.path, filepath.ToSlash(s.path)}
+
+	var ret []int32
+
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil || !strings.HasPrefix(name, "qemu-system") {
+			// The process could have exited since we've listed it. Or we
+			// have no permission to inspect it, in which case it's not ours.
+			continue
+		}
+
+		cmdline, err := p.Cmdline()
+		if err != nil {
+			continue
+		}
+
+		var usesDataDir bool
+		for _, dataDirPath := range dataDirPaths {
+			if strings.Contains(cmdline, dataDirPath) {
+				usesDataDir = true
+				break
+			}
+		}
+
+		if !usesDataDir {
+			continue
+		}
+
+		ppid, err := p.Ppid()
+		if err != nil {
+			continue
+		}
+
+		// On Unix-like systems, orphaned processes are reparented to init.
+		_, parentRunning := runningPIDs[ppid]
+		if !parentRunning || (ppid <= 1 && !osspecifics.IsWindows()) {
+			ret = append(ret, p.Pid)
+		}
+	}
+
+	return ret, nil
+}
+
+func getRunningPIDs() (map[int32]struct{}, error) {
+	pids, err := process.Pids()
+	if err != nil {
+		return nil, errors.Wrap(err, "get pids")
+	}
+
+	ret := make(map[int32]struct{}, len(pids))
+	for _, pid := range pids {
+		ret[pid] = struct{}{}
+	}
+
+	return ret, nil
+}
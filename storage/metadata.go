@@ -0,0 +1,122 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/AlexSSD7/linsk/constants"
+	"github.com/AlexSSD7/linsk/imgbuilder"
+	"github.com/pkg/errors"
+)
+
+const maxImageReleaseMetadataSize = 1 << 20
+
+// ImageReleaseMetadata describes prebuilt VM images published together with
+// their detached minisign signatures. It allows shipping image updates
+// without releasing a new Linsk binary.
+type ImageReleaseMetadata struct {
+	Images []ImageReleaseEntry `json:"images"`
+}
+
+type ImageReleaseEntry struct {
+	// Arch is the guest architecture, "x86_64" or "aarch64".
+	Arch string `json:"arch"`
+	// Flavor is the image flavor, "standard" if empty.
+	Flavor string `json:"flavor,omitempty"`
+	// Tags is informational, e.g. "3.20.3-x86_64-linsk1".
+	Tags   string `json:"tags,omitempty"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Minisig holds the contents of the detached .minisig signature file.
+	Minisig string `json:"minisig"`
+	// BlockIndexURL optionally points to the BlockIndex of the image,
+	// enabling delta downloads from a previously downloaded image.
+	BlockIndexURL string `json:"block_index_url,omitempty"`
+}
+
+func (s *Storage) fetchImageReleaseMetadata(ctx context.Context, url string) (*ImageReleaseMetadata, error) {
+	if s.offline {
+		return nil, errors.Wrapf(ErrOffline, "fetch '%v'", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create new http get request")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "http get")
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad http status: %v", resp.Status)
+	}
+
+	var md ImageReleaseMetadata
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxImageReleaseMetadataSize)).Decode(&md)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode json")
+	}
+
+	return &md, nil
+}
+
+func (md *ImageReleaseMetadata) findImage(arch string, flavor imgbuilder.Flavor) (ImageReleaseEntry, error) {
+	for _, img := range md.Images {
+		imgFlavor := imgbuilder.Flavor(img.Flavor)
+		if imgFlavor == "" {
+			imgFlavor = imgbuilder.FlavorStandard
+		}
+
+		if img.Arch == arch && imgFlavor == flavor {
+			if img.URL == "" {
+				return ImageReleaseEntry{}, fmt.Errorf("image entry for arch '%v' has no url", arch)
+			}
+
+			if img.Minisig == "" {
+				return ImageReleaseEntry{}, fmt.Errorf("image entry for arch '%v' has no signature", arch)
+			}
+
+			return img, nil
+		}
+	}
+
+	return ImageReleaseEntry{}, fmt.Errorf("no image for arch '%v' and flavor '%v' in release metadata", arch, flavor)
+}
+
+func (s *Storage) resolveImageReleaseMetadata(ctx context.Context, url string) (ImageReleaseEntry, error) {
+	md, err := s.fetchImageReleaseMetadata(ctx, url)
+	if err != nil {
+		return ImageReleaseEntry{}, errors.Wrap(err, "fetch image release metadata")
+	}
+
+	img, err := md.findImage(constants.GetVMImageArch(), s.ImageFlavor())
+	if err != nil {
+		return ImageReleaseEntry{}, err
+	}
+
+	return img, nil
+}
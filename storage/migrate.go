@@ -0,0 +1,228 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// MigrateTo moves the contents of the data directory into newDir, which
+// must be empty or nonexistent. Persistent overlays are rebased onto the
+// moved images. The storage uses the new directory afterwards.
+func (s *Storage) MigrateTo(ctx context.Context, newDir string) error {
+	oldDir, err := filepath.Abs(s.path)
+	if err != nil {
+		return errors.Wrap(err, "get absolute old data dir path")
+	}
+
+	newDir, err = filepath.Abs(newDir)
+	if err != nil {
+		return errors.Wrap(err, "get absolute new data dir path")
+	}
+
+	if newDir == oldDir || strings.HasPrefix(newDir, oldDir+string(filepath.Separator)) {
+		return fmt.Errorf("new data directory must not be the old one or reside inside it")
+	}
+
+	err = os.MkdirAll(newDir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "mkdir all new data dir")
+	}
+
+	existing, err := os.ReadDir(newDir)
+	if err != nil {
+		return errors.Wrap(err, "read new data dir")
+	}
+
+	if len(existing) != 0 {
+		return fmt.Errorf("new data directory '%v' is not empty", newDir)
+	}
+
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		return errors.Wrap(err, "read old data dir")
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(oldDir, entry.Name())
+		dst := filepath.Join(newDir, entry.Name())
+
+		s.logger.Info("Moving", "from", src, "to", dst)
+
+		err = movePath(src, dst)
+		if err != nil {
+			return errors.Wrapf(err, "move '%v'", src)
+		}
+	}
+
+	s.path = newDir
+
+	err = s.rebaseMigratedOverlays(ctx, oldDir)
+	if err != nil {
+		return errors.Wrap(err, "rebase overlays")
+	}
+
+	err = os.Remove(oldDir)
+	if err != nil {
+		s.logger.Warn("Failed to remove the old data directory", "path", oldDir, "error", err.Error())
+	}
+
+	return nil
+}
+
+// rebaseMigratedOverlays points the overlays of the images that were moved
+// from oldDir to the new image locations. Overlay names depend on the base
+// image path, so they're renamed as well.
+func (s *Storage) rebaseMigratedOverlays(ctx context.Context, oldDir string) error {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return errors.Wrap(err, "read data dir")
+	}
+
+	overlayDirs, err := s.listVMOverlayDirs()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".qcow2" {
+			continue
+		}
+
+		for _, overlayDir := range overlayDirs {
+			err := s.rebaseMigratedOverlay(ctx, overlayDir, filepath.Join(oldDir, entry.Name()), filepath.Join(s.path, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebaseMigratedOverlay points the overlay of oldBase in overlayDir (if any) to newBase.
+func (s *Storage) rebaseMigratedOverlay(ctx context.Context, overlayDir string, oldBase string, newBase string) error {
+	oldOverlay := filepath.Join(overlayDir, getVMOverlayName(oldBase))
+
+	_, err := os.Stat(oldOverlay)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrap(err, "stat overlay")
+	}
+
+	imgCmd, err := qemucli.NewImgRebaseUnsafeCommand(oldOverlay, qemucli.ImgFormatQCOW2, newBase)
+	if err != nil {
+		return errors.Wrap(err, "create qemu-img rebase cmd")
+	}
+
+	out, err := imgCmd.ExecCmd(ctx).CombinedOutput()
+	if err != nil {
+		return utils.WrapErrWithLog(err, "run qemu-img rebase cmd", string(out))
+	}
+
+	newOverlay := filepath.Join(overlayDir, getVMOverlayName(newBase))
+
+	err = os.Rename(oldOverlay, newOverlay)
+	if err != nil {
+		return errors.Wrap(err, "rename overlay")
+	}
+
+	s.logger.Info("Rebased persistent VM overlay", "path", newOverlay, "base", newBase)
+
+	return nil
+}
+
+// movePath renames src to dst, falling back to copying and removing
+// if they're on different file systems.
+func movePath(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	stat, err := os.Lstat(src)
+	if err != nil {
+		return errors.Wrap(err, "lstat")
+	}
+
+	if stat.IsDir() {
+		err = os.Mkdir(dst, stat.Mode().Perm())
+		if err != nil {
+			return errors.Wrap(err, "mkdir")
+		}
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return errors.Wrap(err, "read dir")
+		}
+
+		for _, entry := range entries {
+			err = movePath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+
+		return errors.Wrap(os.Remove(src), "remove dir")
+	}
+
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("unsupported file type '%v'", stat.Mode().Type())
+	}
+
+	err = copyFile(src, dst, stat.Mode().Perm())
+	if err != nil {
+		_ = os.Remove(dst)
+		return errors.Wrap(err, "copy file")
+	}
+
+	// Read-only files cannot be removed on Windows.
+	_ = os.Chmod(src, 0600)
+
+	return errors.Wrap(os.Remove(src), "remove file")
+}
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm|0200)
+	if err != nil {
+		return errors.Wrap(err, "open destination")
+	}
+
+	_, err = io.Copy(out, in)
+
+	return multierr.Combine(err, out.Close(), os.Chmod(dst, perm))
+}
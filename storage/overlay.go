@@ -0,0 +1,189 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/qemucli"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+const vmOverlaysDirName = "overlays"
+
+func (s *Storage) getVMOverlaysDirPath() string {
+	return filepath.Join(s.path, vmOverlaysDirName)
+}
+
+// getNamedVMOverlaysDirPath returns the overlays directory of the named
+// instance. The overlays of the unnamed instances are kept in the root.
+func (s *Storage) getNamedVMOverlaysDirPath(name string) (string, error) {
+	if name == "" {
+		return s.getVMOverlaysDirPath(), nil
+	}
+
+	err := validateInstanceID(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.getVMOverlaysDirPath(), name), nil
+}
+
+// listVMOverlayDirs returns the overlays directory root and
+// the overlays directories of the named instances.
+func (s *Storage) listVMOverlayDirs() ([]string, error) {
+	entries, err := os.ReadDir(s.getVMOverlaysDirPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "read overlays dir")
+	}
+
+	ret := []string{s.getVMOverlaysDirPath()}
+	for _, entry := range entries {
+		if entry.IsDir() && validateInstanceID(entry.Name()) == nil {
+			ret = append(ret, filepath.Join(s.getVMOverlaysDirPath(), entry.Name()))
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *Storage) getVMOverlayPath(baseImagePath string, name string) (string, error) {
+	absPath, err := filepath.Abs(baseImagePath)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute base image path")
+	}
+
+	dir, err := s.getNamedVMOverlaysDirPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, getVMOverlayName(absPath)), nil
+}
+
+func getVMOverlayName(absBaseImagePath string) string {
+	// Custom images may share a file name, hence the path hash.
+	pathHash := sha256.Sum256([]byte(absBaseImagePath))
+	return strings.TrimSuffix(filepath.Base(absBaseImagePath), filepath.Ext(absBaseImagePath)) + "-" + hex.EncodeToString(pathHash[:4]) + ".qcow2"
+}
+
+// CheckCreateVMOverlay returns the path of the persistent writable overlay
+// for the base VM image, creating one if it doesn't exist. Changes made in
+// the guest are stored in the overlay and survive between runs. Named
+// instances get overlays of their own, so that they can run concurrently.
+func (s *Storage) CheckCreateVMOverlay(ctx context.Context, baseImagePath string, name string) (string, error) {
+	overlayPath, err := s.getVMOverlayPath(baseImagePath, name)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = os.Stat(overlayPath)
+	if err == nil {
+		return overlayPath, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", errors.Wrap(err, "stat overlay path")
+	}
+
+	err = os.MkdirAll(filepath.Dir(overlayPath), 0700)
+	if err != nil {
+		return "", errors.Wrap(err, "mkdir overlays dir")
+	}
+
+	absBasePath, err := filepath.Abs(baseImagePath)
+	if err != nil {
+		return "", errors.Wrap(err, "get absolute base image path")
+	}
+
+	imgCmd, err := qemucli.NewImgCreateOverlayCommand(qemucli.ImgFormatQCOW2, absBasePath, overlayPath)
+	if err != nil {
+		return "", errors.Wrap(err, "create qemu-img create cmd")
+	}
+
+	out, err := imgCmd.ExecCmd(ctx).CombinedOutput()
+	if err != nil {
+		return "", utils.WrapErrWithLog(err, "run qemu-img create cmd", string(out))
+	}
+
+	s.logger.Info("Created persistent VM overlay", "path", overlayPath, "base", absBasePath)
+
+	return overlayPath, nil
+}
+
+// ResetVMOverlays removes the persistent VM overlays of the named instance,
+// discarding the changes made in the guest. All overlays are removed if
+// the name is empty.
+func (s *Storage) ResetVMOverlays(name string) ([]string, error) {
+	dirs, err := s.listVMOverlayDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		dir, err := s.getNamedVMOverlaysDirPath(name)
+		if err != nil {
+			return nil, err
+		}
+
+		dirs = []string{dir}
+	}
+
+	var removed []string
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return removed, errors.Wrap(err, "read overlays dir")
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".qcow2" {
+				continue
+			}
+
+			p := filepath.Join(dir, entry.Name())
+
+			err = os.Remove(p)
+			if err != nil {
+				return removed, errors.Wrapf(err, "remove overlay '%v'", p)
+			}
+
+			removed = append(removed, p)
+		}
+
+		if dir != s.getVMOverlaysDirPath() {
+			// Removing the named instance's directory if it's empty now.
+			_ = os.Remove(dir)
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,191 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	portReservationsDirName  = "ports"
+	portReservationsLockName = ".lock"
+)
+
+func (s *Storage) getPortReservationsDirPath() string {
+	return filepath.Join(s.path, portReservationsDirName)
+}
+
+// ReservePort reserves the port for the current process, so that the other
+// Linsk instances using the same data directory don't pick it. It returns
+// false if the port is reserved by another running instance. Reservations
+// of the instances that are no longer running are taken over.
+func (s *Storage) ReservePort(port uint16) (bool, error) {
+	unlock, err := s.lockPortReservations()
+	if err != nil {
+		return false, err
+	}
+
+	defer unlock()
+
+	p := filepath.Join(s.getPortReservationsDirPath(), fmt.Sprint(port))
+
+	pid, err := readReservationPID(p)
+	if err != nil {
+		return false, err
+	}
+
+	switch pid {
+	case os.Getpid():
+		return true, nil
+	case 0, -1:
+		// Not reserved, or left malformed by a crashed instance. The
+		// reservations are written atomically, so it's not in progress.
+	default:
+		running, err := process.PidExists(int32(pid))
+		if err != nil {
+			return false, errors.Wrapf(err, "check whether process %v exists", pid)
+		}
+
+		if running {
+			return false, nil
+		}
+	}
+
+	// Written to a temporary file first, so that a crash
+	// never leaves an empty reservation behind.
+	tmpPath := p + ".tmp"
+
+	err = os.WriteFile(tmpPath, []byte(fmt.Sprint(os.Getpid())), 0600)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return false, errors.Wrap(err, "write port reservation file")
+	}
+
+	err = os.Rename(tmpPath, p)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return false, errors.Wrap(err, "move port reservation file into place")
+	}
+
+	return true, nil
+}
+
+// lockPortReservations serialises the changes to the port reservations
+// between the processes sharing the data directory. The returned function
+// releases the lock.
+func (s *Storage) lockPortReservations() (func(), error) {
+	err := os.MkdirAll(s.getPortReservationsDirPath(), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "create port reservations dir")
+	}
+
+	lockPath := filepath.Join(s.getPortReservationsDirPath(), portReservationsLockName)
+
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open port reservations lock file")
+	}
+
+	err = osspecifics.LockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "lock port reservations")
+	}
+
+	return func() {
+		_ = osspecifics.UnlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+// ReleasePortReservations releases all ports reserved by the current process.
+func (s *Storage) ReleasePortReservations() error {
+	_, err := os.Stat(s.getPortReservationsDirPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrap(err, "stat port reservations dir")
+	}
+
+	unlock, err := s.lockPortReservations()
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	entries, err := os.ReadDir(s.getPortReservationsDirPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrap(err, "read port reservations dir")
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == portReservationsLockName {
+			continue
+		}
+
+		p := filepath.Join(s.getPortReservationsDirPath(), entry.Name())
+
+		pid, err := readReservationPID(p)
+		if err != nil {
+			return err
+		}
+
+		if pid == os.Getpid() {
+			err = os.Remove(p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return errors.Wrap(err, "remove port reservation file")
+			}
+		}
+	}
+
+	return nil
+}
+
+// readReservationPID returns the PID of the reservation owner, 0 if the
+// reservation doesn't exist, or -1 if the reservation file is malformed.
+func readReservationPID(p string) (int, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errors.Wrap(err, "read port reservation file")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return -1, nil
+	}
+
+	return pid, nil
+}
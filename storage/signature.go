@@ -0,0 +1,220 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Minisign signature algorithms. "Ed" signs the raw file contents,
+// while "ED" signs the BLAKE2b-512 hash of the file (the default for
+// recent minisign versions).
+const (
+	minisignAlgoLegacy    = "Ed"
+	minisignAlgoPrehashed = "ED"
+)
+
+const minisignKeyIDLen = 8
+
+type minisignPublicKey struct {
+	keyID [minisignKeyIDLen]byte
+	key   ed25519.PublicKey
+}
+
+type minisignSignature struct {
+	algo            string
+	keyID           [minisignKeyIDLen]byte
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+func parseMinisignPublicKey(s string) (minisignPublicKey, error) {
+	s = strings.TrimSpace(s)
+
+	// Accept the full .pub file contents as well as the bare key.
+	if lines := strings.Split(s, "\n"); len(lines) == 2 && strings.HasPrefix(lines[0], "untrusted comment:") {
+		s = strings.TrimSpace(lines[1])
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return minisignPublicKey{}, errors.Wrap(err, "decode base64")
+	}
+
+	if len(raw) != 2+minisignKeyIDLen+ed25519.PublicKeySize {
+		return minisignPublicKey{}, fmt.Errorf("bad public key length %v", len(raw))
+	}
+
+	if string(raw[:2]) != minisignAlgoLegacy {
+		return minisignPublicKey{}, fmt.Errorf("unsupported public key algorithm '%v'", string(raw[:2]))
+	}
+
+	var pk minisignPublicKey
+	copy(pk.keyID[:], raw[2:2+minisignKeyIDLen])
+	pk.key = ed25519.PublicKey(raw[2+minisignKeyIDLen:])
+
+	return pk, nil
+}
+
+func parseMinisignSignature(data []byte) (minisignSignature, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return minisignSignature{}, errors.Wrap(err, "scan lines")
+	}
+
+	if len(lines) < 4 {
+		return minisignSignature{}, fmt.Errorf("bad signature: want 4 lines, have %v", len(lines))
+	}
+
+	if !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return minisignSignature{}, fmt.Errorf("bad signature: missing untrusted comment")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return minisignSignature{}, errors.Wrap(err, "decode signature base64")
+	}
+
+	if len(raw) != 2+minisignKeyIDLen+ed25519.SignatureSize {
+		return minisignSignature{}, fmt.Errorf("bad signature length %v", len(raw))
+	}
+
+	trustedComment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return minisignSignature{}, fmt.Errorf("bad signature: missing trusted comment")
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return minisignSignature{}, errors.Wrap(err, "decode global signature base64")
+	}
+
+	sig := minisignSignature{
+		algo:            string(raw[:2]),
+		signature:       raw[2+minisignKeyIDLen:],
+		trustedComment:  trustedComment,
+		globalSignature: globalSignature,
+	}
+	copy(sig.keyID[:], raw[2:2+minisignKeyIDLen])
+
+	return sig, nil
+}
+
+func (pk minisignPublicKey) verifyFile(path string, sig minisignSignature) error {
+	if sig.keyID != pk.keyID {
+		return fmt.Errorf("%w: key id mismatch: signature '%X', public key '%X'", ErrSignatureInvalid, sig.keyID, pk.keyID)
+	}
+
+	pathClean := filepath.Clean(path)
+
+	f, err := os.OpenFile(pathClean, os.O_RDONLY, 0400)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var msg []byte
+
+	switch sig.algo {
+	case minisignAlgoPrehashed:
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return errors.Wrap(err, "create blake2b hash")
+		}
+
+		_, err = io.Copy(h, f)
+		if err != nil {
+			return errors.Wrap(err, "hash file")
+		}
+
+		msg = h.Sum(nil)
+	case minisignAlgoLegacy:
+		// Legacy signatures cover the whole file, so it has to be read into memory.
+		msg, err = io.ReadAll(f)
+		if err != nil {
+			return errors.Wrap(err, "read file")
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm '%v'", sig.algo)
+	}
+
+	if !ed25519.Verify(pk.key, msg, sig.signature) {
+		return fmt.Errorf("%w: verification failed (path '%v')", ErrSignatureInvalid, pathClean)
+	}
+
+	if !ed25519.Verify(pk.key, append(append([]byte{}, sig.signature...), sig.trustedComment...), sig.globalSignature) {
+		return fmt.Errorf("%w: trusted comment verification failed (path '%v')", ErrSignatureInvalid, pathClean)
+	}
+
+	return nil
+}
+
+// verifyFileSignature checks that the file is signed by any of the
+// supplied minisign public keys.
+func verifyFileSignature(path string, sigData []byte, publicKeys []string) (string, error) {
+	if len(publicKeys) == 0 {
+		return "", fmt.Errorf("no trusted public keys available")
+	}
+
+	sig, err := parseMinisignSignature(sigData)
+	if err != nil {
+		return "", errors.Wrap(err, "parse signature")
+	}
+
+	var lastErr error
+
+	for i, pkStr := range publicKeys {
+		pk, err := parseMinisignPublicKey(pkStr)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse public key #%v", i)
+		}
+
+		if pk.keyID != sig.keyID {
+			continue
+		}
+
+		lastErr = pk.verifyFile(path, sig)
+		if lastErr == nil {
+			return sig.trustedComment, nil
+		}
+	}
+
+	if lastErr == nil {
+		return "", fmt.Errorf("%w: key id '%X' does not match any trusted public key", ErrSignatureInvalid, sig.keyID)
+	}
+
+	return "", lastErr
+}
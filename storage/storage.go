@@ -27,13 +27,41 @@ import (
 
 	"github.com/AlexSSD7/linsk/constants"
 	"github.com/AlexSSD7/linsk/imgbuilder"
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/progress"
 	"github.com/pkg/errors"
 )
 
 type Storage struct {
 	logger *slog.Logger
 
-	path string
+	path    string
+	offline bool
+	flavor  imgbuilder.Flavor
+
+	alpineMirrors []string
+
+	progress progress.Func
+}
+
+// fallbackDataDir is the data directory used if the user home directory is unknown.
+const fallbackDataDir = "linsk-data-dir"
+
+// GetDefaultDataDir returns the default data directory in the user home
+// directory. If the home directory cannot be determined, the local fallback
+// directory is returned along with the error.
+func GetDefaultDataDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fallbackDataDir, errors.Wrap(err, "get user home dir")
+	}
+
+	homeDirName := ".linsk"
+	if osspecifics.IsWindows() {
+		homeDirName = "Linsk"
+	}
+
+	return filepath.Join(homeDir, homeDirName), nil
 }
 
 func NewStorage(logger *slog.Logger, dataDir string) (*Storage, error) {
@@ -53,64 +81,150 @@ func NewStorage(logger *slog.Logger, dataDir string) (*Storage, error) {
 
 func (s *Storage) CheckDownloadBaseImage(ctx context.Context) (string, error) {
 	baseImagePath := filepath.Join(s.path, constants.GetAlpineBaseImageFileName())
-	_, err := os.Stat(baseImagePath)
+	exists, err := s.checkCachedFileIntegrity(baseImagePath, constants.GetAlpineBaseImageHash())
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return "", errors.Wrap(err, "stat base image path")
-		}
+		return "", errors.Wrap(err, "check existing base image")
+	}
 
-		// Image doesn't exist. Download one.
-		err := s.download(ctx, constants.GetAlpineBaseImageURL(), constants.GetAlpineBaseImageHash(), baseImagePath, nil)
+	if !exists {
+		// Image doesn't exist (or was corrupted). Download one.
+		err := s.downloadWithFailover(ctx, constants.GetAlpineBaseImageURLs(s.alpineMirrors), constants.GetAlpineBaseImageHash(), baseImagePath, nil)
 		if err != nil {
 			return "", errors.Wrap(err, "download base alpine image")
 		}
+	}
+
+	return baseImagePath, nil
+}
+
+// SetAlpineMirrors sets the Alpine mirror base URLs that are tried
+// before the built-in ones when downloading the base image.
+func (s *Storage) SetAlpineMirrors(mirrors []string) {
+	s.alpineMirrors = mirrors
+}
 
-		return baseImagePath, nil
+// SetOffline makes all operations that would require a network fetch
+// fail with ErrOffline.
+func (s *Storage) SetOffline(offline bool) {
+	s.offline = offline
+}
+
+// SetProgress sets the function receiving the download progress updates.
+// If it is nil, the progress is logged instead.
+func (s *Storage) SetProgress(fn progress.Func) {
+	s.progress = fn
+}
+
+// SetImageFlavor selects the VM image flavor to build and use.
+func (s *Storage) SetImageFlavor(flavor imgbuilder.Flavor) {
+	s.flavor = flavor
+}
+
+func (s *Storage) ImageFlavor() imgbuilder.Flavor {
+	if s.flavor == "" {
+		return imgbuilder.FlavorStandard
 	}
 
-	// Image exists. Ensure that the hash is correct.
-	err = validateFileHash(baseImagePath, constants.GetAlpineBaseImageHash())
-	if err != nil {
-		return "", errors.Wrap(err, "validate hash of existing image")
+	return s.flavor
+}
+
+func getVMImageFlavorSuffix(flavor imgbuilder.Flavor) string {
+	// Standard flavor images keep the original naming for compatibility.
+	if flavor == imgbuilder.FlavorStandard || flavor == "" {
+		return ""
 	}
 
-	return baseImagePath, nil
+	return "-" + string(flavor)
 }
 
 func (s *Storage) GetVMImagePath() string {
-	return filepath.Join(s.path, constants.GetVMImageTags()+".qcow2")
+	return filepath.Join(s.path, constants.GetVMImageTags()+getVMImageFlavorSuffix(s.ImageFlavor())+".qcow2")
 }
 
 func (s *Storage) GetAarch64EFIImagePath() string {
 	return filepath.Join(s.path, constants.GetAarch64EFIImageName())
 }
 
-func (s *Storage) RunCLIImageBuild(showBuilderVMDisplay bool, overwrite bool) int {
+type ImageBuildOptions struct {
+	ShowBuilderVMDisplay bool
+	Overwrite            bool
+
+	// OutPath overrides the default VM image path in the data directory.
+	OutPath string
+
+	// BaseImagePath points to a local Alpine ISO to use instead of
+	// downloading one. It is validated against BaseImageHash, or the
+	// pinned hash if the former is nil. The file is left in place.
+	BaseImagePath string
+	BaseImageHash []byte
+
+	Builder imgbuilder.BuildOptions
+}
+
+func (s *Storage) RunCLIImageBuild(ctx context.Context, showBuilderVMDisplay bool, overwrite bool) int {
+	return s.RunCLIImageBuildWithOptions(ctx, ImageBuildOptions{
+		ShowBuilderVMDisplay: showBuilderVMDisplay,
+		Overwrite:            overwrite,
+	})
+}
+
+func (s *Storage) RunCLIImageBuildWithOptions(ctx context.Context, opts ImageBuildOptions) int {
+	if s.offline && len(opts.Builder.Repositories) == 0 {
+		slog.Error("Building the VM image requires installing packages from the network. In offline mode, specify a reachable local package mirror with --apk-repository (see `linsk image build`), or import a prebuilt image with `linsk image import`", "error", ErrOffline.Error())
+		return 1
+	}
+
 	vmImagePath := s.GetVMImagePath()
-	removed, err := checkExistsOrRemove(vmImagePath, overwrite)
+	if opts.OutPath != "" {
+		vmImagePath = filepath.Clean(opts.OutPath)
+	}
+
+	removed, err := checkExistsOrRemove(vmImagePath, opts.Overwrite)
 	if err != nil {
 		slog.Error("Failed to check for (or remove if overwrite mode is on) existing VM image", "error", err.Error())
 		return 1
 	}
 
-	// We're using context.Background() everywhere because this is intended
-	// to be executed as a blocking CLI command.
-
-	baseImagePath, err := s.CheckDownloadBaseImage(context.Background())
+	err = removeImageHash(vmImagePath)
 	if err != nil {
-		slog.Error("Failed to check or download base VM image", "error", err.Error())
+		slog.Error("Failed to remove stale VM image hash", "error", err.Error())
 		return 1
 	}
 
-	biosPath, err := s.CheckDownloadVMBIOS(context.Background())
+	var baseImagePath string
+	if opts.BaseImagePath != "" {
+		baseImagePath = filepath.Clean(opts.BaseImagePath)
+
+		wantHash := opts.BaseImageHash
+		if wantHash == nil {
+			wantHash = constants.GetAlpineBaseImageHash()
+		}
+
+		err = validateFileHash(baseImagePath, wantHash)
+		if err != nil {
+			slog.Error("Failed to validate local base VM image", "error", err.Error())
+			return 1
+		}
+	} else {
+		baseImagePath, err = s.CheckDownloadBaseImage(ctx)
+		if err != nil {
+			slog.Error("Failed to check or download base VM image", "error", err.Error())
+			return 1
+		}
+	}
+
+	biosPath, err := s.CheckDownloadVMBIOS(ctx)
 	if err != nil {
 		slog.Error("Failed to check or download VM BIOS", "error", err.Error())
 		return 1
 	}
 
-	s.logger.Info("Building VM image", "tags", constants.GetAlpineBaseImageTags(), "overwriting", removed, "dst", vmImagePath)
+	// The image path depends on the flavor, so it's the storage that decides.
+	opts.Builder.Flavor = s.ImageFlavor()
+
+	s.logger.Info("Building VM image", "tags", constants.GetAlpineBaseImageTags(), "flavor", opts.Builder.Flavor, "overwriting", removed, "dst", vmImagePath)
 
-	buildCtx, err := imgbuilder.NewBuildContext(s.logger.With("subcaller", "imgbuilder"), baseImagePath, vmImagePath, showBuilderVMDisplay, biosPath)
+	buildCtx, err := imgbuilder.NewBuildContext(s.logger.With("subcaller", "imgbuilder"), baseImagePath, vmImagePath, opts.ShowBuilderVMDisplay, biosPath, opts.Builder)
 	if err != nil {
 		slog.Error("Failed to create new image build context", "error", err.Error())
 		return 1
@@ -121,6 +235,16 @@ func (s *Storage) RunCLIImageBuild(showBuilderVMDisplay bool, overwrite bool) in
 		return exitCode
 	}
 
+	err = recordImageHash(vmImagePath)
+	if err != nil {
+		s.logger.Error("Failed to record VM image hash, it will be recorded on the next run", "error", err.Error())
+	}
+
+	if opts.BaseImagePath != "" {
+		// The base image was supplied by the user. Leave it be.
+		return 0
+	}
+
 	err = os.Remove(baseImagePath)
 	if err != nil {
 		s.logger.Error("Failed to remove base image", "error", err.Error(), "path", baseImagePath)
@@ -166,27 +290,40 @@ func (s *Storage) CheckDownloadVMBIOS(ctx context.Context) (string, error) {
 	return "", nil
 }
 
+// PlanVMBIOS is the dry-run counterpart of CheckDownloadVMBIOS. It returns
+// the BIOS path and, if the BIOS has yet to be downloaded, the URL it
+// would be downloaded from. Nothing is downloaded or removed.
+func (s *Storage) PlanVMBIOS() (string, string, error) {
+	if runtime.GOARCH != "arm64" {
+		return "", "", nil
+	}
+
+	efiImagePath := s.GetAarch64EFIImagePath()
+	exists, err := isCachedFileValid(efiImagePath, constants.GetAarch64EFIImageHash())
+	if err != nil {
+		return "", "", errors.Wrap(err, "check existing efi image")
+	}
+
+	if exists {
+		return efiImagePath, "", nil
+	}
+
+	return efiImagePath, constants.GetAarch64EFIImageBZ2URLs()[0], nil
+}
+
 func (s *Storage) CheckDownloadAarch64EFIImage(ctx context.Context) (string, error) {
 	efiImagePath := s.GetAarch64EFIImagePath()
-	_, err := os.Stat(efiImagePath)
+	exists, err := s.checkCachedFileIntegrity(efiImagePath, constants.GetAarch64EFIImageHash())
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return "", errors.Wrap(err, "stat base image path")
-		}
+		return "", errors.Wrap(err, "check existing efi image")
+	}
 
-		// EFI image doesn't exist. Download one.
-		err := s.download(ctx, constants.GetAarch64EFIImageBZ2URL(), constants.GetAarch64EFIImageHash(), efiImagePath, bzip2.NewReader)
+	if !exists {
+		// EFI image doesn't exist (or was corrupted). Download one.
+		err := s.downloadWithFailover(ctx, constants.GetAarch64EFIImageBZ2URLs(), constants.GetAarch64EFIImageHash(), efiImagePath, bzip2.NewReader)
 		if err != nil {
 			return "", errors.Wrap(err, "download base alpine image")
 		}
-
-		return efiImagePath, nil
-	}
-
-	// EFI image exists. Ensure that the hash is correct.
-	err = validateFileHash(efiImagePath, constants.GetAarch64EFIImageHash())
-	if err != nil {
-		return "", errors.Wrap(err, "validate hash of existing image")
 	}
 
 	return efiImagePath, nil
@@ -0,0 +1,100 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tmpDirName = "tmp"
+
+func (s *Storage) getTempDirsRoot() string {
+	return filepath.Join(s.path, tmpDirName)
+}
+
+// CreateTempDir creates a new temporary directory in the data directory.
+// The directory name embeds the PID of the current process, which lets
+// us detect directories left over by crashed sessions. It's the caller's
+// responsibility to remove the directory afterwards.
+func (s *Storage) CreateTempDir() (string, error) {
+	root := s.getTempDirsRoot()
+
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return "", errors.Wrap(err, "mkdir all temp dirs root")
+	}
+
+	time.Sleep(time.Millisecond)
+	p := filepath.Join(root, fmt.Sprintf("%v-%v", os.Getpid(), time.Now().UnixNano()))
+
+	err = os.Mkdir(p, 0700)
+	if err != nil {
+		return "", errors.Wrap(err, "mkdir temp dir")
+	}
+
+	return p, nil
+}
+
+type tempDir struct {
+	Path string
+	PID  int
+}
+
+func (s *Storage) listTempDirs() ([]tempDir, error) {
+	root := s.getTempDirsRoot()
+
+	dirEntries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "read temp dirs root")
+	}
+
+	var ret []tempDir
+
+	for _, entry := range dirEntries {
+		entryPath := filepath.Join(root, entry.Name())
+
+		pidStr, _, ok := strings.Cut(entry.Name(), "-")
+		if !ok {
+			s.logger.Warn("Found a temp dir with unknown name format, skipping", "path", entryPath)
+			continue
+		}
+
+		pid, err := strconv.ParseUint(pidStr, 10, strconv.IntSize-1) // We're aiming for `int` PID.
+		if err != nil {
+			s.logger.Warn("Failed to parse temp dir pid, skipping", "path", entryPath, "error", err.Error())
+			continue
+		}
+
+		ret = append(ret, tempDir{
+			Path: entryPath,
+			PID:  int(pid),
+		})
+	}
+
+	return ret, nil
+}
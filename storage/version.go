@@ -0,0 +1,194 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/constants"
+	"github.com/AlexSSD7/linsk/imgbuilder"
+	"github.com/pkg/errors"
+)
+
+const imagePinFileName = "image-pin"
+
+var vmImageFileNameRegexp = regexp.MustCompile(`^(\d+\.\d+\.\d+)-(x86_64|aarch64)-linsk(\d+)(?:-(minimal|recovery))?\.qcow2$`)
+
+// LocalVMImage is a VM image built into the data directory.
+type LocalVMImage struct {
+	AlpineVersion string `json:"alpine_version"`
+	Arch          string `json:"arch"`
+	Version       int    `json:"version"`
+	Flavor        string `json:"flavor"`
+	Path          string `json:"path"`
+	Size          int64  `json:"size"`
+}
+
+func parseVMImageVersion(v string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(v), "linsk"))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad image version '%v'", v)
+	}
+
+	return n, nil
+}
+
+// LatestVMImageVersion returns the image version this Linsk binary builds.
+func LatestVMImageVersion() int {
+	n, err := parseVMImageVersion(constants.LinskVMImageVersion)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+// ListVMImages returns the VM images present in the data directory,
+// newest first.
+func (s *Storage) ListVMImages() ([]LocalVMImage, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read data dir")
+	}
+
+	var ret []LocalVMImage
+
+	for _, entry := range entries {
+		m := vmImageFileNameRegexp.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+
+		v, err := parseVMImageVersion(m[3])
+		if err != nil {
+			continue
+		}
+
+		flavor := imgbuilder.Flavor(m[4])
+		if flavor == "" {
+			flavor = imgbuilder.FlavorStandard
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat '%v'", entry.Name())
+		}
+
+		ret = append(ret, LocalVMImage{
+			AlpineVersion: m[1],
+			Arch:          m[2],
+			Version:       v,
+			Flavor:        string(flavor),
+			Path:          filepath.Join(s.path, entry.Name()),
+			Size:          info.Size(),
+		})
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Version != ret[j].Version {
+			return ret[i].Version > ret[j].Version
+		}
+
+		return ret[i].AlpineVersion > ret[j].AlpineVersion
+	})
+
+	return ret, nil
+}
+
+// GetPinnedVMImageVersion returns the image version pinned with
+// `linsk image update`, or 0 if no version is pinned.
+func (s *Storage) GetPinnedVMImageVersion() (int, error) {
+	data, err := os.ReadFile(filepath.Join(s.path, imagePinFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errors.Wrap(err, "read pin file")
+	}
+
+	v, err := parseVMImageVersion(string(data))
+	if err != nil {
+		return 0, errors.Wrap(err, "parse pin file")
+	}
+
+	return v, nil
+}
+
+func (s *Storage) SetPinnedVMImageVersion(v int) error {
+	err := os.WriteFile(filepath.Join(s.path, imagePinFileName), []byte(strconv.Itoa(v)+"\n"), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write pin file")
+	}
+
+	return nil
+}
+
+// ResolveVMImage returns the path of the VM image to use. The version is
+// taken from the pin argument, then from the pin file, and defaults to the
+// latest version. An empty path is returned if the image does not exist.
+func (s *Storage) ResolveVMImage(pin string) (string, int, error) {
+	version := LatestVMImageVersion()
+
+	if pin != "" {
+		v, err := parseVMImageVersion(pin)
+		if err != nil {
+			return "", 0, err
+		}
+
+		version = v
+	} else {
+		v, err := s.GetPinnedVMImageVersion()
+		if err != nil {
+			return "", 0, errors.Wrap(err, "get pinned image version")
+		}
+
+		if v != 0 {
+			version = v
+		}
+	}
+
+	if version == LatestVMImageVersion() {
+		p, err := s.CheckVMImageExists()
+		if err != nil {
+			return "", 0, err
+		}
+
+		if p != "" {
+			return p, version, nil
+		}
+	}
+
+	images, err := s.ListVMImages()
+	if err != nil {
+		return "", 0, errors.Wrap(err, "list vm images")
+	}
+
+	for _, img := range images {
+		if img.Arch == constants.GetVMImageArch() && img.Version == version && img.Flavor == string(s.ImageFlavor()) {
+			return img.Path, version, nil
+		}
+	}
+
+	return "", version, nil
+}
@@ -0,0 +1,297 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package transfer
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// FTP is the FileTransfer over a connection to the FTP share. The share user
+// is chrooted to the mounted file system, so the paths are used as-is. TLS is
+// not supported, as the share is meant to be reached over the loopback port
+// forwarding.
+type FTP struct {
+	c     *ftp.ServerConn
+	conns *connTracker
+}
+
+var _ FileTransfer = (*FTP)(nil)
+
+// DialFTP connects to the FTP server at the address and logs in.
+func DialFTP(ctx context.Context, addr string, user string, password string) (*FTP, error) {
+	conns := &connTracker{
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	var c *ftp.ServerConn
+
+	err := runWithContext(ctx, conns, func() error {
+		var err error
+
+		// Tracking the data connections too, so that the blocked
+		// transfers can be interrupted by closing them.
+		c, err = ftp.Dial(addr, ftp.DialWithDialFunc(conns.dial), ftp.DialWithForceListHidden(true))
+		if err != nil {
+			return errors.Wrap(err, "dial")
+		}
+
+		err = c.Login(user, password)
+		if err != nil {
+			_ = c.Quit()
+			return errors.Wrap(err, "login")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to ftp server")
+	}
+
+	return &FTP{
+		c:     c,
+		conns: conns,
+	}, nil
+}
+
+func (t *FTP) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	var entries []*ftp.Entry
+
+	err := runWithContext(ctx, t.conns, func() error {
+		var err error
+		entries, err = t.c.List(cleanPath(dir))
+		return mapFTPError(err)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list directory '%v'", dir)
+	}
+
+	ret := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+
+		ret = append(ret, ftpFileInfo{e: e})
+	}
+
+	return ret, nil
+}
+
+func (t *FTP) Stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	p = cleanPath(p)
+	if p == "/" {
+		return ftpFileInfo{e: &ftp.Entry{Name: "/", Type: ftp.EntryTypeFolder}}, nil
+	}
+
+	// Listing the parent directory, as LIST on a directory returns its contents,
+	// and the MLST command returning the entry itself is not supported by vsftpd.
+	entries, err := t.List(ctx, path.Dir(p))
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat '%v'", p)
+	}
+
+	for _, e := range entries {
+		if e.Name() == path.Base(p) {
+			return e, nil
+		}
+	}
+
+	return nil, errors.Wrapf(fs.ErrNotExist, "stat '%v'", p)
+}
+
+func (t *FTP) Get(ctx context.Context, p string, w io.Writer) error {
+	rc, err := t.Stream(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		_ = rc.Close()
+		return errors.Wrapf(err, "get '%v'", p)
+	}
+
+	err = rc.Close()
+	if err != nil {
+		return errors.Wrapf(err, "finish getting '%v'", p)
+	}
+
+	return nil
+}
+
+// Put implements FileTransfer. The permissions are
+// determined by the server umask, and perm is ignored.
+func (t *FTP) Put(ctx context.Context, p string, r io.Reader, perm fs.FileMode) error {
+	err := runWithContext(ctx, t.conns, func() error {
+		return mapFTPError(t.c.Stor(cleanPath(p), r))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "put '%v'", p)
+	}
+
+	return nil
+}
+
+func (t *FTP) Stream(ctx context.Context, p string) (io.ReadCloser, error) {
+	var resp *ftp.Response
+
+	err := runWithContext(ctx, t.conns, func() error {
+		var err error
+		resp, err = t.c.Retr(cleanPath(p))
+		return mapFTPError(err)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieve '%v'", p)
+	}
+
+	return newCtxReadCloser(ctx, t.conns, resp), nil
+}
+
+func (t *FTP) Mkdir(ctx context.Context, dir string) error {
+	err := runWithContext(ctx, t.conns, func() error {
+		return mapFTPError(t.c.MakeDir(cleanPath(dir)))
+	})
+	if err == nil {
+		return nil
+	}
+
+	stat, statErr := t.Stat(ctx, dir)
+	if statErr == nil && stat.IsDir() {
+		return nil
+	}
+
+	return errors.Wrapf(err, "create directory '%v'", dir)
+}
+
+func (t *FTP) Close() error {
+	return multierr.Combine(t.c.Quit(), t.conns.Close())
+}
+
+// mapFTPError makes the "file unavailable" replies match fs.ErrNotExist.
+// The reply is ambiguous, as vsftpd sends it for the permission errors too.
+func mapFTPError(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code == ftp.StatusFileUnavailable {
+		return errors.Wrap(fs.ErrNotExist, tpErr.Msg)
+	}
+
+	return err
+}
+
+type ftpFileInfo struct {
+	e *ftp.Entry
+}
+
+func (fi ftpFileInfo) Name() string {
+	return fi.e.Name
+}
+
+func (fi ftpFileInfo) Size() int64 {
+	return int64(fi.e.Size)
+}
+
+// Mode implements fs.FileInfo. The FTP listings
+// are not parsed for permissions, so they are made up.
+func (fi ftpFileInfo) Mode() fs.FileMode {
+	switch fi.e.Type {
+	case ftp.EntryTypeFolder:
+		return fs.ModeDir | 0755
+	case ftp.EntryTypeLink:
+		return fs.ModeSymlink | 0777
+	default:
+		return 0644
+	}
+}
+
+func (fi ftpFileInfo) ModTime() time.Time {
+	return fi.e.Time
+}
+
+func (fi ftpFileInfo) IsDir() bool {
+	return fi.e.Type == ftp.EntryTypeFolder
+}
+
+func (fi ftpFileInfo) Sys() any {
+	return fi.e
+}
+
+// connTracker dials the FTP control and data connections and closes
+// all of them on Close, which interrupts any blocked operation.
+type connTracker struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func (ct *connTracker) dial(network string, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.closed {
+		_ = conn.Close()
+		return nil, net.ErrClosed
+	}
+
+	ct.conns[conn] = struct{}{}
+
+	return &trackedConn{Conn: conn, ct: ct}, nil
+}
+
+func (ct *connTracker) Close() error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.closed = true
+
+	var err error
+	for conn := range ct.conns {
+		err = multierr.Append(err, conn.Close())
+	}
+
+	clear(ct.conns)
+
+	return err
+}
+
+type trackedConn struct {
+	net.Conn
+	ct *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.ct.mu.Lock()
+	delete(c.ct.conns, c.Conn)
+	c.ct.mu.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,244 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package transfer
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP is the FileTransfer over the SFTP subsystem of the root SSH
+// connection to the VM. Unlike the SFTP share, it needs no extra guest
+// configuration, and the files are created as root.
+type SFTP struct {
+	c    *vm.SFTPClient
+	root string
+}
+
+var (
+	_ FileTransfer    = (*SFTP)(nil)
+	_ SymlinkTransfer = (*SFTP)(nil)
+)
+
+// DialSFTP opens the SFTP session over a new SSH connection to the VM.
+func DialSFTP(ctx context.Context, vi vm.Instance) (*SFTP, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := vi.DialSFTP()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial sftp")
+	}
+
+	return &SFTP{
+		c:    c,
+		root: vm.MountRoot,
+	}, nil
+}
+
+// NewSFTP opens the SFTP session over the SSH connection, with the paths
+// resolved relative to the root directory in the VM. The connection is
+// closed by Close.
+func NewSFTP(ctx context.Context, sc *ssh.Client, root string) (*SFTP, error) {
+	var c *vm.SFTPClient
+
+	err := runWithContext(ctx, sc, func() error {
+		var err error
+		c, err = vm.NewSFTPClient(sc)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SFTP{
+		c:    c,
+		root: root,
+	}, nil
+}
+
+func (t *SFTP) vmPath(p string) string {
+	return path.Join(t.root, cleanPath(p))
+}
+
+func (t *SFTP) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	var ret []fs.FileInfo
+
+	err := runWithContext(ctx, t, func() error {
+		var err error
+		ret, err = t.c.ReadDir(t.vmPath(dir))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "read directory '%v'", dir)
+	}
+
+	return ret, nil
+}
+
+func (t *SFTP) Stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	var ret fs.FileInfo
+
+	err := runWithContext(ctx, t, func() error {
+		var err error
+		ret, err = t.c.Lstat(t.vmPath(p))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat '%v'", p)
+	}
+
+	return ret, nil
+}
+
+func (t *SFTP) Get(ctx context.Context, p string, w io.Writer) error {
+	err := runWithContext(ctx, t, func() error {
+		f, err := t.c.Open(t.vmPath(p))
+		if err != nil {
+			return errors.Wrap(err, "open")
+		}
+
+		defer func() { _ = f.Close() }()
+
+		_, err = f.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "get '%v'", p)
+	}
+
+	return nil
+}
+
+func (t *SFTP) Put(ctx context.Context, p string, r io.Reader, perm fs.FileMode) error {
+	err := runWithContext(ctx, t, func() error {
+		f, err := t.c.OpenFile(t.vmPath(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return errors.Wrap(err, "open")
+		}
+
+		_, err = f.ReadFrom(r)
+		if err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "write")
+		}
+
+		err = f.Chmod(perm.Perm())
+		if err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "chmod")
+		}
+
+		return f.Close()
+	})
+	if err != nil {
+		return errors.Wrapf(err, "put '%v'", p)
+	}
+
+	return nil
+}
+
+func (t *SFTP) Stream(ctx context.Context, p string) (io.ReadCloser, error) {
+	var f *sftp.File
+
+	err := runWithContext(ctx, t, func() error {
+		var err error
+		f, err = t.c.Open(t.vmPath(p))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open '%v'", p)
+	}
+
+	return newCtxReadCloser(ctx, t, f), nil
+}
+
+func (t *SFTP) Mkdir(ctx context.Context, dir string) error {
+	err := runWithContext(ctx, t, func() error {
+		err := t.c.Mkdir(t.vmPath(dir))
+		if err == nil {
+			return nil
+		}
+
+		stat, statErr := t.c.Stat(t.vmPath(dir))
+		if statErr == nil && stat.IsDir() {
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create directory '%v'", dir)
+	}
+
+	return nil
+}
+
+func (t *SFTP) Readlink(ctx context.Context, p string) (string, error) {
+	var ret string
+
+	err := runWithContext(ctx, t, func() error {
+		var err error
+		ret, err = t.c.ReadLink(t.vmPath(p))
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "read symlink '%v'", p)
+	}
+
+	return ret, nil
+}
+
+func (t *SFTP) Symlink(ctx context.Context, target string, p string) error {
+	err := runWithContext(ctx, t, func() error {
+		err := t.c.Symlink(target, t.vmPath(p))
+		if err == nil {
+			return nil
+		}
+
+		stat, statErr := t.c.Lstat(t.vmPath(p))
+		if statErr != nil || stat.IsDir() {
+			return err
+		}
+
+		err = t.c.Remove(t.vmPath(p))
+		if err != nil {
+			return errors.Wrap(err, "remove existing file")
+		}
+
+		return t.c.Symlink(target, t.vmPath(p))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create symlink '%v'", p)
+	}
+
+	return nil
+}
+
+func (t *SFTP) Close() error {
+	return t.c.Close()
+}
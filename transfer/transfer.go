@@ -0,0 +1,134 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package transfer
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// The IDs of the protocols to select the FileTransfer implementation with.
+const (
+	ProtocolSFTP = "sftp"
+	ProtocolFTP  = "ftp"
+)
+
+// ListProtocols returns the IDs of the supported protocols.
+func ListProtocols() []string {
+	return []string{ProtocolSFTP, ProtocolFTP}
+}
+
+// FileTransfer moves files between the host and the file system mounted
+// in the VM over a particular protocol. The paths are slash-separated and
+// relative to the root of the mounted file system, so "/" and "" both refer
+// to the root.
+//
+// Implementations are not safe for concurrent use, and the stream returned
+// by Stream must be closed before making the next call. None of the protocol
+// clients support contexts, so canceling the context of an in-flight call
+// closes the FileTransfer, which cannot be used afterwards.
+type FileTransfer interface {
+	// List returns the entries of the directory, without following the
+	// symlinks. The "." and ".." entries are not included.
+	List(ctx context.Context, dir string) ([]fs.FileInfo, error)
+
+	// Stat returns the information about the file, without following the
+	// symlink if the file is one. The error matches fs.ErrNotExist if
+	// the file does not exist.
+	Stat(ctx context.Context, p string) (fs.FileInfo, error)
+
+	// Get writes the contents of the file to w.
+	Get(ctx context.Context, p string, w io.Writer) error
+
+	// Put creates or truncates the file and writes the contents read from r
+	// to it. The permissions are applied where the protocol supports it.
+	Put(ctx context.Context, p string, r io.Reader, perm fs.FileMode) error
+
+	// Stream opens the file for reading.
+	Stream(ctx context.Context, p string) (io.ReadCloser, error)
+
+	// Mkdir creates the directory. It is not an error if it already exists.
+	Mkdir(ctx context.Context, dir string) error
+
+	Close() error
+}
+
+// SymlinkTransfer is an optional interface for the FileTransfer
+// implementations that can read and create symbolic links.
+type SymlinkTransfer interface {
+	Readlink(ctx context.Context, p string) (string, error)
+
+	// Symlink creates the symbolic link at p pointing to the target. An
+	// existing file at p is replaced, while a directory is not.
+	Symlink(ctx context.Context, target string, p string) error
+}
+
+// cleanPath makes the path absolute relative to the root of the mounted file system.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// runWithContext runs fn and closes c if the context is done before fn
+// returns, which is the only way to interrupt a blocked protocol call.
+// The context error is returned in this case.
+func runWithContext(ctx context.Context, c io.Closer, fn func() error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+
+	err = fn()
+	if !stop() {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// ctxReadCloser closes the underlying FileTransfer if the
+// context is done before the stream is closed.
+type ctxReadCloser struct {
+	io.ReadCloser
+	ctx  context.Context
+	stop func() bool
+}
+
+func newCtxReadCloser(ctx context.Context, c io.Closer, rc io.ReadCloser) *ctxReadCloser {
+	return &ctxReadCloser{
+		ReadCloser: rc,
+		ctx:        ctx,
+		stop:       context.AfterFunc(ctx, func() { _ = c.Close() }),
+	}
+}
+
+func (r *ctxReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && r.ctx.Err() != nil {
+		return n, r.ctx.Err()
+	}
+
+	return n, err
+}
+
+func (r *ctxReadCloser) Close() error {
+	r.stop()
+	return r.ReadCloser.Close()
+}
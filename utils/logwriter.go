@@ -0,0 +1,63 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// LogLineWriter logs every line written to it at the debug level.
+// An incomplete last line is kept until the rest of it is written.
+type LogLineWriter struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	msg    string
+	buf    []byte
+}
+
+func NewLogLineWriter(logger *slog.Logger, msg string) *LogLineWriter {
+	return &LogLineWriter{
+		logger: logger,
+		msg:    msg,
+	}
+}
+
+func (w *LogLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		line := strings.TrimRight(ClearUnprintableChars(string(w.buf[:i]), false), " \r")
+		w.buf = w.buf[i+1:]
+
+		if line != "" {
+			w.logger.Debug(w.msg, "line", line)
+		}
+	}
+
+	return len(p), nil
+}
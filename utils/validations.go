@@ -43,7 +43,7 @@ func ValidateFsType(s string) bool {
 	return fsTypeRegexp.MatchString(s)
 }
 
-var mountOptionsRegexp = regexp.MustCompile(`^([a-zA-Z0-9_]+(=[a-zA-Z0-9]+)?)(,[a-zA-Z0-9_]+(=[a-zA-Z0-9]+)?)*$`)
+var mountOptionsRegexp = regexp.MustCompile(`^([a-zA-Z0-9_]+(=[a-zA-Z0-9._:-]+)?)(,[a-zA-Z0-9_]+(=[a-zA-Z0-9._:-]+)?)*$`)
 
 func ValidateMountOptions(s string) bool {
 	return mountOptionsRegexp.MatchString(s)
@@ -58,12 +58,54 @@ func ValidateDevName(s string) bool {
 	return devNameRegexp.MatchString(s)
 }
 
+var lvmVolumeRegexp = regexp.MustCompile(`^[0-9A-Za-z_][0-9A-Za-z_-]*/[0-9A-Za-z_][0-9A-Za-z_-]*$`)
+
+// ValidateLVMVolume validates an LVM logical volume
+// reference in the "<volume group>/<logical volume>" form.
+func ValidateLVMVolume(s string) bool {
+	return lvmVolumeRegexp.MatchString(s)
+}
+
+var btrfsSubvolumeRegexp = regexp.MustCompile(`^[0-9A-Za-z_@.+-]+(/[0-9A-Za-z_@.+-]+)*$`)
+
+// ValidateBtrfsSubvolume validates a btrfs subvolume path
+// relative to the top-level subvolume, like "@home".
+func ValidateBtrfsSubvolume(s string) bool {
+	if !btrfsSubvolumeRegexp.MatchString(s) {
+		return false
+	}
+
+	for _, c := range strings.Split(s, "/") {
+		if c == "." || c == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+var mountNameRegexp = regexp.MustCompile(`^[0-9A-Za-z_][0-9A-Za-z_.-]*$`)
+
+// ValidateMountName validates the name of a share
+// root subdirectory to mount a device at.
+func ValidateMountName(s string) bool {
+	return mountNameRegexp.MatchString(s)
+}
+
 var unixUsernameRegexp = regexp.MustCompile(`^[a-z_]([a-z0-9_-]{0,31}|[a-z0-9_-]{0,30}\$)$`)
 
 func ValidateUnixUsername(s string) bool {
 	return unixUsernameRegexp.MatchString(s)
 }
 
+// NetBIOS names are limited to 15 characters, and we want
+// the hostname to be usable as one.
+var hostnameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,13}[a-z0-9])?$`)
+
+func ValidateHostname(s string) bool {
+	return hostnameRegexp.MatchString(s)
+}
+
 func Uint16ToBytesBE(v uint16) []byte {
 	b := make([]byte, 2)
 	binary.BigEndian.PutUint16(b, v)
@@ -0,0 +1,94 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/pkg/errors"
+)
+
+// AccelMode selects how the VM CPU is run.
+type AccelMode string
+
+const (
+	// AccelAuto uses hardware acceleration if available, falling back
+	// to software emulation otherwise.
+	AccelAuto AccelMode = "auto"
+	// AccelHardware requires hardware acceleration (KVM, HVF or WHPX).
+	AccelHardware AccelMode = "hw"
+	// AccelTCG forces software emulation with QEMU's TCG.
+	AccelTCG AccelMode = "tcg"
+)
+
+// emulationTimeoutMultiplier scales the VM timeouts under software
+// emulation, as booting takes several times longer.
+const emulationTimeoutMultiplier = 4
+
+func ParseAccelMode(s string) (AccelMode, error) {
+	switch AccelMode(s) {
+	case "", AccelAuto:
+		return AccelAuto, nil
+	case AccelHardware, AccelTCG:
+		return AccelMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown acceleration mode '%v' (available: auto, hw, tcg)", s)
+	}
+}
+
+// resolveAccelMode turns AccelAuto into either AccelHardware or AccelTCG
+// depending on the host capabilities.
+func resolveAccelMode(logger *slog.Logger, mode AccelMode) (AccelMode, error) {
+	if isWindowsARM64() {
+		// QEMU's WHPX backend supports x86_64 hosts only.
+		if mode == AccelHardware {
+			return "", fmt.Errorf("hardware acceleration is not supported by QEMU on Windows on ARM64")
+		}
+
+		logger.Warn("Windows on ARM64 has no QEMU hardware acceleration support, the VM will be emulated and run slower")
+
+		return AccelTCG, nil
+	}
+
+	if mode == AccelTCG || mode == AccelHardware {
+		return mode, nil
+	}
+
+	available, err := osspecifics.CheckHardwareAccelerationAvailable()
+	if err != nil {
+		return "", errors.Wrap(err, "check hardware acceleration available")
+	}
+
+	if available {
+		return AccelHardware, nil
+	}
+
+	logger.Warn("Hardware acceleration (KVM/HVF/WHPX) is unavailable, falling back to software emulation. The VM will be considerably slower and the timeouts are extended")
+
+	return AccelTCG, nil
+}
+
+func scaleTimeoutForAccel(mode AccelMode, timeout time.Duration) time.Duration {
+	if mode == AccelTCG {
+		return timeout * emulationTimeoutMultiplier
+	}
+
+	return timeout
+}
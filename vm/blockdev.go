@@ -0,0 +1,320 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+)
+
+// BlockDevice is a block device (disk, partition, or a mapped device) in the VM.
+type BlockDevice struct {
+	Name string `json:"name"`
+
+	// Type is the lsblk device type, like "disk", "part", "crypt", "lvm" or "raid1".
+	Type string `json:"type"`
+
+	Size   uint64 `json:"size"`
+	FSType string `json:"fstype"`
+	Label  string `json:"label"`
+	UUID   string `json:"uuid"`
+
+	// PartType is the partition type GUID (GPT) or ID (MBR, like "0x82").
+	PartType string `json:"parttype"`
+
+	// Encryption is "luks", "bitlocker", etc. for encrypted containers,
+	// and "unlocked" for the opened mappings of them. Empty otherwise.
+	Encryption string `json:"encryption,omitempty"`
+
+	// UsedBytes and FreeBytes are set by ProbeUsage only.
+	UsedBytes *uint64 `json:"used_bytes,omitempty"`
+	FreeBytes *uint64 `json:"free_bytes,omitempty"`
+
+	Classification DeviceClassification `json:"classification"`
+
+	Children []BlockDevice `json:"children,omitempty"`
+}
+
+// lsblkValue accepts both JSON strings and numbers, as the lsblk JSON
+// output types differ between util-linux versions.
+type lsblkValue string
+
+func (v *lsblkValue) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*v = ""
+		return nil
+	}
+
+	if len(b) != 0 && b[0] == '"' {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+
+		*v = lsblkValue(s)
+		return nil
+	}
+
+	*v = lsblkValue(b)
+	return nil
+}
+
+type lsblkDevice struct {
+	Name     lsblkValue    `json:"name"`
+	Type     lsblkValue    `json:"type"`
+	Size     lsblkValue    `json:"size"`
+	FSType   lsblkValue    `json:"fstype"`
+	Label    lsblkValue    `json:"label"`
+	UUID     lsblkValue    `json:"uuid"`
+	PartType lsblkValue    `json:"parttype"`
+	Children []lsblkDevice `json:"children"`
+}
+
+func (ld lsblkDevice) toBlockDevice() BlockDevice {
+	size, _ := strconv.ParseUint(string(ld.Size), 10, 64)
+
+	bd := BlockDevice{
+		Name:     string(ld.Name),
+		Type:     string(ld.Type),
+		Size:     size,
+		FSType:   string(ld.FSType),
+		Label:    string(ld.Label),
+		UUID:     string(ld.UUID),
+		PartType: strings.ToLower(string(ld.PartType)),
+	}
+
+	switch {
+	case bd.FSType == "crypto_LUKS":
+		bd.Encryption = "luks"
+	case bd.FSType == "BitLocker":
+		bd.Encryption = "bitlocker"
+	case bd.Type == "crypt":
+		bd.Encryption = "unlocked"
+	}
+
+	for _, c := range ld.Children {
+		bd.Children = append(bd.Children, c.toBlockDevice())
+	}
+
+	bd.Classification = bd.Classify()
+
+	return bd
+}
+
+// ListBlockDevices lists the block devices in the VM as a tree. The VM
+// OS drive and the other non-user devices are excluded.
+func (fm *FileManager) ListBlockDevices(ctx context.Context) ([]BlockDevice, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	out, err := sshutil.RunCmd(ctx, r, "lsblk -J -b -o NAME,TYPE,SIZE,FSTYPE,LABEL,UUID,PARTTYPE -e 7,11,2")
+	if err != nil {
+		return nil, errors.Wrap(err, "run lsblk")
+	}
+
+	var lsblkOut struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+
+	err = json.Unmarshal(out, &lsblkOut)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal lsblk output")
+	}
+
+	var ret []BlockDevice
+
+	for _, ld := range lsblkOut.BlockDevices {
+		// The VM OS drive.
+		if ld.Name == "vda" {
+			continue
+		}
+
+		ret = append(ret, ld.toBlockDevice())
+	}
+
+	return ret, nil
+}
+
+// FindBlockDevice looks up the device by name in the device tree. The
+// "mapper/" prefix is ignored. Nil is returned if there is no such device.
+func FindBlockDevice(devs []BlockDevice, name string) *BlockDevice {
+	name = strings.TrimPrefix(name, "mapper/")
+
+	for i := range devs {
+		if devs[i].Name == name {
+			return &devs[i]
+		}
+
+		if found := FindBlockDevice(devs[i].Children, name); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+type DeviceKind string
+
+const (
+	DeviceKindFilesystem     DeviceKind = "filesystem"
+	DeviceKindPartitionTable DeviceKind = "partition-table"
+	DeviceKindEFISystem      DeviceKind = "efi-system"
+	DeviceKindWindowsNTFS    DeviceKind = "windows-ntfs"
+	DeviceKindSwap           DeviceKind = "swap"
+	DeviceKindRAIDMember     DeviceKind = "raid-member"
+	DeviceKindLVMMember      DeviceKind = "lvm-member"
+	DeviceKindLUKS           DeviceKind = "luks"
+	DeviceKindBitLocker      DeviceKind = "bitlocker"
+	DeviceKindUnknown        DeviceKind = "unknown"
+)
+
+// DeviceClassification tells what a block device holds and whether
+// it makes sense to mount and share it.
+type DeviceClassification struct {
+	Kind      DeviceKind `json:"kind"`
+	Mountable bool       `json:"mountable"`
+
+	// Hint is a human-readable explanation or the next step to take. Optional.
+	Hint string `json:"hint,omitempty"`
+}
+
+const efiSystemPartGUID = "c12a7328-f81f-11d2-ba4b-00a0c93ec93b"
+
+// Classify classifies the block device by its contents.
+func (bd *BlockDevice) Classify() DeviceClassification {
+	switch bd.FSType {
+	case "swap":
+		return DeviceClassification{Kind: DeviceKindSwap, Hint: "swap space holds no files"}
+	case "linux_raid_member":
+		return DeviceClassification{Kind: DeviceKindRAIDMember, Hint: "assemble the array with --raid and mount the md device"}
+	case "LVM2_member":
+		return DeviceClassification{Kind: DeviceKindLVMMember, Hint: "mount the logical volumes (mapper/<vg>-<lv>) instead"}
+	case "crypto_LUKS":
+		return DeviceClassification{Kind: DeviceKindLUKS, Hint: "open with --luks, or --luks-container if it holds LVM"}
+	case "BitLocker":
+		return DeviceClassification{Kind: DeviceKindBitLocker, Hint: "BitLocker volumes are better accessed from Windows"}
+	case "":
+		if len(bd.Children) != 0 {
+			return DeviceClassification{Kind: DeviceKindPartitionTable, Hint: "mount one of the partitions instead"}
+		}
+
+		return DeviceClassification{Kind: DeviceKindUnknown, Hint: "no recognized file system (unformatted, or encrypted with plain dm-crypt/VeraCrypt)"}
+	}
+
+	if bd.PartType == efiSystemPartGUID || bd.PartType == "0xef" {
+		return DeviceClassification{Kind: DeviceKindEFISystem, Mountable: true, Hint: "EFI system partition, holds boot loaders only"}
+	}
+
+	if bd.FSType == "ntfs" {
+		return DeviceClassification{Kind: DeviceKindWindowsNTFS, Mountable: true, Hint: "Windows and macOS can read NTFS natively"}
+	}
+
+	return DeviceClassification{Kind: DeviceKindFilesystem, Mountable: true}
+}
+
+// getNoReplayMountOptions returns the mount options to mount the file system
+// read-only without replaying its journal, which would write to the device.
+func getNoReplayMountOptions(fsType string) string {
+	switch fsType {
+	case "ext3", "ext4":
+		return "ro,noload"
+	case "xfs":
+		return "ro,norecovery"
+	case "btrfs":
+		return "ro,rescue=nologreplay"
+	default:
+		return "ro"
+	}
+}
+
+// ProbeUsage fills in the used and free space of the mountable file systems
+// in the device tree. Every file system is briefly mounted read-only (without
+// a journal replay) for that. File systems that fail to mount are skipped.
+func (fm *FileManager) ProbeUsage(ctx context.Context, devs []BlockDevice) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	const tmpMnt = "/tmp/linsk-probe"
+
+	_, err = sshutil.RunCmd(ctx, r, "mkdir -p "+tmpMnt)
+	if err != nil {
+		return errors.Wrap(err, "create probe mount point")
+	}
+
+	var probe func(devs []BlockDevice) error
+	probe = func(devs []BlockDevice) error {
+		for i := range devs {
+			dev := &devs[i]
+
+			if dev.Classification.Mountable && utils.ValidateDevName(dev.Name) && utils.ValidateFsType(dev.FSType) {
+				out, err := sshutil.RunCmd(ctx, r, "mount -t "+dev.FSType+" -o "+getNoReplayMountOptions(dev.FSType)+" "+getBlockDevicePath(dev)+" "+tmpMnt+" 2>/dev/null && { stat -f -c '%S %b %a' "+tmpMnt+"; umount "+tmpMnt+"; } || true")
+				if err != nil {
+					return errors.Wrapf(err, "probe '%v'", dev.Name)
+				}
+
+				var blockSize, blocks, availBlocks uint64
+				_, err = fmt.Sscan(string(out), &blockSize, &blocks, &availBlocks)
+				if err == nil && availBlocks <= blocks {
+					used := (blocks - availBlocks) * blockSize
+					free := availBlocks * blockSize
+					dev.UsedBytes = &used
+					dev.FreeBytes = &free
+				}
+			}
+
+			err := probe(dev.Children)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return probe(devs)
+}
+
+func getBlockDevicePath(dev *BlockDevice) string {
+	switch dev.Type {
+	case "crypt", "lvm":
+		return "/dev/mapper/" + dev.Name
+	default:
+		return "/dev/" + dev.Name
+	}
+}
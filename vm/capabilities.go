@@ -0,0 +1,139 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/pkg/errors"
+)
+
+type GuestCapabilities struct {
+	KernelVersion string   `json:"kernel_version"`
+	Filesystems   []string `json:"filesystems"`
+
+	// Empty if the tool is not installed in the guest.
+	CryptsetupVersion string `json:"cryptsetup_version"`
+	LVMVersion        string `json:"lvm_version"`
+	MdadmVersion      string `json:"mdadm_version"`
+
+	RootFSTotalBytes uint64 `json:"rootfs_total_bytes"`
+	RootFSAvailBytes uint64 `json:"rootfs_avail_bytes"`
+}
+
+// InspectCapabilities queries the running guest for the kernel version,
+// supported file systems, device mapping tool versions, and the available
+// root file system space.
+func (fm *FileManager) InspectCapabilities(ctx context.Context) (*GuestCapabilities, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	var caps GuestCapabilities
+
+	kernelVersion, err := sshutil.RunCmd(ctx, r, "uname -r")
+	if err != nil {
+		return nil, errors.Wrap(err, "run uname cmd")
+	}
+
+	caps.KernelVersion = strings.TrimSpace(string(kernelVersion))
+
+	caps.Filesystems, err = fm.inspectFilesystems(ctx, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect filesystems")
+	}
+
+	caps.CryptsetupVersion, err = fm.inspectToolVersion(ctx, r, "cryptsetup --version")
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect cryptsetup version")
+	}
+
+	caps.LVMVersion, err = fm.inspectToolVersion(ctx, r, "lvm version | head -n 1")
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect lvm version")
+	}
+
+	caps.MdadmVersion, err = fm.inspectToolVersion(ctx, r, "mdadm --version 2>&1")
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect mdadm version")
+	}
+
+	caps.RootFSTotalBytes, caps.RootFSAvailBytes, err = fm.inspectRootFSSpace(ctx, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect root fs space")
+	}
+
+	return &caps, nil
+}
+
+func (fm *FileManager) inspectFilesystems(ctx context.Context, r sshutil.CommandRunner) ([]string, error) {
+	// Both the file systems that are already registered in the kernel
+	// and the ones that are available as loadable modules are included.
+	// The modules are mapped to the file systems through their "fs-<name>"
+	// aliases, as the module names (and the kernel/fs directories, which
+	// also hold helpers like nls or jbd2) differ from the file system ones.
+	out, err := sshutil.RunCmd(ctx, r, `grep -v nodev /proc/filesystems; sed -n 's/^alias fs-\([^ ]*\) .*/\1/p' "/lib/modules/$(uname -r)/modules.alias" 2>/dev/null || true`)
+	if err != nil {
+		return nil, errors.Wrap(err, "run list filesystems cmd")
+	}
+
+	fsMap := make(map[string]struct{})
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fsMap[line] = struct{}{}
+	}
+
+	fsList := make([]string, 0, len(fsMap))
+	for fs := range fsMap {
+		fsList = append(fsList, fs)
+	}
+
+	sort.Strings(fsList)
+
+	return fsList, nil
+}
+
+func (fm *FileManager) inspectToolVersion(ctx context.Context, r sshutil.CommandRunner, cmd string) (string, error) {
+	out, err := sshutil.RunCmd(ctx, r, "("+cmd+") 2>/dev/null || true")
+	if err != nil {
+		return "", errors.Wrap(err, "run version cmd")
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (fm *FileManager) inspectRootFSSpace(ctx context.Context, r sshutil.CommandRunner) (uint64, uint64, error) {
+	out, err := sshutil.RunCmd(ctx, r, "df -Pk /")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "run df cmd")
+	}
+
+	return parseDfOutput(out)
+}
@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
@@ -24,7 +25,6 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 
@@ -56,25 +56,60 @@ func cleanQEMUPath(s string) string {
 	return path
 }
 
-func configureBaseVMCmd(logger *slog.Logger, cfg Config) (string, []qemucli.Arg, error) {
+func isWindowsARM64() bool {
+	return osspecifics.IsWindows() && runtime.GOARCH == "arm64"
+}
+
+// GetQEMUSystemBaseCmd returns the name of the QEMU system
+// emulator binary suitable for the host architecture.
+func GetQEMUSystemBaseCmd() (string, error) {
 	baseCmd := "qemu-system"
 
+	switch runtime.GOARCH {
+	case "amd64":
+		baseCmd += "-x86_64"
+	case "arm64":
+		baseCmd += "-aarch64"
+	default:
+		return "", fmt.Errorf("arch '%v' is not supported", runtime.GOARCH)
+	}
+
+	if osspecifics.IsWindows() {
+		baseCmd += ".exe"
+	}
+
+	return baseCmd, nil
+}
+
+func configureBaseVMCmd(logger *slog.Logger, cfg Config) (string, []qemucli.Arg, error) {
+	baseCmd, err := GetQEMUSystemBaseCmd()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "get qemu system base cmd")
+	}
+
 	args := []qemucli.Arg{
 		qemucli.MustNewStringArg("serial", "stdio"),
 		qemucli.MustNewUintArg("m", cfg.MemoryAlloc),
-		qemucli.MustNewUintArg("smp", runtime.NumCPU()),
+		qemucli.MustNewUintArg("smp", cfg.CPUs),
 	}
 
-	if osspecifics.IsMacOS() {
+	if osspecifics.IsMacOS() && cfg.Accel != AccelTCG {
 		args = append(args, qemucli.MustNewStringArg("cpu", "host"))
 	}
 
 	var accel []qemucli.KeyValueArgItem
 	switch {
+	case cfg.Accel == AccelTCG:
+		// The default aarch64 "virt" CPU is 32-bit, hence "max".
+		args = append(args, qemucli.MustNewStringArg("cpu", "max"))
+		accel = []qemucli.KeyValueArgItem{
+			{Key: "tcg"},
+			{Key: "thread", Value: "multi"},
+		}
 	case osspecifics.IsWindows():
 		accel = []qemucli.KeyValueArgItem{
 			{Key: "whpx"},
-			{Key: "kernel-irqchip", Value: "off"},
+			qemucli.NewOnOffItem("kernel-irqchip", qemucli.Off),
 		}
 	case osspecifics.IsMacOS():
 		accel = []qemucli.KeyValueArgItem{{
@@ -86,10 +121,7 @@ func configureBaseVMCmd(logger *slog.Logger, cfg Config) (string, []qemucli.Arg,
 		}}
 	}
 
-	switch runtime.GOARCH {
-	case "amd64":
-		baseCmd += "-x86_64"
-	case "arm64":
+	if runtime.GOARCH == "arm64" {
 		if cfg.BIOSPath == "" {
 			logger.Warn("BIOS image path is not specified while attempting to run an aarch64 (arm64) VM. The VM will not boot.")
 		}
@@ -98,13 +130,9 @@ func configureBaseVMCmd(logger *slog.Logger, cfg Config) (string, []qemucli.Arg,
 		args = append(args,
 			qemucli.MustNewKeyValueArg("machine", []qemucli.KeyValueArgItem{
 				{Key: "type", Value: "virt"},
-				{Key: "highmem", Value: "off"},
+				qemucli.NewOnOffItem("highmem", qemucli.Off),
 			}),
 		)
-
-		baseCmd += "-aarch64"
-	default:
-		return "", nil, fmt.Errorf("arch '%v' is not supported", runtime.GOARCH)
 	}
 
 	args = append(args, qemucli.MustNewKeyValueArg("accel", accel))
@@ -135,50 +163,26 @@ func configureBaseVMCmd(logger *slog.Logger, cfg Config) (string, []qemucli.Arg,
 		args = append(args, cdromArg, qemucli.MustNewStringArg("boot", "d"))
 	}
 
-	if osspecifics.IsWindows() {
-		baseCmd += ".exe"
-	}
-
 	return baseCmd, args, nil
 }
 
 func configureVMCmdUserNetwork(ports []PortForwardingRule, unrestricted bool) ([]qemucli.Arg, error) {
-	netID := getUniqueQEMUNetID()
-
-	userNetdevValues := []qemucli.KeyValueArgItem{
-		{Key: "type", Value: "user"},
-		{Key: "id", Value: netID},
-	}
-
-	if !unrestricted {
-		userNetdevValues = append(userNetdevValues, qemucli.KeyValueArgItem{Key: "restrict", Value: "on"})
+	userNet := qemucli.UserNet{
+		ID:       getUniqueQEMUNetID(),
+		Restrict: !unrestricted,
 	}
 
 	for _, pf := range ports {
-		hostIPStr := ""
-		if pf.HostIP != nil {
-			hostIPStr = pf.HostIP.String()
-		}
-
-		userNetdevValues = append(userNetdevValues, qemucli.KeyValueArgItem{
-			Key:   "hostfwd",
-			Value: "tcp:" + hostIPStr + ":" + utils.UintToStr(pf.HostPort) + "-:" + utils.UintToStr(pf.VMPort),
+		userNet.Forwards = append(userNet.Forwards, qemucli.UserNetForward{
+			HostIP:    pf.HostIP,
+			HostPort:  pf.HostPort,
+			GuestPort: pf.VMPort,
 		})
 	}
 
-	netdevArg, err := qemucli.NewKeyValueArg("netdev", userNetdevValues)
+	args, err := userNet.Args()
 	if err != nil {
-		return nil, errors.Wrap(err, "create netdev key-value arg")
-	}
-
-	deviceArg, err := qemucli.NewKeyValueArg("device", []qemucli.KeyValueArgItem{{Key: "driver", Value: "virtio-net"}, {Key: "netdev", Value: netID}})
-	if err != nil {
-		return nil, errors.Wrap(err, "create device key-value arg")
-	}
-
-	args := []qemucli.Arg{
-		netdevArg,
-		deviceArg,
+		return nil, errors.Wrap(err, "create user network args")
 	}
 
 	return args, nil
@@ -190,19 +194,15 @@ func configureVMCmdTapNetwork(tapName string) ([]qemucli.Arg, error) {
 		return nil, errors.Wrapf(err, "validate network tap name '%v'", tapName)
 	}
 
-	netID := getUniqueQEMUNetID()
-
-	netdevArg, err := qemucli.NewKeyValueArg("netdev", []qemucli.KeyValueArgItem{{Key: "type", Value: "tap"}, {Key: "id", Value: netID}, {Key: "ifname", Value: tapName}, {Key: "script", Value: "no"}, {Key: "downscript", Value: "no"}})
-	if err != nil {
-		return nil, errors.Wrap(err, "create netdev key-value arg")
-	}
-
-	deviceArg, err := qemucli.NewKeyValueArg("device", []qemucli.KeyValueArgItem{{Key: "driver", Value: "virtio-net"}, {Key: "netdev", Value: netID}})
+	args, err := qemucli.TapNet{
+		ID:     getUniqueQEMUNetID(),
+		IfName: tapName,
+	}.Args()
 	if err != nil {
-		return nil, errors.Wrap(err, "create device key-value arg")
+		return nil, errors.Wrap(err, "create tap network args")
 	}
 
-	return []qemucli.Arg{netdevArg, deviceArg}, nil
+	return args, nil
 }
 
 func configureVMCmdNetworking(logger *slog.Logger, cfg Config, sshPort uint16) ([]qemucli.Arg, error) {
@@ -245,67 +245,54 @@ func configureVMCmdDrives(cfg Config) ([]qemucli.Arg, error) {
 			return nil, errors.Wrapf(err, "stat drive #%v path", i)
 		}
 
-		driveID := getUniqueQEMUDriveID()
 		drivePath := cleanQEMUPath(drive.Path)
 
-		driveKVItems := []qemucli.KeyValueArgItem{
-			{Key: "file", Value: drivePath},
-			{Key: "format", Value: "qcow2"},
-			{Key: "if", Value: "none"},
-			{Key: "id", Value: driveID},
-		}
-
-		if drive.SnapshotMode {
-			driveKVItems = append(driveKVItems, qemucli.KeyValueArgItem{
-				Key:   "snapshot",
-				Value: "on",
-			})
-		}
-
-		deviceKVItems := []qemucli.KeyValueArgItem{
-			{Key: "driver", Value: "virtio-blk-pci"},
-			{Key: "drive", Value: driveID},
+		qemuDrive := qemucli.Drive{
+			ID:       getUniqueQEMUDriveID(),
+			File:     drivePath,
+			Format:   qemucli.ImgFormatQCOW2,
+			Snapshot: drive.SnapshotMode,
 		}
 
 		if cfg.CdromImagePath == "" {
-			deviceKVItems = append(deviceKVItems, qemucli.KeyValueArgItem{
-				Key:   "bootindex",
-				Value: utils.IntToStr(i),
-			})
+			bootIndex := i
+			qemuDrive.BootIndex = &bootIndex
 		}
 
-		driveArg, err := qemucli.NewKeyValueArg("drive", driveKVItems)
+		driveArgs, err := qemuDrive.Args()
 		if err != nil {
-			return nil, errors.Wrapf(err, "create drive key-value arg (path '%v')", drivePath)
+			return nil, errors.Wrapf(err, "create drive args (path '%v')", drivePath)
 		}
 
-		deviceArg, err := qemucli.NewKeyValueArg("device", deviceKVItems)
-		if err != nil {
-			return nil, errors.Wrapf(err, "create device key-value arg (path '%v')", drivePath)
-		}
-
-		args = append(args, driveArg, deviceArg)
+		args = append(args, driveArgs...)
 	}
 
 	return args, nil
 }
 
-func configureVMCmdUSBPassthrough(cfg Config) []qemucli.Arg {
-	var args []qemucli.Arg
+func configureVMCmdUSBPassthrough(cfg Config) ([]qemucli.Arg, error) {
+	if len(cfg.PassthroughConfig.USB) == 0 {
+		return nil, nil
+	}
 
-	if len(cfg.PassthroughConfig.USB) != 0 {
-		args = append(args, qemucli.MustNewKeyValueArg("device", []qemucli.KeyValueArgItem{{Key: "driver", Value: "nec-usb-xhci"}}))
+	args, err := qemucli.XHCIController{}.Args()
+	if err != nil {
+		return nil, errors.Wrap(err, "create xhci controller args")
+	}
 
-		for _, dev := range cfg.PassthroughConfig.USB {
-			args = append(args, qemucli.MustNewKeyValueArg("device", []qemucli.KeyValueArgItem{
-				{Key: "driver", Value: "usb-host"},
-				{Key: "vendorid", Value: "0x" + hex.EncodeToString(utils.Uint16ToBytesBE(dev.VendorID))},
-				{Key: "productid", Value: "0x" + hex.EncodeToString(utils.Uint16ToBytesBE(dev.ProductID))},
-			}))
+	for _, dev := range cfg.PassthroughConfig.USB {
+		devArgs, err := qemucli.USBHostDevice{
+			VendorID:  dev.VendorID,
+			ProductID: dev.ProductID,
+		}.Args()
+		if err != nil {
+			return nil, errors.Wrapf(err, "create usb host device args (vendor id '%v', product id '%v')", dev.VendorID, dev.ProductID)
 		}
+
+		args = append(args, devArgs...)
 	}
 
-	return args
+	return args, nil
 }
 
 func configureVMCmdBlockDevicePassthrough(logger *slog.Logger, cfg Config) ([]qemucli.Arg, error) {
@@ -318,7 +305,7 @@ func configureVMCmdBlockDevicePassthrough(logger *slog.Logger, cfg Config) ([]qe
 	for _, dev := range cfg.PassthroughConfig.Block {
 		// It's always a user's responsibility to ensure that no drives are mounted
 		// in both host and guest system. This should serve as the last resort.
-		{
+		if dev.ImageFormat == "" {
 			seemsMounted, err := osspecifics.CheckDeviceSeemsMounted(dev.Path)
 			if err != nil {
 				return nil, errors.Wrapf(err, "check whether device seems to be mounted (path '%v')", dev.Path)
@@ -327,6 +314,11 @@ func configureVMCmdBlockDevicePassthrough(logger *slog.Logger, cfg Config) ([]qe
 			if seemsMounted {
 				return nil, fmt.Errorf("device '%v' seems to be already mounted in the host system", dev.Path)
 			}
+
+			err = osspecifics.CheckDeviceExclusiveAccess(dev.Path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "check exclusive access to device '%v'", dev.Path)
+			}
 		}
 
 		if dev.BlockSize == 0 {
@@ -341,33 +333,43 @@ func configureVMCmdBlockDevicePassthrough(logger *slog.Logger, cfg Config) ([]qe
 			return nil, fmt.Errorf("unaligned block size specified for device '%v' (must be in increments of 512): '%v'", dev.Path, dev.BlockSize)
 		}
 
-		strBlockSize := strconv.FormatUint(dev.BlockSize, 10)
-
 		devPath := cleanQEMUPath(dev.Path)
-		driveID := getUniqueQEMUDriveID()
 
-		driveDevArg, err := qemucli.NewKeyValueArg("device", []qemucli.KeyValueArgItem{
-			{Key: "driver", Value: "virtio-blk-pci"},
-			{Key: "drive", Value: driveID},
-			{Key: "logical_block_size", Value: strBlockSize},
-			{Key: "physical_block_size", Value: strBlockSize},
-		})
+		format := qemucli.ImgFormatRaw
+		if dev.ImageFormat != "" {
+			format = dev.ImageFormat
+		}
+
+		driveIO, err := resolveDriveIO(cfg.DriveIO, dev)
 		if err != nil {
-			return nil, errors.Wrapf(err, "create drive device key-value arg (path '%v')", devPath)
+			return nil, errors.Wrapf(err, "resolve drive io modes (path '%v')", dev.Path)
 		}
 
-		driveArg, err := qemucli.NewKeyValueArg("drive", []qemucli.KeyValueArgItem{
-			{Key: "file", Value: devPath},
-			{Key: "format", Value: "raw"},
-			{Key: "if", Value: "none"},
-			{Key: "id", Value: driveID},
-		})
+		driveArgs, err := qemucli.Drive{
+			ID:        getUniqueQEMUDriveID(),
+			File:      devPath,
+			Format:    format,
+			ReadOnly:  dev.ReadOnly,
+			Cache:     driveIO.Cache,
+			AIO:       driveIO.AIO,
+			BlockSize: dev.BlockSize,
+		}.Args()
 		if err != nil {
-			return nil, errors.Wrapf(err, "create drive key-value arg (path '%v')", devPath)
+			return nil, errors.Wrapf(err, "create drive args (path '%v')", devPath)
 		}
 
-		args = append(args, driveDevArg, driveArg)
+		args = append(args, driveArgs...)
 	}
 
 	return args, nil
 }
+
+func generateSessionHostname() (string, error) {
+	sessionID := make([]byte, 2)
+	_, err := rand.Read(sessionID)
+	if err != nil {
+		return "", errors.Wrap(err, "random read")
+	}
+
+	return "linsk-" + hex.EncodeToString(sessionID), nil
+}
@@ -0,0 +1,53 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"log/slog"
+	"runtime"
+)
+
+// maxAutoCPUs caps the automatically picked vCPU count. The in-VM work
+// (checksums, LUKS, compression, fsck) rarely scales any further, while
+// every vCPU takes host memory and scheduling time.
+const maxAutoCPUs = 8
+
+// resolveCPUs returns the vCPU count to use. Unless set explicitly, the VM
+// gets half of the host logical CPUs (capped at maxAutoCPUs), leaving the
+// rest to the host and the QEMU I/O threads.
+func resolveCPUs(logger *slog.Logger, cpus uint32) uint32 {
+	hostCPUs := runtime.NumCPU()
+
+	if cpus != 0 {
+		if int(cpus) > hostCPUs {
+			logger.Warn("The VM vCPU count exceeds the number of host logical CPUs, which is likely to hurt the performance", "vcpus", cpus, "host-cpus", hostCPUs)
+		}
+
+		return cpus
+	}
+
+	auto := hostCPUs / 2
+	if auto < 1 {
+		auto = 1
+	}
+
+	if auto > maxAutoCPUs {
+		auto = maxAutoCPUs
+	}
+
+	return uint32(auto)
+}
@@ -0,0 +1,100 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+)
+
+var devSpecTags = []string{"LABEL", "UUID", "PARTLABEL", "PARTUUID"}
+
+// IsDevSpec reports whether s references a device by a tag like "LABEL=data"
+// or "UUID=...", as opposed to a device name. Tag references are stable
+// regardless of the order the devices are enumerated in.
+func IsDevSpec(s string) bool {
+	tag, _, ok := strings.Cut(s, "=")
+	if !ok {
+		return false
+	}
+
+	for _, t := range devSpecTags {
+		if tag == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fm *FileManager) resolveDevSpecWithRunner(ctx context.Context, r sshutil.CommandRunner, spec string) (string, error) {
+	_, value, _ := strings.Cut(spec, "=")
+	if value == "" || strings.IndexFunc(value, unicode.IsControl) != -1 {
+		return "", fmt.Errorf("bad device tag value")
+	}
+
+	out, err := sshutil.RunCmd(ctx, r, "blkid -o device -t "+shellescape.Quote(spec)+" || true")
+	if err != nil {
+		return "", errors.Wrap(err, "run blkid")
+	}
+
+	devPaths := strings.Fields(string(out))
+
+	switch len(devPaths) {
+	case 0:
+		return "", fmt.Errorf("%w: no device matching '%v' found", ErrDeviceNotFound, spec)
+	case 1:
+	default:
+		return "", fmt.Errorf("'%v' is ambiguous, matching devices: %v", spec, strings.Join(devPaths, ", "))
+	}
+
+	devName := strings.TrimPrefix(devPaths[0], "/dev/")
+	if !utils.ValidateDevName(devName) {
+		return "", fmt.Errorf("resolved device path '%v' is not supported", devPaths[0])
+	}
+
+	fm.logger.Info("Resolved device", "spec", spec, "dev", devPaths[0])
+
+	return devName, nil
+}
+
+// ResolveDevName resolves a device tag reference (see IsDevSpec) into a
+// device name. Device names are returned unchanged.
+func (fm *FileManager) ResolveDevName(ctx context.Context, devName string) (string, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	if !IsDevSpec(devName) {
+		return devName, nil
+	}
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return "", errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	return fm.resolveDevSpecWithRunner(ctx, r, devName)
+}
@@ -0,0 +1,89 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/AlexSSD7/linsk/qemucli"
+)
+
+// DriveIOAuto is the CLI value selecting the per-platform default
+// drive cache or AIO mode.
+const DriveIOAuto = "auto"
+
+// DriveIOConfig selects how QEMU does the I/O on the passed through block
+// devices and disk images. The empty modes are picked per platform.
+type DriveIOConfig struct {
+	Cache qemucli.DriveCache
+	AIO   qemucli.DriveAIO
+}
+
+// ParseDriveCache parses the CLI drive cache mode. "auto" is the default.
+func ParseDriveCache(s string) (qemucli.DriveCache, error) {
+	switch c := qemucli.DriveCache(s); c {
+	case "", DriveIOAuto:
+		return qemucli.DriveCacheDefault, nil
+	case qemucli.DriveCacheNone, qemucli.DriveCacheWriteBack, qemucli.DriveCacheWriteThrough, qemucli.DriveCacheDirectSync, qemucli.DriveCacheUnsafe:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown drive cache mode '%v' (available: auto, none, writeback, writethrough, directsync, unsafe)", s)
+	}
+}
+
+// ParseDriveAIO parses the CLI drive AIO mode. "auto" is the default.
+func ParseDriveAIO(s string) (qemucli.DriveAIO, error) {
+	switch a := qemucli.DriveAIO(s); a {
+	case "", DriveIOAuto:
+		return qemucli.DriveAIODefault, nil
+	case qemucli.DriveAIOThreads, qemucli.DriveAIONative, qemucli.DriveAIOIOUring:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown drive aio mode '%v' (available: auto, threads, native, io_uring)", s)
+	}
+}
+
+// resolveDriveIO returns the cache and AIO modes to use for the passed
+// through device. Unless set explicitly, the raw block devices on Linux
+// bypass the host page cache and use the native AIO, which is considerably
+// faster than QEMU's defaults (writeback and a thread pool). The block
+// devices always support O_DIRECT, unlike some of the file systems the
+// disk images can be on, so the images keep QEMU's defaults.
+func resolveDriveIO(cfg DriveIOConfig, dev BlockDevicePassthroughConfig) (DriveIOConfig, error) {
+	switch cfg.AIO {
+	case qemucli.DriveAIONative:
+		if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+			return DriveIOConfig{}, fmt.Errorf("drive aio mode '%v' is not supported on %v", cfg.AIO, runtime.GOOS)
+		}
+	case qemucli.DriveAIOIOUring:
+		if runtime.GOOS != "linux" {
+			return DriveIOConfig{}, fmt.Errorf("drive aio mode '%v' is not supported on %v", cfg.AIO, runtime.GOOS)
+		}
+	}
+
+	if cfg.Cache == qemucli.DriveCacheDefault && cfg.AIO == qemucli.DriveAIODefault && runtime.GOOS == "linux" && dev.ImageFormat == "" {
+		return DriveIOConfig{Cache: qemucli.DriveCacheNone, AIO: qemucli.DriveAIONative}, nil
+	}
+
+	// The native AIO works only with O_DIRECT.
+	if cfg.AIO == qemucli.DriveAIONative && cfg.Cache == qemucli.DriveCacheDefault {
+		cfg.Cache = qemucli.DriveCacheNone
+	}
+
+	return cfg, nil
+}
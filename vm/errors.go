@@ -22,4 +22,32 @@ import (
 
 var (
 	ErrSSHUnavailable = errors.New("ssh unavailable")
+
+	// ErrBootTimeout is returned when the VM does not boot or
+	// finish its setup in time.
+	ErrBootTimeout = errors.New("vm boot timeout")
+
+	// ErrDeviceNotFound is returned when the device to pass
+	// through or to mount does not exist.
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrPermissionDenied is returned when the host denies
+	// access to the device to pass through.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrHostKeyMismatch is returned when the guest SSH server presents
+	// a host key other than the one scanned during the VM setup.
+	ErrHostKeyMismatch = errors.New("ssh host key mismatch")
+
+	// ErrUnlockFailed is returned when an encrypted volume cannot be
+	// unlocked, most commonly because of a wrong password or key.
+	ErrUnlockFailed = errors.New("unlock failed")
+
+	// ErrInstanceExists is returned by Manager.Start when
+	// an instance with the same name is already running.
+	ErrInstanceExists = errors.New("instance already exists")
+
+	// ErrInstanceNotFound is returned when the Manager
+	// has no running instance with the name.
+	ErrInstanceNotFound = errors.New("instance not found")
 )
@@ -0,0 +1,97 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventTypeLowMemory    EventType = "low-memory"
+	EventTypeLowDiskSpace EventType = "low-disk-space"
+)
+
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Message string
+	Remedy  string
+}
+
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+func (eb *eventBus) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+
+	eb.mu.Lock()
+	eb.subs[ch] = struct{}{}
+	eb.mu.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			eb.mu.Lock()
+			delete(eb.subs, ch)
+			eb.mu.Unlock()
+
+			close(ch)
+		})
+	}
+}
+
+func (eb *eventBus) emit(e Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for ch := range eb.subs {
+		select {
+		case ch <- e:
+		default:
+			// Event gets discarded if the subscriber is not keeping up.
+		}
+	}
+}
+
+// SubscribeEvents returns a channel that receives the VM events, and
+// a function to cancel the subscription. Events are dropped if the
+// channel buffer is full.
+func (vm *VM) SubscribeEvents() (<-chan Event, func()) {
+	return vm.events.subscribe()
+}
+
+func (vm *VM) emitEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	vm.logger.Warn(e.Message, "event", e.Type, "remedy", e.Remedy)
+
+	vm.events.emit(e)
+}
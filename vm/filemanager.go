@@ -21,9 +21,12 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -41,26 +44,167 @@ import (
 type FileManager struct {
 	logger *slog.Logger
 
-	vm *VM
+	vm Instance
+
+	// runner runs the guest commands if set with SetCommandRunner.
+	// Otherwise, the commands run over the VM SSH connection.
+	runner sshutil.CommandRunner
+
+	// readOnly is set once the device is mounted read-only.
+	// The file share servers are configured to reject writes then.
+	readOnly bool
+
+	// shareUser is the name of the guest user the file
+	// share servers authenticate and access files as.
+	shareUser string
+
+	shareProfile ShareProfile
 }
 
-func NewFileManager(logger *slog.Logger, vm *VM) *FileManager {
+// ShareProfile tunes the file share servers for a specific use case.
+type ShareProfile string
+
+const (
+	ShareProfileDefault ShareProfile = ""
+
+	// ShareProfileTimeMachine makes the SMB and AFP servers
+	// usable as macOS Time Machine backup destinations.
+	ShareProfileTimeMachine ShareProfile = "timemachine"
+)
+
+func ValidateShareProfile(p ShareProfile) error {
+	switch p {
+	case ShareProfileDefault, ShareProfileTimeMachine:
+		return nil
+	default:
+		return fmt.Errorf("unknown share profile '%v'", p)
+	}
+}
+
+// DefaultShareUser is the name of the guest user owning the shared files.
+const DefaultShareUser = "linsk"
+
+func NewFileManager(logger *slog.Logger, vm Instance) *FileManager {
 	return &FileManager{
 		logger: logger,
 
 		vm: vm,
+
+		shareUser: DefaultShareUser,
 	}
 }
 
-func (fm *FileManager) InitLVM() error {
+// SetCommandRunner makes the FileManager run the guest commands with the
+// runner, like a fake one in tests. The operations that need the raw SSH
+// connection, like copying files and entering passwords, still use the VM.
+func (fm *FileManager) SetCommandRunner(r sshutil.CommandRunner) {
+	fm.runner = r
+}
+
+// dialRunner returns the runner for the guest commands of an operation,
+// along with the function to release it once the operation is done.
+func (fm *FileManager) dialRunner() (sshutil.CommandRunner, func(), error) {
+	if fm.runner != nil {
+		return fm.runner, func() {}, nil
+	}
+
 	sc, err := fm.vm.DialSSH()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sshutil.NewSSHRunner(sc), func() { _ = sc.Close() }, nil
+}
+
+// runnerFor returns the runner for the guest commands of an
+// operation which uses the SSH connection for other things too.
+func (fm *FileManager) runnerFor(sc *ssh.Client) sshutil.CommandRunner {
+	if fm.runner != nil {
+		return fm.runner
+	}
+
+	return sshutil.NewSSHRunner(sc)
+}
+
+// withVMContext returns a context which is canceled when either the
+// passed one is canceled or the VM is shutting down.
+func (fm *FileManager) withVMContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(fm.vm.Context(), cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// SetShareUser renames the guest share user. This needs to be done
+// before any file share server is started. The user keeps its UID and
+// the "linsk" group, so the ownership of the files is not affected.
+func (fm *FileManager) SetShareUser(ctx context.Context, username string) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	if !utils.ValidateUnixUsername(username) {
+		return fmt.Errorf("invalid username '%v'", username)
+	}
+
+	if username == fm.shareUser {
+		return nil
+	}
+
+	r, closeRunner, err := fm.dialRunner()
 	if err != nil {
 		return errors.Wrap(err, "dial vm ssh")
 	}
 
-	defer func() { _ = sc.Close() }()
+	defer closeRunner()
+
+	// The username was validated above and is safe to use in the sed expression.
+	_, err = sshutil.RunCmd(ctx, r, "sed -i "+shellescape.Quote("s/^"+fm.shareUser+":/"+username+":/")+" /etc/passwd /etc/shadow")
+	if err != nil {
+		return errors.Wrap(err, "rename user")
+	}
+
+	fm.shareUser = username
+
+	return nil
+}
+
+// SetShareProfile sets the profile the file share servers are configured
+// with. This needs to be done before any file share server is started.
+func (fm *FileManager) SetShareProfile(p ShareProfile) error {
+	err := ValidateShareProfile(p)
+	if err != nil {
+		return err
+	}
+
+	if p == ShareProfileTimeMachine && fm.readOnly {
+		return fmt.Errorf("time machine profile cannot be used with a read-only mount")
+	}
+
+	fm.shareProfile = p
+
+	return nil
+}
+
+// ShareUser returns the name of the guest share user.
+func (fm *FileManager) ShareUser() string {
+	return fm.shareUser
+}
+
+func (fm *FileManager) InitLVM(ctx context.Context) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
 
-	_, err = sshutil.RunSSHCmd(fm.vm.ctx, sc, "vgchange -ay")
+	_, err = sshutil.RunCmd(ctx, r, "vgchange -ay")
 	if err != nil {
 		return errors.Wrap(err, "run vgchange cmd")
 	}
@@ -68,13 +212,89 @@ func (fm *FileManager) InitLVM() error {
 	return nil
 }
 
-func (fm *FileManager) Lsblk() ([]byte, error) {
-	sc, err := fm.vm.DialSSH()
+// LVMDevName returns the device mapper device name (with the "mapper/"
+// prefix) of the LVM logical volume in the "<vg>/<lv>" form.
+func LVMDevName(vgLV string) (string, error) {
+	if !utils.ValidateLVMVolume(vgLV) {
+		return "", fmt.Errorf("bad lvm volume reference '%v', expected '<volume group>/<logical volume>'", vgLV)
+	}
+
+	vg, lv, _ := strings.Cut(vgLV, "/")
+
+	// Device mapper escapes dashes in LVM names by doubling them.
+	return "mapper/" + strings.ReplaceAll(vg, "-", "--") + "-" + strings.ReplaceAll(lv, "-", "--"), nil
+}
+
+func (fm *FileManager) activateLVWithRunner(ctx context.Context, r sshutil.CommandRunner, vgLV string) error {
+	if !utils.ValidateLVMVolume(vgLV) {
+		return fmt.Errorf("bad lvm volume reference")
+	}
+
+	// -K ignores the activation skip flag, which is set
+	// on thin snapshots by default.
+	_, err := sshutil.RunCmd(ctx, r, "lvchange -ay -K "+vgLV)
+	if err != nil {
+		return errors.Wrap(err, "run lvchange cmd")
+	}
+
+	return nil
+}
+
+// ListLVs lists all LVM logical volumes, including snapshots and thin pools.
+func (fm *FileManager) ListLVs(ctx context.Context) ([]byte, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	ret, err := sshutil.RunCmd(ctx, r, "lvs -a -o vg_name,lv_name,lv_attr,lv_size,origin,pool_lv,lv_active")
+	if err != nil {
+		return nil, errors.Wrap(err, "run lvs")
+	}
+
+	return ret, nil
+}
+
+// ListBtrfsSubvolumes lists the subvolumes of all btrfs file systems found.
+// Every file system is temporarily mounted read-only for that.
+func (fm *FileManager) ListBtrfsSubvolumes(ctx context.Context) ([]byte, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	const tmpMnt = "/tmp/linsk-btrfs"
+
+	ret, err := sshutil.RunCmd(ctx, r, `mkdir -p `+tmpMnt+` && for dev in $(blkid -t TYPE=btrfs -o device); do echo "$dev:"; mount -o ro "$dev" `+tmpMnt+` && { btrfs subvolume list `+tmpMnt+`; umount `+tmpMnt+`; }; done`)
+	if err != nil {
+		return nil, errors.Wrap(err, "run btrfs subvolume list")
+	}
+
+	return ret, nil
+}
+
+func (fm *FileManager) Lsblk(ctx context.Context) ([]byte, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
 	if err != nil {
 		return nil, errors.Wrap(err, "dial vm ssh")
 	}
 
-	ret, err := sshutil.RunSSHCmd(fm.vm.ctx, sc, "lsblk -o NAME,SIZE,FSTYPE,LABEL -e 7,11,2")
+	defer closeRunner()
+
+	ret, err := sshutil.RunCmd(ctx, r, "lsblk -o NAME,SIZE,FSTYPE,LABEL -e 7,11,2")
 	if err != nil {
 		return nil, errors.Wrap(err, "run lsblk")
 	}
@@ -82,18 +302,209 @@ func (fm *FileManager) Lsblk() ([]byte, error) {
 	return ret, nil
 }
 
+// LUKSOptions configures how LUKS devices (or, despite the name, VeraCrypt
+// volumes and plain dm-crypt mappings) are opened.
+type LUKSOptions struct {
+	// KeyFile is the host path to the key file to unlock the devices with.
+	// The password is prompted interactively if both this and Password are empty.
+	KeyFile string
+
+	// Password is the password to unlock the devices with, for the
+	// unattended use. The password is prompted interactively if it is empty.
+	Password []byte
+
+	// Header is the host path to a detached LUKS header file.
+	Header string
+
+	// Offset is the start of the encrypted data in 512-byte sectors.
+	// Zero means the cryptsetup default.
+	Offset uint64
+
+	// KeySlot restricts the unlock to a specific key slot. Nil means any.
+	KeySlot *int
+
+	// VeraCrypt opens VeraCrypt/TrueCrypt volumes (cryptsetup
+	// tcrypt mode) instead of LUKS ones.
+	VeraCrypt bool
+
+	// VeraCryptHidden opens the hidden volume within a VeraCrypt volume.
+	VeraCryptHidden bool
+
+	// VeraCryptPIM is the VeraCrypt Personal Iterations Multiplier. Zero means the default.
+	VeraCryptPIM uint32
+
+	// Plain opens plain (non-LUKS) dm-crypt mappings instead of LUKS ones if not nil.
+	Plain *PlainCryptOptions
+}
+
+// PlainCryptOptions are the plain dm-crypt mapping parameters. As plain
+// mode has no header, these must match the ones used to create the mapping.
+// Empty values mean the cryptsetup defaults, which differ between versions.
+type PlainCryptOptions struct {
+	Cipher  string
+	Hash    string
+	KeySize uint32
+
+	// Skip is the number of 512-byte sectors to skip at the
+	// beginning when calculating the IV.
+	Skip uint64
+}
+
+var cryptParamRegexp = regexp.MustCompile(`^[a-z0-9:_-]+$`)
+
+func (po *PlainCryptOptions) args() (string, error) {
+	var args string
+
+	if po.Cipher != "" {
+		if !cryptParamRegexp.MatchString(po.Cipher) {
+			return "", fmt.Errorf("bad cipher")
+		}
+
+		args += "--cipher " + po.Cipher + " "
+	}
+
+	if po.Hash != "" {
+		if !cryptParamRegexp.MatchString(po.Hash) {
+			return "", fmt.Errorf("bad hash")
+		}
+
+		args += "--hash " + po.Hash + " "
+	}
+
+	if po.KeySize != 0 {
+		args += "--key-size " + fmt.Sprint(po.KeySize) + " "
+	}
+
+	if po.Skip != 0 {
+		args += "--skip " + fmt.Sprint(po.Skip) + " "
+	}
+
+	return args, nil
+}
+
+// luksOpenCmd prepares the guest for opening a LUKS device and returns the
+// cryptsetup command prefix (without the device and mapping names). The
+// returned cleanup function removes the transferred files and must be
+// called once the command finishes.
+func (fm *FileManager) luksOpenCmd(ctx context.Context, r sshutil.CommandRunner, luksDMName string, readOnly bool, opts LUKSOptions) (string, func(), error) {
+	cmd := "cryptsetup luksOpen "
+
+	switch {
+	case opts.VeraCrypt && opts.Plain != nil:
+		return "", nil, fmt.Errorf("veracrypt and plain modes are mutually exclusive")
+	case opts.Plain != nil:
+		if opts.KeySlot != nil || opts.Header != "" {
+			return "", nil, fmt.Errorf("key slots and detached headers are unsupported in plain mode")
+		}
+
+		plainArgs, err := opts.Plain.args()
+		if err != nil {
+			return "", nil, errors.Wrap(err, "plain mode options")
+		}
+
+		cmd = "cryptsetup open --type plain " + plainArgs
+	case opts.VeraCrypt:
+		if opts.KeyFile != "" || opts.KeySlot != nil {
+			return "", nil, fmt.Errorf("key files and key slots are unsupported for veracrypt volumes")
+		}
+
+		cmd = "cryptsetup tcryptOpen --veracrypt "
+		if opts.VeraCryptHidden {
+			cmd += "--tcrypt-hidden "
+		}
+		if opts.VeraCryptPIM != 0 {
+			cmd += "--veracrypt-pim " + fmt.Sprint(opts.VeraCryptPIM) + " "
+		}
+	}
+
+	if !opts.VeraCrypt && (opts.VeraCryptHidden || opts.VeraCryptPIM != 0) {
+		return "", nil, fmt.Errorf("veracrypt options specified for a non-veracrypt volume")
+	}
+
+	if readOnly {
+		cmd += "--readonly "
+	}
+
+	if opts.Offset != 0 {
+		cmd += "--offset " + fmt.Sprint(opts.Offset) + " "
+	}
+
+	if opts.KeySlot != nil {
+		if *opts.KeySlot < 0 {
+			return "", nil, fmt.Errorf("bad luks key slot %v", *opts.KeySlot)
+		}
+
+		cmd += "--key-slot " + fmt.Sprint(*opts.KeySlot) + " "
+	}
+
+	if opts.Header == "" {
+		return cmd, func() {}, nil
+	}
+
+	headerFile, err := os.Open(opts.Header)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "open luks header file")
+	}
+
+	defer func() { _ = headerFile.Close() }()
+
+	// /run is a tmpfs. The header is only needed to set up the mapping.
+	guestHeaderPath := "/run/linsk-" + luksDMName + ".header"
+
+	err = fm.copyFile(ctx, headerFile, guestHeaderPath, "0400")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "copy luks header file")
+	}
+
+	cleanup := func() {
+		_, err := sshutil.RunCmd(ctx, r, "rm -f "+guestHeaderPath)
+		if err != nil {
+			fm.logger.Error("Failed to remove the LUKS header file from the VM", "error", err.Error())
+		}
+	}
+
+	return cmd + "--header " + guestHeaderPath + " ", cleanup, nil
+}
+
 type MountConfig struct {
 	LUKSContainerPreopen string
+	LUKSOptions          LUKSOptions
+
+	// LVMActivate is the LVM logical volume in the "<vg>/<lv>" form
+	// to activate before mounting. This is needed for snapshots that
+	// are not activated by default, such as thin snapshots.
+	LVMActivate string
 
 	FSTypeOverride string
 	LUKS           bool
 	MountOptions   string
+
+	// BtrfsSubvolume is the btrfs subvolume to mount
+	// instead of the default one. Optional.
+	BtrfsSubvolume string
+
+	// ReadOnly mounts the file system (and opens LUKS devices) read-only,
+	// and makes the file share servers reject writes.
+	ReadOnly bool
 }
 
-func (fm *FileManager) luksOpen(sc *ssh.Client, fullDevPath string, luksDMName string) error {
+func (fm *FileManager) luksOpen(ctx context.Context, sc *ssh.Client, fullDevPath string, luksDMName string, readOnly bool, opts LUKSOptions) error {
+	r := fm.runnerFor(sc)
+
+	if opts.KeyFile != "" {
+		return fm.luksOpenWithKeyFile(ctx, r, fullDevPath, luksDMName, readOnly, opts)
+	}
+
 	lg := fm.logger.With("vm-path", fullDevPath)
 
-	return sshutil.NewSSHSessionWithDelayedTimeout(fm.vm.ctx, time.Second*15, sc, func(sess *ssh.Session, startTimeout func(preTimeout func())) error {
+	cmd, cleanup, err := fm.luksOpenCmd(ctx, r, luksDMName, readOnly, opts)
+	if err != nil {
+		return errors.Wrap(err, "prepare cryptsetup luksopen cmd")
+	}
+
+	defer cleanup()
+
+	return sshutil.NewSSHSessionWithDelayedTimeout(ctx, time.Second*15, sc, func(sess *ssh.Session, startTimeout func(preTimeout func())) error {
 		stdinPipe, err := sess.StdinPipe()
 		if err != nil {
 			return errors.Wrap(err, "create vm ssh session stdin pipe")
@@ -102,24 +513,30 @@ func (fm *FileManager) luksOpen(sc *ssh.Client, fullDevPath string, luksDMName s
 		stderrBuf := bytes.NewBuffer(nil)
 		sess.Stderr = stderrBuf
 
-		err = sess.Start("cryptsetup luksOpen " + shellescape.Quote(fullDevPath) + " " + luksDMName)
+		err = sess.Start(cmd + shellescape.Quote(fullDevPath) + " " + luksDMName)
 		if err != nil {
 			return errors.Wrap(err, "start cryptsetup luksopen cmd")
 		}
 
 		lg.Info("Attempting to open a LUKS device")
 
-		_, err = os.Stderr.Write([]byte("Enter Password: "))
-		if err != nil {
-			return errors.Wrap(err, "write prompt to stderr")
-		}
+		var pwd []byte
+		if len(opts.Password) != 0 {
+			// The copy is cleared after use, not the original.
+			pwd = slices.Clone(opts.Password)
+		} else {
+			_, err = os.Stderr.Write([]byte("Enter Password: "))
+			if err != nil {
+				return errors.Wrap(err, "write prompt to stderr")
+			}
 
-		pwd, err := term.ReadPassword(int(syscall.Stdin)) //nolint:unconvert // On Windows it's a different non-int type.
-		if err != nil {
-			return errors.Wrap(err, "read luks password")
-		}
+			pwd, err = term.ReadPassword(int(syscall.Stdin)) //nolint:unconvert // On Windows it's a different non-int type.
+			if err != nil {
+				return errors.Wrap(err, "read luks password")
+			}
 
-		fmt.Print("\n")
+			fmt.Print("\n")
+		}
 
 		// We start the timeout countdown now only to avoid timing out
 		// while the user is entering the password, or shortly after that.
@@ -158,7 +575,7 @@ func (fm *FileManager) luksOpen(sc *ssh.Client, fullDevPath string, luksDMName s
 				fm.logger.Warn("Detected not enough memory to open a LUKS device, please allocate more memory using --vm-mem-alloc flag.")
 			}
 
-			return utils.WrapErrWithLog(err, "wait for cryptsetup luksopen cmd to finish", stderrBuf.String())
+			return utils.WrapErrWithLog(fmt.Errorf("%w: %w", ErrUnlockFailed, err), "wait for cryptsetup luksopen cmd to finish", stderrBuf.String())
 		}
 
 		lg.Info("LUKS device opened successfully")
@@ -170,7 +587,67 @@ func (fm *FileManager) luksOpen(sc *ssh.Client, fullDevPath string, luksDMName s
 	})
 }
 
-func (fm *FileManager) PreopenLUKSContainer(containerDevPath string) error {
+// luksOpenWithKeyFile transfers the key file to the guest tmpfs and opens
+// the LUKS device with it. The key file is shredded right after.
+func (fm *FileManager) luksOpenWithKeyFile(ctx context.Context, r sshutil.CommandRunner, fullDevPath string, luksDMName string, readOnly bool, opts LUKSOptions) error {
+	lg := fm.logger.With("vm-path", fullDevPath)
+
+	key, err := os.ReadFile(opts.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "read luks key file")
+	}
+
+	defer func() {
+		// Clear the memory up for security.
+		for i := 0; i < len(key); i++ {
+			key[i] = 0
+		}
+	}()
+
+	if len(key) == 0 {
+		return fmt.Errorf("luks key file is empty")
+	}
+
+	// /run is a tmpfs, so the key never reaches a persistent storage.
+	guestKeyFilePath := "/run/linsk-" + luksDMName + ".key"
+
+	err = fm.copyFile(ctx, bytes.NewReader(key), guestKeyFilePath, "0400")
+	if err != nil {
+		return errors.Wrap(err, "copy luks key file")
+	}
+
+	defer func() {
+		_, err := sshutil.RunCmd(ctx, r, "shred -u "+guestKeyFilePath)
+		if err != nil {
+			lg.Error("Failed to shred the LUKS key file in the VM", "error", err.Error())
+		}
+	}()
+
+	cmd, cleanup, err := fm.luksOpenCmd(ctx, r, luksDMName, readOnly, opts)
+	if err != nil {
+		return errors.Wrap(err, "prepare cryptsetup luksopen cmd")
+	}
+
+	defer cleanup()
+
+	cmd += "--key-file " + guestKeyFilePath + " "
+
+	lg.Info("Attempting to open a LUKS device with a key file")
+
+	_, err = sshutil.RunCmd(ctx, r, cmd+shellescape.Quote(fullDevPath)+" "+luksDMName)
+	if err != nil {
+		return errors.Wrap(fmt.Errorf("%w: %w", ErrUnlockFailed, err), "run cryptsetup luksopen cmd")
+	}
+
+	lg.Info("LUKS device opened successfully")
+
+	return nil
+}
+
+func (fm *FileManager) PreopenLUKSContainer(ctx context.Context, containerDevPath string, opts LUKSOptions) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
 	sc, err := fm.vm.DialSSH()
 	if err != nil {
 		return errors.Wrap(err, "dial vm ssh")
@@ -178,10 +655,10 @@ func (fm *FileManager) PreopenLUKSContainer(containerDevPath string) error {
 
 	defer func() { _ = sc.Close() }()
 
-	return fm.preopenLUKSContainerWithSSH(sc, containerDevPath)
+	return fm.preopenLUKSContainerWithSSH(ctx, sc, containerDevPath, false, opts)
 }
 
-func (fm *FileManager) preopenLUKSContainerWithSSH(sc *ssh.Client, containerDevPath string) error {
+func (fm *FileManager) preopenLUKSContainerWithSSH(ctx context.Context, sc *ssh.Client, containerDevPath string, readOnly bool, opts LUKSOptions) error {
 	if !utils.ValidateDevName(containerDevPath) {
 		return fmt.Errorf("bad luks container device name")
 	}
@@ -190,12 +667,12 @@ func (fm *FileManager) preopenLUKSContainerWithSSH(sc *ssh.Client, containerDevP
 
 	fm.logger.Info("Preopening a LUKS container", "container", fullContainerDevPath)
 
-	err := fm.luksOpen(sc, fullContainerDevPath, "cryptcontainer")
+	err := fm.luksOpen(ctx, sc, fullContainerDevPath, "cryptcontainer", readOnly, opts)
 	if err != nil {
 		return errors.Wrap(err, "luks (pre)open container")
 	}
 
-	err = fm.InitLVM()
+	err = fm.InitLVM(ctx)
 	if err != nil {
 		return errors.Wrap(err, "reinit lvm")
 	}
@@ -203,22 +680,70 @@ func (fm *FileManager) preopenLUKSContainerWithSSH(sc *ssh.Client, containerDevP
 	return nil
 }
 
-func (fm *FileManager) Mount(devName string, mc MountConfig) error {
-	if devName == "" {
-		return fmt.Errorf("device name is empty")
+func (fm *FileManager) Mount(ctx context.Context, devName string, mc MountConfig) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	return fm.mount(ctx, []MountTarget{{DevName: devName}}, mc)
+}
+
+// MountTarget is a device to be mounted by MountMultiple.
+type MountTarget struct {
+	DevName string
+
+	// Name is the subdirectory of the share root to mount the device at.
+	Name string
+}
+
+// MountMultiple mounts several devices (e.g., the root and home partitions)
+// into the subdirectories of a single share root. LUKS containers are
+// supported, while LUKS volumes and btrfs subvolumes are not.
+func (fm *FileManager) MountMultiple(ctx context.Context, targets []MountTarget, mc MountConfig) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	if len(targets) == 0 {
+		return fmt.Errorf("no mount targets specified")
 	}
 
-	// It does allow "mapper/" prefix for mapped devices.
-	// This is to enable the support for LVM and LUKS.
-	if !utils.ValidateDevName(devName) {
-		return fmt.Errorf("bad device name")
+	if mc.LUKS {
+		return fmt.Errorf("luks volumes are unsupported when mounting multiple devices")
 	}
 
-	// We're intentionally not calling filepath.Clean() as
-	// this causes unintended consequences when run on Windows.
-	// (Windows Go standard library treats the path as it's for
-	// Windows, but we're targeting a Linux VM.)
-	fullDevPath := "/dev/" + devName
+	if mc.BtrfsSubvolume != "" {
+		return fmt.Errorf("btrfs subvolumes are unsupported when mounting multiple devices")
+	}
+
+	for i, t := range targets {
+		if !utils.ValidateMountName(t.Name) {
+			return fmt.Errorf("bad mount name '%v'", t.Name)
+		}
+
+		for _, prev := range targets[:i] {
+			if prev.Name == t.Name {
+				return fmt.Errorf("duplicate mount name '%v'", t.Name)
+			}
+		}
+	}
+
+	return fm.mount(ctx, targets, mc)
+}
+
+// mount mounts the targets. A target with an empty name is mounted at the share root.
+func (fm *FileManager) mount(ctx context.Context, targets []MountTarget, mc MountConfig) error {
+	for _, t := range targets {
+		if t.DevName == "" {
+			return fmt.Errorf("device name is empty")
+		}
+
+		// It does allow "mapper/" prefix for mapped devices.
+		// This is to enable the support for LVM and LUKS.
+		// Tag references are resolved and validated later, as
+		// the device may appear only after LUKS/LVM setup.
+		if !IsDevSpec(t.DevName) && !utils.ValidateDevName(t.DevName) {
+			return fmt.Errorf("bad device name")
+		}
+	}
 
 	var fsOverride string
 	if mc.FSTypeOverride != "" {
@@ -236,6 +761,25 @@ func (fm *FileManager) Mount(devName string, mc MountConfig) error {
 		mountOptions = mc.MountOptions
 	}
 
+	if mc.BtrfsSubvolume != "" {
+		if !utils.ValidateBtrfsSubvolume(mc.BtrfsSubvolume) {
+			return fmt.Errorf("bad btrfs subvolume")
+		}
+
+		if mountOptions != "" {
+			mountOptions += ","
+		}
+		mountOptions += "subvol=" + mc.BtrfsSubvolume
+	}
+
+	if mc.ReadOnly {
+		// The last option wins, so "ro" cannot be overridden by the user-supplied options.
+		if mountOptions != "" {
+			mountOptions += ","
+		}
+		mountOptions += "ro"
+	}
+
 	sc, err := fm.vm.DialSSH()
 	if err != nil {
 		return errors.Wrap(err, "dial vm ssh")
@@ -243,45 +787,169 @@ func (fm *FileManager) Mount(devName string, mc MountConfig) error {
 
 	defer func() { _ = sc.Close() }()
 
+	r := fm.runnerFor(sc)
+
 	if mc.LUKSContainerPreopen != "" {
-		err := fm.preopenLUKSContainerWithSSH(sc, mc.LUKSContainerPreopen)
+		err := fm.preopenLUKSContainerWithSSH(ctx, sc, mc.LUKSContainerPreopen, mc.ReadOnly, mc.LUKSOptions)
 		if err != nil {
 			return errors.Wrap(err, "preopen luks container")
 		}
 	}
 
-	if mc.LUKS {
-		luksDMName := "cryptmnt"
+	if mc.LVMActivate != "" {
+		err := fm.activateLVWithRunner(ctx, r, mc.LVMActivate)
+		if err != nil {
+			return errors.Wrap(err, "activate lvm volume")
+		}
+	}
+
+	for _, t := range targets {
+		devName := t.DevName
+		if IsDevSpec(devName) {
+			devName, err = fm.resolveDevSpecWithRunner(ctx, r, devName)
+			if err != nil {
+				return errors.Wrap(err, "resolve device")
+			}
+		}
+
+		// We're intentionally not calling filepath.Clean() as
+		// this causes unintended consequences when run on Windows.
+		// (Windows Go standard library treats the path as it's for
+		// Windows, but we're targeting a Linux VM.)
+		fullDevPath := "/dev/" + devName
+
+		_, err = sshutil.RunCmd(ctx, r, "test -b "+shellescape.Quote(fullDevPath))
+		if err != nil {
+			if exitErr := new(ssh.ExitError); errors.As(err, &exitErr) {
+				return fmt.Errorf("%w: '%v'", ErrDeviceNotFound, devName)
+			}
+
+			return errors.Wrap(err, "check whether device exists")
+		}
+
+		if mc.LUKS {
+			luksDMName := "cryptmnt"
+
+			err = fm.luksOpen(ctx, sc, fullDevPath, luksDMName, mc.ReadOnly, mc.LUKSOptions)
+			if err != nil {
+				return errors.Wrap(err, "luks open")
+			}
+
+			fullDevPath = "/dev/mapper/" + luksDMName
+		}
+
+		// The full device path is not escaped, so it needs to be validated.
+		if !utils.ValidateDevName(strings.TrimPrefix(fullDevPath, "/dev/")) {
+			return fmt.Errorf("bad resolved device path")
+		}
+
+		err = fm.loadFSModule(ctx, r, fullDevPath, fsOverride)
+		if err != nil {
+			return errors.Wrap(err, "load file system kernel module")
+		}
 
-		err = fm.luksOpen(sc, fullDevPath, luksDMName)
+		mountPoint := "/mnt"
+		cmd := "mount "
+		if t.Name != "" {
+			mountPoint += "/" + t.Name
+			cmd = "mkdir -p " + mountPoint + " && " + cmd
+		}
+
+		if fsOverride != "" {
+			cmd += "-t " + shellescape.Quote(fsOverride) + " "
+		}
+		if mountOptions != "" {
+			cmd += "-o " + shellescape.Quote(mountOptions) + " "
+		}
+		cmd += shellescape.Quote(fullDevPath) + " " + mountPoint
+
+		_, err = sshutil.RunCmd(ctx, r, cmd)
 		if err != nil {
-			return errors.Wrap(err, "luks open")
+			return errors.Wrapf(err, "run mount cmd for '%v'", fullDevPath)
 		}
+	}
 
-		fullDevPath = "/dev/mapper/" + luksDMName
+	// The user-supplied "ro" option makes the mount read-only too.
+	fm.readOnly = mc.ReadOnly || slices.Contains(strings.Split(mountOptions, ","), "ro")
+
+	return nil
+}
+
+// ReadOnly returns whether the device is mounted read-only.
+func (fm *FileManager) ReadOnly() bool {
+	return fm.readOnly
+}
+
+// yesNo formats b for the file share server configs.
+func yesNo(b bool) string {
+	if b {
+		return "YES"
 	}
 
-	cmd := "mount "
-	if fsOverride != "" {
-		cmd += "-t " + shellescape.Quote(fsOverride) + " "
+	return "NO"
+}
+
+// ShareTLSConfig configures TLS for the file share servers. A self-signed
+// certificate is generated for the session if CertPEM and KeyPEM are empty.
+type ShareTLSConfig struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+const (
+	shareTLSCertPath = "/etc/linsk-tls/cert.pem"
+	shareTLSKeyPath  = "/etc/linsk-tls/key.pem"
+)
+
+// installShareTLSCert installs the certificate used by the file share servers
+// and returns its SHA-256 fingerprint.
+func (fm *FileManager) installShareTLSCert(ctx context.Context, r sshutil.CommandRunner, tlsCfg *ShareTLSConfig) (string, error) {
+	_, err := sshutil.RunCmd(ctx, r, "mkdir -p /etc/linsk-tls && chmod 0700 /etc/linsk-tls")
+	if err != nil {
+		return "", errors.Wrap(err, "create tls directory")
 	}
-	if mountOptions != "" {
-		cmd += "-o " + shellescape.Quote(mountOptions) + " "
+
+	if len(tlsCfg.CertPEM) == 0 && len(tlsCfg.KeyPEM) == 0 {
+		_, err = sshutil.RunCmd(ctx, r, "openssl req -x509 -newkey rsa:2048 -nodes -days 30 -subj "+shellescape.Quote("/CN="+fm.vm.Hostname())+" -keyout "+shareTLSKeyPath+" -out "+shareTLSCertPath+" && chmod 0400 "+shareTLSKeyPath)
+		if err != nil {
+			return "", errors.Wrap(err, "generate self-signed certificate")
+		}
+	} else {
+		if len(tlsCfg.CertPEM) == 0 || len(tlsCfg.KeyPEM) == 0 {
+			return "", fmt.Errorf("both tls certificate and key must be supplied")
+		}
+
+		err = fm.copyConfigFile(ctx, string(tlsCfg.CertPEM), shareTLSCertPath)
+		if err != nil {
+			return "", errors.Wrap(err, "copy certificate")
+		}
+
+		err = fm.copyConfigFile(ctx, string(tlsCfg.KeyPEM), shareTLSKeyPath)
+		if err != nil {
+			return "", errors.Wrap(err, "copy private key")
+		}
 	}
-	cmd += shellescape.Quote(fullDevPath) + " /mnt"
 
-	_, err = sshutil.RunSSHCmd(fm.vm.ctx, sc, cmd)
+	out, err := sshutil.RunCmd(ctx, r, "openssl x509 -in "+shareTLSCertPath+" -noout -fingerprint -sha256")
 	if err != nil {
-		return errors.Wrap(err, "run mount cmd")
+		return "", errors.Wrap(err, "get certificate fingerprint")
 	}
 
-	return nil
+	_, fingerprint, _ := strings.Cut(strings.TrimSpace(string(out)), "=")
+
+	return fingerprint, nil
 }
 
-func (fm *FileManager) StartFTP(pwd string, passivePortStart uint16, passivePortCount uint16, extIP net.IP) error {
+// StartFTP starts an FTP server. If tlsCfg is not nil, explicit TLS (FTPS)
+// is required for both logins and data transfers, and the SHA-256
+// fingerprint of the certificate in use is returned.
+func (fm *FileManager) StartFTP(ctx context.Context, pwd string, passivePortStart uint16, passivePortCount uint16, extIP net.IP, tlsCfg *ShareTLSConfig) (string, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
 	ftpdCfg := `anonymous_enable=NO
 local_enable=YES
-write_enable=YES
+write_enable=` + yesNo(!fm.readOnly) + `
 local_umask=022
 chroot_local_user=YES
 allow_writeable_chroot=YES
@@ -292,12 +960,55 @@ pasv_max_port=` + fmt.Sprint(passivePortStart+passivePortCount) + `
 pasv_address=` + extIP.String() + `
 `
 
-	return fm.startGenericShare(pwd, ftpdCfg, "/etc/vsftpd/vsftpd.conf", "vsftpd", sshutil.ChangeUnixPass)
+	var fingerprint string
+
+	if tlsCfg != nil {
+		r, closeRunner, err := fm.dialRunner()
+		if err != nil {
+			return "", errors.Wrap(err, "dial ssh")
+		}
+
+		defer closeRunner()
+
+		fingerprint, err = fm.installShareTLSCert(ctx, r, tlsCfg)
+		if err != nil {
+			return "", errors.Wrap(err, "install tls certificate")
+		}
+
+		// The passive address is sent inside the encrypted control channel,
+		// so routers cannot rewrite it. This is why pasv_address is mandatory.
+		// Session reuse is not required as many clients do not support it.
+		ftpdCfg += `ssl_enable=YES
+allow_anon_ssl=NO
+force_local_logins_ssl=YES
+force_local_data_ssl=YES
+ssl_tlsv1=YES
+ssl_sslv2=NO
+ssl_sslv3=NO
+require_ssl_reuse=NO
+ssl_ciphers=HIGH
+rsa_cert_file=` + shareTLSCertPath + `
+rsa_private_key_file=` + shareTLSKeyPath + `
+`
+	}
+
+	err := fm.startGenericShare(ctx, pwd, ftpdCfg, "/etc/vsftpd/vsftpd.conf", "vsftpd", sshutil.ChangeUnixPass)
+	if err != nil {
+		return "", err
+	}
+
+	return fingerprint, nil
 }
 
-func (fm *FileManager) StartSMB(pwd string) error {
+func (fm *FileManager) StartSMB(ctx context.Context, pwd string) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
 	sambaCfg := `[global]
 workgroup = WORKGROUP
+netbios name = ` + fm.vm.Hostname() + `
+server string = Linsk (` + fm.vm.Hostname() + `)
+mdns name = netbios
 dos charset = cp866
 unix charset = utf-8
 client min protocol = SMB2
@@ -314,33 +1025,358 @@ server signing = no
 
 [linsk]
 browseable = yes
-writeable = yes
+writeable = ` + strings.ToLower(yesNo(!fm.readOnly)) + `
 path = /mnt
-force user = linsk
+force user = ` + fm.shareUser + `
 force group = linsk
 create mask = 0664
 `
-	return fm.startGenericShare(pwd, sambaCfg, "/etc/samba/smb.conf", "samba", sshutil.ChangeSambaPass)
+
+	if fm.shareProfile == ShareProfileTimeMachine {
+		// The fruit VFS module implements the Apple SMB extensions Time Machine
+		// relies on. The global options need to be set before any share section.
+		sambaCfg = strings.Replace(sambaCfg, "\n[linsk]\n", `
+vfs objects = catia fruit streams_xattr
+fruit:aapl = yes
+fruit:metadata = stream
+fruit:model = MacSamba
+fruit:posix_rename = yes
+fruit:veto_appledouble = no
+fruit:nfs_aces = no
+fruit:wipe_intentionally_left_blank_rfork = yes
+fruit:delete_empty_adfiles = yes
+
+[linsk]
+fruit:time machine = yes
+`, 1)
+	}
+
+	return fm.startGenericShare(ctx, pwd, sambaCfg, "/etc/samba/smb.conf", "samba", sshutil.ChangeSambaPass)
 }
 
-func (fm *FileManager) StartAFP(pwd string) error {
+func (fm *FileManager) StartAFP(ctx context.Context, pwd string) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
 	afpCfg := `[Global]
+zeroconf name = Linsk (` + fm.vm.Hostname() + `)
 
 [linsk]
 path = /mnt
 file perm = 0664
 directory perm = 0775
-valid users = linsk
-force user = linsk
+valid users = ` + fm.shareUser + `
+force user = ` + fm.shareUser + `
 force group = linsk
 `
 
-	return fm.startGenericShare(pwd, afpCfg, "/etc/afp.conf", "netatalk", sshutil.ChangeUnixPass)
+	if fm.readOnly {
+		afpCfg += "read only = yes\n"
+	}
+
+	if fm.shareProfile == ShareProfileTimeMachine {
+		afpCfg += "time machine = yes\n"
+	}
+
+	return fm.startGenericShare(ctx, pwd, afpCfg, "/etc/afp.conf", "netatalk", sshutil.ChangeUnixPass)
+}
+
+func (fm *FileManager) StartNFS(ctx context.Context) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	// Only NFSv4 is enabled as it works over a single TCP port, which
+	// makes it possible to forward it. All forwarded connections come
+	// from the QEMU user network gateway (10.0.2.2).
+	nfsConfCfg := `OPTS_RPC_NFSD="8 -N 2 -N 3 -U"
+OPTS_RPC_MOUNTD="-N 2 -N 3"
+`
+
+	err := fm.copyConfigFile(ctx, nfsConfCfg, "/etc/conf.d/nfs")
+	if err != nil {
+		return errors.Wrap(err, "copy nfs service config file")
+	}
+
+	exportMode := "rw"
+	if fm.readOnly {
+		exportMode = "ro"
+	}
+
+	exportsCfg := `/mnt 10.0.2.2(` + exportMode + `,fsid=0,insecure,no_subtree_check,all_squash,anonuid=1000,anongid=1000)
+`
+
+	return fm.startGenericShare(ctx, "", exportsCfg, "/etc/exports", "nfs", func(context.Context, *ssh.Client, string, string) error {
+		// NFS has no password authentication.
+		return nil
+	})
 }
 
-func (fm *FileManager) startGenericShare(pwd string, cfg string, cfgPath string, rcServiceName string, changePassFunc sshutil.ChangePassFunc) error {
+// StartWebDAV starts a WebDAV server. If tlsCfg is not nil, the server is
+// served over HTTPS, and the SHA-256 fingerprint of the certificate is returned.
+func (fm *FileManager) StartWebDAV(ctx context.Context, pwd string, tlsCfg *ShareTLSConfig) (string, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	const usersFilePath = "/etc/lighttpd/linsk-users"
+
+	modules := `"mod_access", "mod_auth", "mod_authn_file", "mod_webdav"`
+	port := "80"
+	if tlsCfg != nil {
+		modules += `, "mod_openssl"`
+		port = "443"
+	}
+
+	webDAVReadOnly := "disable"
+	if fm.readOnly {
+		webDAVReadOnly = "enable"
+	}
+
+	lighttpdCfg := `server.modules = ( ` + modules + ` )
+server.document-root = "/mnt"
+server.port = ` + port + `
+server.username = "` + fm.shareUser + `"
+server.groupname = "linsk"
+server.errorlog = "/var/log/lighttpd/error.log"
+server.pid-file = "/run/lighttpd.pid"
+server.tag = "Linsk (` + fm.vm.Hostname() + `)"
+dir-listing.activate = "enable"
+webdav.activate = "enable"
+webdav.is-readonly = "` + webDAVReadOnly + `"
+auth.backend = "plain"
+auth.backend.plain.userfile = "` + usersFilePath + `"
+auth.require = ( "/" => ( "method" => "basic", "realm" => "Linsk (` + fm.vm.Hostname() + `)", "require" => "valid-user" ) )
+`
+
+	sc, err := fm.vm.DialSSH()
+	if err != nil {
+		return "", errors.Wrap(err, "dial ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	r := fm.runnerFor(sc)
+
+	// lighttpd drops privileges to the share user, so it needs to be able to write logs.
+	_, err = sshutil.RunCmd(ctx, r, "mkdir -p /var/log/lighttpd && chown "+fm.shareUser+":linsk /var/log/lighttpd")
+	if err != nil {
+		return "", errors.Wrap(err, "prepare log directory")
+	}
+
+	var fingerprint string
+
+	if tlsCfg != nil {
+		fingerprint, err = fm.installShareTLSCert(ctx, r, tlsCfg)
+		if err != nil {
+			return "", errors.Wrap(err, "install tls certificate")
+		}
+
+		lighttpdCfg += `ssl.engine = "enable"
+ssl.pemfile = "` + shareTLSCertPath + `"
+ssl.privkey = "` + shareTLSKeyPath + `"
+`
+	}
+
+	err = fm.startGenericShare(ctx, pwd, lighttpdCfg, "/etc/lighttpd/lighttpd.conf", "lighttpd", func(ctx context.Context, sc *ssh.Client, user string, pwd string) error {
+		err := fm.copyConfigFile(ctx, user+":"+pwd+"\n", usersFilePath)
+		if err != nil {
+			return errors.Wrap(err, "copy users file")
+		}
+
+		// The users file is read by lighttpd after it drops privileges.
+		_, err = sshutil.RunCmd(ctx, r, "chown root:linsk "+usersFilePath+" && chmod 0440 "+usersFilePath)
+		if err != nil {
+			return errors.Wrap(err, "set users file permissions")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fingerprint, nil
+}
+
+// NBDPort is the guest port of the NBD server.
+const NBDPort = 10809
+
+// NBDExportName is the name of the export served by StartNBD.
+const NBDExportName = "linsk"
+
+// StartNBD exports the raw block device (as opposed to the mounted file
+// system) over NBD. The device must not be mounted in the VM.
+func (fm *FileManager) StartNBD(ctx context.Context, devName string, readOnly bool) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	if !utils.ValidateDevName(devName) {
+		return fmt.Errorf("bad device name")
+	}
+
+	readOnlyStr := "false"
+	if readOnly {
+		readOnlyStr = "true"
+	}
+
+	nbdCfg := `[generic]
+port = ` + fmt.Sprint(NBDPort) + `
+
+[` + NBDExportName + `]
+exportname = /dev/` + devName + `
+readonly = ` + readOnlyStr + `
+`
+
+	err := fm.copyConfigFile(ctx, nbdCfg, "/etc/nbd-server/config")
+	if err != nil {
+		return errors.Wrap(err, "copy nbd server config file")
+	}
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial ssh")
+	}
+
+	defer closeRunner()
+
+	_, err = sshutil.RunCmd(ctx, r, "nbd-server -C /etc/nbd-server/config")
+	if err != nil {
+		return errors.Wrap(err, "start nbd server")
+	}
+
+	return nil
+}
+
+// SFTPPort is the guest port of the SFTP-only SSH server listener.
+const SFTPPort = 2022
+
+// StartSFTP makes the guest SSH server accept SFTP-only password logins
+// for the share user on SFTPPort. The user is chrooted to a directory
+// containing the mount point, as sshd requires the chroot directory to be
+// owned by root, which is not the case for the mounted file system.
+func (fm *FileManager) StartSFTP(ctx context.Context, pwd string) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	const chrootDir = "/srv/linsk-sftp"
+
+	sc, err := fm.vm.DialSSH()
+	if err != nil {
+		return errors.Wrap(err, "dial ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	r := fm.runnerFor(sc)
+
+	_, err = sshutil.RunCmd(ctx, r, "mkdir -p "+chrootDir+"/linsk && chmod 0755 "+chrootDir+" && mount --bind /mnt "+chrootDir+"/linsk")
+	if err != nil {
+		return errors.Wrap(err, "prepare chroot directory")
+	}
+
+	sftpCmd := "internal-sftp -d /linsk"
+	if fm.readOnly {
+		sftpCmd += " -R"
+	}
+
+	// Port directives must precede any Match blocks, hence the rewrite. The
+	// blocks added previously (the overlays of persistent VMs keep /etc) are
+	// removed first, so that the ports are not listed multiple times.
+	const cfgBlockBegin, cfgBlockEnd = "# BEGIN linsk-sftp", "# END linsk-sftp"
+	sshdCfgCmd := `sed '/^` + cfgBlockBegin + `$/,/^` + cfgBlockEnd + `$/d' /etc/ssh/sshd_config > /tmp/sshd_config.orig && { printf '` + cfgBlockBegin + `\nPort 22\nPort ` + fmt.Sprint(SFTPPort) + `\n` + cfgBlockEnd + `\n'; cat /tmp/sshd_config.orig; printf '` + cfgBlockBegin + `\nMatch LocalPort ` + fmt.Sprint(SFTPPort) + `\n\tPermitRootLogin no\n\tPasswordAuthentication yes\n\tAllowTcpForwarding no\n\tX11Forwarding no\n\tChrootDirectory ` + chrootDir + `\n\tForceCommand ` + sftpCmd + `\n` + cfgBlockEnd + `\n'; } > /tmp/sshd_config && rm /tmp/sshd_config.orig && mv /tmp/sshd_config /etc/ssh/sshd_config && rc-service sshd reload`
+
+	_, err = sshutil.RunCmd(ctx, r, sshdCfgCmd)
+	if err != nil {
+		return errors.Wrap(err, "configure and reload sshd")
+	}
+
+	err = sshutil.ChangeUnixPass(ctx, sc, fm.shareUser, pwd)
+	if err != nil {
+		return errors.Wrap(err, "change pass")
+	}
+
+	return nil
+}
+
+// AuthorizeLinskSSHKey allows the share user to log in over SSH with the
+// specified public key. This is used for SFTP-based host mounts. The key is
+// stored outside of the user home directory, as the home is the mounted disk.
+func (fm *FileManager) AuthorizeLinskSSHKey(ctx context.Context, pubKey []byte) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	const authorizedKeysPath = "/etc/ssh/linsk_authorized_keys"
+
+	err := fm.copyConfigFile(ctx, string(pubKey), authorizedKeysPath)
+	if err != nil {
+		return errors.Wrap(err, "copy authorized keys file")
+	}
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial ssh")
+	}
+
+	defer closeRunner()
+
+	_, err = sshutil.RunCmd(ctx, r, "chown "+fm.shareUser+":linsk "+authorizedKeysPath+" && printf '\\nMatch User "+fm.shareUser+"\\n\\tAuthorizedKeysFile "+authorizedKeysPath+"\\n' >> /etc/ssh/sshd_config && rc-service sshd reload")
+	if err != nil {
+		return errors.Wrap(err, "configure and reload sshd")
+	}
+
+	return nil
+}
+
+// IsServiceStarted reports whether the specified guest OpenRC service is
+// started. A crashed service is reported as not started.
+func (fm *FileManager) IsServiceStarted(ctx context.Context, rcServiceName string) (bool, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return false, errors.Wrap(err, "dial ssh")
+	}
+
+	defer closeRunner()
+
+	out, err := sshutil.RunCmd(ctx, r, "rc-service "+shellescape.Quote(rcServiceName)+" status >/dev/null 2>&1 && echo started || echo stopped")
+	if err != nil {
+		return false, errors.Wrap(err, "run rc service status command")
+	}
+
+	return strings.TrimSpace(string(out)) == "started", nil
+}
+
+// RestartService restarts the specified guest OpenRC service. Services
+// whose daemon died are zapped first, as OpenRC refuses to stop them.
+func (fm *FileManager) RestartService(ctx context.Context, rcServiceName string) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial ssh")
+	}
+
+	defer closeRunner()
+
+	svc := shellescape.Quote(rcServiceName)
+
+	_, err = sshutil.RunCmd(ctx, r, "rc-service "+svc+" restart || { rc-service "+svc+" zap && rc-service "+svc+" start; }")
+	if err != nil {
+		return errors.Wrap(err, "restart rc service")
+	}
+
+	return nil
+}
+
+func (fm *FileManager) copyConfigFile(ctx context.Context, cfg string, cfgPath string) error {
+	return fm.copyFile(ctx, strings.NewReader(cfg), cfgPath, "0400")
+}
+
+func (fm *FileManager) copyFile(ctx context.Context, r io.Reader, path string, perm string) error {
 	// This timeout is for the SCP client exclusively.
-	scpCtx, scpCtxCancel := context.WithTimeout(fm.vm.ctx, time.Second*5)
+	scpCtx, scpCtxCancel := context.WithTimeout(ctx, time.Second*5)
 	defer scpCtxCancel()
 
 	scpClient, err := fm.vm.DialSCP()
@@ -350,12 +1386,19 @@ func (fm *FileManager) startGenericShare(pwd string, cfg string, cfgPath string,
 
 	defer scpClient.Close()
 
-	err = scpClient.CopyFile(scpCtx, strings.NewReader(cfg), cfgPath, "0400")
+	err = scpClient.CopyFile(scpCtx, r, path, perm)
 	if err != nil {
-		return errors.Wrap(err, "copy config file")
+		return errors.Wrap(err, "copy file")
 	}
 
-	scpClient.Close()
+	return nil
+}
+
+func (fm *FileManager) startGenericShare(ctx context.Context, pwd string, cfg string, cfgPath string, rcServiceName string, changePassFunc sshutil.ChangePassFunc) error {
+	err := fm.copyConfigFile(ctx, cfg, cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "copy config file")
+	}
 
 	sc, err := fm.vm.DialSSH()
 	if err != nil {
@@ -364,12 +1407,14 @@ func (fm *FileManager) startGenericShare(pwd string, cfg string, cfgPath string,
 
 	defer func() { _ = sc.Close() }()
 
-	_, err = sshutil.RunSSHCmd(fm.vm.ctx, sc, "rc-update add "+shellescape.Quote(rcServiceName)+" && rc-service "+shellescape.Quote(rcServiceName)+" start")
+	r := fm.runnerFor(sc)
+
+	_, err = sshutil.RunCmd(ctx, r, "rc-update add "+shellescape.Quote(rcServiceName)+" && rc-service "+shellescape.Quote(rcServiceName)+" start")
 	if err != nil {
 		return errors.Wrap(err, "add and start rc service")
 	}
 
-	err = changePassFunc(fm.vm.ctx, sc, "linsk", pwd)
+	err = changePassFunc(ctx, sc, fm.shareUser, pwd)
 	if err != nil {
 		return errors.Wrap(err, "change pass")
 	}
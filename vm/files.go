@@ -0,0 +1,276 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// MountRoot is the VM directory the devices are mounted at by Mount.
+const MountRoot = "/mnt"
+
+// mountedPath maps the absolute path on the mounted file system to
+// the VM path. The result never points outside of MountRoot.
+func mountedPath(p string) string {
+	// We're intentionally using the "path" package
+	// rather than "path/filepath" as the VM is Linux.
+	return path.Join(MountRoot, path.Clean("/"+p))
+}
+
+// IsDir returns whether the path on the mounted file system is a
+// directory. An error wrapping os.ErrNotExist is returned if the
+// path does not exist.
+func (fm *FileManager) IsDir(ctx context.Context, p string) (bool, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return false, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	vmPath := shellescape.Quote(mountedPath(p))
+
+	out, err := sshutil.RunCmd(ctx, r, "if [ -d "+vmPath+" ]; then echo dir; elif [ -e "+vmPath+" ] || [ -L "+vmPath+" ]; then echo file; fi")
+	if err != nil {
+		return false, errors.Wrap(err, "run test cmd")
+	}
+
+	switch strings.TrimSpace(string(out)) {
+	case "dir":
+		return true, nil
+	case "file":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: '%v'", os.ErrNotExist, p)
+	}
+}
+
+// FileInfo describes a file on the mounted file system.
+type FileInfo struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Owner   string      `json:"owner"`
+	Group   string      `json:"group"`
+
+	// LinkTarget is the symlink target. It is set by Stat only.
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// statFormat is the stat(1) format parsed by parseStatLine. The path goes
+// last, as the other fields cannot contain the separator.
+const statFormat = "%f/%s/%Y/%U/%G/%n"
+
+// ReadDir returns the entries of the directory at the path on the
+// mounted file system, sorted by name. Symlinks are not followed.
+func (fm *FileManager) ReadDir(ctx context.Context, dir string) ([]FileInfo, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	vmPath := shellescape.Quote(mountedPath(dir))
+
+	out, err := sshutil.RunCmd(ctx, r, "test -d "+vmPath+" && find "+vmPath+" -mindepth 1 -maxdepth 1 -exec stat -c "+shellescape.Quote(statFormat)+" {} +")
+	if err != nil {
+		return nil, errors.Wrap(err, "run find cmd")
+	}
+
+	var ret []FileInfo
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fi, err := parseStatLine(line)
+		if err != nil {
+			// The file names containing newlines are
+			// not supported and end up here too.
+			return nil, errors.Wrapf(err, "parse stat line '%v'", line)
+		}
+
+		ret = append(ret, fi)
+	}
+
+	slices.SortFunc(ret, func(a, b FileInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ret, nil
+}
+
+// Stat returns the information about the file at the path on the
+// mounted file system. Symlinks are not followed.
+func (fm *FileManager) Stat(ctx context.Context, p string) (FileInfo, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	vmPath := shellescape.Quote(mountedPath(p))
+
+	_, err = sshutil.RunCmd(ctx, r, "test -e "+vmPath+" || test -L "+vmPath)
+	if err != nil {
+		if exitErr := new(ssh.ExitError); errors.As(err, &exitErr) {
+			return FileInfo{}, fmt.Errorf("%w: '%v'", os.ErrNotExist, p)
+		}
+
+		return FileInfo{}, errors.Wrap(err, "check whether file exists")
+	}
+
+	out, err := sshutil.RunCmd(ctx, r, "stat -c "+shellescape.Quote(statFormat)+" "+vmPath)
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "run stat cmd")
+	}
+
+	fi, err := parseStatLine(strings.TrimSuffix(string(out), "\n"))
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "parse stat output")
+	}
+
+	if fi.Mode&fs.ModeSymlink != 0 {
+		out, err := sshutil.RunCmd(ctx, r, "readlink "+vmPath)
+		if err != nil {
+			return FileInfo{}, errors.Wrap(err, "run readlink cmd")
+		}
+
+		fi.LinkTarget = strings.TrimSuffix(string(out), "\n")
+	}
+
+	return fi, nil
+}
+
+// ReadFile streams the contents of the file at the path
+// on the mounted file system to w.
+func (fm *FileManager) ReadFile(ctx context.Context, p string, w io.Writer) error {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	return fm.runStreamCmd(ctx, "cat "+shellescape.Quote(mountedPath(p)), nil, w)
+}
+
+func parseStatLine(line string) (FileInfo, error) {
+	split := strings.SplitN(line, "/", 6)
+	if want, have := 6, len(split); want != have {
+		return FileInfo{}, fmt.Errorf("bad field count: want %v, have %v", want, have)
+	}
+
+	rawMode, err := strconv.ParseUint(split[0], 16, 32)
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "parse mode")
+	}
+
+	size, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "parse size")
+	}
+
+	modTime, err := strconv.ParseInt(split[2], 10, 64)
+	if err != nil {
+		return FileInfo{}, errors.Wrap(err, "parse modification time")
+	}
+
+	return FileInfo{
+		Name:    path.Base("/" + split[5]),
+		Size:    size,
+		Mode:    unixModeToFileMode(uint32(rawMode)),
+		ModTime: time.Unix(modTime, 0),
+		Owner:   split[3],
+		Group:   split[4],
+	}, nil
+}
+
+// unixModeToFileMode converts the raw Linux st_mode to fs.FileMode.
+func unixModeToFileMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0777)
+
+	switch m & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	}
+
+	if m&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	return mode
+}
+
+func (fm *FileManager) runStreamCmd(ctx context.Context, cmd string, stdin io.Reader, stdout io.Writer) error {
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	// Unlike the short commands, the transfers have no timeout
+	// as they can take arbitrarily long for the large files.
+	_, err = r.Run(ctx, sshutil.Command{
+		Cmd:     cmd,
+		Stdin:   stdin,
+		Stdout:  stdout,
+		Timeout: sshutil.NoTimeout,
+	})
+
+	return err
+}
@@ -0,0 +1,165 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/AlexSSD7/linsk/vm/vmtest"
+)
+
+func startFake(t *testing.T) *vmtest.Fake {
+	t.Helper()
+
+	f, err := vmtest.New(slog.New(slog.NewTextHandler(io.Discard, nil)), vmtest.Config{})
+	if err != nil {
+		t.Fatalf("create fake vm: %v", err)
+	}
+
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- f.Run()
+	}()
+
+	t.Cleanup(func() {
+		_ = f.Cancel()
+
+		err := <-runErrCh
+		if err != nil {
+			t.Errorf("run fake vm: %v", err)
+		}
+	})
+
+	select {
+	case <-f.SSHUpNotifyChan():
+	case <-time.After(time.Second * 10):
+		t.Fatal("fake vm ssh server did not come up")
+	}
+
+	return f
+}
+
+func TestFileManagerReadDir(t *testing.T) {
+	f := startFake(t)
+
+	f.Handle("test -d '/mnt/some dir' && find '/mnt/some dir' ", vmtest.Output(
+		"81a4/1234/1700000000/linsk/linsk//mnt/some dir/b.txt\n"+
+			"41ed/4096/1700000001/root/root//mnt/some dir/a dir\n"+
+			"a1ff/5/1700000002/linsk/linsk//mnt/some dir/link\n",
+	))
+
+	fm := vm.NewFileManager(slog.New(slog.NewTextHandler(io.Discard, nil)), f)
+
+	entries, err := fm.ReadDir(context.Background(), "some dir")
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+
+	want := []vm.FileInfo{
+		{Name: "a dir", Size: 4096, Mode: fs.ModeDir | 0755, ModTime: time.Unix(1700000001, 0), Owner: "root", Group: "root"},
+		{Name: "b.txt", Size: 1234, Mode: 0644, ModTime: time.Unix(1700000000, 0), Owner: "linsk", Group: "linsk"},
+		{Name: "link", Size: 5, Mode: fs.ModeSymlink | 0777, ModTime: time.Unix(1700000002, 0), Owner: "linsk", Group: "linsk"},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("want %v entries, have %v: %+v", len(want), len(entries), entries)
+	}
+
+	for i := range want {
+		if !entries[i].ModTime.Equal(want[i].ModTime) {
+			t.Errorf("entry #%v: want mod time %v, have %v", i, want[i].ModTime, entries[i].ModTime)
+		}
+
+		entries[i].ModTime = want[i].ModTime
+
+		if entries[i] != want[i] {
+			t.Errorf("entry #%v: want %+v, have %+v", i, want[i], entries[i])
+		}
+	}
+}
+
+func TestFileManagerStat(t *testing.T) {
+	f := startFake(t)
+
+	f.Handle("test -e /mnt/link ", vmtest.Output(""))
+	f.Handle("stat -c %f/%s/%Y/%U/%G/%n /mnt/link", vmtest.Output("a1ff/6/1700000000/linsk/linsk//mnt/link\n"))
+	f.Handle("readlink /mnt/link", vmtest.Output("b.txt\n"))
+	f.Handle("test -e /mnt/missing ", vmtest.Fail(1, ""))
+
+	fm := vm.NewFileManager(slog.New(slog.NewTextHandler(io.Discard, nil)), f)
+
+	fi, err := fm.Stat(context.Background(), "/link")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	if fi.Name != "link" || fi.Mode != fs.ModeSymlink|0777 || fi.LinkTarget != "b.txt" {
+		t.Errorf("unexpected file info: %+v", fi)
+	}
+
+	_, err = fm.Stat(context.Background(), "/missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want an os.ErrNotExist error for a missing file, have %v", err)
+	}
+}
+
+func TestFileManagerMount(t *testing.T) {
+	f := startFake(t)
+
+	f.Handle("test -b /dev/vdb1", vmtest.Output(""))
+	f.Handle("blkid -o value -s TYPE /dev/vdb1", vmtest.Output("ext4\n"))
+	f.Handle("mount ", vmtest.Output(""))
+
+	fm := vm.NewFileManager(slog.New(slog.NewTextHandler(io.Discard, nil)), f)
+
+	err := fm.Mount(context.Background(), "vdb1", vm.MountConfig{
+		MountOptions: "noatime",
+		ReadOnly:     true,
+	})
+	if err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+
+	if !fm.ReadOnly() {
+		t.Error("want the mount to be read-only")
+	}
+
+	want := []string{
+		"test -b /dev/vdb1",
+		"blkid -o value -s TYPE /dev/vdb1 || true",
+		"mount -o noatime,ro /dev/vdb1 /mnt",
+	}
+
+	have := f.Commands()
+	if len(have) != len(want) {
+		t.Fatalf("want commands %q, have %q", want, have)
+	}
+
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("command #%v: want %q, have %q", i, want[i], have[i])
+		}
+	}
+}
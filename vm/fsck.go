@@ -0,0 +1,269 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/progress"
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+type FsckConfig struct {
+	LUKSContainerPreopen string
+	LUKSOptions          LUKSOptions
+
+	FSTypeOverride string
+	LUKS           bool
+
+	// Repair makes the checker fix the found problems. Otherwise,
+	// the file system is only checked and left untouched.
+	Repair bool
+
+	// The checker output (including progress) is streamed to these.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Progress receives the check progress updates. Optional. Only the
+	// ext2/3/4 checker reports the progress in a machine-readable form,
+	// and its progress bar is not printed to Stdout if this is set.
+	Progress progress.Func
+}
+
+// getFsckCmd returns the checker command for the file system type.
+// If machineProgress is true, the ext2/3/4 checker prints the progress
+// as "<pass> <current> <max> <device>" lines instead of a progress bar.
+func getFsckCmd(fsType string, repair bool, machineProgress bool) (string, error) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		progressFD := 0
+		if machineProgress {
+			progressFD = 1
+		}
+
+		if repair {
+			return fmt.Sprintf("e2fsck -f -y -C %v", progressFD), nil
+		}
+		return fmt.Sprintf("e2fsck -f -n -C %v", progressFD), nil
+	case "btrfs":
+		if repair {
+			return "btrfs check --repair --force --progress", nil
+		}
+		return "btrfs check --readonly --progress", nil
+	case "xfs":
+		if repair {
+			return "xfs_repair", nil
+		}
+		return "xfs_repair -n", nil
+	case "f2fs":
+		if repair {
+			return "fsck.f2fs -f -y", nil
+		}
+		return "fsck.f2fs -f --dry-run", nil
+	case "jfs":
+		if repair {
+			return "jfs_fsck -f", nil
+		}
+		return "jfs_fsck -n", nil
+	case "reiserfs":
+		if repair {
+			return "reiserfsck -y --fix-fixable", nil
+		}
+		return "reiserfsck -y --check", nil
+	default:
+		return "", fmt.Errorf("no checker available for file system type '%v'", fsType)
+	}
+}
+
+// Fsck runs the checker of the device's file system and streams its output.
+// The device must not be mounted. The exit code of the checker is returned,
+// its meaning depends on the checker.
+func (fm *FileManager) Fsck(ctx context.Context, devName string, fc FsckConfig) (int, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	isDevSpec := IsDevSpec(devName)
+	if !isDevSpec && !utils.ValidateDevName(devName) {
+		return 0, fmt.Errorf("bad device name")
+	}
+
+	if fc.FSTypeOverride != "" && !utils.ValidateFsType(fc.FSTypeOverride) {
+		return 0, fmt.Errorf("bad fs type override (contains illegal characters)")
+	}
+
+	sc, err := fm.vm.DialSSH()
+	if err != nil {
+		return 0, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	r := fm.runnerFor(sc)
+
+	if fc.LUKSContainerPreopen != "" {
+		err := fm.preopenLUKSContainerWithSSH(ctx, sc, fc.LUKSContainerPreopen, !fc.Repair, fc.LUKSOptions)
+		if err != nil {
+			return 0, errors.Wrap(err, "preopen luks container")
+		}
+	}
+
+	if isDevSpec {
+		devName, err = fm.resolveDevSpecWithRunner(ctx, r, devName)
+		if err != nil {
+			return 0, errors.Wrap(err, "resolve device")
+		}
+	}
+
+	fullDevPath := "/dev/" + devName
+
+	if fc.LUKS {
+		luksDMName := "cryptfsck"
+
+		err = fm.luksOpen(ctx, sc, fullDevPath, luksDMName, !fc.Repair, fc.LUKSOptions)
+		if err != nil {
+			return 0, errors.Wrap(err, "luks open")
+		}
+
+		fullDevPath = "/dev/mapper/" + luksDMName
+	}
+
+	fsType := fc.FSTypeOverride
+	if fsType == "" {
+		out, err := sshutil.RunCmd(ctx, r, "blkid -o value -s TYPE "+fullDevPath+" || true")
+		if err != nil {
+			return 0, errors.Wrap(err, "run blkid")
+		}
+
+		fsType = strings.TrimSpace(string(out))
+		if fsType == "" {
+			return 0, fmt.Errorf("failed to detect the file system type, please specify it explicitly")
+		}
+	}
+
+	checkCmd, err := getFsckCmd(fsType, fc.Repair, fc.Progress != nil)
+	if err != nil {
+		return 0, err
+	}
+
+	fm.logger.Info("Running file system checker", "dev", fullDevPath, "fs", fsType, "repair", fc.Repair, "cmd", checkCmd)
+
+	cmd := sshutil.Command{
+		Cmd:    checkCmd + " " + fullDevPath,
+		Stdout: fc.Stdout,
+		Stderr: fc.Stderr,
+
+		// Checking large file systems takes a long time.
+		Timeout: sshutil.NoTimeout,
+	}
+
+	if fc.Progress != nil {
+		pw := &e2fsckProgressWriter{
+			out: fc.Stdout,
+			fn:  fc.Progress,
+		}
+
+		defer pw.flush()
+
+		cmd.Stdout = pw
+	}
+
+	_, err = r.Run(ctx, cmd)
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitStatus(), nil
+		}
+
+		return 0, errors.Wrap(err, "run checker cmd")
+	}
+
+	return 0, nil
+}
+
+// e2fsckProgressWriter reports the "<pass> <current> <max> <device>" progress
+// lines printed by e2fsck with "-C 1", and forwards all other output.
+type e2fsckProgressWriter struct {
+	out io.Writer
+	fn  progress.Func
+	buf []byte
+}
+
+func (w *e2fsckProgressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		line := w.buf[:i+1]
+		if !w.tryReport(string(line)) && w.out != nil {
+			_, err := w.out.Write(line)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *e2fsckProgressWriter) tryReport(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return false
+	}
+
+	var nums [3]int64
+	for i := range nums {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return false
+		}
+
+		nums[i] = n
+	}
+
+	w.fn(progress.Update{
+		Op:    progress.OpFsck,
+		Stage: fmt.Sprintf("pass %v", nums[0]),
+		Name:  fields[3],
+		Done:  nums[1],
+		Total: nums[2],
+	})
+
+	return true
+}
+
+func (w *e2fsckProgressWriter) flush() {
+	if len(w.buf) != 0 && w.out != nil {
+		_, _ = w.out.Write(w.buf)
+	}
+
+	w.buf = nil
+}
@@ -0,0 +1,142 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/pkg/errors"
+)
+
+// FSTooling describes the guest image support for a file system
+// which is not handled by the Alpine base system out of the box.
+type FSTooling struct {
+	FSType string
+
+	// Package is the Alpine package providing the user space utilities.
+	Package string
+
+	// RepairCmd is the repair utility. Empty if the file system has none.
+	RepairCmd string
+}
+
+var extraFSTooling = []FSTooling{
+	{FSType: "xfs", Package: "xfsprogs", RepairCmd: "xfs_repair"},
+	{FSType: "f2fs", Package: "f2fs-tools", RepairCmd: "fsck.f2fs"},
+	{FSType: "jfs", Package: "jfsutils", RepairCmd: "jfs_fsck"},
+	// NILFS2 recovers on mount by itself and has no offline repair utility.
+	{FSType: "nilfs2", Package: "nilfs-utils"},
+	{FSType: "reiserfs", Package: "reiserfsprogs", RepairCmd: "reiserfsck"},
+}
+
+// ExtraFSTooling returns the file systems with first-class support in the guest image
+// in addition to the ones supported out of the box (ext2/3/4, vfat, btrfs, etc.).
+func ExtraFSTooling() []FSTooling {
+	return append([]FSTooling(nil), extraFSTooling...)
+}
+
+// ExtraFSToolingPackages returns the Alpine packages to install into
+// the guest image for the file systems returned by ExtraFSTooling.
+func ExtraFSToolingPackages() []string {
+	pkgs := make([]string, 0, len(extraFSTooling))
+	for _, t := range extraFSTooling {
+		pkgs = append(pkgs, t.Package)
+	}
+
+	return pkgs
+}
+
+func getExtraFSTooling(fsType string) *FSTooling {
+	for _, t := range extraFSTooling {
+		if t.FSType == fsType {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+// DetectedFS is a block device with a file system that requires extra tooling.
+type DetectedFS struct {
+	DevName string
+	Tooling FSTooling
+}
+
+// DetectExtraFilesystems returns the block devices holding file
+// systems from ExtraFSTooling.
+func (fm *FileManager) DetectExtraFilesystems(ctx context.Context) ([]DetectedFS, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	out, err := sshutil.RunCmd(ctx, r, "lsblk -rno NAME,FSTYPE -e 7,11,2")
+	if err != nil {
+		return nil, errors.Wrap(err, "run lsblk")
+	}
+
+	var ret []DetectedFS
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		if t := getExtraFSTooling(fields[1]); t != nil {
+			ret = append(ret, DetectedFS{
+				DevName: fields[0],
+				Tooling: *t,
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// loadFSModule loads the kernel module of a file system from ExtraFSTooling,
+// as they are not loaded automatically by mount in the guest. The file
+// system type is detected if fsType is empty. It is a no-op for other file
+// systems.
+func (fm *FileManager) loadFSModule(ctx context.Context, r sshutil.CommandRunner, fullDevPath string, fsType string) error {
+	if fsType == "" {
+		out, err := sshutil.RunCmd(ctx, r, "blkid -o value -s TYPE "+fullDevPath+" || true")
+		if err != nil {
+			return errors.Wrap(err, "run blkid")
+		}
+
+		fsType = strings.TrimSpace(string(out))
+	}
+
+	if getExtraFSTooling(fsType) == nil {
+		return nil
+	}
+
+	_, err := sshutil.RunCmd(ctx, r, "modprobe "+fsType)
+	if err != nil {
+		return errors.Wrapf(err, "load '%v' kernel module", fsType)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+
+	"github.com/bramvdbogaerde/go-scp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Instance is the surface of a running VM that the FileManager and the file
+// share backends use. It is implemented by VM, and by the in-process fake in
+// package vmtest, which makes it possible to test the orchestration logic
+// without QEMU installed.
+type Instance interface {
+	// Run starts the VM and blocks until it stops. It returns nil if
+	// the VM was stopped with Cancel.
+	Run() error
+
+	// Cancel stops the VM. It is safe to call multiple times.
+	Cancel() error
+
+	// Context returns the context that is canceled when the
+	// VM is being shut down. Same goes for Done.
+	Context() context.Context
+	Done() <-chan struct{}
+
+	// SSHUpNotifyChan returns the channel that is closed once the
+	// guest SSH server is set up and DialSSH can be used.
+	SSHUpNotifyChan() chan struct{}
+
+	DialSSH() (*ssh.Client, error)
+	DialSCP() (*scp.Client, error)
+
+	// DialSFTP starts the SFTP session over a new SSH connection. The
+	// session must be closed, which closes the connection too.
+	DialSFTP() (*SFTPClient, error)
+
+	// SSHMappedPort returns the host port the guest SSH server is forwarded to.
+	SSHMappedPort() uint16
+
+	Hostname() string
+}
+
+var _ Instance = (*VM)(nil)
@@ -0,0 +1,327 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/phayes/freeport"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"golang.org/x/exp/maps"
+)
+
+// The number of free ports tried before giving up on the SSH port allocation.
+const maxPortAllocationAttempts = 32
+
+// PortReserver reserves a port across all concurrently running Linsk
+// processes. It returns false if another process has reserved the port.
+type PortReserver func(port uint16) (bool, error)
+
+// InstanceFactory creates the instance with the guest SSH
+// server forwarded to the host port allocated by the Manager.
+type InstanceFactory func(sshPort uint16) (Instance, error)
+
+// NewQEMUFactory returns the InstanceFactory creating the QEMU VMs.
+func NewQEMUFactory(logger *slog.Logger, cfg Config) InstanceFactory {
+	return func(sshPort uint16) (Instance, error) {
+		cfg.SSHPort = sshPort
+
+		vi, err := NewVM(logger, cfg)
+		if err != nil {
+			// Not returning a typed nil as the interface.
+			return nil, err
+		}
+
+		return vi, nil
+	}
+}
+
+// ManagerOptions configures the Manager.
+type ManagerOptions struct {
+	// PortReserver makes the allocated SSH ports reserved across the
+	// processes sharing the data directory, like Storage.ReservePort.
+	// The ports are only tracked in-process if it is nil.
+	PortReserver PortReserver
+}
+
+// Manager runs multiple instances in the same process. The SSH ports are
+// allocated by the Manager, so that the instances started concurrently
+// don't pick the same one, and the shared files in the data directory,
+// like the VM images and the overlays, are to be prepared under the
+// cache locks. It is safe for concurrent use.
+type Manager struct {
+	logger *slog.Logger
+	opts   ManagerOptions
+
+	mu        sync.Mutex
+	instances map[string]*ManagedInstance
+	ports     map[uint16]struct{}
+	closed    bool
+
+	cacheLocksMu sync.Mutex
+	cacheLocks   map[string]*sync.Mutex
+}
+
+func NewManager(logger *slog.Logger, opts ManagerOptions) *Manager {
+	return &Manager{
+		logger: logger,
+		opts:   opts,
+
+		instances: make(map[string]*ManagedInstance),
+		ports:     make(map[uint16]struct{}),
+
+		cacheLocks: make(map[string]*sync.Mutex),
+	}
+}
+
+// ManagedInstance is the instance run by the Manager.
+type ManagedInstance struct {
+	Instance
+
+	name    string
+	sshPort uint16
+
+	exitedCh chan struct{}
+	runErr   error
+}
+
+// Name returns the name the instance is registered with in the Manager.
+func (mi *ManagedInstance) Name() string {
+	return mi.name
+}
+
+// Exited returns the channel that is closed once Run returns.
+// Unlike Done, it is closed after the QEMU process has exited.
+func (mi *ManagedInstance) Exited() <-chan struct{} {
+	return mi.exitedCh
+}
+
+// Wait blocks until the instance stops and returns the error returned by Run.
+func (mi *ManagedInstance) Wait() error {
+	<-mi.exitedCh
+	return mi.runErr
+}
+
+// Start creates the instance with the factory and runs it in the background.
+// The instance is named by its hostname if the name is empty. The instance
+// is removed from the Manager and its SSH port is freed once it stops.
+// Start does not wait for the instance to boot.
+func (m *Manager) Start(name string, newInstance InstanceFactory) (*ManagedInstance, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("manager closed")
+	}
+
+	if _, ok := m.instances[name]; ok {
+		m.mu.Unlock()
+		return nil, errors.Wrapf(ErrInstanceExists, "start '%v'", name)
+	}
+
+	sshPort, err := m.allocatePort()
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "allocate ssh port")
+	}
+
+	vi, err := newInstance(sshPort)
+	if err != nil {
+		m.freePort(sshPort)
+		return nil, errors.Wrap(err, "create instance")
+	}
+
+	if name == "" {
+		name = vi.Hostname()
+	}
+
+	mi := &ManagedInstance{
+		Instance: vi,
+
+		name:    name,
+		sshPort: sshPort,
+
+		exitedCh: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	// The lock was released while the instance was being created.
+	if _, ok := m.instances[name]; ok || m.closed {
+		m.mu.Unlock()
+		m.freePort(sshPort)
+		_ = vi.Cancel()
+
+		if !ok {
+			return nil, fmt.Errorf("manager closed")
+		}
+
+		return nil, errors.Wrapf(ErrInstanceExists, "start '%v'", name)
+	}
+
+	m.instances[name] = mi
+	m.mu.Unlock()
+
+	go m.run(mi)
+
+	return mi, nil
+}
+
+func (m *Manager) run(mi *ManagedInstance) {
+	mi.runErr = mi.Run()
+
+	m.mu.Lock()
+	if m.instances[mi.name] == mi {
+		delete(m.instances, mi.name)
+	}
+
+	delete(m.ports, mi.sshPort)
+	m.mu.Unlock()
+
+	if mi.runErr != nil {
+		m.logger.Warn("Managed instance exited with an error", "name", mi.name, "error", mi.runErr.Error())
+	} else {
+		m.logger.Debug("Managed instance exited", "name", mi.name)
+	}
+
+	close(mi.exitedCh)
+}
+
+// allocatePort picks a free port that is not used by the other instances
+// nor reserved by another process. The caller must hold m.mu.
+func (m *Manager) allocatePort() (uint16, error) {
+	for i := 0; i < maxPortAllocationAttempts; i++ {
+		p, err := freeport.GetFreePort()
+		if err != nil {
+			return 0, errors.Wrap(err, "get free port")
+		}
+
+		port := uint16(p)
+
+		// Nothing listens on the port before the VM starts,
+		// so the OS may hand it out again in the meantime.
+		if _, ok := m.ports[port]; ok {
+			continue
+		}
+
+		if m.opts.PortReserver != nil {
+			ok, err := m.opts.PortReserver(port)
+			if err != nil {
+				return 0, errors.Wrapf(err, "reserve port %v", port)
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		m.ports[port] = struct{}{}
+
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("no free port found after %v attempts", maxPortAllocationAttempts)
+}
+
+func (m *Manager) freePort(port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.ports, port)
+}
+
+// Get returns the running instance with the name.
+func (m *Manager) Get(name string) (*ManagedInstance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mi, ok := m.instances[name]
+
+	return mi, ok
+}
+
+// List returns the names of the running instances, sorted.
+func (m *Manager) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := maps.Keys(m.instances)
+	slices.Sort(names)
+
+	return names
+}
+
+// Stop cancels the instance with the name and waits for it to stop. It
+// returns ErrInstanceNotFound if there is no such instance running.
+func (m *Manager) Stop(name string) error {
+	mi, ok := m.Get(name)
+	if !ok {
+		return errors.Wrapf(ErrInstanceNotFound, "stop '%v'", name)
+	}
+
+	return m.stop(mi)
+}
+
+func (m *Manager) stop(mi *ManagedInstance) error {
+	var err error
+
+	select {
+	case <-mi.Done():
+	default:
+		err = errors.Wrap(mi.Cancel(), "cancel")
+	}
+
+	return multierr.Append(err, errors.Wrap(mi.Wait(), "run"))
+}
+
+// Close stops all instances and makes the Manager refuse to start new ones.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	instances := maps.Values(m.instances)
+	m.mu.Unlock()
+
+	var err error
+
+	for _, mi := range instances {
+		err = multierr.Append(err, errors.Wrapf(m.stop(mi), "stop '%v'", mi.name))
+	}
+
+	return err
+}
+
+// LockCache locks the shared cache entry, like the VM image or the overlay
+// path, for preparing it. It returns the function to unlock the entry. The
+// data directory operations like downloading the images or creating the
+// overlays check whether the files exist before writing them, so the
+// instances started concurrently must prepare the same files one at a time.
+func (m *Manager) LockCache(key string) (unlock func()) {
+	m.cacheLocksMu.Lock()
+	mu, ok := m.cacheLocks[key]
+	if !ok {
+		mu = new(sync.Mutex)
+		m.cacheLocks[key] = mu
+	}
+	m.cacheLocksMu.Unlock()
+
+	mu.Lock()
+
+	return mu.Unlock
+}
@@ -0,0 +1,187 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+const (
+	guestResourceMonitorInterval = time.Second * 10
+
+	// The warnings are emitted when the available amount drops below
+	// the percentage threshold AND the absolute threshold.
+	lowMemoryPercentThreshold    = 10
+	lowMemoryAbsThreshold        = 64 << 20
+	lowDiskSpacePercentThreshold = 10
+	lowDiskSpaceAbsThreshold     = 64 << 20
+)
+
+// runGuestResourceMonitor periodically checks the guest memory and
+// the root file system free space to warn the user before the processes
+// in the guest fail with OOM or ENOSPC.
+func (vm *VM) runGuestResourceMonitor() {
+	var memWarned, diskWarned bool
+
+	for {
+		select {
+		case <-vm.ctx.Done():
+			return
+		case <-time.After(guestResourceMonitorInterval):
+			memTotal, memAvail, rootTotal, rootAvail, err := vm.queryGuestResources()
+			if err != nil {
+				if vm.ctx.Err() == nil {
+					vm.logger.Debug("Failed to query guest resources", "error", err.Error())
+				}
+				continue
+			}
+
+			memLow := isResourceLow(memTotal, memAvail, lowMemoryPercentThreshold, lowMemoryAbsThreshold)
+			if memLow && !memWarned {
+				vm.emitEvent(Event{
+					Type:    EventTypeLowMemory,
+					Message: fmt.Sprintf("The VM is running low on memory (%v available of %v)", humanize.IBytes(memAvail), humanize.IBytes(memTotal)),
+					Remedy:  "Increase the VM memory allocation using --vm-mem-alloc flag, or enable zram swap in the VM.",
+				})
+			}
+			// We're warning only once until the situation improves.
+			memWarned = memLow
+
+			diskLow := isResourceLow(rootTotal, rootAvail, lowDiskSpacePercentThreshold, lowDiskSpaceAbsThreshold)
+			if diskLow && !diskWarned {
+				vm.emitEvent(Event{
+					Type:    EventTypeLowDiskSpace,
+					Message: fmt.Sprintf("The VM root file system is running out of space (%v available of %v)", humanize.IBytes(rootAvail), humanize.IBytes(rootTotal)),
+					Remedy:  "Avoid writing temporary files to the VM root file system, and use a scratch directory on the mounted device instead.",
+				})
+			}
+			diskWarned = diskLow
+		}
+	}
+}
+
+func isResourceLow(total uint64, avail uint64, percentThreshold uint64, absThreshold uint64) bool {
+	if total == 0 {
+		return false
+	}
+
+	return avail*100/total < percentThreshold && avail < absThreshold
+}
+
+func (vm *VM) queryGuestResources() (uint64, uint64, uint64, uint64, error) {
+	sc, err := vm.DialSSH()
+	if err != nil {
+		return 0, 0, 0, 0, errors.Wrap(err, "dial ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	meminfo, err := sshutil.RunSSHCmd(vm.ctx, sc, "cat /proc/meminfo")
+	if err != nil {
+		return 0, 0, 0, 0, errors.Wrap(err, "read meminfo")
+	}
+
+	memTotal, memAvail, err := parseMeminfo(meminfo)
+	if err != nil {
+		return 0, 0, 0, 0, errors.Wrap(err, "parse meminfo")
+	}
+
+	df, err := sshutil.RunSSHCmd(vm.ctx, sc, "df -Pk /")
+	if err != nil {
+		return 0, 0, 0, 0, errors.Wrap(err, "run df cmd")
+	}
+
+	rootTotal, rootAvail, err := parseDfOutput(df)
+	if err != nil {
+		return 0, 0, 0, 0, errors.Wrap(err, "parse df output")
+	}
+
+	return memTotal, memAvail, rootTotal, rootAvail, nil
+}
+
+func parseMeminfo(meminfo []byte) (uint64, uint64, error) {
+	var total, avail uint64
+	var haveTotal, haveAvail bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(meminfo))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var dst *uint64
+		switch fields[0] {
+		case "MemTotal:":
+			dst = &total
+			haveTotal = true
+		case "MemAvailable:":
+			dst = &avail
+			haveAvail = true
+		default:
+			continue
+		}
+
+		kib, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "parse '%v' value", fields[0])
+		}
+
+		*dst = kib * 1024
+	}
+
+	if !haveTotal || !haveAvail {
+		return 0, 0, fmt.Errorf("total or available memory is missing")
+	}
+
+	return total, avail, nil
+}
+
+// parseDfOutput parses the output of `df -Pk <path>` and
+// returns the total and the available space in bytes.
+func parseDfOutput(out []byte) (uint64, uint64, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if want, have := 2, len(lines); want != have {
+		return 0, 0, fmt.Errorf("bad df output line count: want %v, have %v", want, have)
+	}
+
+	fields := strings.Fields(lines[1])
+	if want, have := 6, len(fields); want > have {
+		return 0, 0, fmt.Errorf("bad df output field count: want %v > have %v (line '%v')", want, have, lines[1])
+	}
+
+	totalKiB, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parse total size")
+	}
+
+	availKiB, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parse available size")
+	}
+
+	return totalKiB * 1024, availKiB * 1024, nil
+}
@@ -17,9 +17,13 @@
 package vm
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/AlexSSD7/linsk/sshutil"
 	"github.com/AlexSSD7/linsk/utils"
@@ -51,3 +55,73 @@ func (vm *VM) ConfigureInterfaceStaticNet(ctx context.Context, iface string, cid
 
 	return nil
 }
+
+// NetInterfaceStats holds the traffic counters of a guest network interface.
+type NetInterfaceStats struct {
+	Name    string
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// GuestNetStats returns the traffic counters of the guest network
+// interfaces, except the loopback one. As the VM networking is restricted
+// by default, the traffic is the file share traffic plus the little SSH
+// traffic Linsk itself makes.
+func (vm *VM) GuestNetStats(ctx context.Context) ([]NetInterfaceStats, error) {
+	sc, err := vm.DialSSH()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	out, err := sshutil.RunSSHCmd(ctx, sc, "cat /proc/net/dev")
+	if err != nil {
+		return nil, errors.Wrap(err, "read net dev stats")
+	}
+
+	return parseProcNetDev(out)
+}
+
+// parseProcNetDev parses the contents of /proc/net/dev.
+func parseProcNetDev(out []byte) ([]NetInterfaceStats, error) {
+	var ret []NetInterfaceStats
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// The two header lines have no colon. The counters may
+		// follow the colon without a space in older kernels.
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(counters)
+		if want, have := 16, len(fields); want > have {
+			return nil, fmt.Errorf("bad net dev stats field count for '%v': want %v > have %v", name, want, have)
+		}
+
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse '%v' received bytes", name)
+		}
+
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse '%v' transmitted bytes", name)
+		}
+
+		ret = append(ret, NetInterfaceStats{
+			Name:    name,
+			RxBytes: rx,
+			TxBytes: tx,
+		})
+	}
+
+	return ret, nil
+}
@@ -16,6 +16,8 @@
 
 package vm
 
+import "github.com/AlexSSD7/linsk/qemucli"
+
 type USBDevicePassthroughConfig struct {
 	VendorID  uint16
 	ProductID uint16
@@ -24,6 +26,47 @@ type USBDevicePassthroughConfig struct {
 type BlockDevicePassthroughConfig struct {
 	Path      string
 	BlockSize uint64
+
+	// ReadOnly makes QEMU reject all writes to the device.
+	ReadOnly bool
+
+	// ImageFormat is the disk image format if Path is a disk
+	// image file rather than a block device. Empty otherwise.
+	ImageFormat qemucli.ImgFormat
+}
+
+// AttachedBlockDevice is a passed through block device or disk image
+// along with its in-VM device name.
+type AttachedBlockDevice struct {
+	DevName string
+	Source  BlockDevicePassthroughConfig
+}
+
+// AttachedBlockDevices returns the passed through block devices and disk images
+// with their in-VM device names. The partitions of each (including the ones in
+// disk images) are then available as "<device name><partition number>".
+func (vm *VM) AttachedBlockDevices() []AttachedBlockDevice {
+	// The virtio block devices are named in the order they are attached, and
+	// the VM drives are attached before the passed through devices.
+	ret := make([]AttachedBlockDevice, 0, len(vm.originalCfg.PassthroughConfig.Block))
+	for i, dev := range vm.originalCfg.PassthroughConfig.Block {
+		ret = append(ret, AttachedBlockDevice{
+			DevName: virtioBlockDevName(len(vm.originalCfg.Drives) + i),
+			Source:  dev,
+		})
+	}
+
+	return ret
+}
+
+func virtioBlockDevName(index int) string {
+	// vda, ..., vdz, vdaa, ..., vdzz, etc.
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('a'+(index-1)%26)) + name
+	}
+
+	return "vd" + name
 }
 
 type PassthroughConfig struct {
@@ -0,0 +1,86 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"github.com/AlexSSD7/linsk/sshutil"
+	"github.com/pkg/errors"
+)
+
+// ScanRAID returns the mdadm configuration lines of the md arrays whose
+// member superblocks were found on the attached devices. The output is
+// empty if there are none.
+func (fm *FileManager) ScanRAID(ctx context.Context) ([]byte, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	ret, err := sshutil.RunCmd(ctx, r, "mdadm --examine --scan")
+	if err != nil {
+		return nil, errors.Wrap(err, "run mdadm examine cmd")
+	}
+
+	return ret, nil
+}
+
+// AssembleRAID assembles all md arrays found on the attached devices and
+// activates the LVM volumes on top of them. Arrays with missing members
+// are started only if allowDegraded is set. The contents of /proc/mdstat
+// are returned to show the resulting md devices.
+func (fm *FileManager) AssembleRAID(ctx context.Context, allowDegraded bool, readOnly bool) ([]byte, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	r, closeRunner, err := fm.dialRunner()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer closeRunner()
+
+	cmd := "mdadm --assemble --scan"
+	if allowDegraded {
+		cmd += " --run"
+	}
+	if readOnly {
+		cmd += " --readonly"
+	}
+
+	_, err = sshutil.RunCmd(ctx, r, cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "run mdadm assemble cmd")
+	}
+
+	err = fm.InitLVM(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reinit lvm")
+	}
+
+	ret, err := sshutil.RunCmd(ctx, r, "cat /proc/mdstat")
+	if err != nil {
+		return nil, errors.Wrap(err, "read mdstat")
+	}
+
+	return ret, nil
+}
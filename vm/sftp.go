@@ -0,0 +1,223 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/multierr"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPClient is the SFTP session over the root SSH connection to the VM.
+// Unlike the SFTP share, it needs no extra guest configuration. The
+// embedded client gives the full read-write access to the guest files,
+// while FS provides the read-only view usable with the standard library.
+type SFTPClient struct {
+	*sftp.Client
+
+	sc *ssh.Client
+}
+
+// NewSFTPClient starts the SFTP session over the SSH connection. The
+// connection is taken over by the client and is closed by Close.
+func NewSFTPClient(sc *ssh.Client) (*SFTPClient, error) {
+	c, err := sftp.NewClient(sc)
+	if err != nil {
+		return nil, errors.Wrap(err, "start sftp session")
+	}
+
+	return &SFTPClient{
+		Client: c,
+		sc:     sc,
+	}, nil
+}
+
+// Close ends the SFTP session and closes the SSH connection.
+func (c *SFTPClient) Close() error {
+	return multierr.Combine(c.Client.Close(), c.sc.Close())
+}
+
+// FS returns the read-only view of the guest directory, like MountRoot.
+// The file system implements fs.StatFS and fs.ReadDirFS, and the opened
+// regular files implement io.Seeker and io.ReaderAt, so that it can be
+// used with http.FS or fs.WalkDir. Symlinks are followed, like with os.DirFS.
+func (c *SFTPClient) FS(root string) fs.FS {
+	return &sftpFS{
+		c:    c.Client,
+		root: root,
+	}
+}
+
+// DialSFTP starts the SFTP session over a new SSH connection.
+func (vm *VM) DialSFTP() (*SFTPClient, error) {
+	sc, err := vm.DialSSH()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial ssh")
+	}
+
+	c, err := NewSFTPClient(sc)
+	if err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+type sftpFS struct {
+	c    *sftp.Client
+	root string
+}
+
+var (
+	_ fs.StatFS    = (*sftpFS)(nil)
+	_ fs.ReadDirFS = (*sftpFS)(nil)
+)
+
+func (fsys *sftpFS) vmPath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return path.Join(fsys.root, name), nil
+}
+
+func (fsys *sftpFS) Open(name string) (fs.File, error) {
+	p, err := fsys.vmPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Opening a directory succeeds on the OpenSSH server, but reading it
+	// fails, so the directories are told apart beforehand.
+	fi, err := fsys.c.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if fi.IsDir() {
+		return &sftpDir{
+			fsys: fsys,
+			name: name,
+			fi:   fi,
+		}, nil
+	}
+
+	f, err := fsys.c.Open(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fsys *sftpFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := fsys.vmPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fsys.c.Stat(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return fi, nil
+}
+
+func (fsys *sftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := fsys.vmPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fis, err := fsys.c.ReadDir(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	ret := make([]fs.DirEntry, 0, len(fis))
+	for _, fi := range fis {
+		ret = append(ret, fs.FileInfoToDirEntry(fi))
+	}
+
+	slices.SortFunc(ret, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return ret, nil
+}
+
+// sftpDir is the directory opened with sftpFS.Open. The
+// entries are read in full on the first ReadDir call.
+type sftpDir struct {
+	fsys *sftpFS
+	name string
+	fi   fs.FileInfo
+
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *sftpDir) Stat() (fs.FileInfo, error) {
+	return d.fi, nil
+}
+
+func (d *sftpDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *sftpDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		ret := d.entries
+		d.entries = nil
+
+		return ret, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	ret := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return ret, nil
+}
+
+func (d *sftpDir) Close() error {
+	return nil
+}
@@ -21,7 +21,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"time"
 
@@ -55,11 +54,11 @@ func ParseSSHKeyScan(knownHosts []byte) (ssh.HostKeyCallback, error) {
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 		knownKey, ok := knownKeysMap[key.Type()]
 		if !ok {
-			return fmt.Errorf("unknown key type '%v'", key.Type())
+			return fmt.Errorf("%w: unknown key type '%v'", ErrHostKeyMismatch, key.Type())
 		}
 
 		if !bytes.Equal(key.Marshal(), knownKey) {
-			return fmt.Errorf("public key mismatch")
+			return fmt.Errorf("%w: '%v' key differs", ErrHostKeyMismatch, key.Type())
 		}
 
 		return nil
@@ -84,7 +83,7 @@ func (vm *VM) scanSSHIdentity() ([]byte, error) {
 		case <-vm.ctx.Done():
 			return nil, vm.ctx.Err()
 		case <-time.After(time.Until(deadline)):
-			return nil, fmt.Errorf("keyscan command timed out %v", utils.GetLogErrMsg(stdOutErrBuf.String(), "stdout/stderr log"))
+			return nil, fmt.Errorf("%w: keyscan command timed out %v", ErrBootTimeout, utils.GetLogErrMsg(stdOutErrBuf.String(), "stdout/stderr log"))
 		case data := <-vm.serialStdoutCh:
 			if len(data) == 0 {
 				continue
@@ -125,7 +124,10 @@ func (vm *VM) sshSetup() (ssh.Signer, error) {
 		installSSHDCmd = "apk add openssh; "
 	}
 
-	cmd := `do_setup () { sh -c "set -ex; ifconfig eth0 up && ifconfig lo up && udhcpc; ` + installSSHDCmd + `mkdir -p ~/.ssh; echo ` + shellescape.Quote(string(sshPublicKey)) + ` > ~/.ssh/authorized_keys; rc-update add sshd; rc-service sshd start"; echo "SERIAL"" ""STATUS: $?"; }; do_setup` + "\n"
+	// The hostname is validated at the creation of the VM, it's safe to include it as-is.
+	hostnameCmd := `hostname ` + vm.hostname + `; echo ` + vm.hostname + ` > /etc/hostname; echo 'Linsk session ` + vm.hostname + `' > /etc/motd; `
+
+	cmd := `do_setup () { sh -c "set -ex; ` + hostnameCmd + `ifconfig eth0 up && ifconfig lo up && udhcpc; ` + installSSHDCmd + `mkdir -p ~/.ssh; echo ` + shellescape.Quote(string(sshPublicKey)) + ` > ~/.ssh/authorized_keys; rc-update add sshd; rc-service sshd start"; echo "SERIAL"" ""STATUS: $?"; }; do_setup` + "\n"
 
 	err = vm.writeSerial([]byte(cmd))
 	if err != nil {
@@ -141,7 +143,7 @@ func (vm *VM) sshSetup() (ssh.Signer, error) {
 		case <-vm.ctx.Done():
 			return nil, vm.ctx.Err()
 		case <-time.After(time.Until(deadline)):
-			return nil, fmt.Errorf("setup command timed out %v", utils.GetLogErrMsg(stdOutErrBuf.String(), "stdout/stderr log"))
+			return nil, fmt.Errorf("%w: setup command timed out %v", ErrBootTimeout, utils.GetLogErrMsg(stdOutErrBuf.String(), "stdout/stderr log"))
 		case data := <-vm.serialStdoutCh:
 			// This isn't clean at all, but there is no better
 			// way to achieve an exit status check like this.
@@ -153,9 +155,7 @@ func (vm *VM) sshSetup() (ssh.Signer, error) {
 				}
 
 				if data[len(prefix)] != '0' {
-					// A non-pretty yet effective debug print to assist with debugging
-					// in case something ever goes wrong.
-					fmt.Fprintf(os.Stderr, "SSH SETUP FAILURE:\n%v", stdOutErrBuf.String())
+					vm.logger.Debug("SSH setup command failed", "log", stdOutErrBuf.String())
 
 					return nil, fmt.Errorf("non-zero setup command status code: '%v' %v", string(data[len(prefix)]), utils.GetLogErrMsg(stdOutErrBuf.String(), "stdout/stderr log"))
 				}
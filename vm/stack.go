@@ -0,0 +1,172 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The maximum number of layers (partition tables, LUKS, LVM, etc.)
+// to walk through before giving up. This protects from loops.
+const maxStackDepth = 8
+
+type StackResolveConfig struct {
+	LUKSOptions LUKSOptions
+	ReadOnly    bool
+
+	// Choose is called to pick one of the devices when a layer (like a
+	// partition table or an LVM volume group) has multiple candidates.
+	// The index of the chosen device is returned. If nil, ambiguous
+	// layers result in an error.
+	Choose func(candidates []BlockDevice) (int, error)
+}
+
+// ResolveStack walks through the storage stack layers starting from the
+// device (partition tables, LUKS containers, LVM physical volumes, in any
+// combination) until a mountable file system is reached. LUKS containers
+// are opened (the password is prompted), and LVM volumes are activated on
+// the way. The name of the device holding the file system is returned,
+// ready to be passed to Mount.
+func (fm *FileManager) ResolveStack(ctx context.Context, devName string, rc StackResolveConfig) (string, error) {
+	ctx, cancel := fm.withVMContext(ctx)
+	defer cancel()
+
+	sc, err := fm.vm.DialSSH()
+	if err != nil {
+		return "", errors.Wrap(err, "dial vm ssh")
+	}
+
+	defer func() { _ = sc.Close() }()
+
+	cur := strings.TrimPrefix(devName, "mapper/")
+	luksCount := 0
+
+	for depth := 0; depth < maxStackDepth; depth++ {
+		devs, err := fm.ListBlockDevices(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "list block devices")
+		}
+
+		dev := FindBlockDevice(devs, cur)
+		if dev == nil {
+			return "", fmt.Errorf("%w: '%v'", ErrDeviceNotFound, cur)
+		}
+
+		c := dev.Classification
+
+		fm.logger.Info("Resolving storage stack", "dev", dev.Name, "kind", c.Kind)
+
+		switch c.Kind {
+		case DeviceKindLUKS:
+			if len(dev.Children) != 0 {
+				// Already opened.
+				cur = dev.Children[0].Name
+				continue
+			}
+
+			luksDMName := fmt.Sprintf("linskcrypt%v", luksCount)
+			luksCount++
+
+			err := fm.luksOpen(ctx, sc, getBlockDevicePath(dev), luksDMName, rc.ReadOnly, rc.LUKSOptions)
+			if err != nil {
+				return "", errors.Wrapf(err, "luks open '%v'", dev.Name)
+			}
+
+			cur = luksDMName
+		case DeviceKindLVMMember:
+			if len(dev.Children) == 0 {
+				err := fm.InitLVM(ctx)
+				if err != nil {
+					return "", errors.Wrap(err, "activate lvm volumes")
+				}
+
+				devs, err = fm.ListBlockDevices(ctx)
+				if err != nil {
+					return "", errors.Wrap(err, "list block devices")
+				}
+
+				dev = FindBlockDevice(devs, cur)
+				if dev == nil || len(dev.Children) == 0 {
+					return "", fmt.Errorf("no logical volumes found on lvm physical volume '%v'", cur)
+				}
+			}
+
+			cur, err = chooseStackDevice(dev, rc.Choose)
+			if err != nil {
+				return "", err
+			}
+		case DeviceKindPartitionTable:
+			cur, err = chooseStackDevice(dev, rc.Choose)
+			if err != nil {
+				return "", err
+			}
+		default:
+			if !c.Mountable {
+				return "", fmt.Errorf("cannot resolve '%v' (%v) to a mountable file system: %v", dev.Name, c.Kind, c.Hint)
+			}
+
+			switch dev.Type {
+			case "crypt", "lvm":
+				return "mapper/" + dev.Name, nil
+			default:
+				return dev.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("storage stack is too deep (more than %v layers)", maxStackDepth)
+}
+
+// chooseStackDevice picks the next layer among the children of the device.
+// The devices holding nothing of interest (like swap) are skipped.
+func chooseStackDevice(dev *BlockDevice, choose func([]BlockDevice) (int, error)) (string, error) {
+	var candidates []BlockDevice
+	for _, c := range dev.Children {
+		switch c.Classification.Kind {
+		case DeviceKindSwap, DeviceKindUnknown:
+			continue
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no usable devices found within '%v'", dev.Name)
+	case 1:
+		return candidates[0].Name, nil
+	}
+
+	if choose == nil {
+		return "", fmt.Errorf("'%v' contains multiple candidates, please specify the device explicitly", dev.Name)
+	}
+
+	i, err := choose(candidates)
+	if err != nil {
+		return "", errors.Wrap(err, "choose device")
+	}
+
+	if i < 0 || i >= len(candidates) {
+		return "", fmt.Errorf("invalid choice")
+	}
+
+	return candidates[i].Name, nil
+}
@@ -24,6 +24,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +33,7 @@ import (
 	"log/slog"
 
 	"github.com/AlexSSD7/linsk/osspecifics"
+	"github.com/AlexSSD7/linsk/progress"
 	"github.com/AlexSSD7/linsk/qemucli"
 	"github.com/AlexSSD7/linsk/sshutil"
 	"github.com/AlexSSD7/linsk/utils"
@@ -47,13 +50,20 @@ type VM struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 
-	cmd *exec.Cmd
+	cmd     *exec.Cmd
+	qemuCmd *qemucli.Command
 
 	sshMappedPort uint16
 	sshConf       *ssh.ClientConfig
 	sshReadyCh    chan struct{}
 	installSSH    bool
 
+	// bootDuration is set before sshReadyCh is closed.
+	bootDuration time.Duration
+
+	hostname    string
+	qemuVersion qemucli.Version
+
 	serialRead    *io.PipeReader
 	serialReader  *bufio.Reader
 	serialWrite   *io.PipeWriter
@@ -65,6 +75,9 @@ type VM struct {
 
 	serialStdoutCh chan []byte
 
+	events   *eventBus
+	progress progress.Func
+
 	// These are to be interacted with using `atomic` package
 	disposed uint32
 	canceled uint32
@@ -88,28 +101,65 @@ type Config struct {
 
 	MemoryAlloc uint32 // In KiB.
 
+	// CPUs is the vCPU count. Picked based on the host
+	// CPU count if left zero.
+	CPUs uint32
+
 	PassthroughConfig        PassthroughConfig
 	ExtraPortForwardingRules []PortForwardingRule
 
+	// DriveIO selects the cache and AIO modes of the passed through
+	// block devices. The defaults are picked per platform.
+	DriveIO DriveIOConfig
+
+	// SSHPort is the host port to forward the guest SSH server to.
+	// A free one is picked if this is left zero.
+	SSHPort uint16
+
+	// Hostname is the guest hostname. It is also advertised by the
+	// file share servers. A unique "linsk-<session ID>" hostname
+	// is generated if this is left blank.
+	Hostname string
+
 	// Networking
 	UnrestrictedNetworking bool
 	Taps                   []TapConfig
 
-	// Timeouts
+	// Timeouts. These are scaled up automatically under software emulation.
 	OSUpTimeout  time.Duration
 	SSHUpTimeout time.Duration
 
+	// Accel selects hardware acceleration or software emulation.
+	// Defaults to AccelAuto.
+	Accel AccelMode
+
+	// Progress receives the boot stage updates. Optional.
+	Progress progress.Func
+
 	// Mostly debug-related options.
 	Debug                bool // This will show the display and forward all QEMU warnings/errors to stderr.
 	InstallBaseUtilities bool
 }
 
 func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
-	sshPort, err := freeport.GetFreePort()
+	sshPort := int(cfg.SSHPort)
+	if sshPort == 0 {
+		var err error
+		sshPort, err = freeport.GetFreePort()
+		if err != nil {
+			return nil, errors.Wrap(err, "get free port for ssh server")
+		}
+	}
+
+	var err error
+
+	cfg.Accel, err = resolveAccelMode(logger, cfg.Accel)
 	if err != nil {
-		return nil, errors.Wrap(err, "get free port for ssh server")
+		return nil, errors.Wrap(err, "resolve acceleration mode")
 	}
 
+	cfg.CPUs = resolveCPUs(logger, cfg.CPUs)
+
 	baseCmd, cmdArgs, err := configureBaseVMCmd(logger, cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "configure base vm cmd")
@@ -129,7 +179,10 @@ func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
 
 	cmdArgs = append(cmdArgs, driveCmdArgs...)
 
-	usbCmdArgs := configureVMCmdUSBPassthrough(cfg)
+	usbCmdArgs, err := configureVMCmdUSBPassthrough(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "configure vm cmd usb passthrough")
+	}
 
 	cmdArgs = append(cmdArgs, usbCmdArgs...)
 
@@ -160,7 +213,43 @@ func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
 		return nil, fmt.Errorf("vm ssh setup timeout cannot be lower than os up timeout")
 	}
 
-	encodedCmdArgs, err := qemucli.EncodeArgs(cmdArgs)
+	osUpTimeout = scaleTimeoutForAccel(cfg.Accel, osUpTimeout)
+	sshUpTimeout = scaleTimeoutForAccel(cfg.Accel, sshUpTimeout)
+
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname, err = generateSessionHostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "generate session hostname")
+		}
+	} else if !utils.ValidateHostname(hostname) {
+		return nil, fmt.Errorf("invalid hostname '%v'", hostname)
+	}
+
+	probeCtx, probeCtxCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer probeCtxCancel()
+
+	qemuVersion, err := qemucli.ProbeVersion(probeCtx, baseCmd)
+	if err != nil {
+		// Not critical, QEMU will complain about unsupported args by itself anyway.
+		logger.Warn("Failed to probe QEMU version, skipping version-aware arg validation", "error", err.Error())
+	} else {
+		logger.Debug("Probed QEMU version", "version", qemuVersion)
+
+		if cfg.DriveIO.AIO == qemucli.DriveAIOIOUring && qemuVersion.Less(qemucli.Version{Major: 5}) {
+			return nil, fmt.Errorf("drive aio mode '%v' requires qemu version 5.0.0 or newer (have %v)", cfg.DriveIO.AIO, qemuVersion)
+		}
+
+		cmdArgs, err = qemucli.AdaptArgsForVersion(cmdArgs, qemuVersion)
+		if err != nil {
+			return nil, errors.Wrapf(err, "adapt qemu cli args for installed qemu version %v", qemuVersion)
+		}
+	}
+
+	qemuCmd := qemucli.NewCommand(baseCmd)
+	qemuCmd.Add(cmdArgs...)
+
+	encodedCmdArgs, err := qemuCmd.EncodeArgs()
 	if err != nil {
 		return nil, errors.Wrap(err, "encode qemu cli args")
 	}
@@ -175,7 +264,7 @@ func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
 	cmd.Stdin = sysRead
 	cmd.Stdout = sysWrite
 	stderrBuf := bytes.NewBuffer(nil)
-	cmd.Stderr = stderrBuf
+	cmd.Stderr = io.MultiWriter(stderrBuf, utils.NewLogLineWriter(logger, "QEMU stderr"))
 
 	if cfg.Debug {
 		cmd.Stderr = io.MultiWriter(cmd.Stderr, os.Stderr)
@@ -194,12 +283,16 @@ func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
 		ctx:       ctx,
 		ctxCancel: ctxCancel,
 
-		cmd: cmd,
+		cmd:     cmd,
+		qemuCmd: qemuCmd,
 
 		sshMappedPort: uint16(sshPort),
 		sshReadyCh:    make(chan struct{}),
 		installSSH:    cfg.InstallBaseUtilities,
 
+		hostname:    hostname,
+		qemuVersion: qemuVersion,
+
 		serialRead:    userRead,
 		serialReader:  userReader,
 		serialWrite:   userWrite,
@@ -208,6 +301,9 @@ func NewVM(logger *slog.Logger, cfg Config) (*VM, error) {
 		osUpTimeout:  osUpTimeout,
 		sshUpTimeout: sshUpTimeout,
 
+		events:   newEventBus(),
+		progress: cfg.Progress,
+
 		originalCfg: cfg,
 	}
 
@@ -226,6 +322,8 @@ func (vm *VM) Run() error {
 		return errors.Wrap(err, "start qemu cmd")
 	}
 
+	startedAt := time.Now()
+
 	go vm.runPeriodicHostMountChecker()
 
 	var globalErrsMu sync.Mutex
@@ -244,7 +342,7 @@ func (vm *VM) Run() error {
 		select {
 		case <-time.After(vm.osUpTimeout):
 			vm.logger.Warn("A VM boot timeout detected, consider running with --vm-debug to investigate")
-			globalErrFn(fmt.Errorf("vm boot timeout %v", utils.GetLogErrMsg(string(vm.consumeSerialStdout()), "serial log")))
+			globalErrFn(fmt.Errorf("%w %v", ErrBootTimeout, utils.GetLogErrMsg(string(vm.consumeSerialStdout()), "serial log")))
 		case <-bootReadyCh:
 			vm.logger.Info("The VM is up, setting it up")
 		}
@@ -253,13 +351,14 @@ func (vm *VM) Run() error {
 	go func() {
 		select {
 		case <-time.After(vm.sshUpTimeout):
-			globalErrFn(fmt.Errorf("vm setup timeout %v", utils.GetLogErrMsg(string(vm.consumeSerialStdout()), "serial log")))
+			globalErrFn(fmt.Errorf("%w (setup) %v", ErrBootTimeout, utils.GetLogErrMsg(string(vm.consumeSerialStdout()), "serial log")))
 		case <-vm.sshReadyCh:
 			vm.logger.Info("The VM is ready")
 		}
 	}()
 
-	vm.logger.Info("Booting the VM")
+	vm.logger.Info("Booting the VM", "hostname", vm.hostname)
+	vm.reportBootStage(bootStageBooting)
 
 	go func() {
 		_ = vm.runSerialReader()
@@ -276,6 +375,8 @@ func (vm *VM) Run() error {
 		// This will disable the timeout-handling goroutine.
 		close(bootReadyCh)
 
+		vm.reportBootStage(bootStageSSHSetup)
+
 		sshSigner, err := vm.sshSetup()
 		if err != nil {
 			globalErrFn(errors.Wrap(err, "set up ssh"))
@@ -292,6 +393,8 @@ func (vm *VM) Run() error {
 
 		vm.logger.Debug("Scanned SSH identity")
 
+		vm.reportBootStage(bootStageSSHConnect)
+
 		knownHosts, err := ParseSSHKeyScan(sshKeyScan)
 		if err != nil {
 			globalErrFn(errors.Wrap(err, "parse ssh key scan"))
@@ -307,8 +410,14 @@ func (vm *VM) Run() error {
 			Timeout: time.Second * 5,
 		}
 
+		vm.bootDuration = time.Since(startedAt)
+
 		// This is to notify everyone waiting for SSH to be up that it's ready to go.
 		close(vm.sshReadyCh)
+
+		vm.reportBootStage(bootStageReady)
+
+		go vm.runGuestResourceMonitor()
 	}()
 
 	_, err = vm.cmd.Process.Wait()
@@ -319,6 +428,11 @@ func (vm *VM) Run() error {
 			errors.Wrap(cancelErr, "cancel"),
 		)
 
+		if strings.Contains(vm.qemuStderrBuf.String(), "Permission denied") {
+			// QEMU could not open one of the passed through devices.
+			combinedErr = multierr.Append(combinedErr, ErrPermissionDenied)
+		}
+
 		return fmt.Errorf("%w %v", combinedErr, utils.GetLogErrMsg(vm.qemuStderrBuf.String(), "qemu stderr log"))
 	}
 
@@ -332,6 +446,26 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// The VM boot stages, in order.
+var bootStages = []string{bootStageBooting, bootStageSSHSetup, bootStageSSHConnect, bootStageReady}
+
+const (
+	bootStageBooting    = "booting"
+	bootStageSSHSetup   = "ssh-setup"
+	bootStageSSHConnect = "ssh-connect"
+	bootStageReady      = "ready"
+)
+
+func (vm *VM) reportBootStage(stage string) {
+	vm.progress.Report(progress.Update{
+		Op:    progress.OpBoot,
+		Stage: stage,
+		Name:  vm.hostname,
+		Done:  int64(slices.Index(bootStages, stage)),
+		Total: int64(len(bootStages) - 1),
+	})
+}
+
 func (vm *VM) Cancel() error {
 	if atomic.AddUint32(&vm.canceled, 1) != 1 {
 		return nil
@@ -382,6 +516,10 @@ func (vm *VM) runSerialReader() error {
 			return errors.Wrap(err, "read from serial reader")
 		}
 
+		if line := strings.TrimSpace(utils.ClearUnprintableChars(string(raw), false)); line != "" {
+			vm.logger.Debug("VM serial output", "line", line)
+		}
+
 		select {
 		case vm.serialStdoutCh <- raw:
 		default:
@@ -472,10 +610,64 @@ func (vm *VM) DialSCP() (*scp.Client, error) {
 	return &sc, nil
 }
 
+// SSHMappedPort returns the host port the guest SSH server is forwarded to.
+func (vm *VM) SSHMappedPort() uint16 {
+	return vm.sshMappedPort
+}
+
+// Context returns the context that is canceled when the VM is being shut down.
+func (vm *VM) Context() context.Context {
+	return vm.ctx
+}
+
+// Done returns a channel that is closed when the VM is being shut down.
+func (vm *VM) Done() <-chan struct{} {
+	return vm.ctx.Done()
+}
+
+func (vm *VM) Hostname() string {
+	return vm.hostname
+}
+
+// CPUs returns the vCPU count of the VM.
+func (vm *VM) CPUs() uint32 {
+	return vm.originalCfg.CPUs
+}
+
+// QEMUCommand returns the assembled QEMU command.
+func (vm *VM) QEMUCommand() *qemucli.Command {
+	return vm.qemuCmd
+}
+
+// QEMUCommandLine returns the fully assembled QEMU argv.
+func (vm *VM) QEMUCommandLine() []string {
+	// Making a copy so that remote caller cannot modify the original command.
+	tmp := make([]string, len(vm.cmd.Args))
+	copy(tmp, vm.cmd.Args)
+	return tmp
+}
+
+// QEMUVersion returns the probed version of the installed QEMU. The
+// version is zero if probing has failed.
+func (vm *VM) QEMUVersion() qemucli.Version {
+	return vm.qemuVersion
+}
+
 func (vm *VM) SSHUpNotifyChan() chan struct{} {
 	return vm.sshReadyCh
 }
 
+// BootDuration returns the time it took from starting QEMU until the
+// guest SSH server was ready. It is zero until SSHUpNotifyChan is closed.
+func (vm *VM) BootDuration() time.Duration {
+	select {
+	case <-vm.sshReadyCh:
+		return vm.bootDuration
+	default:
+		return 0
+	}
+}
+
 // It's always a user's responsibility to ensure that no drives are mounted
 // in both host and guest system. This should serve as the last resort.
 func (vm *VM) runPeriodicHostMountChecker() {
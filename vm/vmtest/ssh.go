@@ -0,0 +1,143 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vmtest
+
+import (
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func (f *Fake) runSSHServer() {
+	for {
+		conn, err := f.sshListener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.serveSSH(conn)
+	}
+}
+
+func (f *Fake) serveSSH(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, f.serverConf)
+	if err != nil {
+		f.logger.Warn("Failed to establish fake SSH server conn", "error", err.Error())
+		_ = conn.Close()
+		return
+	}
+
+	defer func() { _ = sconn.Close() }()
+
+	// Closing the connections on shutdown, the way they
+	// are dropped when the real VM is powered off.
+	connDone := make(chan struct{})
+	defer close(connDone)
+
+	go func() {
+		select {
+		case <-f.ctx.Done():
+			_ = sconn.Close()
+		case <-connDone:
+		}
+	}()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			f.logger.Warn("Failed to accept fake SSH session", "error", err.Error())
+			continue
+		}
+
+		go f.serveSession(ch, chReqs)
+	}
+}
+
+func (f *Fake) serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer func() { _ = ch.Close() }()
+
+	for req := range reqs {
+		if req.Type == "subsystem" {
+			f.serveSubsystem(ch, req, reqs)
+			return
+		}
+
+		if req.Type != "exec" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+
+			continue
+		}
+
+		var execReq struct {
+			Command string
+		}
+
+		err := ssh.Unmarshal(req.Payload, &execReq)
+		if err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+
+		_ = req.Reply(true, nil)
+
+		status := f.getHandler(execReq.Command)(execReq.Command, ch, ch, ch.Stderr())
+
+		_ = ch.CloseWrite()
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct {
+			Status uint32
+		}{uint32(status)}))
+
+		return
+	}
+}
+
+func (f *Fake) serveSubsystem(ch ssh.Channel, req *ssh.Request, reqs <-chan *ssh.Request) {
+	var subsystemReq struct {
+		Name string
+	}
+
+	err := ssh.Unmarshal(req.Payload, &subsystemReq)
+	if err != nil || subsystemReq.Name != "sftp" {
+		_ = req.Reply(false, nil)
+		return
+	}
+
+	_ = req.Reply(true, nil)
+
+	go ssh.DiscardRequests(reqs)
+
+	srv := sftp.NewRequestServer(ch, f.sftpHandlers)
+
+	err = srv.Serve()
+	if err != nil && !errors.Is(err, io.EOF) {
+		f.logger.Warn("Fake SFTP server failed", "error", err.Error())
+	}
+
+	_ = srv.Close()
+}
@@ -0,0 +1,322 @@
+// Linsk - A utility to access Linux-native file systems on non-Linux operating systems.
+// Copyright (c) 2023 The Linsk Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package vmtest provides an in-process fake of a VM for testing the code
+// built on top of vm.Instance (like the FileManager, the file share backends
+// and package linsk) without QEMU installed.
+//
+// The fake runs an in-process SSH server on a loopback port. The commands run over it are
+// dispatched to the handlers registered with Fake.Handle, and recorded to be
+// inspected with Fake.Commands. The SFTP subsystem serves an in-memory
+// file system, which is empty when the fake is created.
+package vmtest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexSSD7/linsk/utils"
+	"github.com/AlexSSD7/linsk/vm"
+	"github.com/bramvdbogaerde/go-scp"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// RunFunc handles a command run in the fake VM. It returns the exit status
+// of the command. The stdin is closed when the client closes it.
+type RunFunc func(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+
+// Output returns a RunFunc which writes the output and exits with status 0.
+func Output(stdout string) RunFunc {
+	return func(_ string, _ io.Reader, w io.Writer, _ io.Writer) int {
+		_, _ = io.WriteString(w, stdout)
+		return 0
+	}
+}
+
+// Fail returns a RunFunc which writes the message to stderr and exits with the status.
+func Fail(status int, stderr string) RunFunc {
+	return func(_ string, _ io.Reader, _ io.Writer, w io.Writer) int {
+		_, _ = io.WriteString(w, stderr)
+		return status
+	}
+}
+
+type Config struct {
+	// Hostname defaults to "linsk-fake".
+	Hostname string
+
+	// BootDelay is how long the fake takes to boot before the SSH server is up.
+	BootDelay time.Duration
+
+	// SerialOutput is the scripted serial console output. It is logged
+	// on boot, the way the real VM logs its serial console.
+	SerialOutput []string
+
+	// BootError makes the boot fail with the error (like vm.ErrBootTimeout)
+	// after BootDelay. The serial output is appended to the error message,
+	// the way the real VM does it.
+	BootError error
+}
+
+type handler struct {
+	prefix string
+	fn     RunFunc
+}
+
+// Fake is an in-process fake VM implementing vm.Instance. It must be
+// started with Run, and the SSH server is available once it boots.
+type Fake struct {
+	logger *slog.Logger
+	cfg    Config
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	sshReadyCh    chan struct{}
+	sshListener   net.Listener
+	sshMappedPort uint16
+	serverConf    *ssh.ServerConfig
+	hostKey       ssh.PublicKey
+	sftpHandlers  sftp.Handlers
+
+	mu             sync.Mutex
+	handlers       []handler
+	defaultHandler RunFunc
+	commands       []string
+
+	disposed uint32
+}
+
+var _ vm.Instance = (*Fake)(nil)
+
+func New(logger *slog.Logger, cfg Config) (*Fake, error) {
+	if cfg.Hostname == "" {
+		cfg.Hostname = "linsk-fake"
+	}
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate host key")
+	}
+
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "create signer from host key")
+	}
+
+	serverConf := &ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	serverConf.AddHostKey(signer)
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	return &Fake{
+		logger: logger,
+		cfg:    cfg,
+
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+
+		sshReadyCh:   make(chan struct{}),
+		serverConf:   serverConf,
+		hostKey:      signer.PublicKey(),
+		sftpHandlers: sftp.InMemHandler(),
+	}, nil
+}
+
+// Handle registers the handler for the commands starting with the prefix.
+// The handler with the longest matching prefix is used, and the one
+// registered last wins between the handlers with the same prefix.
+func (f *Fake) Handle(prefix string, fn RunFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handlers = append(f.handlers, handler{prefix: prefix, fn: fn})
+}
+
+// HandleDefault registers the handler for the commands no other handler
+// matches. Without it, such commands fail with exit status 127, like the
+// ones which are not found by a shell.
+func (f *Fake) HandleDefault(fn RunFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.defaultHandler = fn
+}
+
+// Commands returns the commands run in the fake VM so far, in order.
+func (f *Fake) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := make([]string, len(f.commands))
+	copy(ret, f.commands)
+
+	return ret
+}
+
+func (f *Fake) getHandler(cmd string) RunFunc {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands = append(f.commands, cmd)
+
+	var match *handler
+	for i := range f.handlers {
+		h := &f.handlers[i]
+		if strings.HasPrefix(cmd, h.prefix) && (match == nil || len(h.prefix) >= len(match.prefix)) {
+			match = h
+		}
+	}
+
+	if match != nil {
+		return match.fn
+	}
+
+	if f.defaultHandler != nil {
+		return f.defaultHandler
+	}
+
+	return func(cmd string, _ io.Reader, _ io.Writer, stderr io.Writer) int {
+		f.logger.Warn("Unhandled command run in the fake VM", "cmd", cmd)
+		_, _ = fmt.Fprintf(stderr, "vmtest: no handler for command '%v'\n", cmd)
+		return 127
+	}
+}
+
+func (f *Fake) Run() error {
+	if atomic.AddUint32(&f.disposed, 1) != 1 {
+		return fmt.Errorf("vm disposed")
+	}
+
+	for _, line := range f.cfg.SerialOutput {
+		f.logger.Debug("VM serial output", "line", line)
+	}
+
+	select {
+	case <-time.After(f.cfg.BootDelay):
+	case <-f.ctx.Done():
+		return nil
+	}
+
+	if f.cfg.BootError != nil {
+		f.ctxCancel()
+		return fmt.Errorf("%w %v", f.cfg.BootError, utils.GetLogErrMsg(strings.Join(f.cfg.SerialOutput, "\n"), "serial log"))
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		f.ctxCancel()
+		return errors.Wrap(err, "listen ssh")
+	}
+
+	f.sshListener = lis
+	f.sshMappedPort = uint16(lis.Addr().(*net.TCPAddr).Port)
+
+	go f.runSSHServer()
+
+	close(f.sshReadyCh)
+
+	<-f.ctx.Done()
+
+	return errors.Wrap(lis.Close(), "close ssh listener")
+}
+
+func (f *Fake) Cancel() error {
+	f.ctxCancel()
+	return nil
+}
+
+func (f *Fake) Context() context.Context {
+	return f.ctx
+}
+
+func (f *Fake) Done() <-chan struct{} {
+	return f.ctx.Done()
+}
+
+func (f *Fake) SSHUpNotifyChan() chan struct{} {
+	return f.sshReadyCh
+}
+
+func (f *Fake) DialSSH() (*ssh.Client, error) {
+	select {
+	case <-f.sshReadyCh:
+	default:
+		return nil, vm.ErrSSHUnavailable
+	}
+
+	if f.ctx.Err() != nil {
+		return nil, fmt.Errorf("vm is shut down")
+	}
+
+	return ssh.Dial("tcp", f.sshListener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.FixedHostKey(f.hostKey),
+	})
+}
+
+func (f *Fake) DialSCP() (*scp.Client, error) {
+	sc, err := f.DialSSH()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial ssh")
+	}
+
+	c, err := scp.NewClientBySSH(sc)
+	if err != nil {
+		_ = sc.Close()
+		return nil, errors.Wrap(err, "create scp client")
+	}
+
+	return &c, nil
+}
+
+// DialSFTP starts the SFTP session with the in-memory file system of the fake.
+func (f *Fake) DialSFTP() (*vm.SFTPClient, error) {
+	sc, err := f.DialSSH()
+	if err != nil {
+		return nil, errors.Wrap(err, "dial ssh")
+	}
+
+	c, err := vm.NewSFTPClient(sc)
+	if err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SSHMappedPort returns the loopback port of the fake SSH server.
+func (f *Fake) SSHMappedPort() uint16 {
+	return f.sshMappedPort
+}
+
+func (f *Fake) Hostname() string {
+	return f.cfg.Hostname
+}